@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// How chapter boundaries are announced in rendered videos, for viewers who
+// cannot tell chapters apart from the footage.
+const (
+	AnnounceOff = "off"
+	// A subtitle track showing each announcement.
+	AnnounceCaptions = "captions"
+	// A secondary, audio description track speaking each announcement.
+	AnnounceAudio = "audio"
+)
+
+// How long each announcement caption is shown.
+const announcementDuration = 5 * time.Second
+
+// Returns the text announcing a chapter, e.g.
+// "Chapter 2 of 5, Saturday 4 July, 10:12, Oma".
+func chapterAnnouncement(video Video, ix int) string {
+	chapter := video.Chapters[ix]
+	parts := []string{fmt.Sprintf("Chapter %d of %d", ix+1, len(video.Chapters))}
+	if !chapter.CreateTime.IsZero() {
+		parts = append(parts, localTime(chapter.CreateTime).Format("Monday 2 January, 15:04"))
+	}
+	if chapter.Speaker != "" {
+		parts = append(parts, chapter.Speaker)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Formats an offset as a SubRip timestamp, e.g. "01:02:03,450".
+func fmtDurationForSubRip(d time.Duration) string {
+	return fmt.Sprintf("%02d:%02d:%02d,%03d",
+		int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60, d.Milliseconds()%1000)
+}
+
+// Writes a SubRip file showing the announcement of each chapter at its start.
+func writeAnnouncementCaptions(video Video, fileName string) error {
+	var b strings.Builder
+	offsets := video.chapterOffsets()
+	for ix, chapter := range video.Chapters {
+		start := offsets[ix]
+		end := start + announcementDuration
+		if end > start+video.timeline(chapter.Duration) {
+			end = start + video.timeline(chapter.Duration)
+		}
+		fmt.Fprintf(&b, "%d\n%s --> %s\n%s\n\n", ix+1,
+			fmtDurationForSubRip(start), fmtDurationForSubRip(end), chapterAnnouncement(video, ix))
+	}
+	return ioutil.WriteFile(fileName, []byte(b.String()), 0644)
+}
+
+// Returns a command speaking text into an audio file: say on macOS, espeak-ng
+// or espeak elsewhere.
+func speechCommand(ctx context.Context, text, outputFile string) (*exec.Cmd, error) {
+	if runtime.GOOS == "darwin" {
+		return exec.CommandContext(ctx, "say", "-o", outputFile, text), nil
+	}
+	for _, engine := range []string{"espeak-ng", "espeak"} {
+		if _, err := exec.LookPath(engine); err == nil {
+			return exec.CommandContext(ctx, engine, "-w", outputFile, text), nil
+		}
+	}
+	return nil, fmt.Errorf("Could not find espeak-ng or espeak to speak chapter announcements")
+}
+
+// Renders an audio track as long as the video, speaking the announcement of
+// each chapter at its start.
+func renderAnnouncementAudio(ctx context.Context, runner Runner, video Video, tmpDir, outputFile string) error {
+	ext := ".wav"
+	if runtime.GOOS == "darwin" {
+		ext = ".aiff"
+	}
+	var args, filters, mix []string
+	offsets := video.chapterOffsets()
+	for ix := range video.Chapters {
+		clip := filepath.Join(tmpDir, fmt.Sprintf("announcement%d%s", ix, ext))
+		cmd, err := speechCommand(ctx, chapterAnnouncement(video, ix), clip)
+		if err != nil {
+			return err
+		}
+		cmd.Stderr = os.Stderr
+		if err := runCommand(runner, cmd); err != nil {
+			return err
+		}
+		args = append(args, "-i", clip)
+		filters = append(filters, fmt.Sprintf("[%d]adelay=%d:all=1[a%d]", ix, offsets[ix].Milliseconds(), ix))
+		mix = append(mix, fmt.Sprintf("[a%d]", ix))
+	}
+	filters = append(filters, fmt.Sprintf("%samix=inputs=%d:normalize=0,apad=whole_dur=%.3f[out]",
+		strings.Join(mix, ""), len(mix), video.renderedDuration().Seconds()))
+	args = append(args, "-filter_complex", strings.Join(filters, ";"),
+		"-map", "[out]", "-c:a", "aac", "-b:a", "64k", outputFile, "-y")
+	cmd := exec.CommandContext(ctx, binaryPath("ffmpeg"), append([]string{"-v", "warning"}, args...)...)
+	cmd.Stderr = os.Stderr
+	return runCommand(runner, cmd)
+}
+
+// Prepares the chapter announcements of a video according to the config, and
+// returns the ffmpeg input arguments and output arguments adding them as an
+// extra stream. The input is expected to be the third one, after the chapters
+// and metadata.
+func announcementArgs(ctx context.Context, runner Runner, video Video, config *Config, tmpDir string) ([]string, []string, error) {
+	switch config.ChapterAnnouncements {
+	case AnnounceCaptions:
+		fileName := filepath.Join(tmpDir, "announcements.srt")
+		if err := writeAnnouncementCaptions(video, fileName); err != nil {
+			return nil, nil, err
+		}
+		return []string{"-i", fileName}, []string{"-map", "2:s:0",
+			"-c:s", "mov_text",
+			"-metadata:s:s:0", "title=Chapter announcements"}, nil
+	case AnnounceAudio:
+		fileName := filepath.Join(tmpDir, "announcements.m4a")
+		log.Printf(">>> Speaking chapter announcements of %s", video.Title)
+		if err := renderAnnouncementAudio(ctx, runner, video, tmpDir, fileName); err != nil {
+			return nil, nil, err
+		}
+		return []string{"-i", fileName}, []string{"-map", "2:a:0",
+			"-metadata:s:a:1", "title=Chapter announcements",
+			"-disposition:a:1", "visual_impaired"}, nil
+	}
+	return nil, nil, nil
+}
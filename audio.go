@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Integrated loudness audio is normalized to by default, in LUFS: the level
+// YouTube plays videos at.
+const DefaultLoudnessTarget = -14
+
+// Bitrate of reencoded audio tracks.
+const audioBitrate = "256k"
+
+// Returns the loudnorm (EBU R128) filter normalizing audio to the loudness
+// target. It runs in its dynamic mode, so that the level of loud (e.g. wind)
+// and quiet chapters converges over the video.
+func (c *Config) loudnormFilter() string {
+	target := c.LoudnessTarget
+	if target == 0 {
+		target = DefaultLoudnessTarget
+	}
+	// loudnorm resamples to 192 kHz.
+	return fmt.Sprintf("loudnorm=I=%g:TP=-1.5:LRA=11,aresample=48000", target)
+}
+
+// How background music is mixed in, see Music.
+const (
+	// The original audio is dropped.
+	MusicReplace = "replace"
+	// The original audio is kept, lowered under the music.
+	MusicDuck = "duck"
+)
+
+// Defaults of the background music settings.
+const (
+	DefaultMusicFade    = 3 * time.Second
+	DefaultDuckedVolume = -15
+)
+
+// A track mixed into rendered videos, e.g. licensed music for public uploads,
+// where wind noise adds nothing. It is looped over the length of the video,
+// faded in and out.
+type Music struct {
+	File string `json:"file"`
+	// MusicReplace (default) or MusicDuck.
+	Mode string `json:"mode"`
+	// Gain of the music, in dB.
+	Volume float64 `json:"volume"`
+	// Gain of the original audio with MusicDuck, in dB, DefaultDuckedVolume
+	// if zero.
+	OriginalVolume float64 `json:"original_volume"`
+	// Length of the fades, e.g. "5s", DefaultMusicFade if empty.
+	Fade string `json:"fade"`
+}
+
+func (m *Music) validate() error {
+	if m == nil {
+		return nil
+	}
+	if m.File == "" {
+		return fmt.Errorf("music file cannot be empty")
+	}
+	if _, err := os.Stat(m.File); err != nil {
+		return fmt.Errorf("Could not read music file: %v", err)
+	}
+	switch m.Mode {
+	case "", MusicReplace, MusicDuck:
+	default:
+		return fmt.Errorf("invalid music mode %q, expected %s or %s", m.Mode, MusicReplace, MusicDuck)
+	}
+	if _, err := m.fade(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (m *Music) fade() (time.Duration, error) {
+	if m.Fade == "" {
+		return DefaultMusicFade, nil
+	}
+	fade, err := time.ParseDuration(m.Fade)
+	if err != nil || fade < 0 {
+		return 0, fmt.Errorf("invalid music fade %q", m.Fade)
+	}
+	return fade, nil
+}
+
+// Returns the filtergraph mixing the music, input musicInput, into the first
+// audio track of input 0, for a video of the given duration. The output is
+// labelled [a].
+func (m *Music) filters(musicInput int, duration time.Duration) string {
+	fade, _ := m.fade()
+	if fade > duration/2 {
+		fade = duration / 2
+	}
+	music := fmt.Sprintf("[%d:a:0]volume=%gdB,atrim=duration=%.3f,afade=t=in:d=%.3f,afade=t=out:st=%.3f:d=%.3f,aresample=48000",
+		musicInput, m.Volume, duration.Seconds(), fade.Seconds(), (duration - fade).Seconds(), fade.Seconds())
+	if m.Mode != MusicDuck {
+		return music + "[a]"
+	}
+	original := m.OriginalVolume
+	if original == 0 {
+		original = DefaultDuckedVolume
+	}
+	return strings.Join([]string{
+		music + "[music]",
+		fmt.Sprintf("[0:a:0]volume=%gdB,aresample=48000[original]", original),
+		"[original][music]amix=inputs=2:duration=first:normalize=0[a]",
+	}, ";")
+}
+
+// Returns the ffmpeg arguments of the audio of a render: the extra inputs,
+// given the index of the first, the stream to map as first audio track, empty
+// for the default, and the output arguments. Only that track is reencoded,
+// if music is mixed in or it is normalized. Hyperlapses drop the original
+// audio, so music replaces it.
+func (c *Config) audioArgs(video Video, firstInput int) ([]string, string, []string) {
+	music := c.Music
+	if music == nil {
+		if !c.Loudnorm || video.Hyperlapse != nil {
+			return nil, "", nil
+		}
+		return nil, "", []string{"-filter:a:0", c.loudnormFilter(), "-c:a:0", "aac", "-b:a:0", audioBitrate}
+	}
+	if video.Hyperlapse != nil && music.Mode == MusicDuck {
+		replaced := *music
+		replaced.Mode = MusicReplace
+		music = &replaced
+	}
+	filters := music.filters(firstInput, video.renderedDuration())
+	if c.Loudnorm {
+		filters = strings.TrimSuffix(filters, "[a]") + "," + c.loudnormFilter() + "[a]"
+	}
+	return []string{"-stream_loop", "-1", "-i", music.File}, "[a]",
+		[]string{"-filter_complex", filters, "-c:a:0", "aac", "-b:a:0", audioBitrate}
+}
@@ -0,0 +1,690 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const YouTubeScope = "https://www.googleapis.com/auth/youtube"
+
+// Redirect URI for the copy-paste flow: the browser fails to load it, and the
+// authorization code is read from the address bar.
+const pasteRedirectURI = "http://localhost"
+
+const deviceCodeURI = "https://oauth2.googleapis.com/device/code"
+
+// How long the loopback flow waits for the user to grant access.
+const loopbackTimeout = 5 * time.Minute
+
+const (
+	tokenInfoURI = "https://oauth2.googleapis.com/tokeninfo"
+	revokeURI    = "https://oauth2.googleapis.com/revoke"
+)
+
+// How the user authorizes the tool the first time.
+const (
+	// Open a link and paste back the code from the page redirected to.
+	AuthFlowPaste = "paste"
+	// Enter a short code on another device, for headless machines.
+	AuthFlowDevice = "device"
+	// Redirect the browser to a temporary local HTTP server.
+	AuthFlowLoopback = "loopback"
+)
+
+var errAuthRequired = errors.New("Authorization required")
+
+// Error returned by the token endpoint.
+// https://datatracker.ietf.org/doc/html/rfc6749#section-5.2
+type tokenError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *tokenError) Error() string {
+	return fmt.Sprintf("Error fetching token: %s: %s", e.Code, e.Description)
+}
+
+// OAuth client credentials, as downloaded from the Google API console.
+type ClientSecrets struct {
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	AuthURI      string `json:"auth_uri"`
+	TokenURI     string `json:"token_uri"`
+}
+
+type Token struct {
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token"`
+	TokenType    string    `json:"token_type"`
+	Expiry       time.Time `json:"expiry"`
+}
+
+// Verifies if the access token can still be used.
+func (t *Token) valid() bool {
+	return t.AccessToken != "" && time.Now().Add(time.Minute).Before(t.Expiry)
+}
+
+// Reads the client secrets file (either "installed" or "web" application).
+func loadClientSecrets(fileName string) (*ClientSecrets, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Installed *ClientSecrets
+		Web       *ClientSecrets
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	secrets := file.Installed
+	if secrets == nil {
+		secrets = file.Web
+	}
+	if secrets == nil || secrets.ClientID == "" {
+		return nil, fmt.Errorf("Error parsing client secrets: %s", fileName)
+	}
+	if secrets.AuthURI == "" {
+		secrets.AuthURI = "https://accounts.google.com/o/oauth2/auth"
+	}
+	if secrets.TokenURI == "" {
+		secrets.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+	return secrets, nil
+}
+
+// Returns the path of the file caching the OAuth token of a profile, moving
+// it from ~/.credentials where earlier versions kept it.
+func tokenCacheFile(profile string) (string, error) {
+	if profile == "" {
+		profile = DefaultProfile
+	}
+	dir, err := appConfigDir()
+	if err != nil {
+		return "", err
+	}
+	fileName := filepath.Join(dir, "credentials", profile+".json")
+
+	legacyName := "gopro-uploader.json"
+	if profile != DefaultProfile {
+		legacyName = "gopro-uploader-" + profile + ".json"
+	}
+	legacyName, err = legacyFile(".credentials", legacyName)
+	if err != nil {
+		return "", err
+	}
+	if err := migrateFile(legacyName, fileName); err != nil {
+		return "", err
+	}
+	return fileName, nil
+}
+
+// Returns the path of the lock file held while refreshing the token of a
+// profile.
+func tokenLockFile(profile string) (string, error) {
+	if profile == "" {
+		profile = DefaultProfile
+	}
+	dir, err := appCacheDir()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "token-"+profile+".lock"), nil
+}
+
+func loadToken(fileName string) (*Token, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	var token Token
+	if err := json.Unmarshal(data, &token); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// Writes the token cache atomically, so that concurrent readers never see a
+// partially written file.
+func saveToken(fileName string, token *Token) error {
+	if err := os.MkdirAll(filepath.Dir(fileName), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(token, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpName := fileName + ".tmp"
+	if err := ioutil.WriteFile(tmpName, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, fileName)
+}
+
+// Calls the token endpoint and parses the returned token.
+func requestToken(secrets *ClientSecrets, params url.Values) (*Token, error) {
+	params.Set("client_id", secrets.ClientID)
+	params.Set("client_secret", secrets.ClientSecret)
+	resp, err := http.PostForm(secrets.TokenURI, params)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var tokenErr tokenError
+		if json.Unmarshal(body, &tokenErr) == nil && tokenErr.Code != "" {
+			return nil, &tokenErr
+		}
+		return nil, fmt.Errorf("Error fetching token: %s: %s", resp.Status, body)
+	}
+	var data struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		TokenType    string `json:"token_type"`
+		ExpiresIn    int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil, err
+	}
+	return &Token{
+		AccessToken:  data.AccessToken,
+		RefreshToken: data.RefreshToken,
+		TokenType:    data.TokenType,
+		Expiry:       time.Now().Add(time.Duration(data.ExpiresIn) * time.Second),
+	}, nil
+}
+
+// Runs the authorization flow selected in the config.
+func authorize(ctx context.Context, secrets *ClientSecrets, config *Config) (*Token, error) {
+	switch config.AuthFlow {
+	case AuthFlowDevice:
+		return authorizeWithDeviceCode(ctx, secrets)
+	case AuthFlowLoopback:
+		return authorizeWithLoopback(ctx, secrets, config.AuthPort)
+	default:
+		return authorizeWithPaste(secrets)
+	}
+}
+
+// Asks the user to authorize the application in a browser and paste back the
+// resulting authorization code.
+func authorizeWithPaste(secrets *ClientSecrets) (*Token, error) {
+	params := url.Values{
+		"client_id":     {secrets.ClientID},
+		"redirect_uri":  {pasteRedirectURI},
+		"response_type": {"code"},
+		"scope":         {YouTubeScope},
+		"access_type":   {"offline"},
+		"prompt":        {"consent"},
+	}
+	fmt.Printf("Go to the following link in your browser:\n\n%s?%s\n\n",
+		secrets.AuthURI, params.Encode())
+	fmt.Printf("After granting access, paste the code (or the whole URL) of the page you were redirected to: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	code := strings.TrimSpace(line)
+	if u, err := url.Parse(code); err == nil && u.Query().Get("code") != "" {
+		code = u.Query().Get("code")
+	}
+	return requestToken(secrets, url.Values{
+		"code":         {code},
+		"redirect_uri": {pasteRedirectURI},
+		"grant_type":   {"authorization_code"},
+	})
+}
+
+// Asks the user to enter a code on another device, and polls until they did.
+// Requires client secrets of type "TVs and Limited Input devices".
+// Gives up when ctx is done.
+// https://developers.google.com/identity/protocols/oauth2/limited-input-device
+func authorizeWithDeviceCode(ctx context.Context, secrets *ClientSecrets) (*Token, error) {
+	resp, err := http.PostForm(deviceCodeURI, url.Values{
+		"client_id": {secrets.ClientID},
+		"scope":     {YouTubeScope},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Error requesting device code: %s: %s", resp.Status, body)
+	}
+	var device struct {
+		DeviceCode      string `json:"device_code"`
+		UserCode        string `json:"user_code"`
+		VerificationURL string `json:"verification_url"`
+		ExpiresIn       int64  `json:"expires_in"`
+		Interval        int64  `json:"interval"`
+	}
+	if err := json.Unmarshal(body, &device); err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("On any device, go to %s and enter the code: %s\n",
+		device.VerificationURL, device.UserCode)
+	interval := time.Duration(device.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	deadline := time.Now().Add(time.Duration(device.ExpiresIn) * time.Second)
+	for time.Now().Before(deadline) {
+		if err := sleepContext(ctx, interval); err != nil {
+			return nil, err
+		}
+		token, err := requestToken(secrets, url.Values{
+			"device_code": {device.DeviceCode},
+			"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+		})
+		var tokenErr *tokenError
+		if errors.As(err, &tokenErr) {
+			switch tokenErr.Code {
+			case "authorization_pending":
+				continue
+			case "slow_down":
+				interval += 5 * time.Second
+				continue
+			}
+		}
+		return token, err
+	}
+	return nil, fmt.Errorf("Device code expired before authorization")
+}
+
+// Opens the consent page in the browser and redirects it to a temporary HTTP
+// server on localhost, which captures the authorization code. Port 0 picks
+// any free port. Gives up after loopbackTimeout, or when ctx is done.
+// https://developers.google.com/identity/protocols/oauth2/native-app#redirect-uri_loopback
+func authorizeWithLoopback(ctx context.Context, secrets *ClientSecrets, port int) (*Token, error) {
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		return nil, err
+	}
+	defer listener.Close()
+	redirectURI := "http://" + listener.Addr().String()
+	ctx, cancel := context.WithTimeout(ctx, loopbackTimeout)
+	defer cancel()
+
+	// Only redirects carrying the state sent along with the consent page are
+	// trusted, other local requests to the port could inject a code.
+	state := randomID(16)
+	type result struct {
+		code string
+		err  error
+	}
+	results := make(chan result, 1)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(state)) != 1 {
+			http.Error(w, "Invalid authorization state", http.StatusBadRequest)
+			return
+		}
+		res := result{code: query.Get("code")}
+		if res.code == "" {
+			res.err = fmt.Errorf("Authorization failed: %s", query.Get("error"))
+			http.Error(w, res.err.Error(), http.StatusBadRequest)
+		} else {
+			fmt.Fprintln(w, "Authorization complete, you can close this window.")
+		}
+		select {
+		case results <- res:
+		default:
+		}
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	params := url.Values{
+		"client_id":     {secrets.ClientID},
+		"redirect_uri":  {redirectURI},
+		"response_type": {"code"},
+		"scope":         {YouTubeScope},
+		"access_type":   {"offline"},
+		"prompt":        {"consent"},
+		"state":         {state},
+	}
+	authURL := secrets.AuthURI + "?" + params.Encode()
+	if err := openBrowser(authURL); err != nil {
+		fmt.Printf("Go to the following link in your browser:\n\n%s\n\n", authURL)
+	} else {
+		fmt.Printf("Waiting for authorization in the browser. If it did not open, go to:\n\n%s\n\n", authURL)
+	}
+	var res result
+	select {
+	case res = <-results:
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("Authorization not completed within %s", loopbackTimeout)
+		}
+		return nil, ctx.Err()
+	}
+	if res.err != nil {
+		return nil, res.err
+	}
+	return requestToken(secrets, url.Values{
+		"code":         {res.code},
+		"redirect_uri": {redirectURI},
+		"grant_type":   {"authorization_code"},
+	})
+}
+
+// Refreshes the access token using the refresh token.
+func refreshToken(secrets *ClientSecrets, token *Token) (*Token, error) {
+	refreshed, err := requestToken(secrets, url.Values{
+		"refresh_token": {token.RefreshToken},
+		"grant_type":    {"refresh_token"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if refreshed.RefreshToken == "" {
+		refreshed.RefreshToken = token.RefreshToken
+	}
+	return refreshed, nil
+}
+
+// Source of valid access tokens, refreshing and caching them as needed.
+// A single source should be shared by all API clients: it is safe for
+// concurrent use, and refreshes hold a lock on the token cache so that
+// concurrent runs of the tool do not clobber each other's tokens.
+type TokenSource struct {
+	secrets  *ClientSecrets
+	config   *Config
+	store    tokenStore
+	lockFile string
+
+	mu    sync.Mutex
+	token *Token
+}
+
+// Returns a token source, going through the authorization flow if no token
+// was cached yet.
+func newTokenSource(secrets *ClientSecrets, config *Config) (*TokenSource, error) {
+	store, lockFile, err := newTokenStore(config)
+	if err != nil {
+		return nil, err
+	}
+	ts := &TokenSource{secrets: secrets, config: config, store: store, lockFile: lockFile}
+	ts.token, err = store.load()
+	if err != nil {
+		if ts.token, err = ts.reauthorize(context.Background()); err != nil {
+			return nil, err
+		}
+	}
+	return ts, nil
+}
+
+// Returns a valid access token. ctx bounds the authorization flow, if the
+// token has to be authorized again.
+func (ts *TokenSource) Token(ctx context.Context) (*Token, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.token.valid() {
+		return ts.token, nil
+	}
+
+	lock, err := acquireFileLock(ts.lockFile, time.Minute, time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	var once sync.Once
+	release := func() { once.Do(lock) }
+	defer release()
+	// Another process may have refreshed the token while we waited.
+	if cached, err := ts.store.load(); err == nil && cached.valid() {
+		ts.token = cached
+		return cached, nil
+	}
+	token, err := refreshToken(ts.secrets, ts.token)
+	var tokenErr *tokenError
+	if errors.As(err, &tokenErr) && tokenErr.Code == "invalid_grant" {
+		// The refresh token was revoked, or expired (e.g. after 7 days for
+		// apps in testing); it is of no further use.
+		log.Printf(">>> Token of profile %s revoked or expired: %v", ts.config.profile, err)
+		if err := ts.store.remove(); err != nil {
+			return nil, err
+		}
+		// The authorization flow waits for the user for longer than the lock
+		// may be held before other processes break it.
+		release()
+		if token, err = ts.reauthorize(ctx); err != nil {
+			return nil, err
+		}
+		ts.token = token
+		return token, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	ts.token = token
+	if err := ts.store.save(token); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// Runs the authorization flow and caches the resulting token. Fails when
+// nobody is there to complete it, e.g. when running from cron.
+func (ts *TokenSource) reauthorize(ctx context.Context) (*Token, error) {
+	if !isInteractive() {
+		return nil, fmt.Errorf("%w: run `gopro-uploader auth login --profile %s`",
+			errAuthRequired, ts.config.profile)
+	}
+	token, err := authorize(ctx, ts.secrets, ts.config)
+	if err != nil {
+		return nil, err
+	}
+	if err := ts.store.save(token); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// HTTP transport authorizing each request with the current access token.
+type authTransport struct {
+	source *TokenSource
+	base   http.RoundTripper
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.source.Token(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	return t.base.RoundTrip(req)
+}
+
+// Returns an HTTP client authorized to call the YouTube API.
+func newAuthorizedClient(source *TokenSource) *http.Client {
+	return &http.Client{
+		Transport: &tracingTransport{base: &authTransport{source: source, base: http.DefaultTransport}},
+	}
+}
+
+// Information about an access token.
+type tokenInfo struct {
+	Scope     string `json:"scope"`
+	ExpiresIn string `json:"expires_in"`
+}
+
+// Looks up the scopes granted to an access token.
+func fetchTokenInfo(token *Token) (*tokenInfo, error) {
+	resp, err := http.Get(tokenInfoURI + "?" + url.Values{"access_token": {token.AccessToken}}.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Error fetching token info: %s: %s", resp.Status, body)
+	}
+	var info tokenInfo
+	if err := json.Unmarshal(body, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// Revokes a token, invalidating both the refresh token and the access tokens
+// obtained with it.
+func revokeToken(token *Token) error {
+	value := token.RefreshToken
+	if value == "" {
+		value = token.AccessToken
+	}
+	resp, err := http.PostForm(revokeURI, url.Values{"token": {value}})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		var tokenErr tokenError
+		if json.Unmarshal(body, &tokenErr) == nil && tokenErr.Code != "" {
+			return &tokenErr
+		}
+		return fmt.Errorf("Error revoking token: %s: %s", resp.Status, body)
+	}
+	return nil
+}
+
+// Prints which channel a profile is authorized for, with which scopes and
+// until when.
+func printAuthStatus(secrets *ClientSecrets, config *Config, store tokenStore, lockFile string) error {
+	fmt.Printf("Profile: %s\n", config.profile)
+	fmt.Printf("Token store: %s\n", config.TokenStore)
+	token, err := store.load()
+	if err != nil {
+		fmt.Printf("Status: not authorized, run `gopro-uploader auth login --profile %s`\n", config.profile)
+		return nil
+	}
+	if !token.valid() {
+		refreshed, err := refreshToken(secrets, token)
+		var tokenErr *tokenError
+		if errors.As(err, &tokenErr) && tokenErr.Code == "invalid_grant" {
+			fmt.Printf("Status: revoked or expired (%v), run `gopro-uploader auth login --profile %s`\n",
+				err, config.profile)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		token = refreshed
+		if err := store.save(token); err != nil {
+			return err
+		}
+	}
+	info, err := fetchTokenInfo(token)
+	if err != nil {
+		return err
+	}
+	ts := &TokenSource{secrets: secrets, config: config, store: store, lockFile: lockFile, token: token}
+	yt := &YouTube{client: newAuthorizedClient(ts)}
+	channel, err := yt.channel(context.Background())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Status: authorized\n")
+	fmt.Printf("Channel: %s (https://www.youtube.com/channel/%s)\n", channel.Snippet.Title, channel.ID)
+	fmt.Printf("Scopes: %s\n", strings.Join(strings.Fields(info.Scope), ", "))
+	fmt.Printf("Access token expires: %s\n", token.Expiry.Local().Format(time.RFC1123))
+	if token.RefreshToken == "" {
+		fmt.Printf("Refresh token: none, authorization is needed again once the access token expires\n")
+	}
+	return nil
+}
+
+// Manages the cached credentials of a profile.
+func runAuthCommand(args []string) {
+	if len(args) == 0 {
+		fatalf("Usage: auth login|status|logout")
+	}
+	flags := flag.NewFlagSet("auth "+args[0], flag.ExitOnError)
+	readConfig := configFlags(flags)
+	setupLogging := logFlags(flags)
+	flags.Parse(args[1:])
+	setupLogging()
+	config, err := readConfig()
+	if err != nil {
+		fatal(err)
+	}
+	store, lockFile, err := newTokenStore(config)
+	if err != nil {
+		fatal(err)
+	}
+	loadSecrets := func() *ClientSecrets {
+		if config.ClientSecrets == "" {
+			fatalf("client_secrets must be configured to authorize uploads")
+		}
+		secrets, err := loadClientSecrets(config.ClientSecrets)
+		if err != nil {
+			fatal(err)
+		}
+		return secrets
+	}
+
+	switch args[0] {
+	case "login":
+		token, err := authorize(context.Background(), loadSecrets(), config)
+		if err != nil {
+			fatal(err)
+		}
+		if err := store.save(token); err != nil {
+			fatal(err)
+		}
+		log.Printf(">>> Authorized profile %s", config.profile)
+	case "status":
+		if err := printAuthStatus(loadSecrets(), config, store, lockFile); err != nil {
+			fatal(err)
+		}
+	case "logout":
+		token, err := store.load()
+		if err != nil {
+			log.Printf(">>> Profile %s is not authorized", config.profile)
+			return
+		}
+		// The token is deleted even if revoking fails, e.g. when it was already
+		// revoked from the Google account settings.
+		if err := revokeToken(token); err != nil {
+			warnf(">>> Could not revoke token: %v", err)
+		}
+		if err := store.remove(); err != nil {
+			fatal(err)
+		}
+		log.Printf(">>> Logged out profile %s", config.profile)
+	default:
+		fatalf("Unknown auth command %q", args[0])
+	}
+}
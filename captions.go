@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// Name of the chapter list caption track of uploaded videos.
+const chapterCaptionName = "Chapters"
+
+// A caption track to upload. file returns its SubRip file, written to tmpDir
+// if need be.
+type captionTrack struct {
+	name     string
+	language string
+	file     func(tmpDir string) (string, error)
+}
+
+// Returns the caption tracks of a video, as configured.
+func captionTracks(config *Config, video Video, outputDir string) []captionTrack {
+	var tracks []captionTrack
+	if config.ChapterCaptions && len(video.Chapters) > 1 {
+		// Chapter announcements are only in English.
+		tracks = append(tracks, captionTrack{chapterCaptionName, "en", func(tmpDir string) (string, error) {
+			fileName := filepath.Join(tmpDir, "chapters"+SubtitlesExt)
+			return fileName, writeAnnouncementCaptions(video, fileName)
+		}})
+	}
+	if s := config.TelemetrySubtitles; s.appliesTo(video) && s.Upload {
+		// Written along with the render, see TelemetrySubtitles.args.
+		tracks = append(tracks, captionTrack{telemetryCaptionName, s.language(), func(string) (string, error) {
+			fileName := filepath.Join(outputDir, video.Title+SubtitlesExt)
+			_, err := os.Stat(fileName)
+			return fileName, err
+		}})
+	}
+	return tracks
+}
+
+// Uploads the caption tracks of an uploaded video not uploaded yet, see
+// Config.ChapterCaptions and TelemetrySubtitles.Upload. Failures are only
+// logged, and retried on the next run.
+func uploadCaptions(ctx context.Context, yt *YouTube, state *State, config *Config, entry *VideoState, outputDir string) {
+	if entry.VideoID == "" {
+		return
+	}
+	for _, track := range captionTracks(config, entry.Video, outputDir) {
+		if _, ok := entry.Captions[track.name]; ok {
+			continue
+		}
+		if err := uploadCaption(ctx, yt, state, entry, track); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			warnf(">>> Could not upload %s captions of %s: %v", track.name, entry.Title, err)
+		}
+	}
+}
+
+func uploadCaption(ctx context.Context, yt *YouTube, state *State, entry *VideoState, track captionTrack) error {
+	tmpDir, err := ioutil.TempDir("", "gopro-uploader")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+	fileName, err := track.file(tmpDir)
+	if err != nil {
+		return err
+	}
+	log.Printf(">>> Uploading %s captions of %s", track.name, entry.Title)
+	id, err := yt.insertCaption(ctx, entry.VideoID, track.language, track.name, fileName)
+	if err != nil {
+		return err
+	}
+	return state.update(func() {
+		if entry.Captions == nil {
+			entry.Captions = map[string]string{}
+		}
+		entry.Captions[track.name] = id
+	})
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// Identifies an SD card, and the reader slot it was imported from.
+type CardInfo struct {
+	ID   string `json:"id"`
+	Slot string `json:"slot"`
+}
+
+// Chapters imported from a card, so that when it goes bad the footage that
+// came off it can be listed and verified.
+type CardRecord struct {
+	ID      string       `json:"id"`
+	Imports []CardImport `json:"imports"`
+}
+
+type CardImport struct {
+	Time  time.Time      `json:"time"`
+	Slot  string         `json:"slot"`
+	Files []ImportedFile `json:"files"`
+}
+
+// A chapter file as it was copied into the input directory.
+type ImportedFile struct {
+	Path     string `json:"path"`
+	Size     int64  `json:"size"`
+	Checksum string `json:"sha256"`
+}
+
+// Records chapters imported from a card.
+func (s *State) recordImport(card CardInfo, files []ImportedFile) error {
+	return s.update(func() {
+		if s.Cards == nil {
+			s.Cards = map[string]*CardRecord{}
+		}
+		record, ok := s.Cards[card.ID]
+		if !ok {
+			record = &CardRecord{ID: card.ID}
+			s.Cards[card.ID] = record
+		}
+		record.Imports = append(record.Imports, CardImport{Time: time.Now(), Slot: card.Slot, Files: files})
+	})
+}
+
+// Returns the titles of the videos containing each chapter file, by path.
+func (s *State) videosByChapter() map[string][]string {
+	results := map[string][]string{}
+	for _, entry := range s.Videos {
+		for _, chapter := range entry.Video.Chapters {
+			path := filepath.Join(entry.Video.Path, chapter.FileName)
+			results[path] = append(results[path], entry.Title)
+		}
+	}
+	return results
+}
+
+// Lists the cards chapters were imported from, shows the chapters imported
+// from one of them, or verifies that they are intact.
+func runCardsCommand(args []string) {
+	if len(args) == 0 {
+		fatalf("Usage: cards list|show|verify")
+	}
+	flags := flag.NewFlagSet("cards "+args[0], flag.ExitOnError)
+	outputDir := flags.String("output_dir", "", "Directory in which rendered video files are output.")
+	setupLogging := logFlags(flags)
+	cardIDs := parseInterspersed(flags, args[1:])
+	setupLogging()
+	if *outputDir == "" {
+		fatalf("--output_dir cannot be empty")
+	}
+	state, err := loadState(*outputDir)
+	if err != nil {
+		fatal(err)
+	}
+	cardRecord := func() *CardRecord {
+		if len(cardIDs) != 1 {
+			fatalf("Usage: cards %s <card>", args[0])
+		}
+		record, ok := state.Cards[cardIDs[0]]
+		if !ok {
+			fatalf("No chapters were imported from card %q", cardIDs[0])
+		}
+		return record
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+	switch args[0] {
+	case "list":
+		var records []*CardRecord
+		for _, record := range state.Cards {
+			records = append(records, record)
+		}
+		sort.Slice(records, func(i, j int) bool {
+			return records[i].ID < records[j].ID
+		})
+		fmt.Fprintln(w, "CARD\tLAST IMPORT\tSLOT\tIMPORTS\tFILES\tSIZE")
+		for _, record := range records {
+			var files int
+			var size int64
+			for _, imp := range record.Imports {
+				files += len(imp.Files)
+				for _, file := range imp.Files {
+					size += file.Size
+				}
+			}
+			last := record.Imports[len(record.Imports)-1]
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%.1fG\n", record.ID, last.Time.Format("2006-01-02 15:04"),
+				last.Slot, len(record.Imports), files, float64(size)/(1<<30))
+		}
+	case "show":
+		videos := state.videosByChapter()
+		fmt.Fprintln(w, "IMPORTED\tSIZE\tFILE\tVIDEOS")
+		for _, imp := range cardRecord().Imports {
+			for _, file := range imp.Files {
+				titles := videos[file.Path]
+				if len(titles) == 0 {
+					titles = []string{"-"}
+				}
+				fmt.Fprintf(w, "%s\t%.1fG\t%s\t%s\n", imp.Time.Format("2006-01-02 15:04"),
+					float64(file.Size)/(1<<30), file.Path, titles[0])
+				for _, title := range titles[1:] {
+					fmt.Fprintf(w, "\t\t\t%s\n", title)
+				}
+			}
+		}
+	case "verify":
+		var failed int
+		fmt.Fprintln(w, "RESULT\tFILE")
+		for _, imp := range cardRecord().Imports {
+			for _, file := range imp.Files {
+				result := "ok"
+				checksum, err := fileChecksum(file.Path)
+				switch {
+				case os.IsNotExist(err):
+					result = "missing"
+				case err != nil:
+					result = err.Error()
+				case checksum != file.Checksum:
+					result = "changed"
+				}
+				if result != "ok" {
+					failed++
+				}
+				fmt.Fprintf(w, "%s\t%s\n", result, file.Path)
+			}
+		}
+		w.Flush()
+		if failed > 0 {
+			fatalf("%d files imported from card %s are missing or changed", failed, cardIDs[0])
+		}
+	default:
+		fatalf("Unknown cards command %q", args[0])
+	}
+}
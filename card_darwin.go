@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Identifies the card mounted at path by its volume UUID, as reported by
+// diskutil. The slot is the device node of the card reader, e.g. /dev/disk4s1.
+func cardInfo(path string) (CardInfo, error) {
+	out, err := exec.Command("df", "-P", path).Output()
+	if err != nil {
+		return CardInfo{}, err
+	}
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	fields := strings.Fields(lines[len(lines)-1])
+	if len(fields) < 6 {
+		return CardInfo{}, fmt.Errorf("Could not identify the card of %s", path)
+	}
+	// The mount point may contain spaces, but the device does not.
+	out, err = exec.Command("diskutil", "info", fields[0]).Output()
+	if err != nil {
+		return CardInfo{}, err
+	}
+	var info CardInfo
+	for _, line := range strings.Split(string(out), "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "Volume UUID":
+			info.ID = strings.TrimSpace(value)
+		case "Device Node":
+			info.Slot = strings.TrimSpace(value)
+		}
+	}
+	if info.ID == "" {
+		return CardInfo{}, fmt.Errorf("Could not identify the card of %s: no volume UUID", path)
+	}
+	return info, nil
+}
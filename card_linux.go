@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// Identifies the card mounted at path by the UUID of its file system (the
+// volume serial number of FAT and exFAT cards), found in /dev/disk/by-uuid.
+// The slot is the device of the card reader, e.g. /dev/sdb1.
+func cardInfo(path string) (CardInfo, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return CardInfo{}, err
+	}
+	m, err := findMount(abs)
+	if err != nil {
+		return CardInfo{}, err
+	}
+	device, err := filepath.EvalSymlinks(m.Source)
+	if err != nil {
+		return CardInfo{}, fmt.Errorf("Could not identify the card of %s: %v", path, err)
+	}
+	links, _ := filepath.Glob("/dev/disk/by-uuid/*")
+	for _, link := range links {
+		if target, err := filepath.EvalSymlinks(link); err == nil && target == device {
+			return CardInfo{ID: filepath.Base(link), Slot: device}, nil
+		}
+	}
+	return CardInfo{}, fmt.Errorf("Could not identify the card of %s: no UUID for %s", path, m.Source)
+}
@@ -0,0 +1,10 @@
+//go:build !darwin && !linux && !windows
+// +build !darwin,!linux,!windows
+
+package main
+
+import "errors"
+
+func cardInfo(path string) (CardInfo, error) {
+	return CardInfo{}, errors.New("Cards cannot be identified on this platform, pass --card")
+}
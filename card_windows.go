@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	procGetVolumePathNameW    = syscall.NewLazyDLL("kernel32.dll").NewProc("GetVolumePathNameW")
+	procGetVolumeInformationW = syscall.NewLazyDLL("kernel32.dll").NewProc("GetVolumeInformationW")
+)
+
+// Identifies the card mounted at path by its volume serial number. The slot
+// is the drive, e.g. E:\.
+func cardInfo(path string) (CardInfo, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return CardInfo{}, err
+	}
+	p, err := syscall.UTF16PtrFromString(abs)
+	if err != nil {
+		return CardInfo{}, err
+	}
+	root := make([]uint16, syscall.MAX_PATH+1)
+	if ret, _, err := procGetVolumePathNameW.Call(uintptr(unsafe.Pointer(p)),
+		uintptr(unsafe.Pointer(&root[0])), uintptr(len(root))); ret == 0 {
+		return CardInfo{}, err
+	}
+	var serial uint32
+	if ret, _, err := procGetVolumeInformationW.Call(uintptr(unsafe.Pointer(&root[0])), 0, 0,
+		uintptr(unsafe.Pointer(&serial)), 0, 0, 0, 0); ret == 0 {
+		return CardInfo{}, err
+	}
+	return CardInfo{
+		ID:   fmt.Sprintf("%04X-%04X", serial>>16, serial&0xffff),
+		Slot: syscall.UTF16ToString(root),
+	}, nil
+}
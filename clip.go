@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Subdirectory of the output directory in which clips are extracted.
+const ClipsDir = "clips"
+
+// Formats a timestamp for use in file names, e.g. 1.02.03 for 1:02:03.
+func fmtTimestampForFileName(d time.Duration) string {
+	return strings.Replace(fmtDurationForYouTube(d), ":", ".", -1)
+}
+
+// Extracts part of a rendered video. Stream copy starts at the keyframe
+// preceding from; accurate clips are re-encoded to start exactly at it.
+func extractClip(ctx context.Context, inputFname, outputFname string, from, to time.Duration, accurate bool) error {
+	args := []string{"-v", "warning",
+		"-ss", fmt.Sprintf("%.3f", from.Seconds()),
+		"-i", inputFname,
+		"-t", fmt.Sprintf("%.3f", (to - from).Seconds())}
+	if accurate {
+		args = append(args, "-c:v", "libx264", "-crf", "18", "-preset", "medium", "-c:a", "aac", "-b:a", "192k")
+	} else {
+		args = append(args, "-c", "copy", "-avoid_negative_ts", "make_zero")
+	}
+	args = append(args, "-movflags", "+faststart", outputFname, "-y", "-stats")
+	cmd := exec.CommandContext(ctx, binaryPath("ffmpeg"), args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return runCommand(execRunner{}, cmd)
+}
+
+// Extracts a share-ready clip from a rendered video, optionally uploading it
+// as unlisted.
+func runClipCommand(args []string) {
+	flags := flag.NewFlagSet("clip", flag.ExitOnError)
+	outputDir := flags.String("output_dir", "", "Directory containing the rendered video files.")
+	from := flags.String("from", "0", "Start of the clip, e.g. 12:30.")
+	to := flags.String("to", "", "End of the clip, e.g. 14:00.")
+	accurate := flags.Bool("accurate", false, "If true, re-encodes the clip so it starts exactly at --from instead of the preceding keyframe.")
+	upload := flags.Bool("upload", false, "If true, uploads the clip to YouTube as unlisted.")
+	readConfig := configFlags(flags)
+	setupLogging := logFlags(flags)
+	titles := parseInterspersed(flags, args)
+	setupLogging()
+	if len(titles) != 1 {
+		fatalf("Usage: clip <video-title> --from 12:30 --to 14:00")
+	}
+	if *outputDir == "" {
+		fatalf("--output_dir cannot be empty")
+	}
+	if *to == "" {
+		fatalf("--to cannot be empty")
+	}
+	start, err := parseTimestamp(*from)
+	if err != nil {
+		fatal(err)
+	}
+	end, err := parseTimestamp(*to)
+	if err != nil {
+		fatal(err)
+	}
+	if end <= start {
+		fatalf("--to must be after --from")
+	}
+	config, err := readConfig()
+	if err != nil {
+		fatal(err)
+	}
+	checkDependencies("ffmpeg")
+	ctx, stop := interruptContext()
+	defer stop()
+
+	title := titles[0]
+	inputFname := filepath.Join(*outputDir, title+VideoExt)
+	if _, err := os.Stat(inputFname); err != nil {
+		fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(*outputDir, ClipsDir), os.ModePerm); err != nil {
+		fatal(err)
+	}
+	clipTitle := fmt.Sprintf("%s (%s - %s)", title, fmtDurationForYouTube(start), fmtDurationForYouTube(end))
+	outputFname := filepath.Join(*outputDir, ClipsDir, fmt.Sprintf("%s %s-%s%s",
+		title, fmtTimestampForFileName(start), fmtTimestampForFileName(end), VideoExt))
+	log.Printf(">>> Extracting %s", outputFname)
+	if err := extractClip(ctx, inputFname, outputFname, start, end, *accurate); err != nil {
+		fatal(err)
+	}
+	if !*upload {
+		return
+	}
+
+	state, err := loadState(*outputDir)
+	if err != nil {
+		fatal(err)
+	}
+	yt, err := connectYouTube(config, state)
+	if err != nil {
+		fatal(err)
+	}
+	description := fmt.Sprintf("Clip of %s from %s to %s.", title,
+		fmtDurationForYouTube(start), fmtDurationForYouTube(end))
+	if entry, ok := state.Videos[title]; ok && entry.VideoID != "" {
+		description += fmt.Sprintf("\nFull video: https://youtu.be/%s?t=%d", entry.VideoID, int64(start.Seconds()))
+	}
+	log.Printf(">>> Uploading %s", outputFname)
+	result, err := yt.upload(ctx, outputFname, &YouTubeVideo{
+		Snippet: &YouTubeVideoSnippet{Title: youtubeTitle(clipTitle), Description: youtubeDescription(description)},
+		Status:  &YouTubeVideoStatus{PrivacyStatus: "unlisted"},
+	}, "")
+	saveErr := state.save()
+	if err != nil {
+		fatal(err)
+	}
+	if saveErr != nil {
+		fatal(saveErr)
+	}
+	log.Printf(">>> Uploaded https://youtu.be/%s", result.ID)
+}
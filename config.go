@@ -0,0 +1,600 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule used to stagger the public release of uploaded videos.
+type PublishSchedule struct {
+	// Time of day (HH:MM, local time) at which videos go public.
+	Time string `json:"time"`
+	// Interval between two consecutive releases, e.g. "24h".
+	Interval string `json:"interval"`
+}
+
+// How rendered videos are made web-optimized (moov box before media data).
+const (
+	// Pass -movflags +faststart to ffmpeg when rendering.
+	FastStartInline = "inline"
+	// Relocate the moov box in a separate pass after rendering.
+	FastStartPostPass = "postpass"
+	FastStartOff      = "off"
+)
+
+type Config struct {
+	// Name of the profile the configuration belongs to.
+	profile string
+	// Contents of the DescriptionTemplate file.
+	descriptionTemplate string
+
+	// Defaults of the --input_dir, --output_dir and --prefix flags.
+	InputDir  string `json:"input_dir"`
+	OutputDir string `json:"output_dir"`
+	Prefix    string `json:"prefix"`
+	// Path to the OAuth client secrets downloaded from the Google API console.
+	ClientSecrets string `json:"client_secrets"`
+	// One of AuthFlowPaste (default), AuthFlowDevice or AuthFlowLoopback.
+	AuthFlow string `json:"auth_flow"`
+	// Port of the local server of the loopback flow, or 0 for any free port.
+	// Set it if a firewall only allows specific ports.
+	AuthPort int `json:"auth_port"`
+	// One of TokenStoreFile (default) or TokenStoreKeychain.
+	TokenStore string `json:"token_store"`
+	// Template of video titles, see TitleData. Videos are identified by their
+	// title, so changing it renders and uploads them again.
+	TitleTemplate string `json:"title_template"`
+	// Path to a template file of video descriptions, see DescriptionData.
+	// Sidecar descriptions take precedence.
+	DescriptionTemplate string `json:"description_template"`
+	// Language of the titles and descriptions of videos, e.g. "en", which
+	// YouTube shows translations instead of to viewers in other languages.
+	Language string `json:"language"`
+	// Translated titles and descriptions of uploaded videos, by language.
+	Translations map[string]*Translation `json:"translations"`
+	// If true, the chapter list of uploaded videos is also uploaded as a
+	// caption track announcing each chapter, see chapterAnnouncement.
+	ChapterCaptions bool `json:"chapter_captions"`
+	// Privacy status for uploaded videos: private, unlisted or public.
+	Privacy string `json:"privacy"`
+	// If set, videos are uploaded as private and published on this schedule.
+	PublishSchedule *PublishSchedule `json:"publish_schedule"`
+	// Glob patterns (relative to the input directory) of folders whose videos
+	// are uploaded as public.
+	PublicPaths []string `json:"public_paths"`
+	// One of FastStartInline (default), FastStartPostPass or FastStartOff.
+	FastStart string `json:"faststart"`
+	// One of AnnounceOff (default), AnnounceCaptions or AnnounceAudio.
+	ChapterAnnouncements string `json:"chapter_announcements"`
+	// Variant of rendered videos to upload when present, e.g. "youtube" for
+	// <title>.youtube.mp4, instead of the archive master <title>.mp4.
+	UploadVariant string `json:"upload_variant"`
+	// If true, the first GPS fix of a video is set as its recording location.
+	RecordLocation bool `json:"record_location"`
+	// Rules applied to recording locations before they are published.
+	LocationPrivacy *LocationPrivacy `json:"location_privacy"`
+	// If true, the recording location, camera and date are embedded into
+	// rendered videos, see embeddedMetadataArgs.
+	EmbedMetadata bool `json:"embed_metadata"`
+	// How long to wait for YouTube to process an upload before giving up until
+	// the next run, e.g. "2h".
+	VerifyTimeout string `json:"verify_timeout"`
+	// Deadlines for rendering and uploading a single video, e.g. "6h", so that
+	// a stuck ffmpeg or upload does not hold up the others. Unlimited if empty.
+	RenderTimeout string `json:"render_timeout"`
+	UploadTimeout string `json:"upload_timeout"`
+	// If set, the chapters of a folder are split into several videos where
+	// more than this passed between two chapters, e.g. "45m".
+	SplitGap string `json:"split_gap"`
+	// One of GroupByFolder (default), GroupByDate or GroupByNumber.
+	GroupBy string `json:"group_by"`
+	// Which footage is processed, all by default.
+	Filters Filters `json:"filters"`
+	// IANA zone recording times are shown in, in titles, descriptions and
+	// listings, e.g. "Europe/Paris". UTC, as recorded by cameras, by default.
+	Timezone string `json:"timezone"`
+	// ffmpeg and ffprobe binaries to run instead of those in PATH, e.g.
+	// "/opt/ffmpeg6/bin/ffmpeg".
+	FFmpegPath  string `json:"ffmpeg_path"`
+	FFprobePath string `json:"ffprobe_path"`
+	// How footage of several cameras in the same video is handled: one of
+	// MulticamOff (default), MulticamSplit or MulticamInterleave.
+	Multicam string `json:"multicam"`
+	// Labels of cameras by serial number, e.g. {"c3441325...": "Helmet"}.
+	Cameras map[string]string `json:"cameras"`
+	// If set, chapters of a folder mixing resolutions or frame rates are
+	// conformed to these settings instead of being split into parts.
+	Conform *Conform `json:"conform"`
+	// If set, audio is normalized to LoudnessTarget LUFS when rendering,
+	// DefaultLoudnessTarget if zero.
+	Loudnorm       bool    `json:"loudnorm"`
+	LoudnessTarget float64 `json:"loudness_target"`
+	// If set, idle footage is cut from chapters according to their telemetry.
+	TrimIdle *IdleTrim `json:"trim_idle"`
+	// Clips inserted into rendered videos, none if unset.
+	Inserts *Inserts `json:"inserts"`
+	// If true, a metadata file is written next to each rendered video, see
+	// VideoMetadata.
+	MetadataFiles bool `json:"metadata_files"`
+	// If true, a Kodi NFO file and a thumbnail are written next to each
+	// rendered video, for media servers like Jellyfin.
+	NFO bool `json:"nfo"`
+	// If set, a contact sheet of each rendered video is written to StillsDir.
+	ContactSheet *ContactSheet `json:"contact_sheet"`
+	// Logo overlaid on uploaded videos which are reencoded, see Watermark.
+	Watermark *Watermark `json:"watermark"`
+	// If set, telemetry gauges are burnt into rendered videos.
+	TelemetryOverlay *TelemetryOverlay `json:"telemetry_overlay"`
+	// If set, telemetry subtitles are written next to rendered videos.
+	TelemetrySubtitles *TelemetrySubtitles `json:"telemetry_subtitles"`
+	// If set, music is mixed into rendered videos.
+	Music *Music `json:"music"`
+	// Encode profile of uploaded videos, e.g. EncodeAV1: rendered videos are
+	// reencoded with it to a variant named after it, which is uploaded. It
+	// is also used for the reencodes of 360 conversion, picture-in-picture
+	// and conform. Videos are uploaded as recorded if empty.
+	EncodeProfile string `json:"encode_profile"`
+	// Extra encoder arguments of the encode profile, e.g. ["-crf", "20"].
+	EncodeArgs []string `json:"encode_args"`
+	// Layout of the overlays with MulticamPiP.
+	PiP PiPLayout `json:"pip"`
+	// Extensions of chapter files, case insensitive, DefaultChapterExtensions
+	// by default.
+	ChapterExtensions []string `json:"chapter_extensions"`
+	// Whether reports and previews use the thumbnails and low resolution
+	// proxies GoPro records next to chapters (.THM and .LRV files), when
+	// present, rather than decoding the chapters.
+	LowResPreviews bool `json:"low_res_previews"`
+	// How many levels of folders below the input directory are traversed,
+	// unlimited if zero.
+	MaxDepth int `json:"max_depth"`
+	// If set, folders this many levels below the input directory are rendered
+	// together with their subfolders, e.g. 2 for Trip/Day 1/Hour 1, split
+	// where recordings do not continue, see SplitRules.merging.
+	MergeDepth int `json:"merge_depth"`
+	// Glob patterns of folder names never traversed, DefaultSkipDirs by
+	// default. An empty list traverses all folders.
+	SkipDirs []string `json:"skip_dirs"`
+	// If set, chapters which cannot be read, e.g. empty or corrupt files, are
+	// moved to this directory rather than only reported, see Quarantine.
+	QuarantineDir string `json:"quarantine_dir"`
+	// If true, chapters found several times, e.g. copied into two folders,
+	// are only rendered in the first video holding them, see dedupeChapters.
+	// Otherwise they are only warned about.
+	DedupeChapters bool `json:"dedupe_chapters"`
+	// Limits of a rendered video, e.g. "12h" and "256G" (the YouTube limits,
+	// by default). Longer or larger folders are split into several videos.
+	MaxVideoDuration string `json:"max_video_duration"`
+	MaxVideoSize     string `json:"max_video_size"`
+	// Daily YouTube API quota of the project owning the client secrets.
+	DailyQuota int `json:"daily_quota"`
+	// Maximum amount of data uploaded per calendar month, e.g. "200G", for
+	// metered connections.
+	MonthlyDataCap string `json:"monthly_data_cap"`
+	// Number of destinations which must hold a copy of a video, verified by
+	// checksum, before its chapters may be deleted or moved to cold storage.
+	MinVerifiedCopies int `json:"min_verified_copies"`
+	// Rules used to order the work queue, see queue.go.
+	QueuePriority []string `json:"queue_priority"`
+	// Where to send notifications about uploads, see notify.go.
+	Destinations []NotificationDestination `json:"destinations"`
+}
+
+// Extensions of chapter files: MP4 only, others like .mov, or .360 for GoPro
+// MAX footage, are opt-in.
+var DefaultChapterExtensions = []string{VideoExt}
+
+// Folders skipped when traversing input directories: hidden ones (e.g.
+// .Trash-1000), and those of NAS and operating systems.
+var DefaultSkipDirs = []string{".*", "@eaDir", "#recycle", "#snapshot", "$RECYCLE.BIN", "System Volume Information", "lost+found"}
+
+// Returns the configuration used when no config file is given.
+func defaultConfig() *Config {
+	return &Config{
+		AuthFlow:             AuthFlowPaste,
+		TokenStore:           TokenStoreFile,
+		TitleTemplate:        DefaultTitleTemplate,
+		Privacy:              "private",
+		FastStart:            FastStartInline,
+		ChapterAnnouncements: AnnounceOff,
+		GroupBy:              GroupByFolder,
+		SkipDirs:             DefaultSkipDirs,
+		ChapterExtensions:    DefaultChapterExtensions,
+		VerifyTimeout:        "2h",
+		DailyQuota:           DefaultDailyQuota,
+		MinVerifiedCopies:    1,
+		MaxVideoDuration:     "12h",
+		MaxVideoSize:         "256G",
+		PiP:                  PiPLayout{Corner: PiPBottomRight, Size: 0.3},
+	}
+}
+
+// Name of the profile used when none is given.
+const DefaultProfile = "default"
+
+// Returns the path of the default config file of a profile. Config files in
+// ~/.gopro-uploader, where earlier versions looked for them, are still used
+// if there is none in the config directory.
+func profileConfigFile(profile string) (string, error) {
+	dir, err := appConfigDir()
+	if err != nil {
+		return "", err
+	}
+	fileName := filepath.Join(dir, profile+".json")
+	if _, err := os.Stat(fileName); err == nil {
+		return fileName, nil
+	}
+	legacyName, err := legacyFile(".gopro-uploader", profile+".json")
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(legacyName); err == nil {
+		return legacyName, nil
+	}
+	return fileName, nil
+}
+
+// Registers the --config and --profile flags, and returns a function loading
+// the configuration once flags are parsed.
+func configFlags(flags *flag.FlagSet) func() (*Config, error) {
+	configFile := flags.String("config", "", "Path to a JSON configuration file. Defaults to <profile>.json in the user config directory if present.")
+	profile := flags.String("profile", DefaultProfile, "Named account profile, with its own credentials and default config.")
+	ffmpegPath := flags.String("ffmpeg_path", "", "If set, the ffmpeg binary to run, e.g. /opt/ffmpeg6/bin/ffmpeg. Defaults to ffmpeg_path of the config file, or ffmpeg in PATH.")
+	ffprobePath := flags.String("ffprobe_path", "", "If set, the ffprobe binary to run. Defaults to ffprobe_path of the config file, or ffprobe in PATH.")
+	return func() (*Config, error) {
+		config, err := loadConfig(*configFile, *profile)
+		if err != nil {
+			return nil, err
+		}
+		if *ffmpegPath != "" {
+			config.FFmpegPath = *ffmpegPath
+		}
+		if *ffprobePath != "" {
+			config.FFprobePath = *ffprobePath
+		}
+		config.setBinaries()
+		if err := config.setTimezone(); err != nil {
+			return nil, err
+		}
+		return config, nil
+	}
+}
+
+// Loads the configuration of a profile from a JSON file, on top of the
+// defaults. Without file name, the profile's default config file is used if
+// it exists.
+func loadConfig(fileName, profile string) (*Config, error) {
+	config := defaultConfig()
+	config.profile = profile
+	if fileName == "" {
+		defaultFile, err := profileConfigFile(profile)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := os.Stat(defaultFile); err != nil {
+			return config, nil
+		}
+		fileName = defaultFile
+	}
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("Error parsing config %s: %v", fileName, err)
+	}
+	if config.DescriptionTemplate != "" {
+		text, err := ioutil.ReadFile(config.DescriptionTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing config %s: %v", fileName, err)
+		}
+		config.descriptionTemplate = string(text)
+	}
+	if err := loadTranslations(config.Translations); err != nil {
+		return nil, fmt.Errorf("Error parsing config %s: %v", fileName, err)
+	}
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("Error parsing config %s: %v", fileName, err)
+	}
+	return config, nil
+}
+
+// Verifies that the configuration values are usable.
+func (c *Config) validate() error {
+	switch c.AuthFlow {
+	case AuthFlowPaste, AuthFlowDevice, AuthFlowLoopback:
+	default:
+		return fmt.Errorf("invalid auth flow %q", c.AuthFlow)
+	}
+	switch c.TokenStore {
+	case TokenStoreFile, TokenStoreKeychain:
+	default:
+		return fmt.Errorf("invalid token store %q", c.TokenStore)
+	}
+	switch c.Privacy {
+	case "private", "unlisted", "public":
+	default:
+		return fmt.Errorf("invalid privacy %q", c.Privacy)
+	}
+	if _, err := parseTitleTemplate(c.TitleTemplate); err != nil {
+		return fmt.Errorf("invalid title template: %v", err)
+	}
+	if c.descriptionTemplate != "" {
+		if _, err := executeDescriptionTemplate(context.Background(), c.descriptionTemplate, Video{}); err != nil {
+			return fmt.Errorf("invalid description template %s: %v", c.DescriptionTemplate, err)
+		}
+	}
+	switch c.FastStart {
+	case FastStartInline, FastStartPostPass, FastStartOff:
+	default:
+		return fmt.Errorf("invalid faststart %q", c.FastStart)
+	}
+	switch c.GroupBy {
+	case GroupByFolder, GroupByDate, GroupByNumber:
+	default:
+		return fmt.Errorf("invalid group by %q", c.GroupBy)
+	}
+	switch c.ChapterAnnouncements {
+	case AnnounceOff, AnnounceCaptions, AnnounceAudio:
+	default:
+		return fmt.Errorf("invalid chapter announcements %q", c.ChapterAnnouncements)
+	}
+	if _, err := time.ParseDuration(c.VerifyTimeout); err != nil {
+		return fmt.Errorf("invalid verify timeout: %v", err)
+	}
+	for _, timeout := range []string{c.RenderTimeout, c.UploadTimeout} {
+		if timeout == "" {
+			continue
+		}
+		if _, err := time.ParseDuration(timeout); err != nil {
+			return fmt.Errorf("invalid stage timeout: %v", err)
+		}
+	}
+	if len(c.ChapterExtensions) == 0 {
+		return fmt.Errorf("chapter extensions cannot be empty")
+	}
+	for _, ext := range c.ChapterExtensions {
+		if len(ext) < 2 || !strings.HasPrefix(ext, ".") {
+			return fmt.Errorf("invalid chapter extension %q, expected e.g. .mov", ext)
+		}
+		if isLowResFile(ext) {
+			return fmt.Errorf("invalid chapter extension %q: proxies and thumbnails are never rendered", ext)
+		}
+	}
+	switch c.Multicam {
+	case MulticamOff, MulticamSplit, MulticamInterleave, MulticamPiP:
+	default:
+		return fmt.Errorf("invalid multicam %q, expected %s, %s or %s", c.Multicam, MulticamSplit, MulticamInterleave, MulticamPiP)
+	}
+	if c.LoudnessTarget != 0 && (c.LoudnessTarget < -70 || c.LoudnessTarget > -5) {
+		return fmt.Errorf("invalid loudness target %v, expected LUFS between -70 and -5", c.LoudnessTarget)
+	}
+	if err := c.TrimIdle.validate(); err != nil {
+		return err
+	}
+	if err := c.Inserts.validate(); err != nil {
+		return err
+	}
+	if err := c.ContactSheet.validate(); err != nil {
+		return err
+	}
+	if err := c.Watermark.validate(); err != nil {
+		return err
+	}
+	if err := c.TelemetryOverlay.validate(); err != nil {
+		return err
+	}
+	if err := c.TelemetrySubtitles.validate(); err != nil {
+		return err
+	}
+	if err := validateTranslations(c.Language, c.Translations); err != nil {
+		return err
+	}
+	if err := c.Music.validate(); err != nil {
+		return err
+	}
+	if err := validateEncodeProfile(c.EncodeProfile); err != nil {
+		return err
+	}
+	if err := c.Conform.validate(); err != nil {
+		return err
+	}
+	if err := c.PiP.validate(); err != nil {
+		return err
+	}
+	if c.Timezone != "" {
+		if _, err := time.LoadLocation(c.Timezone); err != nil {
+			return fmt.Errorf("invalid timezone %q: %v", c.Timezone, err)
+		}
+	}
+	if c.MaxDepth < 0 {
+		return fmt.Errorf("invalid max depth %d", c.MaxDepth)
+	}
+	if c.MergeDepth < 0 {
+		return fmt.Errorf("invalid merge depth %d", c.MergeDepth)
+	}
+	if c.MergeDepth > 0 && c.GroupBy != GroupByFolder {
+		return fmt.Errorf("merge depth needs group by %s", GroupByFolder)
+	}
+	for _, pattern := range c.SkipDirs {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid skip dirs pattern %q", pattern)
+		}
+	}
+	if _, err := c.Filters.compile(); err != nil {
+		return err
+	}
+	if _, err := c.splitRules(); err != nil {
+		return err
+	}
+	if c.MonthlyDataCap != "" {
+		if _, err := parseByteSize(c.MonthlyDataCap); err != nil {
+			return err
+		}
+	}
+	if c.DailyQuota <= 0 {
+		return fmt.Errorf("invalid daily quota %d", c.DailyQuota)
+	}
+	if c.MinVerifiedCopies < 1 {
+		return fmt.Errorf("invalid min verified copies %d", c.MinVerifiedCopies)
+	}
+	for _, pattern := range c.PublicPaths {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid public path %q: %v", pattern, err)
+		}
+	}
+	if c.LocationPrivacy != nil {
+		if err := c.LocationPrivacy.validate(); err != nil {
+			return err
+		}
+	}
+	for ix := range c.Destinations {
+		if err := c.Destinations[ix].validate(); err != nil {
+			return err
+		}
+	}
+	for _, rule := range c.QueuePriority {
+		if !contains(queuePriorityRules, rule) {
+			return fmt.Errorf("invalid queue priority rule %q", rule)
+		}
+	}
+	if c.PublishSchedule != nil {
+		if _, _, err := parseTimeOfDay(c.PublishSchedule.Time); err != nil {
+			return err
+		}
+		interval, err := time.ParseDuration(c.PublishSchedule.Interval)
+		if err != nil {
+			return fmt.Errorf("invalid publish interval: %v", err)
+		}
+		if interval <= 0 {
+			return fmt.Errorf("invalid publish interval: %s", c.PublishSchedule.Interval)
+		}
+	}
+	return nil
+}
+
+// Returns where the chapters of folders are split into several videos, see
+// splitVideo.
+func (c *Config) splitRules() (SplitRules, error) {
+	rules := SplitRules{Conform: c.Conform}
+	if c.SplitGap != "" {
+		gap, err := time.ParseDuration(c.SplitGap)
+		if err != nil || gap <= 0 {
+			return SplitRules{}, fmt.Errorf("invalid split gap %q", c.SplitGap)
+		}
+		rules.Gap = gap
+	}
+	if c.MaxVideoDuration != "" {
+		duration, err := time.ParseDuration(c.MaxVideoDuration)
+		if err != nil || duration <= 0 {
+			return SplitRules{}, fmt.Errorf("invalid max video duration %q", c.MaxVideoDuration)
+		}
+		rules.MaxDuration = duration
+	}
+	if c.MaxVideoSize != "" {
+		size, err := parseByteSize(c.MaxVideoSize)
+		if err != nil || size <= 0 {
+			return SplitRules{}, fmt.Errorf("invalid max video size %q", c.MaxVideoSize)
+		}
+		rules.MaxSize = size
+	}
+	return rules, nil
+}
+
+// Returns the variant of a rendered video to upload: the equirectangular one
+// for 360 videos, since YouTube does not understand the cubemap of the master,
+// the composited one for videos with picture-in-picture overlays, and else
+// the one of the encode profile, if any.
+func (c *Config) uploadVariant(video Video) string {
+	if video.is360() {
+		return Variant360
+	}
+	if len(video.Overlay) > 0 {
+		return VariantPiP
+	}
+	if c.EncodeProfile != "" {
+		// The master itself, if it was conformed with the profile.
+		return c.EncodeProfile
+	}
+	return c.UploadVariant
+}
+
+// Returns the names of the variants renders may have, see listRenderedVideos.
+func (c *Config) variantNames() []string {
+	names := []string{Variant360, VariantPiP}
+	for _, name := range []string{c.EncodeProfile, c.UploadVariant} {
+		if name != "" && !contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Whether a folder, given by its name and depth below the input directory,
+// is left out of traversals.
+func (c *Config) skipsDir(name string, depth int) bool {
+	if c.MaxDepth > 0 && depth > c.MaxDepth {
+		return true
+	}
+	for _, pattern := range c.SkipDirs {
+		if matched, _ := filepath.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the privacy status for videos found in dirPath.
+func (c *Config) privacyFor(dirPath, rootPath string) string {
+	relPath, err := filepath.Rel(rootPath, dirPath)
+	if err != nil {
+		return c.Privacy
+	}
+	for _, pattern := range c.PublicPaths {
+		if matched, _ := filepath.Match(pattern, relPath); matched {
+			return "public"
+		}
+	}
+	return c.Privacy
+}
+
+// Parses a string like 18:00 into hours and minutes.
+func parseTimeOfDay(spec string) (int, int, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("Error parsing time of day: %s", spec)
+	}
+	hour, err := strconv.Atoi(parts[0])
+	if err != nil || hour < 0 || hour > 23 {
+		return 0, 0, fmt.Errorf("Error parsing time of day: %s", spec)
+	}
+	minute, err := strconv.Atoi(parts[1])
+	if err != nil || minute < 0 || minute > 59 {
+		return 0, 0, fmt.Errorf("Error parsing time of day: %s", spec)
+	}
+	return hour, minute, nil
+}
+
+// Computes the next available publishing slot after both now and the last
+// scheduled release.
+func (s *PublishSchedule) nextSlot(now, last time.Time) time.Time {
+	hour, minute, _ := parseTimeOfDay(s.Time)
+	interval, _ := time.ParseDuration(s.Interval)
+
+	if !last.IsZero() && last.After(now) {
+		return last.Add(interval)
+	}
+	slot := time.Date(now.Year(), now.Month(), now.Day(), hour, minute, 0, 0, now.Location())
+	for !slot.After(now) {
+		slot = slot.Add(interval)
+	}
+	return slot
+}
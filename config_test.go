@@ -0,0 +1,44 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	for _, test := range []struct {
+		name, data string
+		wantErr    bool
+	}{
+		{name: "minimal", data: `{"prefix": "x"}`},
+		{name: "empty", data: `{}`},
+		{name: "loudness target", data: `{"prefix": "x", "loudness_target": -23}`},
+		{name: "loudness target too low", data: `{"prefix": "x", "loudness_target": -80}`, wantErr: true},
+		{name: "loudness target too high", data: `{"prefix": "x", "loudness_target": 3}`, wantErr: true},
+		{name: "invalid json", data: `{"prefix": `, wantErr: true},
+	} {
+		fileName := filepath.Join(t.TempDir(), "config.json")
+		if err := ioutil.WriteFile(fileName, []byte(test.data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		config, err := loadConfig(fileName, DefaultProfile)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: loadConfig() error = %v, want error %v", test.name, err, test.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		// Flags like --group_by validate the config again once applied.
+		if err := config.validate(); err != nil {
+			t.Errorf("%s: validate() = %v after loading", test.name, err)
+		}
+	}
+}
+
+func TestDefaultConfigIsValid(t *testing.T) {
+	if err := defaultConfig().validate(); err != nil {
+		t.Errorf("defaultConfig().validate() = %v", err)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Defaults of the conform encoder settings.
+const (
+	DefaultConformEncoder = "libx264"
+	DefaultConformPreset  = "medium"
+	DefaultConformCRF     = 18
+)
+
+// Settings the chapters of a video mixing resolutions or frame rates, e.g.
+// 4K60 and 1080p120 clips, are reencoded to, so that they make one video
+// instead of being split into parts.
+type Conform struct {
+	Width     int     `json:"width"`
+	Height    int     `json:"height"`
+	FrameRate float64 `json:"frame_rate"`
+	// ffmpeg video encoder, DefaultConformEncoder if empty, e.g. libx265.
+	Encoder string `json:"encoder"`
+	// Encoder preset and constant rate factor, DefaultConformPreset and
+	// DefaultConformCRF if unset.
+	Preset string `json:"preset"`
+	CRF    int    `json:"crf"`
+	// Extra encoder arguments, e.g. ["-tune", "film"].
+	EncoderArgs []string `json:"encoder_args"`
+}
+
+func (c *Conform) validate() error {
+	if c == nil {
+		return nil
+	}
+	if c.Width <= 0 || c.Height <= 0 || c.Width%2 != 0 || c.Height%2 != 0 {
+		return fmt.Errorf("invalid conform size %dx%d, expected even dimensions", c.Width, c.Height)
+	}
+	if c.FrameRate <= 0 {
+		return fmt.Errorf("invalid conform frame rate %v", c.FrameRate)
+	}
+	if c.CRF < 0 || c.CRF > 63 {
+		return fmt.Errorf("invalid conform crf %d", c.CRF)
+	}
+	return nil
+}
+
+// Whether chapters are joined even if their resolutions differ, since they
+// are conformed when rendered.
+func (c *Conform) joins(x, y Chapter) bool {
+	return c != nil && x.Resolution.Codec == y.Resolution.Codec && x.Projection == y.Projection
+}
+
+// Returns whether a video is conformed when rendered: if its chapters mix
+// resolutions or frame rates. 360 videos never are.
+func (c *Conform) appliesTo(video Video) bool {
+	if c == nil || video.is360() || len(video.Chapters) == 0 {
+		return false
+	}
+	first := video.Chapters[0].Resolution
+	for _, chapter := range video.Chapters[1:] {
+		if chapter.Resolution.Width != first.Width || chapter.Resolution.Height != first.Height ||
+			chapter.Resolution.FrameRate != first.FrameRate {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the filter scaling, padding and retiming the video stream to the
+// conform settings.
+func (c *Conform) filter() string {
+	return fmt.Sprintf("scale=%[1]d:%[2]d:force_original_aspect_ratio=decrease,pad=%[1]d:%[2]d:(ow-iw)/2:(oh-ih)/2,setsar=1,fps=%[3]s,format=yuv420p",
+		c.Width, c.Height, strconv.FormatFloat(c.FrameRate, 'f', -1, 64))
+}
+
+// Returns the encoder arguments of conformed videos. Unless an encoder is
+// set, the one of the encode profile is used, if any.
+func (c *Conform) encoderArgs(encodeProfile string) []string {
+	var args []string
+	if profile, ok := encodeProfiles[encodeProfile]; ok && c.Encoder == "" {
+		args = append(args, profile...)
+	} else {
+		encoder, preset, crf := c.Encoder, c.Preset, c.CRF
+		if encoder == "" {
+			encoder = DefaultConformEncoder
+		}
+		if preset == "" {
+			preset = DefaultConformPreset
+		}
+		if crf == 0 {
+			crf = DefaultConformCRF
+		}
+		args = append(args, "-c:v", encoder, "-preset", preset, "-crf", strconv.Itoa(crf))
+	}
+	return append(args, c.EncoderArgs...)
+}
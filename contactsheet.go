@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// Subdirectory of the output directory in which contact sheets and stills
+// are written: <title>.jpg, and <title>/<timestamp>.jpg.
+const StillsDir = "stills"
+
+// Defaults of contact sheets.
+const (
+	DefaultContactSheetColumns = 4
+	DefaultContactSheetRows    = 4
+	DefaultContactSheetWidth   = 480
+)
+
+// Grid of frames of a rendered video, spread evenly over its length, to help
+// pick thumbnails and review videos at a glance.
+type ContactSheet struct {
+	// Size of the grid, DefaultContactSheetColumns and DefaultContactSheetRows
+	// if zero.
+	Columns int `json:"columns"`
+	Rows    int `json:"rows"`
+	// Width of each frame in pixels, DefaultContactSheetWidth if zero.
+	Width int `json:"width"`
+	// If set, a full size still is also saved every interval, e.g. "5m".
+	StillInterval string `json:"still_interval"`
+}
+
+func (s *ContactSheet) validate() error {
+	if s == nil {
+		return nil
+	}
+	if s.Columns < 0 || s.Rows < 0 || s.Columns*s.Rows > 100 {
+		return fmt.Errorf("invalid contact sheet grid %dx%d, expected at most 100 frames", s.Columns, s.Rows)
+	}
+	if s.Width < 0 {
+		return fmt.Errorf("invalid contact sheet width %d", s.Width)
+	}
+	if s.StillInterval != "" {
+		interval, err := time.ParseDuration(s.StillInterval)
+		if err != nil || interval < time.Second {
+			return fmt.Errorf("invalid still interval %q, expected at least 1s", s.StillInterval)
+		}
+	}
+	return nil
+}
+
+// Returns the size of the grid and the width of its frames.
+func (s *ContactSheet) grid() (int, int, int) {
+	columns, rows, width := s.Columns, s.Rows, s.Width
+	if columns == 0 {
+		columns = DefaultContactSheetColumns
+	}
+	if rows == 0 {
+		rows = DefaultContactSheetRows
+	}
+	if width == 0 {
+		width = DefaultContactSheetWidth
+	}
+	return columns, rows, width
+}
+
+// Extracts the frame of a video at the given offset, scaled to width unless
+// zero. Seeking decodes from the preceding keyframe, so the frame is exact.
+func extractFrame(ctx context.Context, runner Runner, fileName string, at time.Duration, width int, outputFname string) error {
+	args := []string{"-v", "error", "-ss", fmt.Sprintf("%.3f", at.Seconds()), "-i", fileName, "-frames:v", "1"}
+	if width > 0 {
+		args = append(args, "-vf", fmt.Sprintf("scale=%d:-2", width))
+	}
+	args = append(args, "-q:v", "3", outputFname, "-y")
+	cmd := exec.CommandContext(ctx, binaryPath("ffmpeg"), args...)
+	cmd.Stderr = os.Stderr
+	return runCommand(runner, cmd)
+}
+
+// Writes the contact sheet and stills of a rendered video, given the file of
+// its uploaded variant, unless written already.
+func (s *ContactSheet) render(ctx context.Context, runner Runner, video Video, fileName, outputDir string) error {
+	if s == nil {
+		return nil
+	}
+	outputFname := filepath.Join(outputDir, StillsDir, video.Title+".jpg")
+	if _, err := os.Stat(outputFname); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Join(outputDir, StillsDir), os.ModePerm); err != nil {
+		return err
+	}
+	tmpDir, removeTmpDir, err := runner.TempDir()
+	if err != nil {
+		return err
+	}
+	defer removeTmpDir()
+
+	// The sheet is moved in place last, since its presence means the stills
+	// are complete.
+	tmpFname := filepath.Join(outputDir, StillsDir, "."+video.Title+".tmp.jpg")
+	log.Printf(">>> Writing contact sheet %s", outputFname)
+	columns, rows, width := s.grid()
+	duration := video.renderedDuration()
+	count := columns * rows
+	for ix := 0; ix < count; ix++ {
+		// Frames are taken from the middle of each stretch of the timeline,
+		// away from the first and last frames, which are often dark.
+		at := duration * time.Duration(2*ix+1) / time.Duration(2*count)
+		if err := extractFrame(ctx, runner, fileName, at, width, filepath.Join(tmpDir, fmt.Sprintf("%03d.jpg", ix))); err != nil {
+			return err
+		}
+	}
+	cmd := exec.CommandContext(ctx, binaryPath("ffmpeg"), "-v", "error",
+		"-i", filepath.Join(tmpDir, "%03d.jpg"),
+		"-vf", fmt.Sprintf("tile=%dx%d:padding=4:margin=4", columns, rows),
+		"-frames:v", "1", "-q:v", "3", tmpFname, "-y")
+	cmd.Stderr = os.Stderr
+	if err := runCommand(runner, cmd); err != nil {
+		os.Remove(tmpFname)
+		return err
+	}
+
+	if s.StillInterval != "" {
+		interval, _ := time.ParseDuration(s.StillInterval)
+		stillsDir := filepath.Join(outputDir, StillsDir, video.Title)
+		if err := os.MkdirAll(stillsDir, os.ModePerm); err != nil {
+			return err
+		}
+		for at := time.Duration(0); at < duration; at += interval {
+			stillFname := filepath.Join(stillsDir, fmtTimestampForFileName(at)+".jpg")
+			if err := extractFrame(ctx, runner, fileName, at, 0, stillFname); err != nil {
+				os.Remove(tmpFname)
+				return err
+			}
+		}
+	}
+	return renameOutput(runner, tmpFname, outputFname)
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Gauges of telemetry overlays.
+const (
+	GaugeSpeed    = "speed"
+	GaugeAltitude = "altitude"
+	GaugeGForce   = "g_force"
+	GaugeTrack    = "track"
+)
+
+// How often the gauges of telemetry overlays are updated.
+const dashboardInterval = time.Second
+
+// Telemetry gauges burnt into rendered videos, like the dashboards of the
+// GoPro apps: speed, altitude and G-force at the bottom left, and the GPS
+// track with the current position at the top right.
+type TelemetryOverlay struct {
+	// Gauges shown, all by default.
+	Gauges []string `json:"gauges"`
+	// Whether speed and altitude are shown in mph and feet rather than km/h
+	// and meters.
+	Imperial bool `json:"imperial"`
+}
+
+func (o *TelemetryOverlay) validate() error {
+	if o == nil {
+		return nil
+	}
+	for _, gauge := range o.Gauges {
+		switch gauge {
+		case GaugeSpeed, GaugeAltitude, GaugeGForce, GaugeTrack:
+		default:
+			return fmt.Errorf("invalid telemetry gauge %q, expected %s, %s, %s or %s", gauge,
+				GaugeSpeed, GaugeAltitude, GaugeGForce, GaugeTrack)
+		}
+	}
+	return nil
+}
+
+// Whether a video gets a telemetry overlay: if it has telemetry. 360 videos
+// never do.
+func (o *TelemetryOverlay) appliesTo(video Video) bool {
+	if o == nil || video.is360() {
+		return false
+	}
+	for _, chapter := range video.Chapters {
+		if chapter.TelemetryStream != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (o *TelemetryOverlay) shows(gauge string) bool {
+	return len(o.Gauges) == 0 || contains(o.Gauges, gauge)
+}
+
+// Telemetry of a video, with offsets from the start of the video.
+type videoTelemetry struct {
+	GPS   []GPSSample
+	Accel []AccelSample
+}
+
+func fetchVideoTelemetry(ctx context.Context, video Video) (*videoTelemetry, error) {
+	result := &videoTelemetry{}
+	offsets := video.chapterOffsets()
+	for ix, chapter := range video.Chapters {
+		telemetry, err := fetchTelemetry(ctx, inputSnapshots.translate(video.Path), chapter)
+		if err != nil {
+			return nil, err
+		}
+		// Samples of the stretch rendered, if idle footage was cut.
+		for _, sample := range telemetry.GPS {
+			if sample.Offset >= chapter.InPoint && sample.Offset < chapter.InPoint+chapter.Duration {
+				sample.Offset = offsets[ix] + video.timeline(sample.Offset-chapter.InPoint)
+				result.GPS = append(result.GPS, sample)
+			}
+		}
+		for _, sample := range telemetry.Accel {
+			if sample.Offset >= chapter.InPoint && sample.Offset < chapter.InPoint+chapter.Duration {
+				sample.Offset = offsets[ix] + video.timeline(sample.Offset-chapter.InPoint)
+				result.Accel = append(result.Accel, sample)
+			}
+		}
+	}
+	return result, nil
+}
+
+// Returns the subtitles filter burning the overlay of a video, writing its
+// gauges as an ASS subtitles file in tmpDir. The size of conformed videos is
+// the conform one.
+func (o *TelemetryOverlay) filter(ctx context.Context, video Video, conform *Conform, tmpDir string) (string, error) {
+	telemetry, err := fetchVideoTelemetry(ctx, video)
+	if err != nil {
+		return "", err
+	}
+	width, height := video.Chapters[0].Resolution.Width, video.Chapters[0].Resolution.Height
+	if conform.appliesTo(video) {
+		width, height = conform.Width, conform.Height
+	}
+	fileName := filepath.Join(tmpDir, "dashboard.ass")
+	if err := ioutil.WriteFile(fileName, []byte(o.subtitles(telemetry, video.renderedDuration(), width, height)), os.ModePerm); err != nil {
+		return "", err
+	}
+	return "subtitles=" + escapeFilterPath(fileName), nil
+}
+
+// Formats an ASS timestamp, e.g. 0:01:02.50.
+func assTime(d time.Duration) string {
+	cs := d.Milliseconds() / 10
+	return fmt.Sprintf("%d:%02d:%02d.%02d", cs/360000, cs/6000%60, cs/100%60, cs%100)
+}
+
+// Returns the ASS subtitles drawing the gauges of a video of the given
+// duration and size.
+func (o *TelemetryOverlay) subtitles(telemetry *videoTelemetry, duration time.Duration, width, height int) string {
+	margin := height / 30
+	lines := []string{
+		"[Script Info]",
+		"ScriptType: v4.00+",
+		fmt.Sprintf("PlayResX: %d", width),
+		fmt.Sprintf("PlayResY: %d", height),
+		"WrapStyle: 2",
+		"ScaledBorderAndShadow: yes",
+		"",
+		"[V4+ Styles]",
+		"Format: Name, Fontname, Fontsize, PrimaryColour, SecondaryColour, OutlineColour, BackColour, Bold, Italic, Underline, StrikeOut, ScaleX, ScaleY, Spacing, Angle, BorderStyle, Outline, Shadow, Alignment, MarginL, MarginR, MarginV, Encoding",
+		fmt.Sprintf("Style: Gauges,Sans,%d,&H00FFFFFF,&H00FFFFFF,&H00000000,&H80000000,1,0,0,0,100,100,0,0,1,%d,0,1,%d,%d,%d,1",
+			height/18, height/400+1, margin, margin, margin),
+		"",
+		"[Events]",
+		"Format: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text",
+	}
+	dialogue := func(start, end time.Duration, text string) {
+		lines = append(lines, fmt.Sprintf("Dialogue: 0,%s,%s,Gauges,,0,0,0,,%s", assTime(start), assTime(end), text))
+	}
+
+	// The track is drawn in a square box, scaled to fit, with longitudes
+	// shrunk by the cosine of the latitude.
+	size := float64(height) / 4
+	boxX, boxY := float64(width-margin)-size, float64(margin)
+	var project func(GPSSample) (float64, float64)
+	if o.shows(GaugeTrack) && len(telemetry.GPS) > 1 {
+		minLat, maxLat := telemetry.GPS[0].Latitude, telemetry.GPS[0].Latitude
+		minLon, maxLon := telemetry.GPS[0].Longitude, telemetry.GPS[0].Longitude
+		for _, sample := range telemetry.GPS {
+			minLat, maxLat = math.Min(minLat, sample.Latitude), math.Max(maxLat, sample.Latitude)
+			minLon, maxLon = math.Min(minLon, sample.Longitude), math.Max(maxLon, sample.Longitude)
+		}
+		cos := math.Cos((minLat + maxLat) / 2 * math.Pi / 180)
+		span := math.Max((maxLon-minLon)*cos, maxLat-minLat)
+		if span > 0 {
+			scale := size / span
+			project = func(sample GPSSample) (float64, float64) {
+				return (sample.Longitude - minLon) * cos * scale, (maxLat - sample.Latitude) * scale
+			}
+		}
+	}
+	if project != nil {
+		// Drawn there and back, so that closing the shape adds no segment.
+		step := len(telemetry.GPS)/200 + 1
+		var points []string
+		for ix := 0; ix < len(telemetry.GPS); ix += step {
+			x, y := project(telemetry.GPS[ix])
+			points = append(points, fmt.Sprintf("%.0f %.0f", x, y))
+		}
+		for ix := len(points) - 2; ix > 0; ix-- {
+			points = append(points, points[ix])
+		}
+		dialogue(0, duration, fmt.Sprintf(`{\an7\pos(%.0f,%.0f)\p1\bord%d\1a&HFF&\3c&HFFFFFF&\shad0}m %s l %s{\p0}`,
+			boxX, boxY, height/300+1, points[0], strings.Join(points[1:], " l ")))
+	}
+
+	speedUnit, speedFactor, altitudeUnit, altitudeFactor := "km/h", 3.6, "m", 1.0
+	if o.Imperial {
+		speedUnit, speedFactor, altitudeUnit, altitudeFactor = "mph", 2.23694, "ft", 3.28084
+	}
+	gps, accel := 0, 0
+	for start := time.Duration(0); start < duration; start += dashboardInterval {
+		end := start + dashboardInterval
+		if end > duration {
+			end = duration
+		}
+		for gps+1 < len(telemetry.GPS) && telemetry.GPS[gps+1].Offset <= start {
+			gps++
+		}
+		var peak float64
+		for ; accel < len(telemetry.Accel) && telemetry.Accel[accel].Offset < end; accel++ {
+			peak = math.Max(peak, telemetry.Accel[accel].G)
+		}
+		var gauges []string
+		if len(telemetry.GPS) > 0 {
+			sample := telemetry.GPS[gps]
+			if o.shows(GaugeSpeed) {
+				gauges = append(gauges, fmt.Sprintf("%.0f %s", sample.Speed2D*speedFactor, speedUnit))
+			}
+			if o.shows(GaugeAltitude) {
+				gauges = append(gauges, fmt.Sprintf("%.0f %s", sample.Altitude*altitudeFactor, altitudeUnit))
+			}
+			if project != nil {
+				x, y := project(sample)
+				dot := height/120 + 2
+				dialogue(start, end, fmt.Sprintf(`{\an5\pos(%.0f,%.0f)\p1\bord0\shad0\1c&H0000FF&}m 0 0 l %[3]d 0 l %[3]d %[3]d l 0 %[3]d{\p0}`,
+					boxX+x, boxY+y, dot))
+			}
+		}
+		if o.shows(GaugeGForce) && peak > 0 {
+			gauges = append(gauges, fmt.Sprintf("%.1f g", peak))
+		}
+		if len(gauges) > 0 {
+			dialogue(start, end, strings.Join(gauges, `\N`))
+		}
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+var errDataCapExceeded = errors.New("monthly data cap reached")
+
+// Bytes uploaded during a calendar month.
+type DataUsage struct {
+	Month string `json:"month"`
+	Bytes int64  `json:"bytes"`
+}
+
+// Returns the calendar month a time belongs to.
+func dataMonth(t time.Time) string {
+	return t.Format("2006-01")
+}
+
+// Tracks uploaded bytes against a monthly data cap, in the state database.
+// Safe for concurrent use.
+type DataTracker struct {
+	state *State
+	// Maximum number of bytes uploaded per month, or 0 for no limit.
+	limit int64
+}
+
+// Returns the number of bytes uploaded this month.
+func (t *DataTracker) used() int64 {
+	t.state.mu.Lock()
+	defer t.state.mu.Unlock()
+	if t.state.DataUsage.Month != dataMonth(time.Now()) {
+		return 0
+	}
+	return t.state.DataUsage.Bytes
+}
+
+// Fails if uploading size more bytes would exceed the cap.
+func (t *DataTracker) reserve(size int64) error {
+	if t.limit > 0 && t.used()+size > t.limit {
+		return errDataCapExceeded
+	}
+	return nil
+}
+
+// Records uploaded bytes.
+func (t *DataTracker) add(n int64) {
+	t.state.mu.Lock()
+	defer t.state.mu.Unlock()
+	if month := dataMonth(time.Now()); t.state.DataUsage.Month != month {
+		t.state.DataUsage = DataUsage{Month: month}
+	}
+	t.state.DataUsage.Bytes += n
+}
+
+// Reader recording the bytes read from it as uploaded.
+type countingReader struct {
+	r       io.Reader
+	tracker *DataTracker
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.tracker.add(int64(n))
+	return n, err
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDataTrackerReserve(t *testing.T) {
+	month := dataMonth(time.Now())
+	for _, test := range []struct {
+		name    string
+		usage   DataUsage
+		limit   int64
+		size    int64
+		wantErr bool
+	}{
+		{"no limit", DataUsage{Month: month, Bytes: 1 << 40}, 0, 1 << 30, false},
+		{"under the cap", DataUsage{Month: month, Bytes: 1 << 30}, 10 << 30, 1 << 30, false},
+		{"exactly the cap", DataUsage{Month: month, Bytes: 9 << 30}, 10 << 30, 1 << 30, false},
+		{"over the cap", DataUsage{Month: month, Bytes: 9 << 30}, 10 << 30, 2 << 30, true},
+		{"larger than the cap", DataUsage{}, 10 << 30, 11 << 30, true},
+		{"previous month", DataUsage{Month: "2000-01", Bytes: 10 << 30}, 10 << 30, 1 << 30, false},
+	} {
+		tracker := &DataTracker{state: &State{DataUsage: test.usage}, limit: test.limit}
+		err := tracker.reserve(test.size)
+		if test.wantErr && !errors.Is(err, errDataCapExceeded) || !test.wantErr && err != nil {
+			t.Errorf("%s: reserve() error = %v, want error %v", test.name, err, test.wantErr)
+		}
+	}
+}
+
+func TestDataTrackerAdd(t *testing.T) {
+	month := dataMonth(time.Now())
+	state := &State{DataUsage: DataUsage{Month: "2000-01", Bytes: 1 << 30}}
+	tracker := &DataTracker{state: state, limit: 10 << 30}
+	if used := tracker.used(); used != 0 {
+		t.Errorf("used() = %d with usage of an earlier month, want 0", used)
+	}
+	tracker.add(100)
+	if state.DataUsage != (DataUsage{Month: month, Bytes: 100}) {
+		t.Errorf("usage after add() = %+v, want 100 bytes in %s", state.DataUsage, month)
+	}
+	// Bytes read for an upload count as they are sent.
+	data, err := ioutil.ReadAll(&countingReader{r: strings.NewReader("0123456789"), tracker: tracker})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if used := tracker.used(); used != 100+int64(len(data)) {
+		t.Errorf("used() = %d after reading %d bytes, want %d", used, len(data), 100+len(data))
+	}
+}
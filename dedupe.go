@@ -0,0 +1,172 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Bytes hashed at each end of a chapter file to tell whether two files of the
+// same size hold the same footage, without reading all of it.
+const fingerprintSize = 1 << 20
+
+// Returns a hash of the size and both ends of a file, which tells copies of
+// the same chapter apart from other files of the same size.
+func fileFingerprint(fileName string) (string, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	binary.Write(hash, binary.BigEndian, info.Size())
+	if _, err := io.Copy(hash, io.NewSectionReader(f, 0, fingerprintSize)); err != nil {
+		return "", err
+	}
+	// The ends overlap in files under twice the size hashed.
+	if info.Size() > fingerprintSize {
+		tail := info.Size() - fingerprintSize
+		if tail < fingerprintSize {
+			tail = fingerprintSize
+		}
+		if _, err := io.Copy(hash, io.NewSectionReader(f, tail, fingerprintSize)); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// A chapter of a discovered video.
+type chapterRef struct {
+	video, chapter int
+	fileName       string
+}
+
+// Returns a reference to each chapter of the videos which uses the file.
+func chapterRefs(videos []Video, fileName string) []chapterRef {
+	var results []chapterRef
+	for ix, video := range videos {
+		for jx, chapter := range video.Chapters {
+			if path.Join(inputSnapshots.translate(video.Path), chapter.FileName) == fileName {
+				results = append(results, chapterRef{video: ix, chapter: jx})
+			}
+		}
+	}
+	return results
+}
+
+// Returns the groups of chapters of the videos holding the same footage, e.g.
+// a file copied into two folders by messy imports, in discovery order. Only
+// files of the same size are hashed.
+func findDuplicateChapters(videos []Video) [][]chapterRef {
+	bySize := map[int64][]chapterRef{}
+	var sizes []int64
+	seen := map[string]bool{}
+	for ix, video := range videos {
+		for jx, chapter := range video.Chapters {
+			fileName := path.Join(inputSnapshots.translate(video.Path), chapter.FileName)
+			// Parts of split videos may share a chapter cut in several.
+			if seen[fileName] {
+				continue
+			}
+			seen[fileName] = true
+			info, err := os.Stat(fileName)
+			if err != nil {
+				continue
+			}
+			if _, ok := bySize[info.Size()]; !ok {
+				sizes = append(sizes, info.Size())
+			}
+			bySize[info.Size()] = append(bySize[info.Size()], chapterRef{ix, jx, fileName})
+		}
+	}
+
+	var results [][]chapterRef
+	for _, size := range sizes {
+		refs := bySize[size]
+		if len(refs) < 2 {
+			continue
+		}
+		groups := map[string][]chapterRef{}
+		var keys []string
+		for _, ref := range refs {
+			fingerprint, err := fileFingerprint(ref.fileName)
+			if err != nil {
+				debugf(">>> Could not hash %s: %v", ref.fileName, err)
+				continue
+			}
+			if _, ok := groups[fingerprint]; !ok {
+				keys = append(keys, fingerprint)
+			}
+			groups[fingerprint] = append(groups[fingerprint], ref)
+		}
+		for _, key := range keys {
+			if len(groups[key]) > 1 {
+				results = append(results, groups[key])
+			}
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		x, y := results[i][0], results[j][0]
+		return x.video < y.video || x.video == y.video && x.chapter < y.chapter
+	})
+	return results
+}
+
+// Warns about chapters found several times among the videos. If dedupe is
+// set, only the first copy is kept, and videos left without chapters are
+// dropped.
+func dedupeChapters(videos []Video, dedupe bool) []Video {
+	duplicates := findDuplicateChapters(videos)
+	if len(duplicates) == 0 {
+		return videos
+	}
+	dropped := map[chapterRef]bool{}
+	for _, group := range duplicates {
+		var fileNames []string
+		for _, ref := range group {
+			fileNames = append(fileNames, ref.fileName)
+		}
+		if !dedupe {
+			warnf(">>> Same footage found several times, set dedupe_chapters to render it once: %s",
+				strings.Join(fileNames, ", "))
+			continue
+		}
+		warnf(">>> Same footage found several times, only rendering %s: %s", fileNames[0],
+			strings.Join(fileNames[1:], ", "))
+		// Parts of split videos sharing a dropped file all drop it.
+		for _, ref := range group[1:] {
+			for _, chapter := range chapterRefs(videos, ref.fileName) {
+				dropped[chapter] = true
+			}
+		}
+	}
+	if !dedupe {
+		return videos
+	}
+	var results []Video
+	for ix, video := range videos {
+		var chapters []Chapter
+		for jx, chapter := range video.Chapters {
+			if !dropped[chapterRef{video: ix, chapter: jx}] {
+				chapters = append(chapters, chapter)
+			}
+		}
+		if len(chapters) == 0 {
+			warnf(">>> %s only holds footage of other videos, skipping..", video.Title)
+			continue
+		}
+		video.Chapters = chapters
+		results = append(results, video)
+	}
+	return results
+}
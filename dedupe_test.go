@@ -0,0 +1,48 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileFingerprint(t *testing.T) {
+	data := func(size, changed int) []byte {
+		result := make([]byte, size)
+		if changed >= 0 {
+			result[changed] = 1
+		}
+		return result
+	}
+	for _, test := range []struct {
+		name string
+		a, b []byte
+		same bool
+	}{
+		{"empty", nil, nil, true},
+		{"same", data(100, -1), data(100, -1), true},
+		{"different sizes", data(100, -1), data(101, -1), false},
+		{"small", data(100, -1), data(100, 99), false},
+		{"start", data(3*fingerprintSize, -1), data(3*fingerprintSize, 0), false},
+		{"end", data(3*fingerprintSize, -1), data(3*fingerprintSize, 3*fingerprintSize-1), false},
+		{"end under twice the size hashed", data(3*fingerprintSize/2, -1), data(3*fingerprintSize/2, 3*fingerprintSize/2-1), false},
+		{"middle not hashed", data(3*fingerprintSize, -1), data(3*fingerprintSize, 3*fingerprintSize/2), true},
+	} {
+		dir := t.TempDir()
+		var fingerprints []string
+		for ix, content := range [][]byte{test.a, test.b} {
+			fileName := filepath.Join(dir, []string{"a.mp4", "b.mp4"}[ix])
+			if err := ioutil.WriteFile(fileName, content, 0644); err != nil {
+				t.Fatal(err)
+			}
+			fingerprint, err := fileFingerprint(fileName)
+			if err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+			fingerprints = append(fingerprints, fingerprint)
+		}
+		if same := fingerprints[0] == fingerprints[1]; same != test.same {
+			t.Errorf("%s: same fingerprints = %v, want %v", test.name, same, test.same)
+		}
+	}
+}
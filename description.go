@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"math"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// A chapter, as listed by description templates.
+type DescriptionChapter struct {
+	// Offset in the video, e.g. 1:02:03.
+	Start    string
+	FileName string
+	Time     time.Time
+	Duration time.Duration
+	Locale   string
+	Speaker  string
+}
+
+// GPS statistics of a video, as shown by description templates.
+type DescriptionGPS struct {
+	// Distance covered in km.
+	Distance float64
+	// Maximum ground speed in km/h.
+	MaxSpeed float64
+	// Cumulated climb in meters.
+	ElevationGain float64
+}
+
+// Fields available in description templates. Camera, GPS and HiLights read
+// the chapter files, and are only computed when a template uses them.
+type DescriptionData struct {
+	Title string
+	// The generated description: chapter timestamps and labels.
+	Chapters string
+	// The chapters, to list them in a custom format.
+	ChapterList []DescriptionChapter
+	// Recording date of the first chapter, e.g. 2020-07-04.
+	Date string
+	// Total duration, e.g. 1:02:03.
+	Duration string
+	// The tags of the video as hashtags, e.g. "#skiing #alps".
+	Hashtags string
+
+	ctx       context.Context
+	video     Video
+	telemetry *TelemetrySummary
+	device    string
+}
+
+// Summarizes the telemetry of all chapters of a video, and returns the
+// camera model recorded in it.
+func summarizeVideoTelemetry(ctx context.Context, video Video) (*TelemetrySummary, string, error) {
+	summary := &TelemetrySummary{}
+	var device string
+	for _, chapter := range video.Chapters {
+		telemetry, err := fetchTelemetry(ctx, video.Path, chapter)
+		if err != nil {
+			return nil, "", err
+		}
+		if device == "" {
+			device = telemetry.Device
+		}
+		chapterSummary := telemetry.summary()
+		if chapterSummary.FirstFix == nil {
+			continue
+		}
+		if summary.FirstFix == nil {
+			summary.FirstFix = chapterSummary.FirstFix
+			summary.MinAltitude = chapterSummary.MinAltitude
+			summary.MaxAltitude = chapterSummary.MaxAltitude
+		}
+		summary.MinAltitude = math.Min(summary.MinAltitude, chapterSummary.MinAltitude)
+		summary.MaxAltitude = math.Max(summary.MaxAltitude, chapterSummary.MaxAltitude)
+		summary.GPSSamples += chapterSummary.GPSSamples
+		summary.Distance += chapterSummary.Distance
+		summary.ElevationGain += chapterSummary.ElevationGain
+		if chapterSummary.MaxSpeed > summary.MaxSpeed {
+			summary.MaxSpeed = chapterSummary.MaxSpeed
+		}
+	}
+	return summary, device, nil
+}
+
+// Reads the telemetry of all chapters, once.
+func (d *DescriptionData) loadTelemetry() error {
+	if d.telemetry != nil {
+		return nil
+	}
+	summary, device, err := summarizeVideoTelemetry(d.ctx, d.video)
+	if err != nil {
+		return err
+	}
+	d.telemetry, d.device = summary, device
+	return nil
+}
+
+// Returns the camera model recorded in the telemetry, e.g. "HERO9 Black".
+func (d *DescriptionData) Camera() (string, error) {
+	if err := d.loadTelemetry(); err != nil {
+		return "", err
+	}
+	return d.device, nil
+}
+
+// Returns the GPS statistics of the video, all zero without GPS fix.
+func (d *DescriptionData) GPS() (DescriptionGPS, error) {
+	if err := d.loadTelemetry(); err != nil {
+		return DescriptionGPS{}, err
+	}
+	return DescriptionGPS{
+		Distance:      d.telemetry.Distance / 1000,
+		MaxSpeed:      d.telemetry.MaxSpeed * 3.6,
+		ElevationGain: d.telemetry.ElevationGain,
+	}, nil
+}
+
+// Returns the offsets of the HiLight tags of the chapters of a video in the
+// rendered video.
+func videoHiLights(video Video) ([]time.Duration, error) {
+	var results []time.Duration
+	offsets := video.chapterOffsets()
+	for ix, chapter := range video.Chapters {
+		hiLights, err := readHiLights(filepath.Join(video.Path, chapter.FileName))
+		if err != nil {
+			return nil, err
+		}
+		for _, offset := range hiLights {
+			if offset >= chapter.InPoint && offset < chapter.InPoint+chapter.Duration {
+				results = append(results, offsets[ix]+video.timeline(offset-chapter.InPoint))
+			}
+		}
+	}
+	return results, nil
+}
+
+// Returns the offsets of the HiLight tags in the video, e.g. 1:02:03.
+func (d *DescriptionData) HiLights() ([]string, error) {
+	hiLights, err := videoHiLights(d.video)
+	if err != nil {
+		return nil, err
+	}
+	var results []string
+	for _, offset := range hiLights {
+		results = append(results, fmtDurationForYouTube(offset))
+	}
+	return results, nil
+}
+
+// Expands a description template for a video.
+func executeDescriptionTemplate(ctx context.Context, text string, video Video) (string, error) {
+	tmpl, err := template.New("description").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	data := &DescriptionData{
+		Title:    video.Title,
+		Chapters: generateVideoDescription(video),
+		Duration: fmtDurationForYouTube(video.renderedDuration()),
+		ctx:      ctx,
+		video:    video,
+	}
+	if start := video.startTime(); !start.IsZero() {
+		data.Date = localTime(start).Format("2006-01-02")
+	}
+	offsets := video.chapterOffsets()
+	for ix, chapter := range video.Chapters {
+		data.ChapterList = append(data.ChapterList, DescriptionChapter{
+			Start:    fmtDurationForYouTube(offsets[ix]),
+			FileName: chapter.FileName,
+			Time:     localTime(chapter.CreateTime),
+			Duration: video.timeline(chapter.Duration),
+			Locale:   chapter.Locale,
+			Speaker:  chapter.Speaker,
+		})
+	}
+	var hashtags []string
+	for _, tag := range video.Tags {
+		if tag = strings.Join(strings.Fields(tag), ""); tag != "" {
+			hashtags = append(hashtags, "#"+tag)
+		}
+	}
+	data.Hashtags = strings.Join(hashtags, " ")
+
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// Returns the description of a video: its template expanded if it has one,
+// e.g. "Skiing with the club\n\n{{.Chapters}}", or else the generated one.
+func videoDescription(ctx context.Context, video Video) string {
+	if video.Description == "" {
+		return generateVideoDescription(video)
+	}
+	description, err := executeDescriptionTemplate(ctx, video.Description, video)
+	if err != nil {
+		warnf(">>> Could not expand description of %s: %v", video.Title, err)
+		return generateVideoDescription(video)
+	}
+	return description
+}
@@ -0,0 +1,10 @@
+//go:build !darwin && !linux && !freebsd && !windows
+// +build !darwin,!linux,!freebsd,!windows
+
+package main
+
+import "errors"
+
+func diskSpace(path string) (uint64, string, error) {
+	return 0, "", errors.New("Free disk space unknown on this platform")
+}
@@ -0,0 +1,19 @@
+//go:build darwin || linux || freebsd
+// +build darwin linux freebsd
+
+package main
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// Returns the bytes available to unprivileged users on the volume holding
+// path, and an identifier of the volume.
+func diskSpace(path string) (uint64, string, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, "", err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), fmt.Sprint(st.Fsid), nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+var procGetDiskFreeSpaceExW = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+
+// Returns the bytes available to the user on the volume holding path, and an
+// identifier of the volume.
+func diskSpace(path string) (uint64, string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return 0, "", err
+	}
+	p, err := syscall.UTF16PtrFromString(abs)
+	if err != nil {
+		return 0, "", err
+	}
+	var available uint64
+	ret, _, err := procGetDiskFreeSpaceExW.Call(uintptr(unsafe.Pointer(p)),
+		uintptr(unsafe.Pointer(&available)), 0, 0)
+	if ret == 0 {
+		return 0, "", err
+	}
+	return available, strings.ToUpper(filepath.VolumeName(abs)), nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Returns the ISO 6709 form of a location, e.g. "+47.3769+008.5417+408.000/",
+// as stored in the ©xyz and loci atoms. The altitude is left out if unknown.
+func iso6709(location YouTubeLocation) string {
+	if location.Altitude == 0 {
+		return fmt.Sprintf("%+08.4f%+09.4f/", location.Latitude, location.Longitude)
+	}
+	return fmt.Sprintf("%+08.4f%+09.4f%+.3f/", location.Latitude, location.Longitude, location.Altitude)
+}
+
+// Returns the ffmpeg output arguments embedding the recording details of a
+// video into its container, see Config.EmbedMetadata: the location of its
+// first GPS fix, subject to the location privacy rules, the camera model and
+// the recording date. The com.apple.quicktime keys are those read by Apple
+// and Google Photos, and need the use_metadata_tags movflag.
+func (c *Config) embeddedMetadataArgs(ctx context.Context, video Video) []string {
+	if !c.EmbedMetadata || len(video.Chapters) == 0 {
+		return nil
+	}
+	var args []string
+	tag := func(key, value string) {
+		args = append(args, "-metadata", key+"="+value)
+	}
+	if start := video.startTime(); !start.IsZero() {
+		tag("date", localTime(start).Format("2006-01-02"))
+		tag("com.apple.quicktime.creationdate", localTime(start).Format(time.RFC3339))
+	}
+	tag("make", "GoPro")
+	tag("com.apple.quicktime.make", "GoPro")
+	telemetry, err := fetchTelemetry(ctx, video.Path, video.Chapters[0])
+	if err != nil {
+		debugf(">>> Could not read telemetry of %s: %v", video.Title, err)
+		return args
+	}
+	if telemetry.Device != "" {
+		tag("model", telemetry.Device)
+		tag("com.apple.quicktime.model", telemetry.Device)
+	}
+	fix, err := firstGPSFix(ctx, video)
+	if err != nil {
+		debugf(">>> Could not read telemetry of %s: %v", video.Title, err)
+		return args
+	}
+	if fix == nil {
+		return args
+	}
+	if location := c.LocationPrivacy.apply(*fix); location != nil {
+		tag("location", iso6709(*location))
+		tag("com.apple.quicktime.location.ISO6709", iso6709(*location))
+	} else {
+		log.Printf(">>> Recording location of %s is private, not embedding it", video.Title)
+	}
+	return args
+}
+
+// Returns the movflags of renders, adding use_metadata_tags to flags when
+// recording details are embedded, e.g. "+faststart+use_metadata_tags".
+func (c *Config) movflags(flags string) string {
+	if c.EmbedMetadata {
+		flags += "+use_metadata_tags"
+	}
+	return flags
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Encode profiles, see Config.EncodeProfile.
+const (
+	EncodeH264 = "h264"
+	EncodeHEVC = "hevc"
+	EncodeAV1  = "av1"
+	EncodeVP9  = "vp9"
+)
+
+// ffmpeg video encoder arguments of each encode profile. Action camera
+// footage is busy, so rate factors are low, trading size for quality; AV1
+// film grain synthesis is off, since it smears fast motion.
+var encodeProfiles = map[string][]string{
+	EncodeH264: {"-c:v", "libx264", "-preset", "slow", "-crf", "16", "-pix_fmt", "yuv420p"},
+	EncodeHEVC: {"-c:v", "libx265", "-preset", "slow", "-crf", "18", "-pix_fmt", "yuv420p", "-tag:v", "hvc1"},
+	EncodeAV1: {"-c:v", "libsvtav1", "-preset", "5", "-crf", "22", "-pix_fmt", "yuv420p10le",
+		"-svtav1-params", "film-grain=0:tune=0"},
+	EncodeVP9: {"-c:v", "libvpx-vp9", "-crf", "18", "-b:v", "0", "-deadline", "good", "-cpu-used", "2",
+		"-row-mt", "1", "-pix_fmt", "yuv420p"},
+}
+
+// Encoder arguments of the reencodes (360 conversion, picture-in-picture)
+// when no encode profile is set.
+var defaultEncoderArgs = []string{"-c:v", "libx264", "-preset", "medium", "-crf", "18"}
+
+// Returns the names of the encode profiles, sorted.
+func encodeProfileNames() []string {
+	var names []string
+	for name := range encodeProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func validateEncodeProfile(name string) error {
+	if _, ok := encodeProfiles[name]; name != "" && !ok {
+		return fmt.Errorf("invalid encode profile %q, expected one of %s", name, strings.Join(encodeProfileNames(), ", "))
+	}
+	return nil
+}
+
+// Returns the video encoder arguments of reencodes: those of the encode
+// profile, followed by the extra arguments.
+func (c *Config) encoderArgs() []string {
+	args := defaultEncoderArgs
+	if c.EncodeProfile != "" {
+		args = encodeProfiles[c.EncodeProfile]
+	}
+	return append(append([]string{}, args...), c.EncodeArgs...)
+}
+
+// Returns the encoder arguments of the master of a video, nil if its video
+// stream is copied, and whether they are those of the encode profile. It is
+// reencoded when conformed, with a telemetry overlay or as a hyperlapse.
+func (c *Config) masterEncoder(video Video) ([]string, bool) {
+	if c.Conform.appliesTo(video) {
+		return c.Conform.encoderArgs(c.EncodeProfile), c.EncodeProfile != "" && c.Conform.Encoder == ""
+	}
+	if c.TelemetryOverlay.appliesTo(video) || video.Hyperlapse != nil {
+		return c.encoderArgs(), c.EncodeProfile != ""
+	}
+	return nil, false
+}
+
+// Whether the encode profile variant of a video is rendered: not for videos
+// uploaded as another variant, nor for those whose master is encoded with the
+// profile already.
+func (c *Config) encodesVariant(video Video) bool {
+	if c.EncodeProfile == "" || video.is360() || len(video.Overlay) > 0 {
+		return false
+	}
+	_, withProfile := c.masterEncoder(video)
+	return !withProfile
+}
+
+// Renders the variant of a rendered video named after the encode profile,
+// reencoding its video stream. Audio, chapters and metadata are copied.
+func encodeVariant(ctx context.Context, runner Runner, video Video, outputDir string, config *Config) error {
+	variant := config.EncodeProfile
+	inputFname := filepath.Join(outputDir, video.Title+VideoExt)
+	outputFname := filepath.Join(outputDir, video.Title+"."+variant+VideoExt)
+	tmpFname := filepath.Join(outputDir, "."+video.Title+"."+variant+".tmp"+VideoExt)
+	log.Printf(">>> Encoding %s", outputFname)
+	args := []string{"-v", "warning",
+		"-i", inputFname,
+		"-map", "0:v:0",
+		"-map", "0:a:0?",
+		"-map_metadata", "0",
+		"-map_chapters", "0"}
+	if config.watermarkStage(video) == variant {
+		args = append(args, "-vf", config.Watermark.graph("[in]", "[out]", config.renderedWidth(video)))
+	}
+	args = append(args, config.encoderArgs()...)
+	args = append(args,
+		"-c:a", "copy",
+		"-movflags", "+faststart",
+		"-progress", "pipe:1", "-nostats",
+		"-f", "mp4", tmpFname, "-y")
+	cmd := exec.CommandContext(ctx, binaryPath("ffmpeg"), args...)
+	cmd.Stderr = os.Stderr
+	if err := progressBars.run(runner, cmd, video.Title, video.renderedDuration()); err != nil {
+		os.Remove(tmpFname)
+		return err
+	}
+	return renameOutput(runner, tmpFname, outputFname)
+}
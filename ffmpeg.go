@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Oldest ffmpeg release with all the filters used, e.g. v360 to convert 360
+// footage.
+const (
+	minFFmpegMajor = 4
+	minFFmpegMinor = 3
+)
+
+// Binaries run instead of the ffmpeg and ffprobe found in PATH, e.g.
+// /opt/ffmpeg6/bin/ffmpeg, see Config.FFmpegPath.
+var binaries = map[string]string{}
+
+// Returns the binary to run for a command.
+func binaryPath(name string) string {
+	if path, ok := binaries[name]; ok {
+		return path
+	}
+	return name
+}
+
+// Sets the ffmpeg and ffprobe binaries of the config.
+func (c *Config) setBinaries() {
+	if c.FFmpegPath != "" {
+		binaries["ffmpeg"] = c.FFmpegPath
+	}
+	if c.FFprobePath != "" {
+		binaries["ffprobe"] = c.FFprobePath
+	}
+}
+
+// Release number in the first line of `ffmpeg -version`, e.g. "ffmpeg
+// version 6.1.1-static" or "ffmpeg version n7.0". Builds from git, e.g.
+// "ffmpeg version N-113000-g1234", have none.
+var ffmpegVersionRegex = regexp.MustCompile(`version n?(\d+)\.(\d+)`)
+
+// Returns the version of ffmpeg or ffprobe, e.g. "ffmpeg version 6.1.1",
+// failing if the release is older than the minimum supported.
+func checkFFmpegVersion(name string) (string, error) {
+	out, err := exec.Command(binaryPath(name), "-version").Output()
+	if err != nil {
+		return "", fmt.Errorf("Could not run %s: %v", binaryPath(name), err)
+	}
+	version := strings.SplitN(string(out), "\n", 2)[0]
+	match := ffmpegVersionRegex.FindStringSubmatch(version)
+	if fields := strings.Fields(version); len(fields) >= 3 {
+		version = strings.Join(fields[:3], " ")
+	}
+	if match == nil {
+		debugf(">>> Could not tell the release of %s, assuming it is recent enough", version)
+		return version, nil
+	}
+	major, _ := strconv.Atoi(match[1])
+	minor, _ := strconv.Atoi(match[2])
+	if major < minFFmpegMajor || major == minFFmpegMajor && minor < minFFmpegMinor {
+		return "", fmt.Errorf("%s is too old, %d.%d or later is needed", version, minFFmpegMajor, minFFmpegMinor)
+	}
+	return version, nil
+}
+
+// An optional capability of the ffmpeg build.
+type ffmpegFeature struct {
+	Name string
+	// Arguments listing the components of the kind, and the component.
+	List      string
+	Component string
+	// What lacks without it.
+	Usage string
+}
+
+var ffmpegFeatures = []ffmpegFeature{
+	{"concat demuxer", "-demuxers", "concat", "rendering"},
+	{"tee muxer", "-muxers", "tee", "--preview_addr"},
+	{"libx264 encoder", "-encoders", "libx264", "proxies, clips and 360 conversion"},
+	{"v360 filter", "-filters", "v360", "360 conversion"},
+	{"libx265 encoder", "-encoders", "libx265", "encode_profile hevc"},
+	{"libsvtav1 encoder", "-encoders", "libsvtav1", "encode_profile av1"},
+	{"libvpx-vp9 encoder", "-encoders", "libvpx-vp9", "encode_profile vp9"},
+	{"sine filter", "-filters", "sine", "--sync_test"},
+	{"loudnorm filter", "-filters", "loudnorm", "loudnorm"},
+	{"subtitles filter", "-filters", "subtitles", "telemetry_overlay"},
+}
+
+// Quotes a path for use as a filter option, e.g. with subtitles. Backslashes
+// of Windows paths are turned into slashes, which ffmpeg understands too.
+func escapeFilterPath(fileName string) string {
+	return "'" + strings.NewReplacer(`\`, "/", ":", `\:`).Replace(fileName) + "'"
+}
+
+// Returns the number of inputs in ffmpeg arguments.
+func countInputs(args []string) int {
+	count := 0
+	for _, arg := range args {
+		if arg == "-i" {
+			count++
+		}
+	}
+	return count
+}
+
+// Lists the components of a kind, e.g. the demuxers with -demuxers.
+func listFFmpegComponents(list string) (map[string]bool, error) {
+	out, err := exec.Command(binaryPath("ffmpeg"), "-hide_banner", list).Output()
+	if err != nil {
+		return nil, err
+	}
+	components := map[string]bool{}
+	for _, line := range strings.Split(string(out), "\n") {
+		// Lines are flags followed by the name, e.g. " D  concat  Virtual
+		// concatenation script".
+		if fields := strings.Fields(line); len(fields) >= 2 {
+			components[fields[1]] = true
+		}
+	}
+	return components, nil
+}
+
+// Returns the hardware acceleration methods of the ffmpeg build, e.g.
+// videotoolbox or cuda.
+func ffmpegHWAccels() ([]string, error) {
+	out, err := exec.Command(binaryPath("ffmpeg"), "-hide_banner", "-hwaccels").Output()
+	if err != nil {
+		return nil, err
+	}
+	var methods []string
+	for _, line := range strings.Split(string(bytes.TrimSpace(out)), "\n")[1:] {
+		if method := strings.TrimSpace(line); method != "" {
+			methods = append(methods, method)
+		}
+	}
+	return methods, nil
+}
+
+// Checks the ffmpeg and ffprobe binaries, and reports the features of the
+// ffmpeg build.
+func runDepsCommand(args []string) {
+	flags := flag.NewFlagSet("deps", flag.ExitOnError)
+	readConfig := configFlags(flags)
+	setupLogging := logFlags(flags)
+	flags.Parse(args)
+	setupLogging()
+	if _, err := readConfig(); err != nil {
+		fatal(err)
+	}
+	failed := false
+	for _, name := range []string{"ffmpeg", "ffprobe"} {
+		path, err := exec.LookPath(binaryPath(name))
+		if err != nil {
+			warnf(">>> Could not find %s: %v", binaryPath(name), err)
+			failed = true
+			continue
+		}
+		version, err := checkFFmpegVersion(name)
+		if err != nil {
+			warnf(">>> %v", err)
+			failed = true
+			continue
+		}
+		log.Printf(">>> %s: %s (%s)", name, path, version)
+	}
+	if failed {
+		exitFatal()
+	}
+
+	lists := map[string]map[string]bool{}
+	for _, feature := range ffmpegFeatures {
+		if _, ok := lists[feature.List]; !ok {
+			components, err := listFFmpegComponents(feature.List)
+			if err != nil {
+				fatalf("Could not list ffmpeg %s: %v", strings.TrimPrefix(feature.List, "-"), err)
+			}
+			lists[feature.List] = components
+		}
+		if lists[feature.List][feature.Component] {
+			log.Printf(">>> %s: available", feature.Name)
+		} else {
+			warnf(">>> %s: missing, needed for %s", feature.Name, feature.Usage)
+		}
+	}
+	methods, err := ffmpegHWAccels()
+	if err != nil {
+		fatalf("Could not list ffmpeg hardware acceleration methods: %v", err)
+	}
+	if len(methods) == 0 {
+		log.Printf(">>> Hardware acceleration: none")
+	} else {
+		log.Printf(">>> Hardware acceleration: %s", strings.Join(methods, ", "))
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Restricts which footage is processed, e.g. last weekend's only.
+type Filters struct {
+	// Only videos with a chapter recorded in this range, as dates (e.g.
+	// "2024-05-04", until included) or RFC 3339 times.
+	Since string `json:"since"`
+	Until string `json:"until"`
+	// Only videos lasting at least this long, e.g. "30s".
+	MinDuration string `json:"min_duration"`
+	// Chapters shorter than this are left out of videos, e.g. "5s" for
+	// accidental recordings. Unlike other filters, this changes chapters.
+	MinChapterDuration string `json:"min_chapter_duration"`
+	// Glob patterns of folders to process (all by default) and to skip. A
+	// pattern without "/" matches folder names at any depth, others paths
+	// relative to the input directory, e.g. "scratch" or "2024-*/Day 1".
+	Include []string `json:"include_globs"`
+	Exclude []string `json:"exclude_globs"`
+}
+
+// Filters, parsed.
+type videoFilter struct {
+	since, until       time.Time
+	minDuration        time.Duration
+	minChapterDuration time.Duration
+	include, exclude   []string
+	// Paths of the chapters left out for being too short.
+	skipped []string
+}
+
+// Parses a date or time of a filter. Dates stand for the start of the day, or
+// its end for until, in the zone recording times are shown in.
+func parseFilterTime(spec string, endOfDay bool) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", spec, recordingZone); err == nil {
+		if endOfDay {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04"} {
+		if t, err := time.ParseInLocation(layout, spec, recordingZone); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("Error parsing time %q, expected e.g. 2024-05-04 or 2024-05-04T10:00:00Z", spec)
+}
+
+// Parses and checks the filters.
+func (f Filters) compile() (*videoFilter, error) {
+	filter := &videoFilter{include: f.Include, exclude: f.Exclude}
+	var err error
+	if f.Since != "" {
+		if filter.since, err = parseFilterTime(f.Since, false); err != nil {
+			return nil, err
+		}
+	}
+	if f.Until != "" {
+		if filter.until, err = parseFilterTime(f.Until, true); err != nil {
+			return nil, err
+		}
+	}
+	if f.MinDuration != "" {
+		if filter.minDuration, err = time.ParseDuration(f.MinDuration); err != nil {
+			return nil, fmt.Errorf("invalid min duration: %v", err)
+		}
+	}
+	if f.MinChapterDuration != "" {
+		if filter.minChapterDuration, err = time.ParseDuration(f.MinChapterDuration); err != nil {
+			return nil, fmt.Errorf("invalid min chapter duration: %v", err)
+		}
+	}
+	for _, pattern := range append(append([]string{}, f.Include...), f.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid glob pattern %q", pattern)
+		}
+	}
+	return filter, nil
+}
+
+// Whether a glob pattern matches a folder, given by its path relative to the
+// input directory, with "/" separators.
+func matchFolder(pattern, rel string) bool {
+	if !strings.Contains(pattern, "/") {
+		rel = path.Base(rel)
+	}
+	matched, _ := path.Match(pattern, rel)
+	return matched
+}
+
+// Whether a folder is skipped along with its subfolders.
+func (f *videoFilter) excludes(rel string) bool {
+	for _, pattern := range f.exclude {
+		if matchFolder(pattern, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether the videos of a folder are processed, i.e. the folder or one of its
+// parents matches an include pattern.
+func (f *videoFilter) includes(rel string) bool {
+	if len(f.include) == 0 {
+		return true
+	}
+	for dir := rel; dir != "." && dir != "/"; dir = path.Dir(dir) {
+		for _, pattern := range f.include {
+			if matchFolder(pattern, dir) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Whether a video passes the time and duration filters. Videos are kept or
+// dropped whole, so that their titles and chapters do not depend on filters.
+func (f *videoFilter) keeps(video Video) bool {
+	if video.duration() < f.minDuration {
+		return false
+	}
+	if f.since.IsZero() && f.until.IsZero() {
+		return true
+	}
+	for _, chapter := range video.Chapters {
+		if !chapter.CreateTime.Before(f.since) && (f.until.IsZero() || chapter.CreateTime.Before(f.until)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the chapters of a folder lasting at least the min chapter duration.
+// The length of the file counts, so that cut chapters are kept.
+func (f *videoFilter) chapters(dirPath string, chapters []Chapter) []Chapter {
+	if f.minChapterDuration <= 0 {
+		return chapters
+	}
+	var results []Chapter
+	for _, chapter := range chapters {
+		if chapter.fileDuration() < f.minChapterDuration {
+			fileName := path.Join(dirPath, chapter.FileName)
+			log.Printf(">>> Skipping %s, lasting %s", fileName, chapter.fileDuration().Round(time.Millisecond))
+			f.skipped = append(f.skipped, fileName)
+			continue
+		}
+		results = append(results, chapter)
+	}
+	return results
+}
+
+// Logs the chapters left out for being too short, if any.
+func (f *videoFilter) reportSkipped() {
+	if len(f.skipped) > 0 {
+		log.Printf(">>> Skipped %d chapters shorter than %s:\n%s", len(f.skipped), f.minChapterDuration,
+			strings.Join(f.skipped, "\n"))
+	}
+}
+
+// Returns the path of a folder relative to the input directory, for filters.
+func filterPath(dirPath, base string) string {
+	rel, err := filepath.Rel(base, dirPath)
+	if err != nil {
+		return filepath.ToSlash(dirPath)
+	}
+	return filepath.ToSlash(rel)
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// An artifact left behind by interrupted or outdated runs.
+type garbage struct {
+	// File or directory, or the title of a state entry.
+	Name   string
+	Reason string
+	remove func() error
+}
+
+// Suffixes of the hidden temporary files written next to rendered videos.
+var tmpRenderSuffixes = []string{".tmp" + VideoExt, ".remux" + VideoExt, ".faststart" + VideoExt, ".hilights" + VideoExt}
+
+// Finds temporary renders and partially written files in the output
+// directory not modified for the given duration, so that those of runs in
+// progress are left alone.
+func findStaleTempFiles(outputDir string, olderThan time.Duration) ([]garbage, error) {
+	files, err := ioutil.ReadDir(outputDir)
+	if err != nil {
+		return nil, err
+	}
+	var results []garbage
+	for _, file := range files {
+		if file.IsDir() || time.Since(file.ModTime()) < olderThan {
+			continue
+		}
+		name := file.Name()
+		isTmp := strings.HasSuffix(name, ".tmp")
+		for _, suffix := range tmpRenderSuffixes {
+			if strings.HasPrefix(name, ".") && strings.HasSuffix(name, suffix) {
+				isTmp = true
+			}
+		}
+		if isTmp {
+			fileName := filepath.Join(outputDir, name)
+			results = append(results, garbage{
+				Name:   fileName,
+				Reason: "stale temporary file",
+				remove: func() error { return os.Remove(fileName) },
+			})
+		}
+	}
+	return results, nil
+}
+
+// Finds temporary directories of renders and remuxes which were not cleaned
+// up, e.g. because ffmpeg was killed.
+func findStaleTempDirs(olderThan time.Duration) ([]garbage, error) {
+	dirs, err := filepath.Glob(filepath.Join(os.TempDir(), "gopro-uploader*"))
+	if err != nil {
+		return nil, err
+	}
+	var results []garbage
+	for _, dirPath := range dirs {
+		info, err := os.Stat(dirPath)
+		if err != nil || !info.IsDir() || time.Since(info.ModTime()) < olderThan {
+			continue
+		}
+		dirPath := dirPath
+		results = append(results, garbage{
+			Name:   dirPath,
+			Reason: "stale temporary directory",
+			remove: func() error { return os.RemoveAll(dirPath) },
+		})
+	}
+	return results, nil
+}
+
+// Finds sidecars in directories which no longer contain any chapter.
+func findOrphanedSidecars(inputDir string, extensions []string) ([]garbage, error) {
+	var results []garbage
+	err := filepath.Walk(inputDir, func(dirPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		sidecarFname := filepath.Join(dirPath, SidecarFileName)
+		if _, err := os.Stat(sidecarFname); err != nil {
+			return nil
+		}
+		// Skipping sidecars apply to subdirectories, even without chapters.
+		if sidecar, err := loadSidecar(dirPath); err != nil || sidecar.Skip {
+			return filepath.SkipDir
+		}
+		files, err := ioutil.ReadDir(dirPath)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			if isChapterFile(file, extensions) {
+				return nil
+			}
+		}
+		results = append(results, garbage{
+			Name:   sidecarFname,
+			Reason: "sidecar without chapters",
+			remove: func() error { return os.Remove(sidecarFname) },
+		})
+		return nil
+	})
+	return results, err
+}
+
+// Finds state entries of videos which were not uploaded yet and whose
+// chapters or rendered file were deleted. Entries of uploaded videos are kept,
+// since they prevent uploading them again.
+func findStaleStateEntries(state *State, outputDir string) []garbage {
+	var results []garbage
+	for title, entry := range state.Videos {
+		if entry.Status == StatusUploaded || entry.Status == StatusProcessing {
+			continue
+		}
+		reason := ""
+		if entry.Status == StatusRendered {
+			if _, err := os.Stat(filepath.Join(outputDir, title+VideoExt)); err != nil {
+				reason = "rendered file deleted"
+			}
+		}
+		for _, chapter := range entry.Video.Chapters {
+			if _, err := os.Stat(filepath.Join(entry.Video.Path, chapter.FileName)); err != nil {
+				reason = "chapter " + chapter.FileName + " deleted"
+				break
+			}
+		}
+		if reason == "" {
+			continue
+		}
+		title := title
+		results = append(results, garbage{
+			Name:   title,
+			Reason: "state entry: " + reason,
+			remove: func() error {
+				return state.update(func() {
+					delete(state.Videos, title)
+				})
+			},
+		})
+	}
+	return results
+}
+
+// Lists orphaned artifacts and removes them after confirmation.
+func runGCCommand(args []string) {
+	flags := flag.NewFlagSet("gc", flag.ExitOnError)
+	inputDir := flags.String("input_dir", "", "If set, also looks for sidecars without chapters in this directory.")
+	outputDir := flags.String("output_dir", "", "Directory containing the rendered video files.")
+	olderThan := flags.Duration("older_than", 24*time.Hour, "Only temporary files not modified for this long are removed.")
+	dryRun := flags.Bool("dry_run", false, "If true, only lists what would be removed.")
+	yes := flags.Bool("yes", false, "If true, removes without asking for confirmation.")
+	lockTimeout := flags.Duration("lock_timeout", 0, "How long to wait for another run using the output directory to finish.")
+	readConfig := configFlags(flags)
+	setupLogging := logFlags(flags)
+	flags.Parse(args)
+	setupLogging()
+	if *outputDir == "" {
+		fatalf("--output_dir cannot be empty")
+	}
+	config, err := readConfig()
+	if err != nil {
+		fatal(err)
+	}
+
+	items, err := findStaleTempFiles(*outputDir, *olderThan)
+	if err != nil {
+		fatal(err)
+	}
+	tmpDirs, err := findStaleTempDirs(*olderThan)
+	if err != nil {
+		fatal(err)
+	}
+	items = append(items, tmpDirs...)
+	if *inputDir != "" {
+		sidecars, err := findOrphanedSidecars(*inputDir, config.ChapterExtensions)
+		if err != nil {
+			fatal(err)
+		}
+		items = append(items, sidecars...)
+	}
+	if !*dryRun {
+		release, err := acquireRunLock(*outputDir, *lockTimeout)
+		if err != nil {
+			fatal(err)
+		}
+		defer release()
+	}
+	state, err := loadState(*outputDir)
+	if err != nil {
+		fatal(err)
+	}
+	items = append(items, findStaleStateEntries(state, *outputDir)...)
+
+	if len(items) == 0 {
+		fmt.Println("Nothing to remove.")
+		return
+	}
+	for _, item := range items {
+		fmt.Printf("%s (%s)\n", item.Name, item.Reason)
+	}
+	if *dryRun {
+		return
+	}
+	if !*yes {
+		fmt.Printf("Remove %d items? [y/N] ", len(items))
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if strings.ToLower(strings.TrimSpace(answer)) != "y" {
+			return
+		}
+	}
+	for _, item := range items {
+		if err := item.remove(); err != nil {
+			fatal(err)
+		}
+	}
+	log.Printf(">>> Removed %d items", len(items))
+}
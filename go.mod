@@ -1,3 +1,3 @@
 module github.com/alexcepoi/gopro-uploader
 
-go 1.15
+go 1.21
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// How chapters are grouped into videos.
+const (
+	// One video per folder (default).
+	GroupByFolder = "folder"
+	// One video per recording date, from all folders, for SD card dumps
+	// which were never sorted into folders.
+	GroupByDate = "date"
+	// One video per GoPro file number within a folder, i.e. per recording.
+	GroupByNumber = "number"
+)
+
+// Name of the group of chapters without recording date.
+const undatedGroup = "Undated"
+
+// Returns the key of the group of a chapter, e.g. "2020-07-04" or "0042".
+func groupKey(chapter Chapter, groupBy string) string {
+	switch groupBy {
+	case GroupByDate:
+		if chapter.CreateTime.IsZero() {
+			return undatedGroup
+		}
+		return localTime(chapter.CreateTime).Format("2006-01-02")
+	case GroupByNumber:
+		fileName := path.Base(chapter.FileName)
+		if match := goproFnameRegex.FindStringSubmatch(fileName); len(match) == 3 {
+			return match[2]
+		}
+		// Not a GoPro chapter, a recording of its own.
+		return strings.TrimSuffix(fileName, path.Ext(fileName))
+	}
+	return ""
+}
+
+// Buckets chapters by group key, keeping their order. Returns the keys in
+// sorted order.
+func groupChapters(chapters []Chapter, groupBy string) ([]string, map[string][]Chapter) {
+	var keys []string
+	groups := map[string][]Chapter{}
+	for _, chapter := range chapters {
+		key := groupKey(chapter, groupBy)
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], chapter)
+	}
+	sort.Strings(keys)
+	return keys, groups
+}
+
+// Gap between the chapters of merged folders under which they are rendered
+// together, unless split_gap is set: recordings continued in the next folder.
+const DefaultMergeGap = 2 * time.Minute
+
+// A folder at Config.MergeDepth, collecting the chapters of its subfolders.
+type mergedFolder struct {
+	dir   string
+	video Video
+	data  TitleData
+	// Chapters of each folder, named relative to dir.
+	batches [][]Chapter
+}
+
+// Adds the chapters of dirPath, the merged folder or one of its subfolders.
+func (f *mergedFolder) add(dirPath string, chapters []Chapter) error {
+	rel, err := filepath.Rel(f.dir, dirPath)
+	if err != nil {
+		return err
+	}
+	var batch []Chapter
+	for _, chapter := range chapters {
+		chapter.FileName = filepath.Join(rel, chapter.FileName)
+		batch = append(batch, chapter)
+	}
+	f.batches = append(f.batches, batch)
+	return nil
+}
+
+// Returns the video of the merged folder: the chapters of its folders, ordered
+// by the start of each folder, which keep their own order.
+func (f *mergedFolder) merge() Video {
+	sort.SliceStable(f.batches, func(i, j int) bool {
+		return f.batches[i][0].CreateTime.Before(f.batches[j][0].CreateTime)
+	})
+	video := f.video
+	video.Chapters = nil
+	for _, batch := range f.batches {
+		video.Chapters = append(video.Chapters, batch...)
+	}
+	return video
+}
+
+// Returns the rules splitting merged folders: at gaps between recordings, by
+// default DefaultMergeGap.
+func (r SplitRules) merging() SplitRules {
+	if r.Gap <= 0 {
+		r.Gap = DefaultMergeGap
+	}
+	return r
+}
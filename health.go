@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Interval between two connectivity checks while offline.
+const connectivityPollInterval = time.Minute
+
+var errOffline = errors.New("YouTube API unreachable")
+
+// Verifies that the YouTube API can be reached. Any HTTP response, even an
+// authorization error, means the network and the API are up.
+func checkConnectivity(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", youTubeAPIURL+"/videos", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return errors.New(resp.Status)
+	}
+	return nil
+}
+
+// Waits until the YouTube API can be reached, for at most timeout.
+func waitOnline(ctx context.Context, timeout time.Duration) error {
+	err := checkConnectivity(ctx)
+	if err == nil {
+		return nil
+	}
+	log.Printf(">>> Offline (%v), waiting for connectivity to upload..", err)
+	deadline := time.Now().Add(timeout)
+	for time.Now().Add(connectivityPollInterval).Before(deadline) {
+		if err := sleepContext(ctx, connectivityPollInterval); err != nil {
+			return err
+		}
+		if checkConnectivity(ctx) == nil {
+			log.Printf(">>> Back online, resuming uploads")
+			return nil
+		}
+	}
+	return errOffline
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Boxes below moov which hold other boxes, descended into when rewriting the
+// moov box. Others, e.g. meta, are copied as is.
+var mp4ContainerBoxes = map[string]bool{
+	"moov": true, "trak": true, "mdia": true, "minf": true, "stbl": true, "udta": true, "edts": true,
+}
+
+// Returns the header of a box with a body of the given size.
+func mp4Header(boxType string, bodySize int) []byte {
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint32(header[0:4], uint32(8+bodySize))
+	copy(header[4:8], boxType)
+	return header
+}
+
+// Returns the HMMT box of HiLight tags at the given offsets, as written by
+// GoPro cameras.
+func hmmtBox(hiLights []time.Duration) []byte {
+	body := make([]byte, 4+4*len(hiLights))
+	binary.BigEndian.PutUint32(body[0:4], uint32(len(hiLights)))
+	for ix, offset := range hiLights {
+		binary.BigEndian.PutUint32(body[4+4*ix:8+4*ix], uint32(offset.Milliseconds()))
+	}
+	return append(mp4Header("HMMT", len(body)), body...)
+}
+
+// Rebuilds a box of the in-memory moov box, replacing the HMMT box of
+// moov/udta and relocating chunk offsets with relocate.
+func rebuildMP4Box(data []byte, box mp4Box, parent string, hmmt []byte, relocate func(int64) (int64, error)) ([]byte, error) {
+	raw := data[box.Offset : box.Offset+box.Size]
+	switch {
+	case box.Type == "stco" || box.Type == "co64":
+		width := 4
+		if box.Type == "co64" {
+			width = 8
+		}
+		result := append([]byte(nil), raw...)
+		body := result[box.HeaderSize:]
+		if len(body) < 8 {
+			return nil, fmt.Errorf("Error parsing MP4: invalid %s box", box.Type)
+		}
+		count := int(binary.BigEndian.Uint32(body[4:8]))
+		if len(body) < 8+count*width {
+			return nil, fmt.Errorf("Error parsing MP4: invalid %s box", box.Type)
+		}
+		for ix := 0; ix < count; ix++ {
+			entry := body[8+ix*width : 8+(ix+1)*width]
+			if width == 4 {
+				offset, err := relocate(int64(binary.BigEndian.Uint32(entry)))
+				if err != nil {
+					return nil, err
+				}
+				if offset > math.MaxUint32 {
+					return nil, fmt.Errorf("chunk offset %d does not fit the stco box", offset)
+				}
+				binary.BigEndian.PutUint32(entry, uint32(offset))
+			} else {
+				offset, err := relocate(int64(binary.BigEndian.Uint64(entry)))
+				if err != nil {
+					return nil, err
+				}
+				binary.BigEndian.PutUint64(entry, uint64(offset))
+			}
+		}
+		return result, nil
+	case !mp4ContainerBoxes[box.Type]:
+		return raw, nil
+	}
+	children, err := mp4Children(data, box)
+	if err != nil {
+		return nil, err
+	}
+	var body []byte
+	hasUdta := false
+	for _, child := range children {
+		if box.Type == "udta" && parent == "moov" && child.Type == "HMMT" {
+			continue
+		}
+		hasUdta = hasUdta || child.Type == "udta"
+		rebuilt, err := rebuildMP4Box(data, child, box.Type, hmmt, relocate)
+		if err != nil {
+			return nil, err
+		}
+		body = append(body, rebuilt...)
+	}
+	if box.Type == "udta" && parent == "moov" {
+		body = append(body, hmmt...)
+	}
+	if box.Type == "moov" && !hasUdta {
+		body = append(body, append(mp4Header("udta", len(hmmt)), hmmt...)...)
+	}
+	return append(mp4Header(box.Type, len(body)), body...), nil
+}
+
+// Writes HiLight tags into a rendered video, in the moov/udta/HMMT box GoPro
+// cameras and Quik read them from, since ffmpeg drops it. If fastStart is set,
+// the moov box is also moved before the media data, instead of having ffmpeg
+// do it: the file is rewritten either way, unless its moov box comes last.
+func writeHiLights(fileName string, hiLights []time.Duration, fastStart bool) error {
+	f, err := os.OpenFile(fileName, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	boxes, err := readMP4Boxes(f, 0, info.Size())
+	if err != nil {
+		return err
+	}
+	moovIx := -1
+	for ix, box := range boxes {
+		if box.Type == "moov" {
+			moovIx = ix
+		}
+	}
+	if moovIx < 0 {
+		return fmt.Errorf("Error parsing MP4: no moov box in %s", fileName)
+	}
+	moov := boxes[moovIx]
+	data := make([]byte, moov.Size)
+	if _, err := f.ReadAt(data, moov.Offset); err != nil {
+		return err
+	}
+	local := mp4Box{Type: "moov", Size: moov.Size, HeaderSize: moov.HeaderSize}
+	hmmt := hmmtBox(hiLights)
+
+	// Chunk offsets do not change the size of the moov box, which is rebuilt
+	// once to lay out the file, then with the chunks relocated.
+	rebuilt, err := rebuildMP4Box(data, local, "", hmmt, func(offset int64) (int64, error) { return offset, nil })
+	if err != nil {
+		return err
+	}
+	order := append([]mp4Box(nil), boxes[:moovIx]...)
+	order = append(order, boxes[moovIx+1:]...)
+	at := len(order)
+	if fastStart {
+		for ix, box := range order {
+			if box.Type == "mdat" {
+				at = ix
+				break
+			}
+		}
+		if at > moovIx {
+			at = moovIx
+		}
+	} else {
+		at = moovIx
+	}
+	order = append(order[:at], append([]mp4Box{moov}, order[at:]...)...)
+	offsets := map[int64]int64{}
+	var position int64
+	for _, box := range order {
+		offsets[box.Offset] = position
+		if box.Type == "moov" {
+			position += int64(len(rebuilt))
+		} else {
+			position += box.Size
+		}
+	}
+	relocate := func(offset int64) (int64, error) {
+		for _, box := range boxes {
+			if box.Type != "moov" && offset >= box.Offset && offset < box.Offset+box.Size {
+				return offset - box.Offset + offsets[box.Offset], nil
+			}
+		}
+		return 0, fmt.Errorf("Error parsing MP4: chunk offset %d out of the media data", offset)
+	}
+	if rebuilt, err = rebuildMP4Box(data, local, "", hmmt, relocate); err != nil {
+		return err
+	}
+
+	if at == len(order)-1 {
+		// The moov box is still last, media data does not move.
+		if _, err := f.WriteAt(rebuilt, moov.Offset); err != nil {
+			return err
+		}
+		return f.Truncate(moov.Offset + int64(len(rebuilt)))
+	}
+	tmpFname := filepath.Join(filepath.Dir(fileName), "."+filepath.Base(fileName)+".hilights"+VideoExt)
+	out, err := os.Create(tmpFname)
+	if err != nil {
+		return err
+	}
+	for _, box := range order {
+		if box.Type == "moov" {
+			_, err = out.Write(rebuilt)
+		} else {
+			_, err = io.Copy(out, io.NewSectionReader(f, box.Offset, box.Size))
+		}
+		if err != nil {
+			out.Close()
+			os.Remove(tmpFname)
+			return err
+		}
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmpFname)
+		return err
+	}
+	return os.Rename(tmpFname, fileName)
+}
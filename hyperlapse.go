@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Speed-up of hyperlapses when none is set.
+const DefaultHyperlapseSpeed = 10
+
+// Renders the videos of a folder sped up, e.g. commutes or long drives. Time
+// stamps of descriptions, chapters and announcements follow the shortened
+// timeline. The original audio is dropped, music is kept, see Config.Music.
+type Hyperlapse struct {
+	// How many times faster than recorded, DefaultHyperlapseSpeed if zero.
+	Speed float64 `json:"speed"`
+	// If true, the frames dropped are blended in with minterpolate, which
+	// smooths motion but is much slower to render.
+	Interpolate bool `json:"interpolate"`
+}
+
+func (h *Hyperlapse) validate() error {
+	if h == nil {
+		return nil
+	}
+	if h.Speed != 0 && (h.Speed <= 1 || h.Speed > 1000) {
+		return fmt.Errorf("invalid hyperlapse speed %v, expected more than 1 and at most 1000", h.Speed)
+	}
+	return nil
+}
+
+// Returns how many times faster than recorded the video is rendered, 1 if it
+// is not a hyperlapse.
+func (v Video) speed() float64 {
+	if v.Hyperlapse == nil {
+		return 1
+	}
+	if v.Hyperlapse.Speed == 0 {
+		return DefaultHyperlapseSpeed
+	}
+	return v.Hyperlapse.Speed
+}
+
+// Returns how long footage recorded for d lasts in the rendered video.
+func (v Video) timeline(d time.Duration) time.Duration {
+	return time.Duration(float64(d) / v.speed())
+}
+
+// Drops the hyperlapse of videos it cannot be applied to: 360 videos, whose
+// streams are copied, and picture-in-picture ones, whose overlays are
+// matched to the recording time. Hyperlapses have no inserts, which would be
+// sped up too.
+func (v *Video) checkHyperlapse() {
+	if v.Hyperlapse == nil {
+		return
+	}
+	if v.is360() || len(v.Overlay) > 0 {
+		warnf(">>> Hyperlapse not applied to %s: 360 and picture-in-picture videos are rendered as recorded", v.Title)
+		v.Hyperlapse = nil
+		return
+	}
+	v.Inserts = nil
+}
+
+// Returns the filter speeding up the video stream, keeping the frame rate of
+// the chapters.
+func (h *Hyperlapse) filter(video Video) string {
+	frameRate := 30.0
+	if len(video.Chapters) > 0 && video.Chapters[0].Resolution.FrameRate > 0 {
+		frameRate = video.Chapters[0].Resolution.FrameRate
+	}
+	if h.Interpolate {
+		return fmt.Sprintf("setpts=PTS/%g,minterpolate=fps=%g:mi_mode=blend", video.speed(), frameRate)
+	}
+	return fmt.Sprintf("setpts=PTS/%g,fps=%g", video.speed(), frameRate)
+}
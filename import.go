@@ -0,0 +1,295 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A chapter file to copy off a card.
+type importFile struct {
+	src, dst string
+	size     int64
+	modTime  time.Time
+}
+
+// Lists the chapter files found on a card that are not in destDir yet.
+func findImportFiles(fromDir, destDir string, extensions []string) ([]importFile, error) {
+	var files []importFile
+	seen := map[string]string{}
+	err := filepath.Walk(fromDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !isChapterFile(info, extensions) {
+			return nil
+		}
+		if other, ok := seen[info.Name()]; ok {
+			return fmt.Errorf("Error importing %s: same file name as %s", path, other)
+		}
+		seen[info.Name()] = path
+		dst := filepath.Join(destDir, info.Name())
+		if existing, err := os.Stat(dst); err == nil {
+			if existing.Size() == info.Size() {
+				return nil
+			}
+			return fmt.Errorf("Error importing %s: %s exists with a different size", path, dst)
+		}
+		files = append(files, importFile{src: path, dst: dst, size: info.Size(), modTime: info.ModTime()})
+		return nil
+	})
+	return files, err
+}
+
+// Copies a file, keeping its modification time, and returns its SHA-256. The
+// copy is written to a temporary file first, so that interrupted imports are
+// not mistaken for chapters.
+func copyFile(file importFile) (string, error) {
+	in, err := os.Open(file.src)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	tmp := filepath.Join(filepath.Dir(file.dst), "."+filepath.Base(file.dst)+".import")
+	out, err := os.Create(tmp)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(out, hash), in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return "", err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	if err := os.Chtimes(tmp, file.modTime, file.modTime); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), os.Rename(tmp, file.dst)
+}
+
+// A top-level input folder, e.g. a trip, whose videos are all uploaded.
+type coldStorageCandidate struct {
+	Dir string
+	// Bytes of its chapters and renders.
+	Size int64
+	// When its last video was recorded.
+	Recorded time.Time
+}
+
+// Lists the trips whose videos are all uploaded to at least minCopies
+// destinations, oldest first, which could be moved to cold storage to make
+// room.
+func findColdStorageCandidates(state *State, inputDir, outputDir string, minCopies int) []coldStorageCandidate {
+	candidates := map[string]*coldStorageCandidate{}
+	pending := map[string]bool{}
+	for _, entry := range state.Videos {
+		rel, err := filepath.Rel(inputDir, entry.Video.Path)
+		if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		dir := strings.Split(rel, string(filepath.Separator))[0]
+		if !entry.sourcesDeletable(minCopies) {
+			pending[dir] = true
+			continue
+		}
+		candidate, ok := candidates[dir]
+		if !ok {
+			candidate = &coldStorageCandidate{Dir: filepath.Join(inputDir, dir)}
+			candidates[dir] = candidate
+		}
+		candidate.Size += entry.Video.size()
+		for _, variant := range append([]string{""}, entry.Variants...) {
+			if info, err := os.Stat(renderedFile(outputDir, entry.Title, variant)); err == nil {
+				candidate.Size += info.Size()
+			}
+		}
+		if start := entry.Video.startTime(); start.After(candidate.Recorded) {
+			candidate.Recorded = start
+		}
+	}
+	var results []coldStorageCandidate
+	for dir, candidate := range candidates {
+		if _, err := os.Stat(candidate.Dir); pending[dir] || err != nil {
+			continue
+		}
+		results = append(results, *candidate)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Recorded.Before(results[j].Recorded)
+	})
+	return results
+}
+
+// Space needed on a volume by an import.
+type volumeUsage struct {
+	dirs      []string
+	needed    uint64
+	available uint64
+}
+
+// Estimates whether the imported footage and its projected renders fit on
+// their volumes. Renders copy streams, so they take about as much space as
+// their chapters. Returns the missing bytes, or 0 if everything fits.
+func estimateImportSpace(footage uint64, inputDir, outputDir string) (missing uint64, footageFits bool, err error) {
+	volumes := map[string]*volumeUsage{}
+	var order []string
+	add := func(dir string, bytes uint64) error {
+		available, volume, err := diskSpace(dir)
+		if err != nil {
+			return err
+		}
+		usage, ok := volumes[volume]
+		if !ok {
+			usage = &volumeUsage{available: available}
+			volumes[volume] = usage
+			order = append(order, volume)
+		}
+		if !contains(usage.dirs, dir) {
+			usage.dirs = append(usage.dirs, dir)
+		}
+		usage.needed += bytes
+		return nil
+	}
+	if err := add(inputDir, footage); err != nil {
+		return 0, false, err
+	}
+	footageFits = footage <= volumes[order[0]].available
+	if outputDir != "" {
+		if err := add(outputDir, footage); err != nil {
+			return 0, false, err
+		}
+	}
+	for _, volume := range order {
+		usage := volumes[volume]
+		log.Printf(">>> %s: %.1fG needed, %.1fG available", strings.Join(usage.dirs, ", "),
+			float64(usage.needed)/(1<<30), float64(usage.available)/(1<<30))
+		if usage.needed > usage.available {
+			missing += usage.needed - usage.available
+		}
+	}
+	return missing, footageFits, nil
+}
+
+// Copies new chapters off a card into a folder of the input directory, after
+// checking that they and their renders fit.
+func runImportCommand(args []string) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	from := flags.String("from", "", "Directory of the card to import chapters from, e.g. /Volumes/GOPRO/DCIM.")
+	inputDir := flags.String("input_dir", "", "Directory to traverse for video files.")
+	to := flags.String("to", "", "Folder of the input directory to import chapters into, e.g. \"Alps 2024/Day 1\".")
+	outputDir := flags.String("output_dir", "", "If set, also checks that renders fit in this directory, and suggests uploaded folders to move to cold storage.")
+	dryRun := flags.Bool("dry_run", false, "If true, only estimates the space needed.")
+	card := flags.String("card", "", "Label of the card, instead of its volume serial number.")
+	lockTimeout := flags.Duration("lock_timeout", 0, "How long to wait for another run using the output directory to finish.")
+	readConfig := configFlags(flags)
+	setupLogging := logFlags(flags)
+	flags.Parse(args)
+	setupLogging()
+	if *from == "" {
+		fatalf("--from cannot be empty")
+	}
+	if *inputDir == "" {
+		fatalf("--input_dir cannot be empty")
+	}
+	if *to == "" {
+		fatalf("--to cannot be empty")
+	}
+	config, err := readConfig()
+	if err != nil {
+		fatal(err)
+	}
+
+	destDir := filepath.Join(*inputDir, *to)
+	files, err := findImportFiles(*from, destDir, config.ChapterExtensions)
+	if err != nil {
+		fatal(err)
+	}
+	if len(files) == 0 {
+		log.Printf(">>> No new chapters in %s", *from)
+		return
+	}
+	var footage uint64
+	for _, file := range files {
+		footage += uint64(file.size)
+	}
+	log.Printf(">>> Importing %d chapters (%.1fG) into %s", len(files), float64(footage)/(1<<30), destDir)
+
+	missing, footageFits, spaceErr := estimateImportSpace(footage, *inputDir, *outputDir)
+	if spaceErr != nil {
+		warnf(">>> Could not estimate free space: %v", spaceErr)
+	}
+	if missing > 0 {
+		warnf(">>> The footage and its renders need %.1fG more than available", float64(missing)/(1<<30))
+		if *outputDir != "" {
+			state, err := loadState(*outputDir)
+			if err != nil {
+				fatal(err)
+			}
+			var freed uint64
+			for _, candidate := range findColdStorageCandidates(state, *inputDir, *outputDir, config.MinVerifiedCopies) {
+				if freed >= missing {
+					break
+				}
+				log.Printf(">>> Uploaded and verified already, could be moved to cold storage: %s (%.1fG, recorded %s)",
+					candidate.Dir, float64(candidate.Size)/(1<<30), candidate.Recorded.Format("2006-01-02"))
+				freed += uint64(candidate.Size)
+			}
+		}
+	}
+	if *dryRun {
+		return
+	}
+	if spaceErr == nil && !footageFits {
+		fatalf("Not enough space in %s for the footage", destDir)
+	}
+	// Identify the card before copying, it may be ejected before it is done.
+	cardID, err := cardInfo(*from)
+	if *card != "" {
+		cardID.ID = *card
+	} else if err != nil {
+		warnf(">>> %v, the chapters are not recorded as coming from it", err)
+	}
+	if cardID.ID != "" && *outputDir == "" {
+		warnf(">>> Without --output_dir, the chapters are not recorded as coming from card %s", cardID.ID)
+	}
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		fatal(err)
+	}
+	var imported []ImportedFile
+	for _, file := range files {
+		log.Printf(">>> Copying %s", file.src)
+		checksum, err := copyFile(file)
+		if err != nil {
+			fatal(err)
+		}
+		imported = append(imported, ImportedFile{Path: file.dst, Size: file.size, Checksum: checksum})
+	}
+	if cardID.ID == "" || *outputDir == "" {
+		return
+	}
+	release, err := acquireRunLock(*outputDir, *lockTimeout)
+	if err != nil {
+		fatal(err)
+	}
+	defer release()
+	state, err := loadState(*outputDir)
+	if err != nil {
+		fatal(err)
+	}
+	if err := state.recordImport(cardID, imported); err != nil {
+		fatal(err)
+	}
+	log.Printf(">>> Recorded %d chapters as imported from card %s", len(imported), cardID.ID)
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Asks questions on the terminal.
+type wizard struct {
+	in *bufio.Reader
+}
+
+// Asks a question, returning the answer or the default if none is given.
+func (w *wizard) ask(question, defaultValue string) string {
+	if defaultValue != "" {
+		fmt.Printf("%s [%s]: ", question, defaultValue)
+	} else {
+		fmt.Printf("%s: ", question)
+	}
+	answer, err := w.in.ReadString('\n')
+	if err != nil && answer == "" {
+		fatalf("Aborted")
+	}
+	if answer = strings.TrimSpace(answer); answer != "" {
+		return answer
+	}
+	return defaultValue
+}
+
+// Asks a question until the answer is accepted by check.
+func (w *wizard) askValid(question, defaultValue string, check func(string) error) string {
+	for {
+		answer := w.ask(question, defaultValue)
+		err := check(answer)
+		if err == nil {
+			return answer
+		}
+		fmt.Printf("  %v\n", err)
+	}
+}
+
+// Asks a yes or no question.
+func (w *wizard) confirm(question string, defaultValue bool) bool {
+	choices := "y/N"
+	if defaultValue {
+		choices = "Y/n"
+	}
+	switch strings.ToLower(w.ask(question+" ["+choices+"]", "")) {
+	case "y", "yes":
+		return true
+	case "n", "no":
+		return false
+	}
+	return defaultValue
+}
+
+// Checks that ffmpeg and ffprobe can be run, and returns the version of
+// ffmpeg, e.g. "ffmpeg version 6.1.1".
+func checkFFmpeg() (string, error) {
+	for _, command := range []string{"ffmpeg", "ffprobe"} {
+		if _, err := exec.LookPath(binaryPath(command)); err != nil {
+			return "", fmt.Errorf("Could not find %s, install ffmpeg and make sure it is in PATH, or set ffmpeg_path and ffprobe_path", binaryPath(command))
+		}
+		if _, err := checkFFmpegVersion(command); err != nil {
+			return "", err
+		}
+	}
+	return checkFFmpegVersion("ffmpeg")
+}
+
+// Returns an error unless dirPath is an existing directory.
+func checkDir(dirPath string) error {
+	info, err := os.Stat(dirPath)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dirPath)
+	}
+	return nil
+}
+
+// Returns an error unless ok lists the answer.
+func checkChoice(ok ...string) func(string) error {
+	return func(answer string) error {
+		if !contains(ok, answer) {
+			return fmt.Errorf("expected one of %s", strings.Join(ok, ", "))
+		}
+		return nil
+	}
+}
+
+// Interactively writes a config file, and authorizes uploads.
+func runInitCommand(args []string) {
+	flags := flag.NewFlagSet("init", flag.ExitOnError)
+	configFile := flags.String("config", "", "Path of the config file to write. Defaults to <profile>.json in the user config directory.")
+	profile := flags.String("profile", DefaultProfile, "Named account profile to write the config of.")
+	setupLogging := logFlags(flags)
+	flags.Parse(args)
+	setupLogging()
+	if !isInteractive() {
+		fatalf("init asks questions, run it in a terminal")
+	}
+	w := &wizard{in: bufio.NewReader(os.Stdin)}
+
+	version, err := checkFFmpeg()
+	if err != nil {
+		warnf(">>> %v", err)
+		if !w.confirm("Continue without ffmpeg?", false) {
+			return
+		}
+	} else {
+		log.Printf(">>> Found %s", version)
+	}
+
+	fileName := *configFile
+	if fileName == "" {
+		dir, err := appConfigDir()
+		if err != nil {
+			fatal(err)
+		}
+		fileName = filepath.Join(dir, *profile+".json")
+	}
+	if _, err := os.Stat(fileName); err == nil && !w.confirm(fileName+" exists, overwrite it?", false) {
+		return
+	}
+
+	// Only answered settings are written, the others keep their defaults.
+	values := map[string]interface{}{}
+	values["input_dir"] = w.askValid("Directory of your GoPro footage", "", checkDir)
+	values["output_dir"] = w.askValid("Directory to output rendered videos to", "", func(answer string) error {
+		if answer == "" {
+			return fmt.Errorf("the output directory cannot be empty")
+		}
+		return nil
+	})
+	values["prefix"] = w.askValid("Prefix of video titles, e.g. the year or trip", "", func(answer string) error {
+		if answer == "" {
+			return fmt.Errorf("the prefix cannot be empty")
+		}
+		return nil
+	})
+	fmt.Println("Titles look like \"[<prefix>] <folder> # <subfolder>\", see title_template to change them.")
+	values["privacy"] = w.askValid("Privacy of uploaded videos (private, unlisted, public)", "private",
+		checkChoice("private", "unlisted", "public"))
+
+	authorizeNow := false
+	if w.confirm("Upload videos to YouTube?", true) {
+		fmt.Println("Create an OAuth client ID of type \"Desktop app\" in the Google API console, enable the")
+		fmt.Println("YouTube Data API v3 and download its client secrets.")
+		secretsFile := w.askValid("Path to the client secrets", "", func(answer string) error {
+			_, err := loadClientSecrets(answer)
+			return err
+		})
+		values["client_secrets"] = secretsFile
+		values["auth_flow"] = w.askValid("How to authorize: paste a code, device code, or loopback redirect (paste, device, loopback)",
+			AuthFlowPaste, checkChoice(AuthFlowPaste, AuthFlowDevice, AuthFlowLoopback))
+		authorizeNow = w.confirm("Authorize uploads now?", true)
+	}
+
+	if hook := w.ask("Webhook or Slack URL to notify of uploads and failures (optional)", ""); hook != "" {
+		destination := map[string]string{"type": DestinationWebhook, "url": hook}
+		if u, err := url.Parse(hook); err == nil && u.Host == "hooks.slack.com" {
+			destination["type"] = DestinationSlack
+		}
+		values["destinations"] = []map[string]string{destination}
+	}
+
+	// The config may be used from elsewhere, e.g. cron.
+	for _, key := range []string{"input_dir", "output_dir", "client_secrets"} {
+		value, ok := values[key].(string)
+		if !ok {
+			continue
+		}
+		if abs, err := filepath.Abs(value); err == nil {
+			values[key] = abs
+		}
+	}
+	data, err := json.MarshalIndent(values, "", "  ")
+	if err != nil {
+		fatal(err)
+	}
+	// Check the config the way it will be read before writing it.
+	config := defaultConfig()
+	config.profile = *profile
+	if err := json.Unmarshal(data, config); err != nil {
+		fatal(err)
+	}
+	if err := config.validate(); err != nil {
+		fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(fileName), 0700); err != nil {
+		fatal(err)
+	}
+	if err := ioutil.WriteFile(fileName, append(data, '\n'), 0600); err != nil {
+		fatal(err)
+	}
+	log.Printf(">>> Wrote %s", fileName)
+
+	if authorizeNow {
+		secrets, err := loadClientSecrets(config.ClientSecrets)
+		if err != nil {
+			fatal(err)
+		}
+		store, _, err := newTokenStore(config)
+		if err != nil {
+			fatal(err)
+		}
+		token, err := authorize(context.Background(), secrets, config)
+		if err != nil {
+			fatal(err)
+		}
+		if err := store.save(token); err != nil {
+			fatal(err)
+		}
+		log.Printf(">>> Authorized profile %s", config.profile)
+	}
+
+	run := []string{"bin/gopro-uploader"}
+	if *configFile != "" {
+		run = append(run, "--config", *configFile)
+	} else if *profile != DefaultProfile {
+		run = append(run, "--profile", *profile)
+	}
+	command := strings.Join(run, " ")
+	fmt.Printf("\nPreview what would be rendered with:\n\n  %s --dry_run\n\n", command)
+	if config.ClientSecrets != "" {
+		fmt.Printf("then render and upload with:\n\n  %s --upload\n", command)
+	} else {
+		fmt.Printf("then render with:\n\n  %s\n", command)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// A directory traversed for video files.
+type InputRoot struct {
+	Dir string
+	// Directory titles are relative to: Dir itself, or for directories
+	// matched by a glob pattern, its longest directory without wildcards, so
+	// that e.g. /mnt/footage/2024-* keeps the trip folders in titles.
+	Base string
+}
+
+// Returns the part of a glob pattern before its first wildcard, as a
+// directory.
+func globBase(pattern string) string {
+	// As in filepath.Match, backslashes only escape outside of Windows.
+	magic := `*?[`
+	if runtime.GOOS != "windows" {
+		magic += `\`
+	}
+	dir := pattern
+	for strings.ContainsAny(dir, magic) {
+		dir = filepath.Dir(dir)
+	}
+	return dir
+}
+
+// Expands input directories and glob patterns into the directories to
+// traverse. Directories found through several patterns are traversed once.
+func expandInputDirs(patterns []string) ([]InputRoot, error) {
+	var roots []InputRoot
+	seen := map[string]bool{}
+	for _, pattern := range patterns {
+		base := globBase(pattern)
+		var dirs []string
+		if base == pattern {
+			dirs = []string{pattern}
+		} else {
+			matches, err := filepath.Glob(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("Error parsing input directory pattern %s: %v", pattern, err)
+			}
+			for _, match := range matches {
+				if info, err := os.Stat(match); err == nil && info.IsDir() {
+					dirs = append(dirs, match)
+				}
+			}
+			if len(dirs) == 0 {
+				return nil, fmt.Errorf("No directory matches %s", pattern)
+			}
+			sort.Strings(dirs)
+		}
+		for _, dir := range dirs {
+			if abs, err := filepath.Abs(dir); err == nil && !seen[abs] {
+				seen[abs] = true
+				roots = append(roots, InputRoot{Dir: dir, Base: base})
+			}
+		}
+	}
+	return roots, nil
+}
+
+// Returns the base of the input directory containing dirPath, or dirPath
+// itself if there is none.
+func inputBase(roots []InputRoot, dirPath string) string {
+	for _, root := range roots {
+		if _, err := relativeToRoot(root.Dir, dirPath); err == nil {
+			return root.Base
+		}
+	}
+	return dirPath
+}
+
+// Returns the videos found by traversing all input directories, failing if
+// videos of different directories get the same title.
+func discoverInputs(ctx context.Context, roots []InputRoot, prefix string, config *Config) ([]Video, error) {
+	var videos []Video
+	paths := map[string]string{}
+	for _, root := range roots {
+		found, err := discoverRoot(ctx, root, prefix, config)
+		if err != nil {
+			return nil, err
+		}
+		for _, video := range found {
+			if other, ok := paths[video.Title]; ok && other != video.Path {
+				return nil, fmt.Errorf("Videos of %s and %s are both titled %q, rename a folder or set a sidecar title", other, video.Path, video.Title)
+			}
+			paths[video.Title] = video.Path
+		}
+		videos = append(videos, found...)
+	}
+	return videos, nil
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Defaults of title cards.
+const (
+	DefaultCardDuration = 3 * time.Second
+	// e.g. "2020-07-04 10:12" and the sidecar location on a second line.
+	DefaultCardTemplate = `{{.Time.Format "2006-01-02 15:04"}}{{if .Location}}
+{{.Location}}{{end}}`
+)
+
+// Clips inserted into rendered videos: an intro, an outro, and title cards
+// between chapters. They are encoded to the format of the chapters, so that
+// the concat demuxer can still join them without reencoding the footage.
+type Inserts struct {
+	// Video files played before the first and after the last chapter.
+	Intro string `json:"intro"`
+	Outro string `json:"outro"`
+	// Whether a title card is shown before each chapter but the first.
+	TitleCards bool `json:"title_cards"`
+	// How long cards are shown, e.g. "5s", DefaultCardDuration if empty.
+	CardDuration string `json:"card_duration"`
+	// Template of the text of cards, with the fields of CardData,
+	// DefaultCardTemplate if empty.
+	CardTemplate string `json:"card_template"`
+	// Font of cards, the default font of fontconfig if empty.
+	FontFile string `json:"font_file"`
+}
+
+// Fields available in card templates.
+type CardData struct {
+	// Title of the video, and number of the chapter, from 1.
+	Title  string
+	Number int
+	// File name and recording time of the chapter.
+	FileName string
+	Time     time.Time
+	Date     string
+	// Location set in the sidecar.
+	Location string
+}
+
+// Lengths of the clips inserted into a video, set when it is discovered.
+type InsertDurations struct {
+	Intro time.Duration `json:"intro,omitempty"`
+	Outro time.Duration `json:"outro,omitempty"`
+	// Length of each title card, zero without cards.
+	Card time.Duration `json:"card,omitempty"`
+}
+
+func (i *Inserts) validate() error {
+	if i == nil {
+		return nil
+	}
+	for _, fileName := range []string{i.Intro, i.Outro} {
+		if fileName == "" {
+			continue
+		}
+		if _, err := readMP4Info(fileName); err != nil {
+			return fmt.Errorf("Could not read insert %s: %v", fileName, err)
+		}
+	}
+	if _, err := i.cardDuration(); err != nil {
+		return err
+	}
+	if _, err := i.cardTemplate(); err != nil {
+		return fmt.Errorf("Error parsing card template: %v", err)
+	}
+	return nil
+}
+
+func (i *Inserts) cardDuration() (time.Duration, error) {
+	if i.CardDuration == "" {
+		return DefaultCardDuration, nil
+	}
+	duration, err := time.ParseDuration(i.CardDuration)
+	if err != nil || duration <= 0 {
+		return 0, fmt.Errorf("invalid card duration %q", i.CardDuration)
+	}
+	return duration, nil
+}
+
+func (i *Inserts) cardTemplate() (*template.Template, error) {
+	text := i.CardTemplate
+	if text == "" {
+		text = DefaultCardTemplate
+	}
+	return template.New("card").Option("missingkey=error").Parse(text)
+}
+
+// Returns the lengths of the inserts, nil if there are none.
+func (i *Inserts) durations() (*InsertDurations, error) {
+	if i == nil {
+		return nil, nil
+	}
+	result := &InsertDurations{}
+	for _, insert := range []struct {
+		fileName string
+		duration *time.Duration
+	}{{i.Intro, &result.Intro}, {i.Outro, &result.Outro}} {
+		if insert.fileName == "" {
+			continue
+		}
+		info, err := readMP4Info(insert.fileName)
+		if err != nil {
+			return nil, err
+		}
+		*insert.duration = info.Duration
+	}
+	if i.TitleCards {
+		duration, err := i.cardDuration()
+		if err != nil {
+			return nil, err
+		}
+		result.Card = duration
+	}
+	if *result == (InsertDurations{}) {
+		return nil, nil
+	}
+	return result, nil
+}
+
+// Returns where each chapter starts in the rendered video, after the intro
+// and title cards, if any.
+func (v Video) chapterOffsets() []time.Duration {
+	var offsets []time.Duration
+	var position time.Duration
+	if v.Inserts != nil {
+		position = v.Inserts.Intro
+	}
+	for ix, chapter := range v.Chapters {
+		if ix > 0 && v.Inserts != nil {
+			position += v.Inserts.Card
+		}
+		offsets = append(offsets, position)
+		position += v.timeline(chapter.Duration)
+	}
+	return offsets
+}
+
+// Returns the length of the rendered video, inserts included.
+func (v Video) renderedDuration() time.Duration {
+	duration := v.timeline(v.duration())
+	if v.Inserts != nil && len(v.Chapters) > 0 {
+		duration += v.Inserts.Intro + v.Inserts.Outro + time.Duration(len(v.Chapters)-1)*v.Inserts.Card
+	}
+	return duration
+}
+
+// Returns the ffmpeg output arguments encoding an insert to the format of the
+// chapters of a video, e.g. H.264 1920x1080 at 59.94 fps with AAC stereo.
+func insertFormatArgs(video Video) []string {
+	resolution := video.Chapters[0].Resolution
+	encoder := []string{"-c:v", "libx264", "-preset", "medium", "-crf", "18", "-pix_fmt", "yuv420p"}
+	if resolution.Codec == "hevc" {
+		encoder = []string{"-c:v", "libx265", "-preset", "medium", "-crf", "20", "-pix_fmt", "yuv420p", "-tag:v", "hvc1"}
+	}
+	frameRate := strconv.FormatFloat(resolution.FrameRate, 'f', -1, 64)
+	args := []string{"-vf", fmt.Sprintf("scale=%[1]d:%[2]d:force_original_aspect_ratio=decrease,pad=%[1]d:%[2]d:(ow-iw)/2:(oh-ih)/2,setsar=1,fps=%[3]s",
+		resolution.Width, resolution.Height, frameRate)}
+	args = append(args, encoder...)
+	return append(args, "-c:a", "aac", "-b:a", audioBitrate, "-ar", "48000", "-ac", "2", "-f", "mp4")
+}
+
+// Encodes an intro or outro to the format of a video. Clips without audio
+// get a silent track, so that all concatenated files have the same streams.
+func renderInsertClip(ctx context.Context, runner Runner, video Video, fileName, outputFname string) error {
+	info, err := readMP4Info(fileName)
+	if err != nil {
+		return err
+	}
+	audio := "0:a:0"
+	args := []string{"-v", "warning", "-i", fileName}
+	hasAudio := false
+	for _, track := range info.Tracks {
+		hasAudio = hasAudio || track.Handler == "soun"
+	}
+	if !hasAudio {
+		args = append(args, "-f", "lavfi", "-t", fmt.Sprintf("%.3f", info.Duration.Seconds()),
+			"-i", "anullsrc=r=48000:cl=stereo")
+		audio = "1:a:0"
+	}
+	args = append(args, "-map", "0:v:0", "-map", audio)
+	args = append(args, insertFormatArgs(video)...)
+	cmd := exec.CommandContext(ctx, binaryPath("ffmpeg"), append(args, outputFname, "-y")...)
+	cmd.Stderr = os.Stderr
+	return runCommand(runner, cmd)
+}
+
+// Renders the title card shown before a chapter of a video.
+func (i *Inserts) renderCard(ctx context.Context, runner Runner, video Video, ix int, tmpDir, outputFname string) error {
+	tmpl, err := i.cardTemplate()
+	if err != nil {
+		return err
+	}
+	chapter := video.Chapters[ix]
+	data := CardData{
+		Title:    video.Title,
+		Number:   ix + 1,
+		FileName: chapter.FileName,
+		Time:     localTime(chapter.CreateTime),
+		Location: video.Location,
+	}
+	if !data.Time.IsZero() {
+		data.Date = data.Time.Format("2006-01-02")
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return fmt.Errorf("Error expanding card template: %v", err)
+	}
+	// Read from a file, so that the text needs no escaping.
+	textFname := filepath.Join(tmpDir, fmt.Sprintf("card%d.txt", ix))
+	if err := ioutil.WriteFile(textFname, []byte(strings.TrimSpace(b.String())), os.ModePerm); err != nil {
+		return err
+	}
+	resolution := video.Chapters[0].Resolution
+	drawtext := fmt.Sprintf("drawtext=textfile=%s:fontcolor=white:fontsize=%d:line_spacing=%d:x=(w-text_w)/2:y=(h-text_h)/2",
+		escapeFilterPath(textFname), resolution.Height/12, resolution.Height/40)
+	if i.FontFile != "" {
+		drawtext += ":fontfile=" + escapeFilterPath(i.FontFile)
+	}
+	duration := fmt.Sprintf("%.3f", video.Inserts.Card.Seconds())
+	args := []string{"-v", "warning",
+		"-f", "lavfi", "-t", duration, "-i", fmt.Sprintf("color=c=black:s=%dx%d", resolution.Width, resolution.Height),
+		"-f", "lavfi", "-t", duration, "-i", "anullsrc=r=48000:cl=stereo",
+		"-map", "0:v:0", "-map", "1:a:0"}
+	formatArgs := insertFormatArgs(video)
+	// The card is drawn before being formatted like the chapters.
+	formatArgs[1] = drawtext + "," + formatArgs[1]
+	args = append(args, formatArgs...)
+	cmd := exec.CommandContext(ctx, binaryPath("ffmpeg"), append(args, outputFname, "-y")...)
+	cmd.Stderr = os.Stderr
+	return runCommand(runner, cmd)
+}
+
+// Renders the inserts of a video in tmpDir, and returns the concat list
+// entries of the files to concatenate: the intro, the chapters with the title
+// cards between them, then the outro.
+func (i *Inserts) concatLines(ctx context.Context, runner Runner, video Video, tmpDir string) ([]string, error) {
+	dirPath := inputSnapshots.translate(video.Path)
+	var lines []string
+	if i.Intro != "" && video.Inserts.Intro > 0 {
+		fileName := filepath.Join(tmpDir, "intro"+VideoExt)
+		if err := renderInsertClip(ctx, runner, video, i.Intro, fileName); err != nil {
+			return nil, err
+		}
+		lines = append(lines, concatEntry(fileName, Chapter{}))
+	}
+	for ix, chapter := range video.Chapters {
+		if ix > 0 && video.Inserts.Card > 0 {
+			fileName := filepath.Join(tmpDir, fmt.Sprintf("card%d%s", ix, VideoExt))
+			if err := i.renderCard(ctx, runner, video, ix, tmpDir, fileName); err != nil {
+				return nil, err
+			}
+			lines = append(lines, concatEntry(fileName, Chapter{}))
+		}
+		lines = append(lines, concatEntry(path.Join(dirPath, chapter.FileName), chapter))
+	}
+	if i.Outro != "" && video.Inserts.Outro > 0 {
+		fileName := filepath.Join(tmpDir, "outro"+VideoExt)
+		if err := renderInsertClip(ctx, runner, video, i.Outro, fileName); err != nil {
+			return nil, err
+		}
+		lines = append(lines, concatEntry(fileName, Chapter{}))
+	}
+	return lines, nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Everything known about a chapter file.
+type InspectedChapter struct {
+	Chapter
+	Telemetry TelemetrySummary `json:"telemetry"`
+	// Offsets of the HiLight tags from the start of the chapter.
+	HiLights []time.Duration `json:"hilights"`
+}
+
+// Reads the metadata, telemetry and HiLight tags of a chapter.
+func inspectChapter(ctx context.Context, dirPath string, chapter Chapter) (*InspectedChapter, error) {
+	result := &InspectedChapter{Chapter: chapter, HiLights: []time.Duration{}}
+	telemetry, err := fetchTelemetry(ctx, dirPath, chapter)
+	if err != nil {
+		return nil, err
+	}
+	result.Telemetry = telemetry.summary()
+	hiLights, err := readHiLights(filepath.Join(dirPath, chapter.FileName))
+	if err != nil {
+		return nil, err
+	}
+	result.HiLights = append(result.HiLights, hiLights...)
+	return result, nil
+}
+
+// Returns the chapters of a directory, or the given chapter file.
+func chaptersAt(ctx context.Context, fileName string, extensions []string) (string, []Chapter, error) {
+	info, err := os.Stat(fileName)
+	if err != nil {
+		return "", nil, err
+	}
+	if info.IsDir() {
+		chapters, err := getChapters(ctx, fileName, extensions)
+		return fileName, chapters, err
+	}
+	dirPath := filepath.Dir(fileName)
+	chapter, err := fetchChapter(ctx, dirPath, info.Name())
+	if err != nil {
+		return "", nil, err
+	}
+	chapter.Size = info.Size()
+	chapters := []Chapter{*chapter}
+	fillCreateTimes(ctx, dirPath, chapters, map[string]time.Time{info.Name(): info.ModTime()})
+	return dirPath, chapters, nil
+}
+
+// Dumps the metadata of the chapters found at a path, without rendering.
+func runInspectCommand(args []string) {
+	flags := flag.NewFlagSet("inspect", flag.ExitOnError)
+	format := flags.String("format", "text", "Output format: text or json.")
+	readConfig := configFlags(flags)
+	setupLogging := logFlags(flags)
+	paths := parseInterspersed(flags, args)
+	setupLogging()
+	if len(paths) != 1 {
+		fatalf("Usage: inspect <directory-or-file> [--format json]")
+	}
+	if *format != "text" && *format != "json" {
+		fatalf("--format must be text or json")
+	}
+	config, err := readConfig()
+	if err != nil {
+		fatal(err)
+	}
+	checkDependencies("ffprobe", "ffmpeg")
+	ctx, stop := interruptContext()
+	defer stop()
+
+	dirPath, chapters, err := chaptersAt(ctx, paths[0], config.ChapterExtensions)
+	if err != nil {
+		fatal(err)
+	}
+	results := []*InspectedChapter{}
+	for _, chapter := range chapters {
+		result, err := inspectChapter(ctx, dirPath, chapter)
+		if err != nil {
+			fatal(err)
+		}
+		results = append(results, result)
+	}
+
+	if *format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(results); err != nil {
+			fatal(err)
+		}
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CHAPTER\tRECORDED\tDURATION\tSIZE\tRESOLUTION\tCODEC\tFPS\tGPS\tDISTANCE\tMAX SPEED\tHILIGHTS")
+	for _, result := range results {
+		gps := "-"
+		if fix := result.Telemetry.FirstFix; fix != nil {
+			gps = fmt.Sprintf("%.5f,%.5f", fix.Latitude, fix.Longitude)
+		}
+		var hiLights []string
+		for _, offset := range result.HiLights {
+			hiLights = append(hiLights, fmtDurationForYouTube(offset))
+		}
+		if len(hiLights) == 0 {
+			hiLights = []string{"-"}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%.1fG\t%dx%d\t%s\t%.2f\t%s\t%.1fkm\t%.0fkm/h\t%s\n",
+			result.FileName, result.CreateTime.Local().Format("2006-01-02 15:04:05"),
+			result.Duration.Round(time.Second), float64(result.Size)/(1<<30),
+			result.Resolution.Width, result.Resolution.Height, result.Resolution.Codec,
+			result.Resolution.FrameRate, gps, result.Telemetry.Distance/1000,
+			result.Telemetry.MaxSpeed*3.6, strings.Join(hiLights, ","))
+	}
+	w.Flush()
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"bytes"
+	"os/exec"
+)
+
+func keychainAvailable() bool {
+	_, err := exec.LookPath("security")
+	return err == nil
+}
+
+func keychainGet(service, account string) ([]byte, error) {
+	out, err := exec.Command("security", "find-generic-password", "-s", service, "-a", account, "-w").Output()
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(out, []byte("\n")), nil
+}
+
+// The secret is passed on the command line, as security cannot read it from
+// stdin without prompting; it is only visible to the user's own processes
+// while the command runs.
+func keychainSet(service, account string, data []byte) error {
+	return exec.Command("security", "add-generic-password", "-U", "-s", service, "-a", account, "-w", string(data)).Run()
+}
+
+func keychainDelete(service, account string) error {
+	return exec.Command("security", "delete-generic-password", "-s", service, "-a", account).Run()
+}
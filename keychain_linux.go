@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+)
+
+// Secret Service is accessed through secret-tool (libsecret-tools), which
+// talks to GNOME Keyring or KWallet over D-Bus.
+func keychainAvailable() bool {
+	if _, err := exec.LookPath("secret-tool"); err != nil {
+		return false
+	}
+	// Fails without a D-Bus session, e.g. over SSH.
+	return exec.Command("secret-tool", "search", "service", keychainService).Run() == nil
+}
+
+func keychainGet(service, account string) ([]byte, error) {
+	out, err := exec.Command("secret-tool", "lookup", "service", service, "account", account).Output()
+	if err != nil {
+		return nil, err
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("No %s secret for %s", service, account)
+	}
+	return out, nil
+}
+
+func keychainSet(service, account string, data []byte) error {
+	cmd := exec.Command("secret-tool", "store", "--label", service+" ("+account+")",
+		"service", service, "account", account)
+	cmd.Stdin = bytes.NewReader(data)
+	return cmd.Run()
+}
+
+func keychainDelete(service, account string) error {
+	return exec.Command("secret-tool", "clear", "service", service, "account", account).Run()
+}
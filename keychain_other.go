@@ -0,0 +1,24 @@
+//go:build !darwin && !linux && !windows
+// +build !darwin,!linux,!windows
+
+package main
+
+import "errors"
+
+var errNoKeychain = errors.New("No keychain support on this platform")
+
+func keychainAvailable() bool {
+	return false
+}
+
+func keychainGet(service, account string) ([]byte, error) {
+	return nil, errNoKeychain
+}
+
+func keychainSet(service, account string, data []byte) error {
+	return errNoKeychain
+}
+
+func keychainDelete(service, account string) error {
+	return errNoKeychain
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// Windows Credential Manager, through the advapi32 Cred* functions.
+var (
+	advapi32        = syscall.NewLazyDLL("advapi32.dll")
+	procCredReadW   = advapi32.NewProc("CredReadW")
+	procCredWriteW  = advapi32.NewProc("CredWriteW")
+	procCredDeleteW = advapi32.NewProc("CredDeleteW")
+	procCredFree    = advapi32.NewProc("CredFree")
+)
+
+const (
+	credTypeGeneric         = 1
+	credPersistLocalMachine = 2
+)
+
+// CREDENTIALW
+type credential struct {
+	Flags              uint32
+	Type               uint32
+	TargetName         *uint16
+	Comment            *uint16
+	LastWritten        syscall.Filetime
+	CredentialBlobSize uint32
+	CredentialBlob     *byte
+	Persist            uint32
+	AttributeCount     uint32
+	Attributes         uintptr
+	TargetAlias        *uint16
+	UserName           *uint16
+}
+
+func keychainAvailable() bool {
+	return procCredReadW.Find() == nil
+}
+
+func keychainGet(service, account string) ([]byte, error) {
+	target, err := syscall.UTF16PtrFromString(service + ":" + account)
+	if err != nil {
+		return nil, err
+	}
+	var cred *credential
+	if ret, _, err := procCredReadW.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0,
+		uintptr(unsafe.Pointer(&cred))); ret == 0 {
+		return nil, err
+	}
+	defer procCredFree.Call(uintptr(unsafe.Pointer(cred)))
+	blob := (*[1 << 20]byte)(unsafe.Pointer(cred.CredentialBlob))[:cred.CredentialBlobSize:cred.CredentialBlobSize]
+	return append([]byte(nil), blob...), nil
+}
+
+func keychainSet(service, account string, data []byte) error {
+	target, err := syscall.UTF16PtrFromString(service + ":" + account)
+	if err != nil {
+		return err
+	}
+	user, err := syscall.UTF16PtrFromString(account)
+	if err != nil {
+		return err
+	}
+	cred := credential{
+		Type:               credTypeGeneric,
+		TargetName:         target,
+		CredentialBlobSize: uint32(len(data)),
+		CredentialBlob:     &data[0],
+		Persist:            credPersistLocalMachine,
+		UserName:           user,
+	}
+	if ret, _, err := procCredWriteW.Call(uintptr(unsafe.Pointer(&cred)), 0); ret == 0 {
+		return err
+	}
+	return nil
+}
+
+func keychainDelete(service, account string) error {
+	target, err := syscall.UTF16PtrFromString(service + ":" + account)
+	if err != nil {
+		return err
+	}
+	if ret, _, err := procCredDeleteW.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0); ret == 0 {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+var videoStatuses = []string{StatusPending, StatusRendered, StatusProcessing, StatusUploaded, StatusFailed}
+
+// Prints all videos known to the state database.
+func runListCommand(args []string) {
+	flags := flag.NewFlagSet("list", flag.ExitOnError)
+	outputDir := flags.String("output_dir", "", "Directory in which rendered video files are output.")
+	status := flags.String("status", "", "If set, only lists videos with these statuses, e.g. pending,rendered.")
+	setupLogging := logFlags(flags)
+	flags.Parse(args)
+	setupLogging()
+	if *outputDir == "" {
+		fatalf("--output_dir cannot be empty")
+	}
+	var statuses []string
+	if *status != "" {
+		statuses = strings.Split(*status, ",")
+		for _, s := range statuses {
+			if !contains(videoStatuses, s) {
+				fatalf("Unknown status %q, must be one of %s", s, strings.Join(videoStatuses, ", "))
+			}
+		}
+	}
+
+	state, err := loadState(*outputDir)
+	if err != nil {
+		fatal(err)
+	}
+	var entries []*VideoState
+	for _, entry := range state.Videos {
+		if len(statuses) == 0 || contains(statuses, entry.Status) {
+			entries = append(entries, entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Title < entries[j].Title
+	})
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STATUS\tDURATION\tSIZE\tRECORDED\tURL\tSOURCE\tTITLE")
+	for _, entry := range entries {
+		videoURL := "-"
+		if entry.VideoID != "" {
+			videoURL = "https://youtu.be/" + entry.VideoID
+		}
+		fmt.Fprintf(w, "%s\t%s\t%.1fG\t%s\t%s\t%s\t%s\n",
+			entry.Status, entry.Video.duration().Round(time.Second),
+			float64(entry.Video.size())/(1<<30),
+			localTime(entry.Video.startTime()).Format("2006-01-02 15:04"), videoURL,
+			entry.Video.Path, entry.Title)
+	}
+	w.Flush()
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// Precisions recording locations can be reduced to.
+const (
+	PrecisionExact = "exact"
+	// One decimal degree, about 10km.
+	PrecisionCity = "city"
+	// Whole degrees, about 100km.
+	PrecisionRegion = "region"
+)
+
+// Rules applied to GPS-derived recording locations before they are published.
+type LocationPrivacy struct {
+	// Places, e.g. home, around which no location is published.
+	Exclude []LocationZone `json:"exclude"`
+	// One of PrecisionExact (default), PrecisionCity or PrecisionRegion.
+	// Altitudes are only published with exact locations.
+	Precision string `json:"precision"`
+}
+
+// A circular area given by its center and radius.
+type LocationZone struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	RadiusKm  float64 `json:"radius_km"`
+}
+
+// Verifies that the rules are usable.
+func (p *LocationPrivacy) validate() error {
+	switch p.Precision {
+	case "", PrecisionExact, PrecisionCity, PrecisionRegion:
+	default:
+		return fmt.Errorf("invalid location precision %q", p.Precision)
+	}
+	for _, zone := range p.Exclude {
+		if math.Abs(zone.Latitude) > 90 || math.Abs(zone.Longitude) > 180 {
+			return fmt.Errorf("invalid excluded location %v,%v", zone.Latitude, zone.Longitude)
+		}
+		if zone.RadiusKm <= 0 {
+			return fmt.Errorf("invalid excluded location radius %v", zone.RadiusKm)
+		}
+	}
+	return nil
+}
+
+// Returns the location to publish for a GPS fix, or nil if it must not be
+// published. Works with nil rules, publishing exact locations.
+func (p *LocationPrivacy) apply(fix GPSSample) *YouTubeLocation {
+	location := &YouTubeLocation{
+		Latitude:  fix.Latitude,
+		Longitude: fix.Longitude,
+		Altitude:  fix.Altitude,
+	}
+	if p == nil {
+		return location
+	}
+	for _, zone := range p.Exclude {
+		center := GPSSample{Latitude: zone.Latitude, Longitude: zone.Longitude}
+		if haversine(fix, center) <= zone.RadiusKm*1000 {
+			return nil
+		}
+	}
+	var scale float64
+	switch p.Precision {
+	case PrecisionCity:
+		scale = 10
+	case PrecisionRegion:
+		scale = 1
+	default:
+		return location
+	}
+	location.Latitude = math.Round(location.Latitude*scale) / scale
+	location.Longitude = math.Round(location.Longitude*scale) / scale
+	location.Altitude = 0
+	return location
+}
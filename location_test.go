@@ -0,0 +1,32 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLocationPrivacyApply(t *testing.T) {
+	fix := GPSSample{Latitude: 44.1789, Longitude: 8.3412, Altitude: 350}
+	home := LocationZone{Latitude: 44.18, Longitude: 8.34, RadiusKm: 1}
+	for _, test := range []struct {
+		name    string
+		privacy *LocationPrivacy
+		want    *YouTubeLocation
+	}{
+		{"no rules", nil, &YouTubeLocation{Latitude: 44.1789, Longitude: 8.3412, Altitude: 350}},
+		{"exact", &LocationPrivacy{Precision: PrecisionExact}, &YouTubeLocation{Latitude: 44.1789, Longitude: 8.3412, Altitude: 350}},
+		{"city", &LocationPrivacy{Precision: PrecisionCity}, &YouTubeLocation{Latitude: 44.2, Longitude: 8.3}},
+		{"region", &LocationPrivacy{Precision: PrecisionRegion}, &YouTubeLocation{Latitude: 44, Longitude: 8}},
+		{"excluded", &LocationPrivacy{Exclude: []LocationZone{home}}, nil},
+		{"excluded before rounding", &LocationPrivacy{Exclude: []LocationZone{home}, Precision: PrecisionRegion}, nil},
+		{
+			"outside excluded zone",
+			&LocationPrivacy{Exclude: []LocationZone{{Latitude: 45, Longitude: 8.34, RadiusKm: 50}}},
+			&YouTubeLocation{Latitude: 44.1789, Longitude: 8.3412, Altitude: 350},
+		},
+	} {
+		if got := test.privacy.apply(fix); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: apply() = %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Interval between two attempts at acquiring a lock file.
+const lockPollInterval = 100 * time.Millisecond
+
+// Acquires a lock file, waiting up to timeout for other holders to release
+// it. Locks older than staleAfter are assumed to be left over by a crashed
+// process and are broken. Returns a function releasing the lock.
+func acquireFileLock(fileName string, timeout, staleAfter time.Duration) (func(), error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		f, err := os.OpenFile(fileName, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			fmt.Fprintf(f, "%d\n", os.Getpid())
+			f.Close()
+			return func() { os.Remove(fileName) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if info, err := os.Stat(fileName); err == nil && time.Since(info.ModTime()) > staleAfter {
+			os.Remove(fileName)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("Timed out waiting for lock %s", fileName)
+		}
+		time.Sleep(lockPollInterval)
+	}
+}
+
+// Name of the lock file held in the output directory while a run uses it.
+const RunLockFileName = ".gopro-uploader.lock"
+
+// Run locks not refreshed for this long are left over by a crashed run.
+const runLockStaleAfter = 5 * time.Minute
+
+// Locks the output directory for the duration of a run, so that overlapping
+// runs (e.g. started by cron) do not render the same videos twice. Waits up
+// to timeout for another run to finish. The lock is refreshed while held, so
+// that long runs are not mistaken for crashed ones. Returns a function
+// releasing the lock.
+func acquireRunLock(outputDir string, timeout time.Duration) (func(), error) {
+	fileName := filepath.Join(outputDir, RunLockFileName)
+	release, err := acquireFileLock(fileName, timeout, runLockStaleAfter)
+	if err != nil {
+		if pid, readErr := ioutil.ReadFile(fileName); readErr == nil {
+			return nil, fmt.Errorf("Another run (pid %s) is using %s, pass --lock_timeout to wait for it",
+				strings.TrimSpace(string(pid)), outputDir)
+		}
+		return nil, err
+	}
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(runLockStaleAfter / 5)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				os.Chtimes(fileName, now, now)
+			}
+		}
+	}()
+	var once sync.Once
+	releaseRun := func() {
+		once.Do(func() {
+			close(done)
+			release()
+		})
+	}
+	fatalHooks = append(fatalHooks, releaseRun)
+	return releaseRun, nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Registers the --verbose, --quiet and --log_format flags, and returns a
+// function setting up logging once flags are parsed. Messages logged with the
+// log package are logged at info level.
+func logFlags(flags *flag.FlagSet) func() {
+	verbose := flags.Bool("verbose", false, "If true, also logs debug messages, such as the ffmpeg commands run.")
+	quiet := flags.Bool("quiet", false, "If true, only logs warnings and errors.")
+	format := flags.String("log_format", "text", "Log format: text or json (one object per line).")
+	return func() {
+		level := slog.LevelInfo
+		if *verbose {
+			level = slog.LevelDebug
+		}
+		if *quiet {
+			level = slog.LevelWarn
+		}
+		var handler slog.Handler
+		switch *format {
+		case "text":
+			handler = &textHandler{w: os.Stderr, level: level, mu: &sync.Mutex{}}
+		case "json":
+			// Progress bars would garble the JSON lines.
+			progressBars.tty = false
+			handler = slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{
+				Level: level,
+				ReplaceAttr: func(groups []string, attr slog.Attr) slog.Attr {
+					// The prefix only helps reading text logs.
+					if attr.Key == slog.MessageKey && len(groups) == 0 {
+						attr.Value = slog.StringValue(strings.TrimPrefix(attr.Value.String(), ">>> "))
+					}
+					return attr
+				},
+			})
+		default:
+			fatalf("--log_format must be text or json")
+		}
+		slog.SetDefault(slog.New(handler))
+	}
+}
+
+// Handler keeping the format of the standard logger, e.g.
+// "2020/07/04 10:12:00 >>> Rendering video.mp4", with the level prepended to
+// warnings and errors and attributes appended as key=value.
+type textHandler struct {
+	w     io.Writer
+	level slog.Level
+	attrs []slog.Attr
+	mu    *sync.Mutex
+}
+
+func (h *textHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *textHandler) Handle(ctx context.Context, r slog.Record) error {
+	var b strings.Builder
+	b.WriteString(r.Time.Format("2006/01/02 15:04:05 "))
+	if r.Level != slog.LevelInfo {
+		b.WriteString(r.Level.String() + " ")
+	}
+	b.WriteString(r.Message)
+	appendAttr := func(attr slog.Attr) bool {
+		fmt.Fprintf(&b, " %s=%v", attr.Key, attr.Value)
+		return true
+	}
+	for _, attr := range h.attrs {
+		appendAttr(attr)
+	}
+	r.Attrs(appendAttr)
+	b.WriteString("\n")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var err error
+	progressBars.write(func() { _, err = io.WriteString(h.w, b.String()) })
+	return err
+}
+
+func (h *textHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &textHandler{w: h.w, level: h.level, attrs: append(append([]slog.Attr{}, h.attrs...), attrs...), mu: h.mu}
+}
+
+// Groups are not used, attributes are logged unqualified.
+func (h *textHandler) WithGroup(name string) slog.Handler {
+	return h
+}
+
+func debugf(format string, args ...interface{}) {
+	slog.Debug(fmt.Sprintf(format, args...))
+}
+
+func warnf(format string, args ...interface{}) {
+	slog.Warn(fmt.Sprintf(format, args...))
+}
+
+// Functions run before exiting on fatal errors, e.g. releasing locks.
+var fatalHooks []func()
+
+// Logs an error and exits, like log.Fatal but at error level so that it is
+// never filtered out.
+func fatal(args ...interface{}) {
+	slog.Error(fmt.Sprint(args...))
+	exitFatal()
+}
+
+func fatalf(format string, args ...interface{}) {
+	slog.Error(fmt.Sprintf(format, args...))
+	exitFatal()
+}
+
+func exitFatal() {
+	for _, hook := range fatalHooks {
+		hook()
+	}
+	os.Exit(1)
+}
+
+// Runs a command with the runner, logging it in verbose mode.
+func runCommand(runner Runner, cmd *exec.Cmd) error {
+	debugf(">>> Running %s", cmd)
+	start := time.Now()
+	err := runner.Run(cmd)
+	debugf(">>> Ran %s in %s", cmd, time.Since(start).Round(time.Millisecond))
+	return err
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Extensions of the files GoPro cameras record next to chapters: a low
+// resolution proxy and a small JPEG thumbnail. They are never rendered.
+const (
+	ExtLRV = ".LRV"
+	ExtTHM = ".THM"
+)
+
+// Whether a file is a low resolution proxy or thumbnail of a chapter.
+func isLowResFile(name string) bool {
+	ext := filepath.Ext(name)
+	return strings.EqualFold(ext, ExtLRV) || strings.EqualFold(ext, ExtTHM)
+}
+
+// Returns the path of the proxy or thumbnail recorded with a chapter, or ""
+// if there is none. Cameras name them after the chapter, e.g. GX010042.THM,
+// except for proxies of HERO6 and later which use a GL prefix, e.g.
+// GL010042.LRV for GX010042.MP4.
+func lowResFile(dirPath, fileName, ext string) string {
+	base := strings.TrimSuffix(fileName, filepath.Ext(fileName))
+	candidates := []string{base}
+	if loc := goproFnameRegex.FindStringIndex(fileName); ext == ExtLRV && loc != nil && loc[0] == 0 {
+		candidates = append(candidates, base[:1]+"L"+base[2:])
+	}
+	for _, candidate := range candidates {
+		for _, name := range []string{candidate + ext, candidate + strings.ToLower(ext)} {
+			fileName := filepath.Join(dirPath, name)
+			if info, err := os.Stat(fileName); err == nil && !info.IsDir() {
+				return fileName
+			}
+		}
+	}
+	return ""
+}
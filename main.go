@@ -2,7 +2,9 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
@@ -22,42 +24,126 @@ import (
 const VideoExt = ".mp4"
 
 // https://community.gopro.com/s/article/GoPro-Camera-File-Naming-Convention
-var goproFnameRegex = regexp.MustCompile(`G[HX](\d{2})(\d{4})\.MP4`)
+var goproFnameRegex = regexp.MustCompile(`(?i)G[HXS](\d{2})(\d{4})\.(?:MP4|360)`)
 
 type VideoResolution struct {
-	Width     int
-	Height    int
-	Codec     string
-	FrameRate float64
+	Width     int     `json:"width"`
+	Height    int     `json:"height"`
+	Codec     string  `json:"codec"`
+	FrameRate float64 `json:"frame_rate"`
 }
 
 type Chapter struct {
-	FileName   string
-	CreateTime time.Time
-	Duration   time.Duration
-	Resolution VideoResolution
+	FileName   string          `json:"file_name"`
+	CreateTime time.Time       `json:"create_time"`
+	Duration   time.Duration   `json:"duration"`
+	Size       int64           `json:"size"`
+	Resolution VideoResolution `json:"resolution"`
+	// Index of the GPMF telemetry stream, or 0 if there is none (stream 0 is
+	// always the video).
+	TelemetryStream int `json:"telemetry_stream"`
+	// ProjectionEAC for 360 footage, empty for flat footage.
+	Projection string `json:"projection,omitempty"`
+	// Serial number of the camera, if known, see Config.Multicam.
+	Camera string `json:"camera,omitempty"`
+	// Stretch of the file rendered, if footage was cut from it, see IdleTrim
+	// and ChapterCut: from InPoint, for Duration. OutPoint is zero if the stretch
+	// lasts until the end of the file, which lasts FileDuration.
+	InPoint      time.Duration `json:"in_point,omitempty"`
+	OutPoint     time.Duration `json:"out_point,omitempty"`
+	FileDuration time.Duration `json:"file_duration,omitempty"`
+	// Create time recorded by the camera, if CreateTime was repaired because
+	// it went backwards, see repairChapterTimes.
+	CameraTime time.Time `json:"camera_time,omitempty"`
+	// Optional labels from the directory sidecar.
+	Locale  string `json:"locale,omitempty"`
+	Speaker string `json:"speaker,omitempty"`
 }
 
 type Video struct {
-	Title    string
-	Path     string
-	Chapters []Chapter
+	Title    string    `json:"title"`
+	Path     string    `json:"path"`
+	Privacy  string    `json:"privacy"`
+	Chapters []Chapter `json:"chapters"`
+	// Template of the description, see videoDescription. The generated
+	// description is used if empty.
+	Description string   `json:"description,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+	// Number of the part and how many there were when the chapters of the
+	// directory were split into several videos, see splitVideo.
+	Part      int `json:"part,omitempty"`
+	PartCount int `json:"part_count,omitempty"`
+	// Chapters of the other cameras, overlaid picture-in-picture, see
+	// MulticamPiP.
+	Overlay []Chapter `json:"overlay,omitempty"`
+	// Location set in the sidecar, shown on title cards.
+	Location string `json:"location,omitempty"`
+	// Clips inserted around and between the chapters, see Config.Inserts.
+	Inserts *InsertDurations `json:"inserts,omitempty"`
+	// Speed-up set in the sidecar, nil if rendered as recorded.
+	Hyperlapse *Hyperlapse `json:"hyperlapse,omitempty"`
 }
 
-// Returns the list of videos present in directory.
-func listRenderedVideos(dirPath string) ([]string, error) {
+// Returns the total duration of the chapters.
+func (v Video) duration() time.Duration {
+	var duration time.Duration
+	for _, chapter := range v.Chapters {
+		duration += chapter.Duration
+	}
+	return duration
+}
+
+// Returns the total size of the chapter files.
+func (v Video) size() int64 {
+	var size int64
+	for _, chapter := range v.Chapters {
+		size += chapter.Size
+	}
+	return size
+}
+
+// Returns the list of videos present in directory, and their variants among
+// variantNames, by title.
+func listRenderedVideos(dirPath string, variantNames []string) ([]string, map[string][]string, error) {
 	files, err := ioutil.ReadDir(dirPath)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	var results []string
+	var names []string
 	for _, file := range files {
-		if strings.HasSuffix(strings.ToLower(file.Name()), VideoExt) {
-			results = append(results, strings.TrimSuffix(file.Name(), VideoExt))
+		// Hidden files are temporary files of renders in progress.
+		if strings.HasSuffix(strings.ToLower(file.Name()), VideoExt) &&
+			!strings.HasPrefix(file.Name(), ".") {
+			names = append(names, strings.TrimSuffix(file.Name(), VideoExt))
 		}
 	}
-	return results, nil
+
+	// Renders of the same video with different settings, e.g. an archive
+	// master and a preset for YouTube, are named <title>.<variant>.mp4. Since
+	// titles may contain dots, e.g. "Day 1.5", a file is only a variant if it
+	// is named after a known variant and <title>.mp4 exists.
+	var results []string
+	variants := map[string][]string{}
+	for _, name := range names {
+		if ix := strings.LastIndex(name, "."); ix > 0 && contains(variantNames, name[ix+1:]) && contains(names, name[:ix]) {
+			variants[name[:ix]] = append(variants[name[:ix]], name[ix+1:])
+			continue
+		}
+		results = append(results, name)
+	}
+	return results, variants, nil
+}
+
+// Returns the file of a rendered video, or of one of its variants if it exists.
+func renderedFile(outputDir, title, variant string) string {
+	if variant != "" {
+		fileName := filepath.Join(outputDir, title+"."+variant+VideoExt)
+		if _, err := os.Stat(fileName); err == nil {
+			return fileName
+		}
+	}
+	return filepath.Join(outputDir, title+VideoExt)
 }
 
 // Parses a string like 60/1 or 15360/256 to determine actual frame rate.
@@ -78,13 +164,102 @@ func parseFrameRate(spec string) (float64, error) {
 }
 
 // Creates a chapter object from file metadata.
-func fetchChapter(dirPath, fileName string) (*Chapter, error) {
-	cmd := exec.Command("ffprobe", "-v", "error", path.Join(dirPath, fileName),
+func fetchChapter(ctx context.Context, dirPath, fileName string) (*Chapter, error) {
+	ctx, span := startSpan(ctx, "probe", "chapter.path", path.Join(dirPath, fileName))
+	chapter, err := probeChapter(ctx, dirPath, fileName)
+	span.end(err)
+	return chapter, err
+}
+
+// Reads the metadata of a chapter from its moov box, falling back to ffprobe
+// for files the MP4 reader does not understand. Fails with errCorruptChapter
+// if neither understands the file.
+func probeChapter(ctx context.Context, dirPath, fileName string) (*Chapter, error) {
+	chapter, err := readChapter(dirPath, fileName)
+	if err == nil {
+		return chapter, nil
+	}
+	debugf(">>> Probing %s with ffprobe: %v", path.Join(dirPath, fileName), err)
+	chapter, probeErr := ffprobeChapter(ctx, dirPath, fileName)
+	if probeErr == nil {
+		return chapter, nil
+	}
+	// Only ffprobe exiting with an error on a file which can be read tells
+	// that the data is bad, rather than e.g. ffprobe being killed.
+	var exitErr *exec.ExitError
+	if errors.As(probeErr, &exitErr) && exitErr.Exited() && ctx.Err() == nil {
+		if err := checkReadable(path.Join(dirPath, fileName)); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %v, ffprobe %v", errCorruptChapter, err, probeErr)
+	}
+	return nil, probeErr
+}
+
+// Creates a chapter object from the moov box of its file.
+func readChapter(dirPath, fileName string) (*Chapter, error) {
+	info, err := readMP4Info(path.Join(dirPath, fileName))
+	if err != nil {
+		return nil, err
+	}
+	var video *mp4Track
+	telemetryStream := 0
+	videoStreams := 0
+	for ix, track := range info.Tracks {
+		if track.Format == "gpmd" {
+			telemetryStream = ix
+		}
+		if track.Handler != "vide" {
+			continue
+		}
+		if mp4CodecNames[track.Format] == "hevc" {
+			videoStreams++
+		}
+		if video == nil {
+			video = &info.Tracks[ix]
+		}
+	}
+	if video == nil {
+		return nil, fmt.Errorf("no video track")
+	}
+	codec, ok := mp4CodecNames[video.Format]
+	if !ok {
+		return nil, fmt.Errorf("unsupported codec %q", video.Format)
+	}
+	width, height := video.codedSize()
+	return &Chapter{
+		FileName:   fileName,
+		Duration:   info.Duration,
+		CreateTime: info.CreateTime,
+		Resolution: VideoResolution{
+			Width:     width,
+			Height:    height,
+			Codec:     codec,
+			FrameRate: video.frameRate(),
+		},
+		TelemetryStream: telemetryStream,
+		Projection:      chapterProjection(fileName, videoStreams),
+		Camera:          info.Camera,
+	}, nil
+}
+
+// GoPro MAX .360 files hold the two halves of an equi-angular cubemap in two
+// HEVC video tracks.
+func chapterProjection(fileName string, videoStreams int) string {
+	if strings.EqualFold(filepath.Ext(fileName), Ext360) && videoStreams >= 2 {
+		return ProjectionEAC
+	}
+	return ""
+}
+
+// Creates a chapter object from the metadata ffprobe reports.
+func ffprobeChapter(ctx context.Context, dirPath, fileName string) (*Chapter, error) {
+	cmd := exec.CommandContext(ctx, binaryPath("ffprobe"), "-v", "error", path.Join(dirPath, fileName),
 		"-print_format", "json", "-show_format", "-show_streams")
 	var stdout bytes.Buffer
 	cmd.Stdout = &stdout
 	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
+	if err := runProbe(cmd); err != nil {
 		return nil, err
 	}
 
@@ -96,10 +271,13 @@ func fetchChapter(dirPath, fileName string) (*Chapter, error) {
 			}
 		}
 		Streams []struct {
-			Coded_width    int
-			Coded_height   int
-			Codec_name     string
-			Avg_frame_rate string
+			Index            int
+			Codec_type       string
+			Codec_tag_string string
+			Coded_width      int
+			Coded_height     int
+			Codec_name       string
+			Avg_frame_rate   string
 		}
 	}
 	if err := json.Unmarshal(stdout.Bytes(), &data); err != nil {
@@ -109,15 +287,30 @@ func fetchChapter(dirPath, fileName string) (*Chapter, error) {
 	if err != nil {
 		return nil, err
 	}
-	createTime, err := time.Parse(time.RFC3339Nano, data.Format.Tags.Creation_time)
-	if err != nil {
-		return nil, err
+	// Missing from files touched by some tools, see fillCreateTimes.
+	var createTime time.Time
+	if data.Format.Tags.Creation_time != "" {
+		createTime, err = time.Parse(time.RFC3339Nano, data.Format.Tags.Creation_time)
+		if err != nil {
+			return nil, err
+		}
 	}
 	frame_rate, err := parseFrameRate(data.Streams[0].Avg_frame_rate)
 	if err != nil {
 		return nil, err
 	}
 
+	telemetryStream := 0
+	videoStreams := 0
+	for _, stream := range data.Streams {
+		if stream.Codec_tag_string == "gpmd" {
+			telemetryStream = stream.Index
+		}
+		if stream.Codec_type == "video" && stream.Codec_name == "hevc" {
+			videoStreams++
+		}
+	}
+
 	return &Chapter{
 		FileName:   fileName,
 		Duration:   duration,
@@ -127,7 +320,10 @@ func fetchChapter(dirPath, fileName string) (*Chapter, error) {
 			Height:    data.Streams[0].Coded_height,
 			Codec:     data.Streams[0].Codec_name,
 			FrameRate: frame_rate,
-		}}, nil
+		},
+		TelemetryStream: telemetryStream,
+		Projection:      chapterProjection(fileName, videoStreams),
+	}, nil
 }
 
 // Determines which chapter was chronologically recorded first.
@@ -153,30 +349,59 @@ func compareChapters(a, b Chapter) bool {
 	return a.CreateTime.Before(b.CreateTime)
 }
 
-// Returns all chapters from a directory (non-recursive).
+// Whether a directory entry is a chapter file, given the chapter extensions.
+func isChapterFile(file os.FileInfo, extensions []string) bool {
+	if file.IsDir() || strings.HasPrefix(file.Name(), ".") || isLowResFile(file.Name()) {
+		return false
+	}
+	ext := filepath.Ext(file.Name())
+	for _, extension := range extensions {
+		if strings.EqualFold(ext, extension) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns all chapters from a directory (non-recursive). Unreadable chapters
+// are skipped, see Quarantine.
 // TODO(alexcepoi): Add support for timelapses.
 // ffmpeg -framerate 60 -pattern_type glob -i '*.JPG' output.mp4
-func getChapters(dirPath string) ([]Chapter, error) {
+func getChapters(ctx context.Context, dirPath string, extensions []string) ([]Chapter, error) {
 	files, err := ioutil.ReadDir(dirPath)
 	if err != nil {
 		return nil, err
 	}
 
 	var results []Chapter
+	modTimes := map[string]time.Time{}
 	for _, file := range files {
-		if !file.IsDir() &&
-			strings.HasSuffix(strings.ToLower(file.Name()), VideoExt) &&
-			!strings.HasPrefix(file.Name(), ".") {
-			chapter, err := fetchChapter(dirPath, file.Name())
+		if isChapterFile(file, extensions) {
+			if file.Size() == 0 {
+				quarantine.add(dirPath, file.Name(), "empty file")
+				continue
+			}
+			chapter, err := fetchChapter(ctx, dirPath, file.Name())
+			if errors.Is(err, errCorruptChapter) {
+				quarantine.add(dirPath, file.Name(), err.Error())
+				continue
+			}
 			if err != nil {
 				return nil, err
 			}
+			chapter.Size = file.Size()
 			results = append(results, *chapter)
+			modTimes[file.Name()] = file.ModTime()
 		}
 	}
+	fillCreateTimes(ctx, dirPath, results, modTimes)
 	sort.Slice(results, func(i, j int) bool {
 		return compareChapters(results[i], results[j])
 	})
+	if repaired := repairChapterTimes(results); len(repaired) > 0 {
+		warnf(">>> Chapters recorded before their predecessors in %s, the camera clock was likely reset: estimated recording times of %s",
+			dirPath, strings.Join(repaired, ", "))
+	}
 	return results, nil
 }
 
@@ -191,15 +416,58 @@ func canUseConcatDemuxer(x, y Chapter) bool {
 	if x.Resolution.Codec != y.Resolution.Codec {
 		return false
 	}
+	if x.Projection != y.Projection {
+		return false
+	}
 	return true
 }
 
+// Where the chapters of a folder are split into several videos, besides
+// changes of settings. Zero values disable a rule.
+type SplitRules struct {
+	// Time between the end of a chapter and the start of the next, e.g. a
+	// morning and an afternoon session.
+	Gap time.Duration
+	// Limits of each video, e.g. those of YouTube uploads.
+	MaxDuration time.Duration
+	MaxSize     int64
+	// If set, chapters of different resolutions are joined and conformed
+	// when rendered.
+	Conform *Conform
+}
+
+// Returns whether two chapters can be rendered in the same video.
+func (r SplitRules) joins(prev, next Chapter) bool {
+	return canUseConcatDemuxer(prev, next) || r.Conform.joins(prev, next)
+}
+
+// Returns whether more than the gap passed between two chapters.
+func (r SplitRules) isSessionGap(prev, next Chapter) bool {
+	if r.Gap <= 0 || prev.CreateTime.IsZero() || next.CreateTime.IsZero() {
+		return false
+	}
+	return next.CreateTime.Sub(prev.CreateTime.Add(prev.Duration)) > r.Gap
+}
+
+// Returns whether adding a chapter to a batch would exceed the limits.
+func (r SplitRules) exceedsLimits(batch []Chapter, next Chapter) bool {
+	duration, size := next.Duration, next.Size
+	for _, chapter := range batch {
+		duration += chapter.Duration
+		size += chapter.Size
+	}
+	return (r.MaxDuration > 0 && duration > r.MaxDuration) || (r.MaxSize > 0 && size > r.MaxSize)
+}
+
 // Splits a video into multiple ones so that ffmpeg concat demuxer can be aplied
-// to all chapters in each video.
-func splitVideo(video Video) []Video {
+// to all chapters in each video, and according to rules. The parts keep the
+// title of the video, see titleParts.
+func splitVideo(video Video, rules SplitRules) []Video {
 	var chapter_batches [][]Chapter
 	for ix, chapter := range video.Chapters {
-		if ix == 0 || !canUseConcatDemuxer(chapter, video.Chapters[ix-1]) {
+		if ix == 0 || !rules.joins(video.Chapters[ix-1], chapter) ||
+			rules.isSessionGap(video.Chapters[ix-1], chapter) ||
+			rules.exceedsLimits(chapter_batches[len(chapter_batches)-1], chapter) {
 			chapter_batches = append(chapter_batches, []Chapter{})
 		}
 		last_batch := &chapter_batches[len(chapter_batches)-1]
@@ -211,74 +479,112 @@ func splitVideo(video Video) []Video {
 	}
 
 	var results []Video
-	for ix, batch := range chapter_batches {
-		results = append(results, Video{
-			Title:    fmt.Sprintf("%s pt %d", video.Title, ix+1),
-			Path:     video.Path,
-			Chapters: batch,
-		})
+	for _, batch := range chapter_batches {
+		part := video
+		part.Chapters = batch
+		results = append(results, part)
 	}
 	return results
 }
 
-// Generates a title for the video based on path.
-func generateVideoTitle(dirPath, rootPath, prefix string) string {
-	var parts []string
-	var part string
-
-	dirPath = path.Clean(dirPath)
-	rootPath = path.Clean(rootPath)
-	for dirPath != "" && dirPath != "/" && dirPath != rootPath {
-		dirPath, part = path.Split(dirPath)
-		dirPath = path.Clean(dirPath)
-		parts = append([]string{part}, parts...)
+// Generates a description for the video based on its chapters.
+func generateVideoDescription(video Video) string {
+	chapters, offsets := video.Chapters, video.chapterOffsets()
+	var lines []string
+	for _, marker := range chapterMarkers(video) {
+		var files []string
+		for _, chapter := range marker.Chapters {
+			files = append(files,
+				fmt.Sprintf("%s [%dx%d @ %06.2f ~ %s]",
+					chapter.FileName,
+					chapter.Resolution.Width,
+					chapter.Resolution.Height,
+					chapter.Resolution.FrameRate,
+					chapter.CreateTime.Format(time.RFC1123)))
+		}
+		lines = append(lines, fmtDurationForYouTube(marker.Start)+" | "+strings.Join(files, ", "))
 	}
-
-	return fmt.Sprintf("[%s] %s", prefix, strings.Join(parts, " # "))
+	if labels := generateLabelsDescription(chapters, offsets); labels != "" {
+		lines = append(lines, "", labels)
+	}
+	if repairs := generateRepairsDescription(chapters); repairs != "" {
+		lines = append(lines, "", repairs)
+	}
+	if trims := generateTrimsDescription(chapters); trims != "" {
+		lines = append(lines, "", trims)
+	}
+	return strings.Join(lines, "\n")
 }
 
-// Generates a description for the video based on its chapters.
-func generateVideoDescription(chapters []Chapter) string {
+// Generates a description block listing where each language and speaker
+// labelled in the sidecar can be found.
+func generateLabelsDescription(chapters []Chapter, offsets []time.Duration) string {
+	var locales, speakers []string
+	localeTimes := map[string][]string{}
+	speakerTimes := map[string][]string{}
+	for ix, chapter := range chapters {
+		timestamp := fmtDurationForYouTube(offsets[ix])
+		if chapter.Locale != "" {
+			if _, ok := localeTimes[chapter.Locale]; !ok {
+				locales = append(locales, chapter.Locale)
+			}
+			localeTimes[chapter.Locale] = append(localeTimes[chapter.Locale], timestamp)
+		}
+		if chapter.Speaker != "" {
+			if _, ok := speakerTimes[chapter.Speaker]; !ok {
+				speakers = append(speakers, chapter.Speaker)
+			}
+			speakerTimes[chapter.Speaker] = append(speakerTimes[chapter.Speaker], timestamp)
+		}
+	}
+
 	var lines []string
-	var startTime time.Duration
-	for _, chapter := range chapters {
-		lines = append(lines,
-			fmt.Sprintf("%s | %s [%dx%d @ %06.2f ~ %s]",
-				fmtDurationForYouTube(startTime),
-				chapter.FileName,
-				chapter.Resolution.Width,
-				chapter.Resolution.Height,
-				chapter.Resolution.FrameRate,
-				chapter.CreateTime.Format(time.RFC1123)))
-		startTime += chapter.Duration
+	if len(locales) > 0 {
+		lines = append(lines, "Languages:")
+		for _, locale := range locales {
+			lines = append(lines, fmt.Sprintf("%s: %s", locale, strings.Join(localeTimes[locale], ", ")))
+		}
+	}
+	if len(speakers) > 0 {
+		lines = append(lines, "Speakers:")
+		for _, speaker := range speakers {
+			lines = append(lines, fmt.Sprintf("%s: %s", speaker, strings.Join(speakerTimes[speaker], ", ")))
+		}
 	}
 	return strings.Join(lines, "\n")
 }
 
 // Write metadata file including chapter information.
 func writeMetadata(video Video, outputFile string) error {
-	chapterStartTimeMs := func(i int, chapters []Chapter) int64 {
-		start := int64(0)
-		for _, chapter := range chapters[:i] {
-			start += chapter.Duration.Milliseconds()
-		}
-		return start
+	offsets := video.chapterOffsets()
+	chapterStartTimeMs := func(i int) int64 {
+		return offsets[i].Milliseconds()
 	}
-	chapterEndTimeMs := func(i int, chapters []Chapter) int64 {
-		return chapterStartTimeMs(i, chapters) + chapters[i].Duration.Milliseconds()
+	chapterEndTimeMs := func(i int) int64 {
+		return chapterStartTimeMs(i) + video.timeline(video.Chapters[i].Duration).Milliseconds()
 	}
 
 	var tmpl = template.Must(template.New("metadata").Funcs(template.FuncMap{
 		"startTimeMs": chapterStartTimeMs,
 		"endTimeMs":   chapterEndTimeMs,
+		"creationTime": func(video Video) string {
+			return video.startTime().UTC().Format(time.RFC3339Nano)
+		},
 	}).Parse(`;FFMETADATA1
 title={{.Title}}
+creation_time={{ creationTime . }}
 {{ range $i, $ch := .Chapters }}
 [CHAPTER]
 TIMEBASE=1/1000
-START={{ startTimeMs $i $.Chapters }}
-END={{ endTimeMs $i $.Chapters  }}
+START={{ startTimeMs $i }}
+END={{ endTimeMs $i }}
 title={{ $ch.FileName }}
+{{- if $ch.Locale }}
+language={{ $ch.Locale }}
+{{- end }}
+{{- if $ch.Speaker }}
+artist={{ $ch.Speaker }}
+{{- end }}
 {{ end  }}`))
 	f, err := os.Create(outputFile)
 	if err != nil {
@@ -287,106 +593,814 @@ title={{ $ch.FileName }}
 	return tmpl.Execute(f, video)
 }
 
-// Renders a video concatenating its chapters.
-func renderVideo(video Video, outputDir string) error {
-	tmpDir, err := ioutil.TempDir("", "gopro-uploader")
+// Returns the videos found by traversing inputDir.
+func discoverVideos(ctx context.Context, inputDir, prefix string, config *Config) ([]Video, error) {
+	return discoverRoot(ctx, InputRoot{Dir: inputDir, Base: inputDir}, prefix, config)
+}
+
+// Returns the videos found by traversing an input directory, titled relative
+// to its base.
+func discoverRoot(ctx context.Context, input InputRoot, prefix string, config *Config) ([]Video, error) {
+	titleTemplate, err := parseTitleTemplate(config.TitleTemplate)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	splitRules, err := config.splitRules()
+	if err != nil {
+		return nil, err
+	}
+	filter, err := config.Filters.compile()
+	if err != nil {
+		return nil, err
+	}
+	inserts, err := config.Inserts.durations()
+	if err != nil {
+		return nil, err
+	}
+	// With --snapshot, chapters are read from the snapshot, but videos keep
+	// their input directory paths.
+	root := inputSnapshots.translate(input.Dir)
+	var videos []Video
+	addSplitVideo := func(video Video, data TitleData, rules SplitRules) error {
+		cameraVideos, cameraData := config.multicamVideos(video, data)
+		for ix, video := range cameraVideos {
+			parts := splitVideo(video, rules)
+			if err := titleParts(titleTemplate, parts, cameraData[ix]); err != nil {
+				return err
+			}
+			for _, part := range parts {
+				part.checkHyperlapse()
+				if filter.keeps(part) {
+					videos = append(videos, part)
+				}
+			}
+		}
+		return nil
+	}
+	addVideo := func(video Video, data TitleData) error {
+		return addSplitVideo(video, data, splitRules)
+	}
+	// Returns the video of a folder, without chapters, and its title data.
+	folderVideo := func(livePath string, sidecar *Sidecar) (Video, TitleData) {
+		video := Video{
+			Path:        livePath,
+			Privacy:     config.privacyFor(livePath, input.Base),
+			Description: sidecar.Description,
+			Tags:        sidecar.Tags,
+			Location:    sidecar.Location,
+			Inserts:     inserts,
+			Hyperlapse:  sidecar.Hyperlapse,
+		}
+		if video.Description == "" {
+			video.Description = config.descriptionTemplate
+		}
+		if sidecar.Privacy != "" {
+			video.Privacy = sidecar.Privacy
+		}
+		data := TitleData{
+			Prefix:   prefix,
+			Dirs:     relativeDirs(livePath, input.Base),
+			Name:     sidecar.Title,
+			Location: sidecar.Location,
+		}
+		if data.Name == "" {
+			data.Name = strings.Join(data.Dirs, " # ")
+		}
+		return video, data
+	}
+	// With group_by date, chapters of all folders, relative to the root.
+	var dated []Chapter
+	// With merge_depth, folders at that depth, with the chapters of their
+	// subfolders.
+	var merged []*mergedFolder
+	err = filepath.Walk(root, func(dirPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		depth := 0
+		if dirPath != root {
+			rel, err := filepath.Rel(root, dirPath)
+			if err != nil {
+				return err
+			}
+			depth = len(strings.Split(filepath.ToSlash(rel), "/"))
+			if config.skipsDir(info.Name(), depth) {
+				return filepath.SkipDir
+			}
+		}
+
+		sidecar, err := loadSidecar(dirPath)
+		if err != nil {
+			return err
+		}
+		if sidecar.Skip {
+			return filepath.SkipDir
+		}
+		livePath := inputSnapshots.live(dirPath)
+		if config.MergeDepth > 0 && depth == config.MergeDepth {
+			video, data := folderVideo(livePath, sidecar)
+			merged = append(merged, &mergedFolder{dir: dirPath, video: video, data: data})
+		}
+		if rel := filterPath(livePath, input.Base); rel != "." {
+			if filter.excludes(rel) {
+				return filepath.SkipDir
+			}
+			if !filter.includes(rel) {
+				return nil
+			}
+		} else if len(filter.include) > 0 {
+			return nil
+		}
+		chapters, err := getChapters(ctx, dirPath, config.ChapterExtensions)
+		if err != nil {
+			return err
+		}
+		if len(chapters) == 0 {
+			return nil
+		}
+		chapters = sidecar.apply(dirPath, chapters)
+		chapters = filter.chapters(dirPath, chapters)
+		chapters = config.TrimIdle.trim(ctx, dirPath, chapters)
+		chapters = snapToKeyframes(dirPath, chapters)
+		if len(chapters) == 0 {
+			return nil
+		}
+		if config.MergeDepth > 0 && depth >= config.MergeDepth {
+			// Folders are walked depth first, the last one merged into is
+			// the ancestor.
+			return merged[len(merged)-1].add(dirPath, chapters)
+		}
+
+		video, data := folderVideo(livePath, sidecar)
+		video.Chapters = chapters
+		switch config.GroupBy {
+		case GroupByDate:
+			rel, err := filepath.Rel(root, dirPath)
+			if err != nil {
+				return err
+			}
+			for _, chapter := range chapters {
+				chapter.FileName = filepath.Join(rel, chapter.FileName)
+				dated = append(dated, chapter)
+			}
+			return nil
+		case GroupByNumber:
+			keys, groups := groupChapters(chapters, GroupByNumber)
+			for _, key := range keys {
+				recording, recordingData := video, data
+				recording.Chapters = groups[key]
+				recordingData.Name = strings.TrimPrefix(data.Name+" # "+key, " # ")
+				if err := addVideo(recording, recordingData); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		return addVideo(video, data)
+	})
+	if err != nil {
+		return nil, err
 	}
-	defer os.RemoveAll(tmpDir)
+	filter.reportSkipped()
+	for _, folder := range merged {
+		if len(folder.batches) == 0 {
+			continue
+		}
+		if err := addSplitVideo(folder.merge(), folder.data, splitRules.merging()); err != nil {
+			return nil, err
+		}
+	}
+	if config.GroupBy != GroupByDate {
+		return videos, nil
+	}
+	keys, groups := groupChapters(dated, GroupByDate)
+	for _, key := range keys {
+		chapters := groups[key]
+		// Folders of several cameras may be interleaved.
+		sort.SliceStable(chapters, func(i, j int) bool {
+			return chapters[i].CreateTime.Before(chapters[j].CreateTime)
+		})
+		video := Video{
+			Path:        input.Dir,
+			Privacy:     config.Privacy,
+			Chapters:    chapters,
+			Description: config.descriptionTemplate,
+			Inserts:     inserts,
+		}
+		if err := addVideo(video, TitleData{Prefix: prefix, Name: key}); err != nil {
+			return nil, err
+		}
+	}
+	return videos, nil
+}
+
+// Renders a video concatenating its chapters with runner, also feeding the
+// preview if one is given. Returns the SHA-256 of the rendered file.
+func renderVideo(ctx context.Context, runner Runner, video Video, outputDir string, config *Config, preview *Preview) (string, error) {
+	tmpDir, removeTmpDir, err := runner.TempDir()
+	if err != nil {
+		return "", err
+	}
+	defer removeTmpDir()
 
 	var inputLines []string
 	for _, chapter := range video.Chapters {
-		inputLines = append(inputLines,
-			fmt.Sprintf("file '%s'", path.Join(video.Path, chapter.FileName)))
+		inputLines = append(inputLines, concatEntry(path.Join(inputSnapshots.translate(video.Path), chapter.FileName), chapter))
+	}
+	if video.Inserts != nil {
+		if config.Inserts == nil {
+			return "", fmt.Errorf("%s was discovered with inserts, which are not configured anymore", video.Title)
+		}
+		if inputLines, err = config.Inserts.concatLines(ctx, runner, video, tmpDir); err != nil {
+			return "", err
+		}
 	}
 
 	inputFname := filepath.Join(tmpDir, "input.txt")
 	if err := ioutil.WriteFile(
 		inputFname, []byte(strings.Join(inputLines, "\n")), os.ModePerm); err != nil {
-		return err
+		return "", err
 	}
 	metadataFname := filepath.Join(tmpDir, "chapters.txt")
 	err = writeMetadata(video, metadataFname)
 	if err != nil {
-		return err
+		return "", err
 	}
 	outputFname := filepath.Join(outputDir, video.Title+VideoExt)
+	// Render to a temporary file, so that interrupted renders are not mistaken
+	// for rendered videos.
+	tmpFname := filepath.Join(outputDir, "."+video.Title+".tmp"+VideoExt)
+	announcementInputs, announcementOutputs, err := announcementArgs(ctx, runner, video, config, tmpDir)
+	if err != nil {
+		return "", err
+	}
 	log.Printf(">>> Rendering %s", outputFname)
-	cmd := exec.Command("ffmpeg", "-v", "warning",
+	args := []string{"-v", "warning",
 		"-f", "concat", "-safe", "0",
 		"-i", inputFname,
-		"-i", metadataFname,
-		"-map_metadata", "1",
-		"-c", "copy", outputFname,
-		"-y", "-stats")
-	cmd.Stdout = os.Stdout
+		"-i", metadataFname}
+	args = append(args, announcementInputs...)
+	// Inputs are the chapters, the metadata, then announcements, if any.
+	audioInputs, audioStream, audioOutputs := config.audioArgs(video, 2+len(announcementInputs)/2)
+	args = append(args, audioInputs...)
+	subtitleStream := 0
+	if config.ChapterAnnouncements == AnnounceCaptions {
+		subtitleStream = 1
+	}
+	// Only commands are printed on dry runs, which leave the output directory
+	// alone.
+	subtitlesDir := outputDir
+	if !runner.Executes() {
+		subtitlesDir = tmpDir
+	}
+	subtitleInputs, subtitleOutputs, err := config.TelemetrySubtitles.args(ctx, video, subtitlesDir,
+		2+len(announcementInputs)/2+countInputs(audioInputs), subtitleStream)
+	if err != nil {
+		return "", err
+	}
+	args = append(args, subtitleInputs...)
+	args = append(args, "-map_metadata", "1", "-c", "copy")
+	args = append(args, config.embeddedMetadataArgs(ctx, video)...)
+	var videoFilters []string
+	if video.Hyperlapse != nil {
+		videoFilters = append(videoFilters, video.Hyperlapse.filter(video))
+	}
+	if config.Conform.appliesTo(video) {
+		videoFilters = append(videoFilters, config.Conform.filter())
+	}
+	if config.TelemetryOverlay.appliesTo(video) {
+		filter, err := config.TelemetryOverlay.filter(ctx, video, config.Conform, tmpDir)
+		if err != nil {
+			return "", err
+		}
+		videoFilters = append(videoFilters, filter)
+	}
+	args = append(args, config.masterVideoFilters(video, videoFilters)...)
+	encoderArgs, _ := config.masterEncoder(video)
+	args = append(args, encoderArgs...)
+	args = append(args, audioOutputs...)
+	if video.is360() {
+		// Both halves of the cubemap, and the spatial audio track.
+		if audioStream == "" {
+			audioStream = "0:a?"
+		}
+		args = append(args, "-map", "0:v:0", "-map", "0:v:1", "-map", audioStream)
+	} else if video.Hyperlapse != nil {
+		// The original audio does not follow the speed-up: only music, if
+		// any, is kept.
+		args = append(args, "-map", "0:v:0")
+		if audioStream != "" {
+			args = append(args, "-map", audioStream)
+		}
+	} else if preview != nil || len(announcementOutputs) > 0 || len(subtitleOutputs) > 0 || audioStream != "" {
+		// The tee muxer and extra streams need streams to be mapped explicitly.
+		if audioStream == "" {
+			audioStream = "0:a:0?"
+		}
+		args = append(args, "-map", "0:v:0", "-map", audioStream)
+	}
+	args = append(args, announcementOutputs...)
+	args = append(args, subtitleOutputs...)
+	// ffmpeg drops the HiLight tags of the chapters, which are written back
+	// once rendered. The moov box is then relocated along with it, rather than
+	// by ffmpeg.
+	var hiLights []time.Duration
+	if runner.Executes() {
+		if hiLights, err = videoHiLights(video); err != nil {
+			warnf(">>> Could not read HiLight tags of %s: %v", video.Title, err)
+		}
+	}
+	fastStart := config.FastStart
+	if len(hiLights) > 0 {
+		fastStart = FastStartOff
+	}
+	if preview != nil {
+		if err := preview.start(video.Title); err != nil {
+			return "", err
+		}
+		defer preview.stop()
+		movflags := ""
+		if fastStart == FastStartInline {
+			movflags = "+faststart"
+		}
+		args = append(args, "-f", "tee", preview.teeOutput(tmpFname, config.movflags(movflags)))
+	} else {
+		if fastStart == FastStartInline {
+			args = append(args, "-movflags", config.movflags("+faststart"))
+		} else if config.EmbedMetadata {
+			args = append(args, "-movflags", config.movflags(""))
+		}
+		args = append(args, "-f", "mp4", tmpFname)
+	}
+	args = append(args, "-y", "-progress", "pipe:1", "-nostats")
+	cmd := exec.CommandContext(ctx, binaryPath("ffmpeg"), args...)
 	cmd.Stderr = os.Stderr
-	return cmd.Run()
+	if err := progressBars.run(runner, cmd, video.Title, video.renderedDuration()); err != nil {
+		os.Remove(tmpFname)
+		return "", err
+	}
+
+	if fastStart == FastStartPostPass {
+		if err := relocateMoov(ctx, runner, tmpFname, config.movflags("+faststart")); err != nil {
+			return "", err
+		}
+	}
+	if len(hiLights) > 0 {
+		if err := writeHiLights(tmpFname, hiLights, config.FastStart != FastStartOff); err != nil {
+			warnf(">>> Could not write HiLight tags of %s: %v", outputFname, err)
+			if config.FastStart != FastStartOff {
+				if err := relocateMoov(ctx, runner, tmpFname, config.movflags("+faststart")); err != nil {
+					return "", err
+				}
+			}
+		}
+	}
+	if !runner.Executes() {
+		// Commands are only printed, there is no render to check or hash.
+		return "", renameOutput(runner, tmpFname, outputFname)
+	}
+	if config.FastStart != FastStartOff {
+		fastStart, err := isFastStart(tmpFname)
+		if err != nil {
+			return "", err
+		}
+		if !fastStart {
+			return "", fmt.Errorf("faststart did not take effect for %s", outputFname)
+		}
+	}
+	// The mp4 muxer seeks back to write the moov box, so its output cannot be
+	// hashed as it is written. It is read again right after, while it is
+	// likely still in the page cache.
+	checksum, err := fileChecksum(tmpFname)
+	if err != nil {
+		return "", err
+	}
+	return checksum, os.Rename(tmpFname, outputFname)
 }
 
 func main() {
-	checkDependencies("ffprobe", "ffmpeg")
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "queue":
+			runQueueCommand(os.Args[2:])
+			return
+		case "remux":
+			runRemuxCommand(os.Args[2:])
+			return
+		case "clip":
+			runClipCommand(os.Args[2:])
+			return
+		case "shorts":
+			runShortsCommand(os.Args[2:])
+			return
+		case "auth":
+			runAuthCommand(os.Args[2:])
+			return
+		case "list":
+			runListCommand(os.Args[2:])
+			return
+		case "inspect":
+			runInspectCommand(os.Args[2:])
+			return
+		case "merge":
+			runMergeCommand(os.Args[2:])
+			return
+		case "gc":
+			runGCCommand(os.Args[2:])
+			return
+		case "import":
+			runImportCommand(os.Args[2:])
+			return
+		case "cards":
+			runCardsCommand(os.Args[2:])
+			return
+		case "init":
+			runInitCommand(os.Args[2:])
+			return
+		case "proxy":
+			runProxyCommand(os.Args[2:])
+			return
+		case "deps":
+			runDepsCommand(os.Args[2:])
+			return
+		}
+	}
+
+	ctx, stop := interruptContext()
+	defer stop()
 
-	inputDir := flag.String("input_dir", "", "Directory to traverse for video files.")
+	var inputDirs stringsFlag
+	flag.Var(&inputDirs, "input_dir", "Directory to traverse for video files, or glob pattern of directories, e.g. '/mnt/footage/2024-*'. Can be repeated.")
 	outputDir := flag.String("output_dir", "", "Directory in which to output rendered video files.")
 	prefix := flag.String("prefix", "", "Prefix to use in all video titles.")
 	dryRun := flag.Bool("dry_run", false, "If true, does not attempt to render videos.")
+	printCommands := flag.Bool("print_commands", false, "If true with --dry_run, prints the commands rendering the discovered videos instead of running them.")
+	emitScriptFname := flag.String("emit_script", "", "If set with --dry_run, writes the commands rendering the discovered videos to this shell script instead of running them, keeping the concat lists and metadata files they read next to it.")
+	preview := flag.Bool("preview", false, "If true, only renders a low resolution review proxy of each discovered video to the proxies folder of the output directory, from the chapters or their LRV files (see low_res_previews).")
+	upload := flag.Bool("upload", false, "If true, uploads rendered videos to YouTube.")
+	readConfig := configFlags(flag.CommandLine)
+	maxUploadRate := flag.String("max_upload_rate", "", "Maximum upload rate in bytes per second, e.g. 2M.")
+	uploadWindow := flag.String("upload_window", "", "Daily time window during which uploads happen, e.g. 01:00-07:00.")
+	offlineTimeout := flag.Duration("offline_timeout", 6*time.Hour, "How long to wait for connectivity before postponing uploads to the next run.")
+	format := flag.String("format", "text", "Format of the listing of discovered videos: text (logged) or json (printed to stdout).")
+	report := flag.String("report", "", "If set, writes an HTML report of the discovered videos to this file.")
+	metricsAddr := flag.String("metrics_addr", "", "If set, serves Prometheus metrics of the run on /metrics on this address, e.g. :9100.")
+	otlpEndpoint := flag.String("otlp_endpoint", otlpEndpointFromEnv(), "If set, exports traces of the run to this OTLP/HTTP endpoint, e.g. http://localhost:4318/v1/traces.")
+	statusAddr := flag.String("status_addr", "", "If set, serves a status page of the run, for phones, on this address, e.g. :8081.")
+	previewAddr := flag.String("preview_addr", "", "Experimental: if set, serves an HLS preview of the video being rendered on this address, e.g. :8080.")
+	supercut := flag.Bool("supercut", false, "If true, also renders one video per top-level folder from its rendered videos.")
+	lockTimeout := flag.Duration("lock_timeout", 0, "How long to wait for another run using the output directory to finish.")
+	timezone := flag.String("timezone", "", "If set, the IANA zone recording times are shown in, in titles and descriptions, e.g. Europe/Paris. Defaults to timezone of the config file, or UTC.")
+	groupBy := flag.String("group_by", "", "How chapters are grouped into videos: folder, date or number. Defaults to group_by of the config file.")
+	maxDepth := flag.Int("max_depth", -1, "If set, only traverses this many levels of folders below the input directories, e.g. 1 for their subfolders. Defaults to max_depth of the config file.")
+	mergeDepth := flag.Int("merge_depth", -1, "If set, renders folders this many levels below the input directories together with their subfolders, e.g. 2 for Trip/Day 1/Hour 1. Defaults to merge_depth of the config file.")
+	since := flag.String("since", "", "If set, only processes videos with a chapter recorded since this date or time, e.g. 2024-05-04.")
+	until := flag.String("until", "", "If set, only processes videos with a chapter recorded until this date (included) or time.")
+	minDuration := flag.String("min_duration", "", "If set, only processes videos lasting at least this long, e.g. 30s.")
+	minChapterDuration := flag.String("min_chapter_duration", "", "If set, leaves chapters shorter than this out of videos, e.g. 5s.")
+	var includeGlobs, excludeGlobs stringsFlag
+	flag.Var(&includeGlobs, "include_glob", "If set, only processes folders matching this glob pattern, e.g. '2024-*'. Can be repeated.")
+	flag.Var(&excludeGlobs, "exclude_glob", "Skips folders matching this glob pattern, e.g. scratch. Can be repeated.")
+	splitGap := flag.String("split_gap", "", "If set, starts a new part when this long passed between two chapters of a folder, e.g. 45m. Defaults to split_gap of the config file.")
+	manifestFname := flag.String("manifest", "", "If set, processes the videos described in this manifest file instead of traversing input directories.")
+	snapshot := flag.Bool("snapshot", false, "If true, renders from a read-only snapshot of the input directory (btrfs, ZFS or APFS), released at the end of the run.")
+	setupLogging := logFlags(flag.CommandLine)
 	flag.Parse()
-	if *inputDir == "" {
-		log.Fatalf("--inputDir cannot be empty")
+	setupLogging()
+	config, err := readConfig()
+	if err != nil {
+		fatal(err)
+	}
+	checkDependencies("ffprobe", "ffmpeg")
+	// Flags take precedence over the config file.
+	if len(inputDirs) == 0 && config.InputDir != "" {
+		inputDirs = stringsFlag{config.InputDir}
 	}
 	if *outputDir == "" {
-		log.Fatalf("--outputDir cannot be empty")
+		*outputDir = config.OutputDir
 	}
 	if *prefix == "" {
-		log.Fatalf("--prefix cannot be empty")
+		*prefix = config.Prefix
+	}
+	if *groupBy != "" {
+		config.GroupBy = *groupBy
+		if err := config.validate(); err != nil {
+			fatal(err)
+		}
+	}
+	if *timezone != "" {
+		config.Timezone = *timezone
+		if err := config.setTimezone(); err != nil {
+			fatal(err)
+		}
+	}
+	if *maxDepth >= 0 {
+		config.MaxDepth = *maxDepth
+	}
+	if *mergeDepth >= 0 {
+		config.MergeDepth = *mergeDepth
+		if err := config.validate(); err != nil {
+			fatal(err)
+		}
+	}
+	if *since != "" {
+		config.Filters.Since = *since
+	}
+	if *until != "" {
+		config.Filters.Until = *until
 	}
+	if *minDuration != "" {
+		config.Filters.MinDuration = *minDuration
+	}
+	if *minChapterDuration != "" {
+		config.Filters.MinChapterDuration = *minChapterDuration
+	}
+	if len(includeGlobs) > 0 {
+		config.Filters.Include = includeGlobs
+	}
+	if len(excludeGlobs) > 0 {
+		config.Filters.Exclude = excludeGlobs
+	}
+	if _, err := config.Filters.compile(); err != nil {
+		fatal(err)
+	}
+	if *splitGap != "" {
+		config.SplitGap = *splitGap
+		if _, err := config.splitRules(); err != nil {
+			fatal(err)
+		}
+	}
+	var manifest *Manifest
+	var roots []InputRoot
+	if *manifestFname != "" {
+		if manifest, err = loadManifest(*manifestFname); err != nil {
+			fatal(err)
+		}
+		if *snapshot {
+			fatalf("--snapshot cannot be used with --manifest")
+		}
+	} else {
+		if len(inputDirs) == 0 {
+			fatalf("--inputDir cannot be empty")
+		}
+		if roots, err = expandInputDirs(inputDirs); err != nil {
+			fatal(err)
+		}
+	}
+	if *outputDir == "" {
+		fatalf("--outputDir cannot be empty")
+	}
+	if *prefix == "" {
+		fatalf("--prefix cannot be empty")
+	}
+	if *format != "text" && *format != "json" {
+		fatalf("--format must be text or json")
+	}
+	if *printCommands && (!*dryRun || *format == "json") {
+		fatalf("--print_commands needs --dry_run, and cannot be used with --format json")
+	}
+	if *emitScriptFname != "" && (!*dryRun || *printCommands) {
+		fatalf("--emit_script needs --dry_run, and cannot be used with --print_commands")
+	}
+	if *otlpEndpoint != "" {
+		tracer = startTracing(*otlpEndpoint, config.profile)
+		defer tracer.stop()
+	}
+	ctx, runSpan := startSpan(ctx, "run", "prefix", *prefix, "upload", *upload, "dry_run", *dryRun)
+	defer runSpan.end(nil)
 
-	err := os.Mkdir(*outputDir, os.ModePerm)
+	err = os.Mkdir(*outputDir, os.ModePerm)
 	if err != nil && !os.IsExist(err) {
-		log.Fatal(err)
+		fatal(err)
 	}
 
-	titles, err := listRenderedVideos(*outputDir)
+	if !*dryRun {
+		release, err := acquireRunLock(*outputDir, *lockTimeout)
+		if err != nil {
+			fatal(err)
+		}
+		defer release()
+	}
+	titles, variants, err := listRenderedVideos(*outputDir, config.variantNames())
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
 
-	err = filepath.Walk(*inputDir, func(dirPath string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if !info.IsDir() {
-			return nil
+	state, err := loadState(*outputDir)
+	if err != nil {
+		fatal(err)
+	}
+	state.setVariants(variants)
+	if *snapshot {
+		for _, root := range roots {
+			snapshot, err := snapshotInputDir(root.Dir)
+			if err != nil {
+				inputSnapshots.remove()
+				fatal(err)
+			}
+			inputSnapshots = append(inputSnapshots, snapshot)
 		}
+		fatalHooks = append(fatalHooks, inputSnapshots.remove)
+		defer inputSnapshots.remove()
+	}
 
-		chapters, err := getChapters(dirPath)
+	var yt *YouTube
+	if *upload && !*dryRun && !*preview {
+		yt, err = connectYouTube(config, state)
 		if err != nil {
-			return err
+			fatal(err)
 		}
-		if len(chapters) == 0 {
-			return nil
+		if *maxUploadRate != "" {
+			yt.maxUploadRate, err = parseByteSize(*maxUploadRate)
+			if err != nil {
+				fatal(err)
+			}
+		}
+	}
+	var window *TimeWindow
+	if *uploadWindow != "" {
+		window, err = parseTimeWindow(*uploadWindow)
+		if err != nil {
+			fatal(err)
 		}
+	}
 
-		videoTitle := generateVideoTitle(dirPath, *inputDir, *prefix)
-		for _, video := range splitVideo(Video{Title: videoTitle, Path: dirPath, Chapters: chapters}) {
-			log.Printf("=== %s\n%v", video.Title, generateVideoDescription(video.Chapters))
+	var videos []Video
+	discoverCtx, span := startSpan(ctx, "discover", "input_dirs", inputDirs.String(), "manifest", *manifestFname)
+	if manifest != nil {
+		videos, err = manifest.videos(discoverCtx, config)
+	} else {
+		videos, err = discoverInputs(discoverCtx, roots, *prefix, config)
+	}
+	span.setAttribute("videos", len(videos))
+	span.end(err)
+	if err != nil {
+		fatal(err)
+	}
+	if manifest == nil {
+		videos = dedupeChapters(videos, config.DedupeChapters)
+	}
+	renamed, err := retitleParts(state, videos, *outputDir, *dryRun)
+	if err != nil {
+		fatal(err)
+	}
+	for oldTitle, newTitle := range renamed {
+		if contains(titles, oldTitle) {
+			titles = append(titles, newTitle)
+		}
+		if _, ok := variants[oldTitle]; ok {
+			variants[newTitle] = variants[oldTitle]
+		}
+	}
+	quarantine.report()
+	if config.QuarantineDir != "" && !*dryRun {
+		quarantine.move(config.QuarantineDir, roots)
+	}
+	switch *format {
+	case "json":
+		if err := writePlan(os.Stdout, buildPlan(ctx, videos, titles, variants, state, *upload, config.MinVerifiedCopies)); err != nil {
+			fatal(err)
+		}
+	default:
+		for _, video := range videos {
+			log.Printf("=== %s\n%v", video.Title, videoDescription(ctx, video))
 			if contains(titles, video.Title) {
 				log.Printf(">>> Already rendered.. skipping..")
-				continue
 			}
-			if *dryRun {
-				continue
+			if len(variants[video.Title]) > 0 {
+				log.Printf(">>> Variants: %s", strings.Join(variants[video.Title], ", "))
 			}
-			err = renderVideo(video, *outputDir)
-			if err != nil {
-				return err
+		}
+		if manifest == nil {
+			for _, group := range findDuplicateFolders(videos, roots) {
+				log.Printf(">>> Possible duplicate folders, see the merge command: %s",
+					strings.Join(group.Dirs, ", "))
 			}
 		}
-		return nil
-	})
-	if err != nil {
-		log.Fatal(err)
+	}
+	if *report != "" {
+		log.Printf(">>> Writing report %s", *report)
+		if err := writeReport(ctx, *report, videos, variants, config.LowResPreviews); err != nil {
+			fatal(err)
+		}
+	}
+	if *dryRun {
+		if *printCommands || *emitScriptFname != "" {
+			runner := printRunner{w: os.Stdout}
+			closeScript := func() error { return nil }
+			if *emitScriptFname != "" {
+				log.Printf(">>> Writing script %s", *emitScriptFname)
+				runner, closeScript, err = emitScript(*emitScriptFname)
+				if err != nil {
+					fatal(err)
+				}
+			}
+			for _, video := range videos {
+				if contains(titles, video.Title) {
+					continue
+				}
+				if _, err := renderVideo(ctx, runner, video, *outputDir, config, nil); err != nil {
+					fatal(err)
+				}
+				if video.is360() {
+					if err := convert360(ctx, runner, video, *outputDir, config); err != nil {
+						fatal(err)
+					}
+				}
+				if len(video.Overlay) > 0 {
+					if err := composePiP(ctx, runner, video, *outputDir, config); err != nil {
+						fatal(err)
+					}
+				}
+				if config.encodesVariant(video) {
+					if err := encodeVariant(ctx, runner, video, *outputDir, config); err != nil {
+						fatal(err)
+					}
+				}
+				uploadFname := renderedFile(*outputDir, video.Title, config.uploadVariant(video))
+				if err := config.ContactSheet.render(ctx, runner, video, uploadFname, *outputDir); err != nil {
+					fatal(err)
+				}
+			}
+			if err := closeScript(); err != nil {
+				fatal(err)
+			}
+		}
+		return
+	}
+	if *preview {
+		if err := os.MkdirAll(filepath.Join(*outputDir, ProxiesDir), os.ModePerm); err != nil {
+			fatal(err)
+		}
+		for _, video := range videos {
+			if err := renderPreviewProxy(ctx, video, *outputDir, config.LowResPreviews); err != nil {
+				fatal(err)
+			}
+		}
+		return
+	}
+
+	for _, video := range videos {
+		state.enqueue(video)
+	}
+	if err := state.save(); err != nil {
+		fatal(err)
+	}
+	pipeline := &Pipeline{
+		config:         config,
+		state:          state,
+		yt:             yt,
+		outputDir:      *outputDir,
+		titles:         titles,
+		uploadWindow:   window,
+		offlineTimeout: *offlineTimeout,
+		notifier:       newNotifier(config),
+		runner:         execRunner{},
+	}
+	if *previewAddr != "" {
+		pipeline.preview, err = startPreview(*previewAddr)
+		if err != nil {
+			fatal(err)
+		}
+	}
+	if *statusAddr != "" {
+		if err := startStatus(*statusAddr, state, config.QueuePriority); err != nil {
+			fatal(err)
+		}
+	}
+	if *metricsAddr != "" {
+		pipeline.metrics, err = startMetrics(*metricsAddr, state)
+		if err != nil {
+			fatal(err)
+		}
+		pipeline.metrics.addDiscovered(len(videos))
+	}
+	if err := pipeline.run(ctx, state.queue(config.QueuePriority)); err != nil {
+		fatal(err)
+	}
+	if *supercut {
+		supercuts, err := buildSupercuts(videos, roots, *outputDir, *prefix, config)
+		if err != nil {
+			fatal(err)
+		}
+		var entries []*VideoState
+		for _, video := range supercuts {
+			entries = append(entries, state.enqueue(video))
+		}
+		pipeline.metrics.addDiscovered(len(entries))
+		if err := pipeline.run(ctx, entries); err != nil {
+			fatal(err)
+		}
+	}
+	if yt != nil && yt.data != nil {
+		log.Printf(">>> Uploaded %.1fG of the %s monthly data cap",
+			float64(yt.data.used())/(1<<30), config.MonthlyDataCap)
+	}
+	if *upload {
+		log.Printf(">>> YouTube quota left for today: %d of %d units",
+			state.Quota.remaining(config.DailyQuota), config.DailyQuota)
 	}
 }
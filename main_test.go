@@ -0,0 +1,149 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestListRenderedVideos(t *testing.T) {
+	variantNames := []string{"youtube", Variant360, VariantPiP}
+	for _, test := range []struct {
+		name         string
+		files        []string
+		want         []string
+		wantVariants map[string][]string
+	}{
+		{name: "empty", wantVariants: map[string][]string{}},
+		{
+			name:         "variants",
+			files:        []string{"A.mp4", "A.youtube.mp4", "A.pip.mp4", "B.mp4"},
+			want:         []string{"A", "B"},
+			wantVariants: map[string][]string{"A": {"pip", "youtube"}},
+		},
+		{
+			name:         "dots in titles",
+			files:        []string{"Day 1.mp4", "Day 1.5.mp4"},
+			want:         []string{"Day 1.5", "Day 1"},
+			wantVariants: map[string][]string{},
+		},
+		{
+			name:         "variant without master",
+			files:        []string{"B.equirect.mp4"},
+			want:         []string{"B.equirect"},
+			wantVariants: map[string][]string{},
+		},
+		{
+			name:         "unknown variant",
+			files:        []string{"A.mp4", "A.draft.mp4"},
+			want:         []string{"A.draft", "A"},
+			wantVariants: map[string][]string{},
+		},
+		{
+			name:         "other files",
+			files:        []string{".A.tmp.mp4", "A.json", "A.srt", "notes.txt"},
+			wantVariants: map[string][]string{},
+		},
+	} {
+		dir := t.TempDir()
+		for _, file := range test.files {
+			if err := ioutil.WriteFile(filepath.Join(dir, file), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		got, variants, err := listRenderedVideos(dir, variantNames)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: listRenderedVideos() = %q, want %q", test.name, got, test.want)
+		}
+		if !reflect.DeepEqual(variants, test.wantVariants) {
+			t.Errorf("%s: listRenderedVideos() variants = %q, want %q", test.name, variants, test.wantVariants)
+		}
+	}
+}
+
+// Returns the number of chapters of each part.
+func partSizes(parts []Video) []int {
+	var results []int
+	for _, part := range parts {
+		results = append(results, len(part.Chapters))
+	}
+	return results
+}
+
+func TestSplitVideoAtSessionGaps(t *testing.T) {
+	start := time.Date(2020, 7, 4, 10, 0, 0, 0, time.UTC)
+	// Chapters lasting a minute, starting the given time after the previous
+	// one ended.
+	chapters := func(gaps ...time.Duration) []Chapter {
+		var results []Chapter
+		at := start
+		for _, gap := range gaps {
+			at = at.Add(gap)
+			results = append(results, Chapter{CreateTime: at, Duration: time.Minute})
+			at = at.Add(time.Minute)
+		}
+		return results
+	}
+	rules := SplitRules{Gap: time.Hour}
+	for _, test := range []struct {
+		name     string
+		rules    SplitRules
+		chapters []Chapter
+		want     []int
+	}{
+		{"empty", rules, nil, nil},
+		{"no gap", rules, chapters(0, 0, 0), []int{3}},
+		{"exactly the gap", rules, chapters(0, time.Hour), []int{2}},
+		{"over the gap", rules, chapters(0, time.Hour+time.Second, 0), []int{1, 2}},
+		{"several sessions", rules, chapters(0, 2*time.Hour, 0, 3*time.Hour), []int{1, 2, 1}},
+		{"no rule", SplitRules{}, chapters(0, 2*time.Hour), []int{2}},
+		{"unknown times", rules, []Chapter{{Duration: time.Minute}, {CreateTime: start, Duration: time.Minute}}, []int{2}},
+	} {
+		parts := splitVideo(Video{Title: "Day 1", Chapters: test.chapters}, test.rules)
+		if got := partSizes(parts); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: splitVideo() parts = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestSplitVideoAtLimits(t *testing.T) {
+	// Chapters of the given sizes in GB, lasting as many minutes.
+	chapters := func(sizes ...int64) []Chapter {
+		var results []Chapter
+		for _, size := range sizes {
+			results = append(results, Chapter{Size: size << 30, Duration: time.Duration(size) * time.Minute})
+		}
+		return results
+	}
+	bySize := SplitRules{MaxSize: 4 << 30}
+	byDuration := SplitRules{MaxDuration: 4 * time.Minute}
+	for _, test := range []struct {
+		name     string
+		rules    SplitRules
+		chapters []Chapter
+		want     []int
+	}{
+		{"empty", bySize, nil, nil},
+		{"under the size", bySize, chapters(1, 2), []int{2}},
+		{"exactly the size", bySize, chapters(1, 3), []int{2}},
+		{"over the size", bySize, chapters(1, 3, 1), []int{2, 1}},
+		{"single chapter over the size", bySize, chapters(5), []int{1}},
+		{"chapter over the size between others", bySize, chapters(1, 5, 1), []int{1, 1, 1}},
+		{"several parts", bySize, chapters(2, 2, 2, 2, 2), []int{2, 2, 1}},
+		{"exactly the duration", byDuration, chapters(2, 2), []int{2}},
+		{"over the duration", byDuration, chapters(2, 2, 1), []int{2, 1}},
+		{"single chapter over the duration", byDuration, chapters(6), []int{1}},
+		{"either limit", SplitRules{MaxSize: 10 << 30, MaxDuration: 4 * time.Minute}, chapters(3, 3), []int{1, 1}},
+		{"no limits", SplitRules{}, chapters(100, 100), []int{2}},
+	} {
+		parts := splitVideo(Video{Title: "Day 1", Chapters: test.chapters}, test.rules)
+		if got := partSizes(parts); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: splitVideo() parts = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// A chapter of a manifest video: the path of its file, absolute or relative
+// to the manifest, and optionally the stretch kept, see ChapterCut. Given as
+// a path alone, or as an object.
+type ManifestChapter struct {
+	Path string `json:"path"`
+	ChapterCut
+}
+
+func (c *ManifestChapter) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &c.Path); err == nil {
+		return nil
+	}
+	type plain ManifestChapter
+	return json.Unmarshal(data, (*plain)(c))
+}
+
+// A video of a manifest, rendered out of exactly its chapters, in order.
+// Options default to those of the config, like for input directories.
+type ManifestVideo struct {
+	// Title of the video as is: the title template does not apply.
+	Title    string            `json:"title"`
+	Chapters []ManifestChapter `json:"chapters"`
+	// Template of the description, see videoDescription.
+	Description string      `json:"description"`
+	Tags        []string    `json:"tags"`
+	Privacy     string      `json:"privacy"`
+	Location    string      `json:"location"`
+	Hyperlapse  *Hyperlapse `json:"hyperlapse"`
+}
+
+// Videos described explicitly rather than discovered in input directories,
+// e.g. compilations of chapters of several folders.
+type Manifest struct {
+	Videos []ManifestVideo `json:"videos"`
+}
+
+// Loads a manifest and checks its videos.
+func loadManifest(fileName string) (*Manifest, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	manifest := &Manifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		// Only the JSON subset of YAML is understood, without a YAML parser.
+		return nil, fmt.Errorf("Error parsing manifest %s, expected JSON: %v", fileName, err)
+	}
+	if err := manifest.validate(filepath.Dir(fileName)); err != nil {
+		return nil, fmt.Errorf("Error parsing manifest %s: %v", fileName, err)
+	}
+	return manifest, nil
+}
+
+// Verifies that the manifest values are usable, resolving chapter paths
+// relative to dir.
+func (m *Manifest) validate(dir string) error {
+	titles := map[string]bool{}
+	for ix := range m.Videos {
+		video := &m.Videos[ix]
+		video.Title = strings.TrimSpace(video.Title)
+		if video.Title == "" || strings.ContainsAny(video.Title, invalidTitleChars()) {
+			return fmt.Errorf("invalid title %q of video %d", video.Title, ix+1)
+		}
+		if titles[video.Title] {
+			return fmt.Errorf("videos are both titled %q", video.Title)
+		}
+		titles[video.Title] = true
+		if len(video.Chapters) == 0 {
+			return fmt.Errorf("%s has no chapters", video.Title)
+		}
+		for jx := range video.Chapters {
+			chapter := &video.Chapters[jx]
+			if !filepath.IsAbs(chapter.Path) {
+				chapter.Path = filepath.Join(dir, chapter.Path)
+			}
+			if err := chapter.validate(); err != nil {
+				return fmt.Errorf("invalid cut of %s: %v", chapter.Path, err)
+			}
+		}
+		switch video.Privacy {
+		case "", "private", "unlisted", "public":
+		default:
+			return fmt.Errorf("invalid privacy %q of %s", video.Privacy, video.Title)
+		}
+		if video.Description != "" {
+			if _, err := executeDescriptionTemplate(context.Background(), video.Description, Video{}); err != nil {
+				return fmt.Errorf("invalid description of %s: %v", video.Title, err)
+			}
+		}
+		if err := video.Hyperlapse.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the longest directory holding all the given files.
+func commonDir(fileNames []string) string {
+	dir := filepath.Dir(fileNames[0])
+	for _, fileName := range fileNames[1:] {
+		for dir != filepath.Dir(dir) {
+			if rel, err := filepath.Rel(dir, fileName); err == nil && !strings.HasPrefix(rel, "..") {
+				break
+			}
+			dir = filepath.Dir(dir)
+		}
+	}
+	return dir
+}
+
+// Returns the videos of a manifest. Chapters of several directories are named
+// relative to the longest directory holding them all, like with group_by
+// date. Chapters are rendered in the order listed, so they must be joinable
+// without splitting the video.
+func (m *Manifest) videos(ctx context.Context, config *Config) ([]Video, error) {
+	rules, err := config.splitRules()
+	if err != nil {
+		return nil, err
+	}
+	inserts, err := config.Inserts.durations()
+	if err != nil {
+		return nil, err
+	}
+	var videos []Video
+	for _, spec := range m.Videos {
+		var paths []string
+		for _, chapter := range spec.Chapters {
+			paths = append(paths, chapter.Path)
+		}
+		dir := commonDir(paths)
+		var chapters []Chapter
+		modTimes := map[string]time.Time{}
+		for _, fileName := range paths {
+			info, err := os.Stat(fileName)
+			if err != nil {
+				return nil, fmt.Errorf("Could not read chapter of %s: %v", spec.Title, err)
+			}
+			rel, err := filepath.Rel(dir, fileName)
+			if err != nil {
+				return nil, err
+			}
+			chapter, err := fetchChapter(ctx, dir, rel)
+			if err != nil {
+				return nil, err
+			}
+			chapter.Size = info.Size()
+			chapters = append(chapters, *chapter)
+			modTimes[rel] = info.ModTime()
+		}
+		fillCreateTimes(ctx, dir, chapters, modTimes)
+		for ix := range chapters {
+			cut := spec.Chapters[ix].ChapterCut
+			if cut.In == "" && cut.Out == "" {
+				continue
+			}
+			cutChapter, ok := cut.apply(chapters[ix])
+			if !ok {
+				return nil, fmt.Errorf("Cut of %s starts past its end (%s)", paths[ix],
+					fmtDurationForYouTube(chapters[ix].Duration))
+			}
+			chapters[ix] = cutChapter
+		}
+		for ix := 1; ix < len(chapters); ix++ {
+			if !rules.joins(chapters[ix-1], chapters[ix]) {
+				return nil, fmt.Errorf("%s and %s of %s cannot be rendered together, set conform in the config",
+					paths[ix-1], paths[ix], spec.Title)
+			}
+		}
+		chapters = config.TrimIdle.trim(ctx, dir, chapters)
+		chapters = snapToKeyframes(dir, chapters)
+		if len(chapters) == 0 {
+			warnf(">>> %s is idle throughout, skipping..", spec.Title)
+			continue
+		}
+
+		video := Video{
+			Title:       spec.Title,
+			Path:        dir,
+			Privacy:     spec.Privacy,
+			Chapters:    chapters,
+			Description: spec.Description,
+			Tags:        spec.Tags,
+			Location:    spec.Location,
+			Inserts:     inserts,
+			Hyperlapse:  spec.Hyperlapse,
+		}
+		if video.Privacy == "" {
+			video.Privacy = config.Privacy
+		}
+		if video.Description == "" {
+			video.Description = config.descriptionTemplate
+		}
+		video.checkHyperlapse()
+		videos = append(videos, video)
+	}
+	return videos, nil
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Folders whose titles only differ in case, spacing or punctuation, e.g.
+// "Alps 2024" and "alps_2024", likely holding footage of the same trip.
+type duplicateFolders struct {
+	Dirs []string
+	// Number of chapters in each folder.
+	Chapters []int
+}
+
+// Reduces a title to lowercase letters and digits separated by single
+// spaces.
+func normalizeTitle(title string) string {
+	words := strings.FieldsFunc(strings.ToLower(title), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.Join(words, " ")
+}
+
+// Groups the folders of the discovered videos which would get near-identical
+// titles.
+func findDuplicateFolders(videos []Video, roots []InputRoot) []duplicateFolders {
+	chapters := map[string]int{}
+	var dirs []string
+	for _, video := range videos {
+		if _, ok := chapters[video.Path]; !ok {
+			dirs = append(dirs, video.Path)
+		}
+		chapters[video.Path] += len(video.Chapters)
+	}
+
+	groups := map[string]*duplicateFolders{}
+	var keys []string
+	for _, dirPath := range dirs {
+		key := normalizeTitle(generateVideoTitle(dirPath, inputBase(roots, dirPath), ""))
+		if groups[key] == nil {
+			groups[key] = &duplicateFolders{}
+			keys = append(keys, key)
+		}
+		groups[key].Dirs = append(groups[key].Dirs, dirPath)
+		groups[key].Chapters = append(groups[key].Chapters, chapters[dirPath])
+	}
+	sort.Strings(keys)
+	var results []duplicateFolders
+	for _, key := range keys {
+		if len(groups[key].Dirs) > 1 {
+			results = append(results, *groups[key])
+		}
+	}
+	return results
+}
+
+// Moves the files of a folder into another one, merging their sidecars.
+// Nothing is moved if a file exists in both.
+func mergeFolder(fromDir, toDir string) error {
+	files, err := ioutil.ReadDir(fromDir)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if file.IsDir() || file.Name() == SidecarFileName {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(toDir, file.Name())); err == nil {
+			return fmt.Errorf("Cannot merge %s into %s: both contain %s", fromDir, toDir, file.Name())
+		}
+	}
+
+	from, err := loadSidecar(fromDir)
+	if err != nil {
+		return err
+	}
+	to, err := loadSidecar(toDir)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if file.IsDir() || file.Name() == SidecarFileName {
+			continue
+		}
+		if err := os.Rename(filepath.Join(fromDir, file.Name()), filepath.Join(toDir, file.Name())); err != nil {
+			return err
+		}
+	}
+	if len(from.Chapters) > 0 || len(from.Exclude) > 0 {
+		if to.Chapters == nil {
+			to.Chapters = map[string]ChapterLabels{}
+		}
+		for name, labels := range from.Chapters {
+			to.Chapters[name] = labels
+		}
+		to.Exclude = append(to.Exclude, from.Exclude...)
+		data, err := json.MarshalIndent(to, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filepath.Join(toDir, SidecarFileName), data, 0644); err != nil {
+			return err
+		}
+	}
+	os.Remove(filepath.Join(fromDir, SidecarFileName))
+	// Only succeeds if nothing else, such as a subfolder, is left.
+	os.Remove(fromDir)
+	return nil
+}
+
+// Drops the renders and state entries of the given videos which were not
+// uploaded yet, so that the merged folder is rendered again from all of its
+// chapters. Returns the titles of uploaded videos, which are kept.
+func resetMergedVideos(state *State, outputDir string, titles, variantNames []string) ([]string, error) {
+	var uploaded, reset []string
+	err := state.update(func() {
+		for _, title := range titles {
+			if entry, ok := state.Videos[title]; ok {
+				if entry.Status == StatusUploaded || entry.Status == StatusProcessing {
+					uploaded = append(uploaded, title)
+					continue
+				}
+				delete(state.Videos, title)
+			}
+			reset = append(reset, title)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rendered, variants, err := listRenderedVideos(outputDir, variantNames)
+	if err != nil {
+		return nil, err
+	}
+	for _, title := range reset {
+		if !contains(rendered, title) {
+			continue
+		}
+		names := []string{title}
+		for _, variant := range variants[title] {
+			names = append(names, title+"."+variant)
+		}
+		for _, name := range names {
+			log.Printf(">>> Removing %s%s", name, VideoExt)
+			if err := os.Remove(filepath.Join(outputDir, name+VideoExt)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return uploaded, nil
+}
+
+// Interactively merges folders likely holding the same footage under one
+// canonical folder, and thus title.
+func runMergeCommand(args []string) {
+	flags := flag.NewFlagSet("merge", flag.ExitOnError)
+	inputDir := flags.String("input_dir", "", "Directory to traverse for video files.")
+	outputDir := flags.String("output_dir", "", "Directory in which rendered video files are output.")
+	prefix := flags.String("prefix", "", "Prefix to use in all video titles.")
+	readConfig := configFlags(flags)
+	lockTimeout := flags.Duration("lock_timeout", 0, "How long to wait for another run using the output directory to finish.")
+	setupLogging := logFlags(flags)
+	flags.Parse(args)
+	setupLogging()
+	if *inputDir == "" {
+		fatalf("--input_dir cannot be empty")
+	}
+	if *outputDir == "" {
+		fatalf("--output_dir cannot be empty")
+	}
+	if *prefix == "" {
+		fatalf("--prefix cannot be empty")
+	}
+	ctx, stop := interruptContext()
+	defer stop()
+
+	config, err := readConfig()
+	if err != nil {
+		fatal(err)
+	}
+	checkDependencies("ffprobe")
+	release, err := acquireRunLock(*outputDir, *lockTimeout)
+	if err != nil {
+		fatal(err)
+	}
+	defer release()
+	state, err := loadState(*outputDir)
+	if err != nil {
+		fatal(err)
+	}
+	videos, err := discoverVideos(ctx, *inputDir, *prefix, config)
+	if err != nil {
+		fatal(err)
+	}
+	if _, err := retitleParts(state, videos, *outputDir, false); err != nil {
+		fatal(err)
+	}
+	groups := findDuplicateFolders(videos, []InputRoot{{Dir: *inputDir, Base: *inputDir}})
+	if len(groups) == 0 {
+		fmt.Println("No duplicate folders found.")
+		return
+	}
+	stdin := bufio.NewReader(os.Stdin)
+	for _, group := range groups {
+		fmt.Println("Possible duplicates:")
+		for ix, dirPath := range group.Dirs {
+			fmt.Printf("  %d) %s (%d chapters)\n", ix+1, dirPath, group.Chapters[ix])
+		}
+		fmt.Printf("Merge into [1-%d], or skip [s]: ", len(group.Dirs))
+		answer, _ := stdin.ReadString('\n')
+		choice, err := strconv.Atoi(strings.TrimSpace(answer))
+		if err != nil || choice < 1 || choice > len(group.Dirs) {
+			continue
+		}
+
+		canonical := group.Dirs[choice-1]
+		var titles []string
+		for _, video := range videos {
+			if contains(group.Dirs, video.Path) {
+				titles = append(titles, video.Title)
+			}
+		}
+		for _, dirPath := range group.Dirs {
+			if dirPath == canonical {
+				continue
+			}
+			log.Printf(">>> Moving %s into %s", dirPath, canonical)
+			if err := mergeFolder(dirPath, canonical); err != nil {
+				fatal(err)
+			}
+		}
+		uploaded, err := resetMergedVideos(state, *outputDir, titles, config.variantNames())
+		if err != nil {
+			fatal(err)
+		}
+		for _, title := range uploaded {
+			log.Printf(">>> %s was uploaded already, it is kept as is", title)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Extension of the metadata files written next to rendered videos, see
+// Config.MetadataFiles.
+const MetadataExt = ".json"
+
+// A chapter of a rendered video, as listed in its metadata file.
+type MetadataChapter struct {
+	FileName string `json:"file_name"`
+	// Offset and length in the rendered video, in seconds.
+	Start      float64   `json:"start"`
+	Duration   float64   `json:"duration"`
+	CreateTime time.Time `json:"create_time"`
+	// SHA-256 of the chapter file.
+	Checksum string `json:"sha256,omitempty"`
+}
+
+// Contents of the metadata file of a rendered video, for other tools to
+// consume the library.
+type VideoMetadata struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	// Length of the rendered video, in seconds.
+	Duration float64           `json:"duration"`
+	Chapters []MetadataChapter `json:"chapters"`
+	// Camera model and telemetry summary, if the chapters have telemetry.
+	Camera    string            `json:"camera,omitempty"`
+	Telemetry *TelemetrySummary `json:"telemetry,omitempty"`
+	// SHA-256 of the rendered file.
+	Checksum string `json:"sha256,omitempty"`
+	// ID of the uploaded video, once uploaded.
+	VideoID string `json:"video_id,omitempty"`
+}
+
+// Writes the metadata file of a rendered video. Checksums of chapter files
+// and the telemetry summary are kept from the previous metadata file, if any,
+// since the chapters may have been deleted once uploaded.
+func writeVideoMetadata(ctx context.Context, entry *VideoState, outputDir string) error {
+	video := entry.Video
+	fileName := filepath.Join(outputDir, video.Title+MetadataExt)
+	var previous VideoMetadata
+	if data, err := ioutil.ReadFile(fileName); err == nil {
+		json.Unmarshal(data, &previous)
+	}
+	checksums := map[string]string{}
+	for _, chapter := range previous.Chapters {
+		checksums[chapter.FileName] = chapter.Checksum
+	}
+	metadata := VideoMetadata{
+		Title:       video.Title,
+		Description: youtubeDescription(videoDescription(ctx, video)),
+		Duration:    video.renderedDuration().Seconds(),
+		Checksum:    entry.Checksum,
+		VideoID:     entry.VideoID,
+	}
+	offsets := video.chapterOffsets()
+	for ix, chapter := range video.Chapters {
+		checksum, ok := checksums[chapter.FileName]
+		if !ok {
+			var err error
+			checksum, err = fileChecksum(filepath.Join(inputSnapshots.translate(video.Path), chapter.FileName))
+			if err != nil {
+				debugf(">>> Could not hash %s: %v", chapter.FileName, err)
+			}
+			checksums[chapter.FileName] = checksum
+		}
+		metadata.Chapters = append(metadata.Chapters, MetadataChapter{
+			FileName:   chapter.FileName,
+			Start:      offsets[ix].Seconds(),
+			Duration:   video.timeline(chapter.Duration).Seconds(),
+			CreateTime: chapter.CreateTime,
+			Checksum:   checksum,
+		})
+	}
+	if summary, device, err := summarizeVideoTelemetry(ctx, video); err == nil {
+		metadata.Camera = device
+		if summary.GPSSamples > 0 {
+			metadata.Telemetry = summary
+		}
+	} else {
+		debugf(">>> Could not read telemetry of %s: %v", video.Title, err)
+		metadata.Camera, metadata.Telemetry = previous.Camera, previous.Telemetry
+	}
+
+	data, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Written atomically, so that readers never see a truncated file.
+	tmpName := filepath.Join(outputDir, "."+video.Title+MetadataExt+".tmp")
+	if err := ioutil.WriteFile(tmpName, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, fileName)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Upper bounds of the render duration histogram buckets, in seconds.
+var renderDurationBuckets = []float64{60, 300, 900, 1800, 3600, 7200, 14400}
+
+// Counters of a run, served on /metrics in the Prometheus text format.
+type Metrics struct {
+	mu         sync.Mutex
+	discovered int
+	rendered   int
+	uploaded   int
+	failed     int
+	bytes      int64
+	// Render durations, counted per bucket of renderDurationBuckets.
+	renderBuckets []int
+	renderCount   int
+	renderSum     time.Duration
+	// Queue depth is computed from the state when scraped.
+	state *State
+}
+
+// Serves metrics on addr, e.g. ":9100".
+func startMetrics(addr string, state *State) (*Metrics, error) {
+	metrics := &Metrics{
+		renderBuckets: make([]int, len(renderDurationBuckets)),
+		state:         state,
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics)
+	go func() {
+		log.Printf(">>> Serving metrics on http://%s/metrics", listener.Addr())
+		if err := http.Serve(listener, mux); err != nil {
+			warnf(">>> Metrics server stopped: %v", err)
+		}
+	}()
+	return metrics, nil
+}
+
+// Records the number of videos discovered in the input directory.
+func (m *Metrics) addDiscovered(n int) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.discovered += n
+}
+
+// Records a finished render and how long it took.
+func (m *Metrics) addRendered(duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rendered++
+	m.renderCount++
+	m.renderSum += duration
+	for ix, bound := range renderDurationBuckets {
+		if duration.Seconds() <= bound {
+			m.renderBuckets[ix]++
+		}
+	}
+}
+
+// Records an upload sent to YouTube.
+func (m *Metrics) addUploadedBytes(n int64) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.bytes += n
+}
+
+// Records the outcome of an upload processed by YouTube.
+func (m *Metrics) addUploaded(status string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch status {
+	case StatusUploaded:
+		m.uploaded++
+	case StatusFailed:
+		m.failed++
+	}
+}
+
+// Writes the metrics in the Prometheus text exposition format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	queued := map[string]int{}
+	m.state.mu.Lock()
+	for _, entry := range m.state.Videos {
+		if entry.Status != StatusUploaded {
+			queued[entry.Status]++
+		}
+	}
+	m.state.mu.Unlock()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	writeMetric(w, "gopro_uploader_videos_discovered_total", "counter", "Videos discovered in the input directory.", m.discovered)
+	writeMetric(w, "gopro_uploader_videos_rendered_total", "counter", "Videos rendered.", m.rendered)
+	writeMetric(w, "gopro_uploader_videos_uploaded_total", "counter", "Videos uploaded and processed by YouTube.", m.uploaded)
+	writeMetric(w, "gopro_uploader_videos_failed_total", "counter", "Videos YouTube failed to process.", m.failed)
+	writeMetric(w, "gopro_uploader_uploaded_bytes_total", "counter", "Bytes of the videos uploaded.", m.bytes)
+
+	fmt.Fprintf(w, "# HELP gopro_uploader_queue_depth Videos queued for rendering or uploading, by status.\n")
+	fmt.Fprintf(w, "# TYPE gopro_uploader_queue_depth gauge\n")
+	for _, status := range []string{StatusPending, StatusRendered, StatusProcessing, StatusFailed} {
+		fmt.Fprintf(w, "gopro_uploader_queue_depth{status=%q} %d\n", status, queued[status])
+	}
+
+	fmt.Fprintf(w, "# HELP gopro_uploader_render_duration_seconds Time taken to render a video.\n")
+	fmt.Fprintf(w, "# TYPE gopro_uploader_render_duration_seconds histogram\n")
+	for ix, bound := range renderDurationBuckets {
+		fmt.Fprintf(w, "gopro_uploader_render_duration_seconds_bucket{le=\"%g\"} %d\n", bound, m.renderBuckets[ix])
+	}
+	fmt.Fprintf(w, "gopro_uploader_render_duration_seconds_bucket{le=\"+Inf\"} %d\n", m.renderCount)
+	fmt.Fprintf(w, "gopro_uploader_render_duration_seconds_sum %g\n", m.renderSum.Seconds())
+	fmt.Fprintf(w, "gopro_uploader_render_duration_seconds_count %d\n", m.renderCount)
+}
+
+// Writes a metric without labels, with its help and type lines.
+func writeMetric(w io.Writer, name, metricType, help string, value interface{}) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, metricType, name, value)
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// An ISO base media file format box (a.k.a. QuickTime atom).
+type mp4Box struct {
+	Type string
+	// Offset of the box header in the file.
+	Offset int64
+	// Size of the box, header included.
+	Size       int64
+	HeaderSize int64
+}
+
+// Reads the boxes stored between start and end, without descending into them.
+func readMP4Boxes(r io.ReaderAt, start, end int64) ([]mp4Box, error) {
+	var boxes []mp4Box
+	header := make([]byte, 16)
+	for offset := start; offset+8 <= end; {
+		if _, err := r.ReadAt(header[:8], offset); err != nil {
+			return nil, err
+		}
+		box := mp4Box{
+			Type:       string(header[4:8]),
+			Offset:     offset,
+			Size:       int64(binary.BigEndian.Uint32(header[0:4])),
+			HeaderSize: 8,
+		}
+		switch box.Size {
+		case 0:
+			// Box extends to the end of the file.
+			box.Size = end - offset
+		case 1:
+			if _, err := r.ReadAt(header[8:16], offset+8); err != nil {
+				return nil, err
+			}
+			box.Size = int64(binary.BigEndian.Uint64(header[8:16]))
+			box.HeaderSize = 16
+		}
+		if box.Size < box.HeaderSize || offset+box.Size > end {
+			return nil, fmt.Errorf("Error parsing MP4: invalid %s box at %d", box.Type, offset)
+		}
+		boxes = append(boxes, box)
+		offset += box.Size
+	}
+	return boxes, nil
+}
+
+// Verifies if the moov box precedes the media data, so that playback can
+// start before the whole file is downloaded.
+func isFastStart(fileName string) (bool, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	boxes, err := readMP4Boxes(f, 0, info.Size())
+	if err != nil {
+		return false, err
+	}
+	for _, box := range boxes {
+		switch box.Type {
+		case "moov":
+			return true, nil
+		case "mdat":
+			return false, nil
+		}
+	}
+	return false, fmt.Errorf("Error parsing MP4: no moov box in %s", fileName)
+}
+
+// Returns the first box of the given type.
+func findMP4Box(boxes []mp4Box, boxType string) (mp4Box, bool) {
+	for _, box := range boxes {
+		if box.Type == boxType {
+			return box, true
+		}
+	}
+	return mp4Box{}, false
+}
+
+// Returns the children of an in-memory box.
+func mp4Children(data []byte, box mp4Box) ([]mp4Box, error) {
+	return readMP4Boxes(bytes.NewReader(data), box.Offset+box.HeaderSize, box.Offset+box.Size)
+}
+
+// Returns the box at the given path below box, e.g. mdia/minf/stbl.
+func findMP4Path(data []byte, box mp4Box, path ...string) (mp4Box, bool, error) {
+	for _, boxType := range path {
+		children, err := mp4Children(data, box)
+		if err != nil {
+			return mp4Box{}, false, err
+		}
+		var ok bool
+		if box, ok = findMP4Box(children, boxType); !ok {
+			return mp4Box{}, false, nil
+		}
+	}
+	return box, true, nil
+}
+
+// Reads the HiLight tags set on the camera while recording, stored as
+// millisecond offsets in the moov/udta/HMMT box.
+func readHiLights(fileName string) ([]time.Duration, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	box := mp4Box{Size: info.Size()}
+	for _, boxType := range []string{"moov", "udta", "HMMT"} {
+		boxes, err := readMP4Boxes(f, box.Offset+box.HeaderSize, box.Offset+box.Size)
+		if err != nil {
+			return nil, err
+		}
+		var ok bool
+		if box, ok = findMP4Box(boxes, boxType); !ok {
+			return nil, nil
+		}
+	}
+
+	data := make([]byte, box.Size-box.HeaderSize)
+	if _, err := f.ReadAt(data, box.Offset+box.HeaderSize); err != nil {
+		return nil, err
+	}
+	if len(data) < 4 {
+		return nil, fmt.Errorf("Error parsing MP4: invalid HMMT box in %s", fileName)
+	}
+	count := int(binary.BigEndian.Uint32(data[0:4]))
+	var results []time.Duration
+	for ix := 0; ix < count && 8+4*ix <= len(data); ix++ {
+		ms := binary.BigEndian.Uint32(data[4+4*ix : 8+4*ix])
+		results = append(results, time.Duration(ms)*time.Millisecond)
+	}
+	return results, nil
+}
+
+// Seconds between the MP4 epoch, 1904-01-01, and the Unix epoch.
+const mp4EpochOffset = 2082844800
+
+// Names ffprobe gives to the codecs of the sample entries of GoPro tracks.
+var mp4CodecNames = map[string]string{
+	"avc1": "h264",
+	"hvc1": "hevc",
+	"hev1": "hevc",
+}
+
+// A track of an MP4 file, as read from its trak box.
+type mp4Track struct {
+	// Handler type, e.g. "vide" or "soun", and sample entry type, e.g. "avc1"
+	// or "gpmd".
+	Handler, Format string
+	Width, Height   int
+	// Number of samples, and their total duration in the media timescale.
+	SampleCount, SampleDuration uint64
+	Timescale                   uint32
+	// Times of the sync samples, from the stss box. Nil if every sample is
+	// one.
+	Keyframes []time.Duration
+}
+
+// Metadata of an MP4 file, as reported by ffprobe.
+type mp4Info struct {
+	Duration time.Duration
+	// Zero if missing.
+	CreateTime time.Time
+	Tracks     []mp4Track
+	// Hex encoded serial number of the GoPro camera which recorded the file,
+	// from the moov/udta/CAME box, if any.
+	Camera string
+}
+
+// Reads the duration, creation time and tracks of an MP4 file from its moov
+// box, which is much faster than running ffprobe.
+func readMP4Info(fileName string) (*mp4Info, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	boxes, err := readMP4Boxes(f, 0, info.Size())
+	if err != nil {
+		return nil, err
+	}
+	moovBox, ok := findMP4Box(boxes, "moov")
+	if !ok {
+		return nil, fmt.Errorf("Error parsing MP4: no moov box in %s", fileName)
+	}
+	moov := make([]byte, moovBox.Size)
+	if _, err := f.ReadAt(moov, moovBox.Offset); err != nil {
+		return nil, err
+	}
+	root := mp4Box{Type: "moov", Size: moovBox.Size, HeaderSize: moovBox.HeaderSize}
+	children, err := mp4Children(moov, root)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &mp4Info{}
+	mvhd, ok := findMP4Box(children, "mvhd")
+	if !ok {
+		return nil, fmt.Errorf("Error parsing MP4: no mvhd box in %s", fileName)
+	}
+	creation, timescale, duration, err := parseMP4Header(moov[mvhd.Offset+mvhd.HeaderSize : mvhd.Offset+mvhd.Size])
+	if err != nil {
+		return nil, err
+	}
+	if timescale == 0 {
+		return nil, fmt.Errorf("Error parsing MP4: no duration in %s", fileName)
+	}
+	// Like ffprobe, rounded to microseconds, and with the creation time in
+	// UTC. A zero creation time is missing, e.g. after an edit by another
+	// tool.
+	result.Duration = time.Duration((duration*1000000+uint64(timescale)/2)/uint64(timescale)) * time.Microsecond
+	if creation >= mp4EpochOffset {
+		creation -= mp4EpochOffset
+	}
+	if creation != 0 {
+		result.CreateTime = time.Unix(int64(creation), 0).UTC()
+	}
+
+	for _, trak := range children {
+		if trak.Type != "trak" {
+			continue
+		}
+		track, err := parseMP4Track(moov, trak)
+		if err != nil {
+			return nil, err
+		}
+		result.Tracks = append(result.Tracks, track)
+	}
+	if came, ok, err := findMP4Path(moov, root, "udta", "CAME"); err != nil {
+		return nil, err
+	} else if ok {
+		result.Camera = hex.EncodeToString(moov[came.Offset+came.HeaderSize : came.Offset+came.Size])
+	}
+	return result, nil
+}
+
+// Parses the creation time, timescale and duration of an mvhd or mdhd box.
+func parseMP4Header(data []byte) (creation uint64, timescale uint32, duration uint64, err error) {
+	if len(data) >= 32 && data[0] == 1 {
+		return binary.BigEndian.Uint64(data[4:12]), binary.BigEndian.Uint32(data[20:24]),
+			binary.BigEndian.Uint64(data[24:32]), nil
+	}
+	if len(data) >= 20 && data[0] == 0 {
+		return uint64(binary.BigEndian.Uint32(data[4:8])), binary.BigEndian.Uint32(data[12:16]),
+			uint64(binary.BigEndian.Uint32(data[16:20])), nil
+	}
+	return 0, 0, 0, fmt.Errorf("Error parsing MP4: invalid header box")
+}
+
+func parseMP4Track(moov []byte, trak mp4Box) (mp4Track, error) {
+	var track mp4Track
+	body := func(box mp4Box) []byte {
+		return moov[box.Offset+box.HeaderSize : box.Offset+box.Size]
+	}
+	if hdlr, ok, err := findMP4Path(moov, trak, "mdia", "hdlr"); err != nil {
+		return track, err
+	} else if ok && len(body(hdlr)) >= 12 {
+		track.Handler = string(body(hdlr)[8:12])
+	}
+	if mdhd, ok, err := findMP4Path(moov, trak, "mdia", "mdhd"); err != nil {
+		return track, err
+	} else if ok {
+		if _, track.Timescale, _, err = parseMP4Header(body(mdhd)); err != nil {
+			return track, err
+		}
+	}
+	stbl, ok, err := findMP4Path(moov, trak, "mdia", "minf", "stbl")
+	if err != nil || !ok {
+		return track, err
+	}
+	if stsd, ok, err := findMP4Path(moov, stbl, "stsd"); err != nil {
+		return track, err
+	} else if ok {
+		// Version and flags, entry count, then the first sample entry.
+		data := body(stsd)
+		if len(data) >= 16 {
+			track.Format = string(data[12:16])
+		}
+		// Width and height of visual sample entries, after the 8 bytes of
+		// the entry header and 24 bytes of reserved and predefined fields.
+		if track.Handler == "vide" && len(data) >= 8+8+28 {
+			track.Width = int(binary.BigEndian.Uint16(data[8+8+24 : 8+8+26]))
+			track.Height = int(binary.BigEndian.Uint16(data[8+8+26 : 8+8+28]))
+		}
+	}
+	if stts, ok, err := findMP4Path(moov, stbl, "stts"); err != nil {
+		return track, err
+	} else if ok {
+		data := body(stts)
+		if len(data) < 8 {
+			return track, fmt.Errorf("Error parsing MP4: invalid stts box")
+		}
+		count := int(binary.BigEndian.Uint32(data[4:8]))
+		if len(data) < 8+8*count {
+			return track, fmt.Errorf("Error parsing MP4: invalid stts box")
+		}
+		var syncSamples []uint32
+		if stss, ok, err := findMP4Path(moov, stbl, "stss"); err != nil {
+			return track, err
+		} else if ok && track.Timescale > 0 {
+			if syncSamples, err = parseMP4SyncSamples(body(stss)); err != nil {
+				return track, err
+			}
+			track.Keyframes = []time.Duration{}
+		}
+		for ix := 0; ix < count; ix++ {
+			entry := data[8+8*ix : 16+8*ix]
+			samples := uint64(binary.BigEndian.Uint32(entry[0:4]))
+			delta := uint64(binary.BigEndian.Uint32(entry[4:8]))
+			// Sync samples are numbered from 1, in increasing order.
+			for len(syncSamples) > 0 && uint64(syncSamples[0]) <= track.SampleCount+samples {
+				at := track.SampleDuration + (uint64(syncSamples[0])-1-track.SampleCount)*delta
+				track.Keyframes = append(track.Keyframes, time.Duration(at*1000000/uint64(track.Timescale))*time.Microsecond)
+				syncSamples = syncSamples[1:]
+			}
+			track.SampleCount += samples
+			track.SampleDuration += samples * delta
+		}
+	}
+	return track, nil
+}
+
+// Parses the sample numbers listed by an stss box.
+func parseMP4SyncSamples(data []byte) ([]uint32, error) {
+	if len(data) < 8 {
+		return nil, fmt.Errorf("Error parsing MP4: invalid stss box")
+	}
+	count := int(binary.BigEndian.Uint32(data[4:8]))
+	if len(data) < 8+4*count {
+		return nil, fmt.Errorf("Error parsing MP4: invalid stss box")
+	}
+	results := make([]uint32, count)
+	for ix := range results {
+		results[ix] = binary.BigEndian.Uint32(data[8+4*ix : 12+4*ix])
+	}
+	return results, nil
+}
+
+// Returns the time of the last keyframe at or before at, where the concat
+// demuxer starts copying a stream from when given at as inpoint.
+func (t mp4Track) keyframeBefore(at time.Duration) time.Duration {
+	if t.Keyframes == nil {
+		return at
+	}
+	result := time.Duration(0)
+	for _, keyframe := range t.Keyframes {
+		if keyframe > at {
+			break
+		}
+		result = keyframe
+	}
+	return result
+}
+
+// Returns the average frame rate of a track, as computed by ffprobe.
+func (t mp4Track) frameRate() float64 {
+	if t.SampleDuration == 0 {
+		return 0
+	}
+	return float64(t.SampleCount*uint64(t.Timescale)) / float64(t.SampleDuration)
+}
+
+// Returns the coded size of a video track, i.e. rounded up to whole
+// macroblocks (16 pixels) for H.264 and minimum coding blocks (8 pixels) for
+// HEVC, as ffprobe reports it, e.g. 1920x1088 for 1080p H.264.
+func (t mp4Track) codedSize() (int, int) {
+	align := 1
+	switch mp4CodecNames[t.Format] {
+	case "h264":
+		align = 16
+	case "hevc":
+		align = 8
+	}
+	round := func(n int) int { return (n + align - 1) / align * align }
+	return round(t.Width), round(t.Height)
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Returns a box holding the given bodies, e.g. child boxes.
+func testBox(boxType string, bodies ...[]byte) []byte {
+	var body []byte
+	for _, b := range bodies {
+		body = append(body, b...)
+	}
+	return append(mp4Header(boxType, len(body)), body...)
+}
+
+// Returns the big endian encoding of 32 bit values.
+func uint32s(values ...uint32) []byte {
+	var result []byte
+	for _, v := range values {
+		result = binary.BigEndian.AppendUint32(result, v)
+	}
+	return result
+}
+
+// Returns an mvhd or mdhd box of version 0 or 1.
+func headerBox(boxType string, version byte, creation uint64, timescale uint32, duration uint64) []byte {
+	var body []byte
+	if version == 1 {
+		body = append([]byte{1, 0, 0, 0}, binary.BigEndian.AppendUint64(nil, creation)...)
+		body = binary.BigEndian.AppendUint64(body, creation)
+		body = binary.BigEndian.AppendUint32(body, timescale)
+		body = binary.BigEndian.AppendUint64(body, duration)
+	} else {
+		body = append([]byte{0, 0, 0, 0}, uint32s(uint32(creation), uint32(creation), timescale, uint32(duration))...)
+	}
+	// Rate, volume and the other fields not read.
+	return testBox(boxType, body, make([]byte, 80))
+}
+
+// Returns a trak box of the given handler and sample entry, with samples
+// described by pairs of sample count and duration.
+func trackBox(handler, format string, width, height uint16, timescale uint32, stts ...uint32) []byte {
+	return syncTrackBox(handler, format, width, height, timescale, stts, nil)
+}
+
+// Returns a trak box like trackBox, with an stss box listing the sync samples
+// unless nil.
+func syncTrackBox(handler, format string, width, height uint16, timescale uint32, stts, stss []uint32) []byte {
+	hdlr := testBox("hdlr", make([]byte, 8), []byte(handler), make([]byte, 13))
+	entry := append(make([]byte, 24), binary.BigEndian.AppendUint16(binary.BigEndian.AppendUint16(nil, width), height)...)
+	entry = testBox(format, entry, make([]byte, 50))
+	stsd := testBox("stsd", uint32s(0, 1), entry)
+	sttsBox := testBox("stts", uint32s(0, uint32(len(stts)/2)), uint32s(stts...))
+	stbl := testBox("stbl", stsd, sttsBox)
+	if stss != nil {
+		stbl = testBox("stbl", stsd, sttsBox, testBox("stss", uint32s(0, uint32(len(stss))), uint32s(stss...)))
+	}
+	return testBox("trak", testBox("mdia", hdlr, headerBox("mdhd", 0, 0, timescale, 0),
+		testBox("minf", stbl)))
+}
+
+// Writes an MP4 file of the given top level boxes.
+func writeMP4(t *testing.T, boxes ...[]byte) string {
+	var data []byte
+	for _, box := range boxes {
+		data = append(data, box...)
+	}
+	fileName := filepath.Join(t.TempDir(), "GX010001.MP4")
+	if err := ioutil.WriteFile(fileName, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return fileName
+}
+
+func TestReadMP4Info(t *testing.T) {
+	ftyp := testBox("ftyp", []byte("mp41"))
+	mdat := testBox("mdat", make([]byte, 64))
+	created := uint64(time.Date(2020, 7, 4, 10, 12, 0, 0, time.UTC).Unix() + mp4EpochOffset)
+	video := trackBox("vide", "hvc1", 3840, 2160, 60000, 100, 1001, 20, 1002)
+	audio := trackBox("soun", "mp4a", 0, 0, 48000, 48, 1024)
+	for _, test := range []struct {
+		name    string
+		boxes   [][]byte
+		want    *mp4Info
+		wantErr bool
+	}{
+		{
+			name:  "mvhd v0",
+			boxes: [][]byte{ftyp, testBox("moov", headerBox("mvhd", 0, created, 1000, 90500)), mdat},
+			want:  &mp4Info{Duration: 90500 * time.Millisecond, CreateTime: time.Date(2020, 7, 4, 10, 12, 0, 0, time.UTC)},
+		},
+		{
+			name:  "mvhd v1",
+			boxes: [][]byte{ftyp, mdat, testBox("moov", headerBox("mvhd", 1, created, 90000, 90000*3600*30))},
+			want:  &mp4Info{Duration: 30 * time.Hour, CreateTime: time.Date(2020, 7, 4, 10, 12, 0, 0, time.UTC)},
+		},
+		{
+			name:  "duration rounded to microseconds",
+			boxes: [][]byte{testBox("moov", headerBox("mvhd", 0, 0, 3, 1))},
+			want:  &mp4Info{Duration: 333333 * time.Microsecond},
+		},
+		{
+			name:  "tracks and camera",
+			boxes: [][]byte{ftyp, testBox("moov", headerBox("mvhd", 0, 0, 1000, 2000), video, audio, testBox("udta", testBox("CAME", []byte{0xc3, 0x44}))), mdat},
+			want: &mp4Info{
+				Duration: 2 * time.Second,
+				Tracks: []mp4Track{
+					{Handler: "vide", Format: "hvc1", Width: 3840, Height: 2160, SampleCount: 120, SampleDuration: 100*1001 + 20*1002, Timescale: 60000},
+					{Handler: "soun", Format: "mp4a", SampleCount: 48, SampleDuration: 48 * 1024, Timescale: 48000},
+				},
+				Camera: "c344",
+			},
+		},
+		{name: "no moov", boxes: [][]byte{ftyp, mdat}, wantErr: true},
+		{name: "empty", wantErr: true},
+		{name: "no mvhd", boxes: [][]byte{testBox("moov", video)}, wantErr: true},
+		{name: "no timescale", boxes: [][]byte{testBox("moov", headerBox("mvhd", 0, 0, 0, 1000))}, wantErr: true},
+		{name: "truncated file", boxes: [][]byte{ftyp, testBox("moov", headerBox("mvhd", 0, 0, 1000, 1000))[:40]}, wantErr: true},
+		{name: "truncated mvhd", boxes: [][]byte{testBox("moov", testBox("mvhd", []byte{0, 0, 0, 0, 1, 2}))}, wantErr: true},
+		{
+			name:    "child box past its parent",
+			boxes:   [][]byte{testBox("moov", append(mp4Header("mvhd", 200), make([]byte, 100)...))},
+			wantErr: true,
+		},
+		{
+			name:    "invalid box size",
+			boxes:   [][]byte{ftyp, append(uint32s(4), []byte("moov")...)},
+			wantErr: true,
+		},
+	} {
+		info, err := readMP4Info(writeMP4(t, test.boxes...))
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: readMP4Info() error = %v, want error %v", test.name, err, test.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(info, test.want) {
+			t.Errorf("%s: readMP4Info() = %+v, want %+v", test.name, info, test.want)
+		}
+	}
+}
+
+func TestMP4Keyframes(t *testing.T) {
+	// 30 fps, then 60 fps from the 61st sample on.
+	stts := []uint32{60, 1000, 60, 500}
+	for _, test := range []struct {
+		name string
+		stss []uint32
+		want []time.Duration
+	}{
+		{"every sample", nil, nil},
+		{"none", []uint32{}, []time.Duration{}},
+		{"first", []uint32{1}, []time.Duration{0}},
+		{"each second", []uint32{1, 31, 61, 91}, []time.Duration{0, time.Second, 2 * time.Second, 2500 * time.Millisecond}},
+		{"past the samples", []uint32{1, 200}, []time.Duration{0}},
+	} {
+		fileName := writeMP4(t, testBox("moov", headerBox("mvhd", 0, 0, 1000, 3000),
+			syncTrackBox("vide", "avc1", 1920, 1080, 30000, stts, test.stss)))
+		info, err := readMP4Info(fileName)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if got := info.Tracks[0].Keyframes; !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: keyframes = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestKeyframeBefore(t *testing.T) {
+	track := mp4Track{Keyframes: []time.Duration{0, time.Second, 2 * time.Second}}
+	for _, test := range []struct {
+		track mp4Track
+		at    time.Duration
+		want  time.Duration
+	}{
+		{track, 0, 0},
+		{track, 500 * time.Millisecond, 0},
+		{track, time.Second, time.Second},
+		{track, 1999 * time.Millisecond, time.Second},
+		{track, time.Hour, 2 * time.Second},
+		{mp4Track{}, 1500 * time.Millisecond, 1500 * time.Millisecond},
+		{mp4Track{Keyframes: []time.Duration{time.Second}}, 500 * time.Millisecond, 0},
+	} {
+		if got := test.track.keyframeBefore(test.at); got != test.want {
+			t.Errorf("keyframeBefore(%v) with keyframes %v = %v, want %v", test.at, test.track.Keyframes, got, test.want)
+		}
+	}
+}
+
+func TestSnapToKeyframes(t *testing.T) {
+	dir := t.TempDir()
+	data := append(testBox("ftyp", []byte("mp41")), testBox("moov", headerBox("mvhd", 0, 0, 1000, 10000),
+		syncTrackBox("vide", "avc1", 1920, 1080, 30, []uint32{300, 1}, []uint32{1, 61, 121, 181, 241}))...)
+	if err := ioutil.WriteFile(filepath.Join(dir, "GX010001.MP4"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2020, 7, 4, 10, 0, 0, 0, time.UTC)
+	chapters := []Chapter{
+		{FileName: "GX010001.MP4", OutPoint: 3 * time.Second, Duration: 3 * time.Second, CreateTime: start},
+		{FileName: "GX010001.MP4", InPoint: 5500 * time.Millisecond, Duration: 2 * time.Second, CreateTime: start.Add(5500 * time.Millisecond)},
+		{FileName: "GX010001.MP4", InPoint: 8 * time.Second, Duration: time.Second, CreateTime: start.Add(8 * time.Second)},
+		{FileName: "GX020001.MP4", InPoint: time.Second, Duration: time.Second},
+	}
+	want := []Chapter{
+		chapters[0],
+		{FileName: "GX010001.MP4", InPoint: 4 * time.Second, Duration: 3500 * time.Millisecond, CreateTime: start.Add(4 * time.Second)},
+		{FileName: "GX010001.MP4", InPoint: 8 * time.Second, Duration: time.Second, CreateTime: start.Add(8 * time.Second)},
+		// Missing files are left as they are.
+		chapters[3],
+	}
+	if got := snapToKeyframes(dir, chapters); !reflect.DeepEqual(got, want) {
+		t.Errorf("snapToKeyframes() = %+v, want %+v", got, want)
+	}
+}
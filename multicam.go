@@ -0,0 +1,88 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// How videos holding the footage of several cameras, e.g. a chest and a
+// helmet mount recording the same session, are rendered.
+const (
+	// As any other video, chapters ordered by camera file numbering.
+	MulticamOff = ""
+	// One video per camera, titled alike with the camera label appended.
+	MulticamSplit = "split"
+	// One video, chapters of all cameras ordered by recording time.
+	MulticamInterleave = "interleave"
+	// One video of the main camera, with the other cameras overlaid
+	// picture-in-picture, see PiPLayout.
+	MulticamPiP = "pip"
+)
+
+// Label of chapters whose camera is unknown, e.g. probed with ffprobe.
+const unknownCamera = "Unknown camera"
+
+// Returns the label of a camera: the configured one, or the start of its
+// serial number.
+func (c *Config) cameraLabel(camera string) string {
+	if label, ok := c.Cameras[camera]; ok {
+		return label
+	}
+	if camera == "" {
+		return unknownCamera
+	}
+	if len(camera) > 8 {
+		camera = camera[:8]
+	}
+	return "Camera " + strings.ToUpper(camera)
+}
+
+// Returns the videos to render for a video according to Multicam, with the
+// data to title each.
+func (c *Config) multicamVideos(video Video, data TitleData) ([]Video, []TitleData) {
+	var cameras []string
+	byCamera := map[string][]Chapter{}
+	for _, chapter := range video.Chapters {
+		if _, ok := byCamera[chapter.Camera]; !ok {
+			cameras = append(cameras, chapter.Camera)
+		}
+		byCamera[chapter.Camera] = append(byCamera[chapter.Camera], chapter)
+	}
+	if c.Multicam == MulticamOff || len(cameras) < 2 {
+		return []Video{video}, []TitleData{data}
+	}
+	if c.Multicam == MulticamInterleave {
+		video.Chapters = append([]Chapter{}, video.Chapters...)
+		sort.SliceStable(video.Chapters, func(i, j int) bool {
+			return video.Chapters[i].CreateTime.Before(video.Chapters[j].CreateTime)
+		})
+		return []Video{video}, []TitleData{data}
+	}
+	if c.Multicam == MulticamPiP {
+		main := c.mainCamera(cameras, byCamera)
+		video.Chapters = byCamera[main]
+		video.Overlay = nil
+		for _, camera := range cameras {
+			if camera != main {
+				video.Overlay = append(video.Overlay, byCamera[camera]...)
+			}
+		}
+		return []Video{video}, []TitleData{data}
+	}
+	// Ordered by label, so that titles do not depend on which camera
+	// started recording first.
+	sort.Slice(cameras, func(i, j int) bool {
+		return c.cameraLabel(cameras[i]) < c.cameraLabel(cameras[j])
+	})
+	var videos []Video
+	var datas []TitleData
+	for _, camera := range cameras {
+		cameraVideo, cameraData := video, data
+		cameraVideo.Chapters = byCamera[camera]
+		cameraData.Camera = c.cameraLabel(camera)
+		cameraData.Name = strings.TrimPrefix(data.Name+" # "+cameraData.Camera, " # ")
+		videos = append(videos, cameraVideo)
+		datas = append(datas, cameraData)
+	}
+	return videos, datas
+}
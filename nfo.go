@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Width of the thumbnails written with NFO files.
+const nfoThumbWidth = 1280
+
+type nfoUniqueID struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+type nfoVideoDetails struct {
+	Codec    string `xml:"codec,omitempty"`
+	Width    int    `xml:"width,omitempty"`
+	Height   int    `xml:"height,omitempty"`
+	Duration int64  `xml:"durationinseconds"`
+}
+
+// Kodi movie NFO, also read by Jellyfin, Emby and Plex agents.
+type nfoMovie struct {
+	XMLName   xml.Name        `xml:"movie"`
+	Title     string          `xml:"title"`
+	Plot      string          `xml:"plot"`
+	Premiered string          `xml:"premiered,omitempty"`
+	Runtime   int64           `xml:"runtime"`
+	Tags      []string        `xml:"tag"`
+	UniqueIDs []nfoUniqueID   `xml:"uniqueid"`
+	Video     nfoVideoDetails `xml:"fileinfo>streamdetails>video"`
+}
+
+// Writes the NFO file of a rendered video next to it, <title>.nfo, with its
+// thumbnail <title>-thumb.jpg, and folder.jpg for the output directory if it
+// has none. Chapters are not part of the format: media servers read them from
+// the rendered file, and the plot lists them.
+func writeNFO(ctx context.Context, entry *VideoState, outputDir string) error {
+	video := entry.Video
+	duration := video.renderedDuration()
+	movie := nfoMovie{
+		Title:   video.Title,
+		Plot:    youtubeDescription(videoDescription(ctx, video)),
+		Runtime: int64(duration.Minutes() + 0.5),
+		Tags:    video.Tags,
+		Video:   nfoVideoDetails{Duration: int64(duration.Seconds())},
+	}
+	if start := video.startTime(); !start.IsZero() {
+		movie.Premiered = localTime(start).Format("2006-01-02")
+	}
+	if entry.VideoID != "" {
+		movie.UniqueIDs = append(movie.UniqueIDs, nfoUniqueID{Type: "youtube", Value: entry.VideoID})
+	}
+	if len(video.Chapters) > 0 {
+		resolution := video.Chapters[0].Resolution
+		movie.Video.Codec, movie.Video.Width, movie.Video.Height = resolution.Codec, resolution.Width, resolution.Height
+	}
+	data, err := xml.MarshalIndent(movie, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append([]byte(xml.Header), data...)
+	fileName := filepath.Join(outputDir, video.Title+".nfo")
+	tmpName := filepath.Join(outputDir, "."+video.Title+".nfo.tmp")
+	if err := ioutil.WriteFile(tmpName, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpName, fileName); err != nil {
+		return err
+	}
+
+	thumbFname := filepath.Join(outputDir, video.Title+"-thumb.jpg")
+	if _, err := os.Stat(thumbFname); err != nil {
+		// A tenth in, past the usual fiddling with the camera at the start.
+		if err := extractFrame(ctx, execRunner{}, filepath.Join(outputDir, video.Title+VideoExt), duration/10, nfoThumbWidth, thumbFname); err != nil {
+			os.Remove(thumbFname)
+			return err
+		}
+	}
+	folderFname := filepath.Join(outputDir, "folder.jpg")
+	if _, err := os.Stat(folderFname); os.IsNotExist(err) {
+		data, err := ioutil.ReadFile(thumbFname)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(folderFname, data, 0644)
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Types of notification destinations.
+const (
+	// JSON POST of the Notification to any URL.
+	DestinationWebhook = "webhook"
+	// Slack incoming webhook.
+	DestinationSlack = "slack"
+	// Telegram bot message.
+	DestinationTelegram = "telegram"
+	// Email sent through an SMTP server.
+	DestinationEmail = "email"
+)
+
+// Events notifications are sent for.
+const (
+	EventRendered     = "rendered"
+	EventRenderFailed = "render_failed"
+	EventUploaded     = "uploaded"
+	EventUploadFailed = "upload_failed"
+	EventAuthRequired = "auth_required"
+)
+
+var notificationEvents = []string{EventRendered, EventRenderFailed, EventUploaded, EventUploadFailed, EventAuthRequired}
+
+// Where notifications of a profile are sent to.
+type NotificationDestination struct {
+	// One of DestinationWebhook, DestinationSlack, DestinationTelegram or
+	// DestinationEmail.
+	Type string `json:"type"`
+	// URL of webhook and Slack destinations.
+	URL string `json:"url"`
+	// Bot token and chat of Telegram destinations.
+	BotToken string `json:"bot_token"`
+	ChatID   string `json:"chat_id"`
+	// SMTP server (host:port) and account of email destinations, and who
+	// sends and receives the emails. Without username, no authentication is
+	// done.
+	SMTPServer string   `json:"smtp_server"`
+	Username   string   `json:"username"`
+	Password   string   `json:"password"`
+	From       string   `json:"from"`
+	To         []string `json:"to"`
+	// Profiles whose notifications are sent to the destination, so that
+	// profiles sharing a config file can notify different people. All
+	// profiles if empty.
+	Profiles []string `json:"profiles"`
+	// Events sent to the destination, all if empty.
+	Events []string `json:"events"`
+}
+
+// Verifies that the destination is usable.
+func (d *NotificationDestination) validate() error {
+	switch d.Type {
+	case DestinationWebhook, DestinationSlack:
+		if _, err := url.ParseRequestURI(d.URL); err != nil {
+			return fmt.Errorf("invalid %s destination url: %v", d.Type, err)
+		}
+	case DestinationTelegram:
+		if d.BotToken == "" || d.ChatID == "" {
+			return fmt.Errorf("telegram destination needs bot_token and chat_id")
+		}
+	case DestinationEmail:
+		if _, _, err := net.SplitHostPort(d.SMTPServer); err != nil {
+			return fmt.Errorf("invalid email destination smtp_server: %v", err)
+		}
+		if d.From == "" || len(d.To) == 0 {
+			return fmt.Errorf("email destination needs from and to")
+		}
+	default:
+		return fmt.Errorf("invalid destination type %q", d.Type)
+	}
+	for _, event := range d.Events {
+		if !contains(notificationEvents, event) {
+			return fmt.Errorf("invalid destination event %q", event)
+		}
+	}
+	return nil
+}
+
+// An event worth telling the owner of a profile about.
+type Notification struct {
+	Event   string `json:"event"`
+	Profile string `json:"profile"`
+	Title   string `json:"title"`
+	// Link to the uploaded video, if any.
+	URL     string `json:"url,omitempty"`
+	Message string `json:"message"`
+}
+
+// Sends notifications to the destinations of a profile.
+type Notifier struct {
+	profile      string
+	destinations []NotificationDestination
+}
+
+// Returns a notifier for the destinations routed to the profile of config.
+func newNotifier(config *Config) *Notifier {
+	n := &Notifier{profile: config.profile}
+	for _, d := range config.Destinations {
+		if len(d.Profiles) == 0 || contains(d.Profiles, config.profile) {
+			n.destinations = append(n.destinations, d)
+		}
+	}
+	return n
+}
+
+// Sends a notification to every destination subscribed to its event. Failures
+// are logged, they never hold up rendering or uploads.
+func (n *Notifier) notify(ctx context.Context, event, title, videoURL, format string, args ...interface{}) {
+	if n == nil {
+		return
+	}
+	notification := Notification{
+		Event:   event,
+		Profile: n.profile,
+		Title:   title,
+		URL:     videoURL,
+		Message: fmt.Sprintf(format, args...),
+	}
+	for _, d := range n.destinations {
+		if len(d.Events) > 0 && !contains(d.Events, event) {
+			continue
+		}
+		if err := d.send(ctx, notification); err != nil {
+			warnf(">>> Could not send %s notification: %v", d.Type, err)
+		}
+	}
+}
+
+// Sends a notification to the destination.
+func (d *NotificationDestination) send(ctx context.Context, notification Notification) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	text := notification.Message
+	if notification.URL != "" {
+		text += " " + notification.URL
+	}
+	var endpoint string
+	var body interface{}
+	switch d.Type {
+	case DestinationEmail:
+		return d.sendEmail(notification, text)
+	case DestinationWebhook:
+		endpoint, body = d.URL, notification
+	case DestinationSlack:
+		endpoint, body = d.URL, map[string]string{"text": text}
+	case DestinationTelegram:
+		endpoint = "https://api.telegram.org/bot" + d.BotToken + "/sendMessage"
+		body = map[string]string{"chat_id": d.ChatID, "text": text}
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		// Do not leak the Telegram bot token, which is part of the URL.
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return urlErr.Err
+		}
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s responded %s", d.Type, resp.Status)
+	}
+	return nil
+}
+
+// Sends a notification by email. The message becomes the subject.
+func (d *NotificationDestination) sendEmail(notification Notification, text string) error {
+	var auth smtp.Auth
+	if d.Username != "" {
+		host, _, _ := net.SplitHostPort(d.SMTPServer)
+		auth = smtp.PlainAuth("", d.Username, d.Password, host)
+	}
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", d.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(d.To, ", "))
+	fmt.Fprintf(&msg, "Subject: [gopro-uploader] %s\r\n", mime.QEncoding.Encode("utf-8", notification.Message))
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&msg, "%s\r\n\r\nProfile: %s\r\nEvent: %s\r\n", text, notification.Profile, notification.Event)
+	return smtp.SendMail(d.SMTPServer, auth, d.From, d.To, []byte(msg.String()))
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// Returns the directory holding configuration files and credentials:
+// $XDG_CONFIG_HOME/gopro-uploader (~/.config) on Linux,
+// ~/Library/Application Support/gopro-uploader on macOS and
+// %AppData%\gopro-uploader on Windows.
+func appConfigDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "gopro-uploader"), nil
+}
+
+// Returns the directory holding files which can be safely deleted, such as
+// lock files: $XDG_CACHE_HOME/gopro-uploader (~/.cache) on Linux,
+// ~/Library/Caches/gopro-uploader on macOS and %LocalAppData%\gopro-uploader on
+// Windows.
+func appCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "gopro-uploader"), nil
+}
+
+// Returns the path of a file in the home directory, as used by earlier
+// versions of the tool.
+func legacyFile(elem ...string) (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(append([]string{home}, elem...)...), nil
+}
+
+// Moves a file from its legacy location if it does not exist at its new one.
+func migrateFile(legacyName, fileName string) error {
+	if _, err := os.Stat(fileName); err == nil {
+		return nil
+	}
+	data, err := ioutil.ReadFile(legacyName)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(legacyName)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(fileName), 0700); err != nil {
+		return err
+	}
+	// Copied rather than renamed, since the directories may be on different
+	// file systems.
+	if err := ioutil.WriteFile(fileName, data, info.Mode().Perm()); err != nil {
+		return err
+	}
+	log.Printf(">>> Moved %s to %s", legacyName, fileName)
+	return os.Remove(legacyName)
+}
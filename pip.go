@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Variant of multicam videos with the footage of the other cameras overlaid
+// picture-in-picture, uploaded instead of the master.
+const VariantPiP = "pip"
+
+// Corners picture-in-picture overlays are placed in.
+const (
+	PiPTopLeft     = "top_left"
+	PiPTopRight    = "top_right"
+	PiPBottomLeft  = "bottom_left"
+	PiPBottomRight = "bottom_right"
+)
+
+// Overlay expressions of each corner, given the margin in pixels.
+var pipCorners = map[string]string{
+	PiPTopLeft:     "x=%[1]d:y=%[1]d",
+	PiPTopRight:    "x=W-w-%[1]d:y=%[1]d",
+	PiPBottomLeft:  "x=%[1]d:y=H-h-%[1]d",
+	PiPBottomRight: "x=W-w-%[1]d:y=H-h-%[1]d",
+}
+
+// How the other cameras are overlaid with multicam pip, see MulticamPiP.
+type PiPLayout struct {
+	// Serial number or label of the camera shown full frame. The one with
+	// the most footage by default.
+	MainCamera string `json:"main_camera"`
+	// One of PiPTopLeft, PiPTopRight, PiPBottomLeft or PiPBottomRight
+	// (default).
+	Corner string `json:"corner"`
+	// Width of the overlay, as a fraction of the video width, 0.3 by default.
+	Size float64 `json:"size"`
+}
+
+func (l PiPLayout) validate() error {
+	if _, ok := pipCorners[l.Corner]; !ok {
+		return fmt.Errorf("invalid pip corner %q, expected %s, %s, %s or %s", l.Corner,
+			PiPTopLeft, PiPTopRight, PiPBottomLeft, PiPBottomRight)
+	}
+	if l.Size <= 0 || l.Size >= 1 {
+		return fmt.Errorf("invalid pip size %v, expected a fraction of the width, e.g. 0.3", l.Size)
+	}
+	return nil
+}
+
+// Returns the camera shown full frame among cameras with footage in chapters:
+// the configured main camera, or else the one with the most footage.
+func (c *Config) mainCamera(cameras []string, chapters map[string][]Chapter) string {
+	for _, camera := range cameras {
+		if camera == c.PiP.MainCamera || c.cameraLabel(camera) == c.PiP.MainCamera {
+			return camera
+		}
+	}
+	footage := func(camera string) time.Duration {
+		return Video{Chapters: chapters[camera]}.duration()
+	}
+	main := cameras[0]
+	for _, camera := range cameras[1:] {
+		if footage(camera) > footage(main) {
+			main = camera
+		}
+	}
+	return main
+}
+
+// A stretch of an overlaid chapter, shown over the footage of the main camera
+// recorded at the same time.
+type pipSegment struct {
+	Chapter Chapter
+	// Position in the overlaid chapter, and in the rendered video.
+	Skip, At time.Duration
+	Duration time.Duration
+}
+
+// Returns the stretches of the overlaid chapters recorded while the main
+// camera was recording the chapters of the video, matched by recording time.
+// Footage recorded between two main chapters is left out, so that the
+// overlay stays in sync across recording gaps.
+func pipSegments(video Video) []pipSegment {
+	var segments []pipSegment
+	offsets := video.chapterOffsets()
+	for ix, chapter := range video.Chapters {
+		start, end := chapter.CreateTime, chapter.CreateTime.Add(chapter.Duration)
+		for _, overlay := range video.Overlay {
+			overlayStart, overlayEnd := overlay.CreateTime, overlay.CreateTime.Add(overlay.Duration)
+			from, to := overlayStart, overlayEnd
+			if start.After(from) {
+				from = start
+			}
+			if end.Before(to) {
+				to = end
+			}
+			if !to.After(from) {
+				continue
+			}
+			segments = append(segments, pipSegment{
+				Chapter:  overlay,
+				Skip:     overlay.InPoint + from.Sub(overlayStart),
+				At:       offsets[ix] + from.Sub(start),
+				Duration: to.Sub(from),
+			})
+		}
+	}
+	sort.SliceStable(segments, func(i, j int) bool { return segments[i].At < segments[j].At })
+	return segments
+}
+
+// Returns the ffmpeg inputs and filtergraph overlaying segments on input 0,
+// the master. The output is labelled [v].
+func pipFilters(video Video, segments []pipSegment, layout PiPLayout) ([]string, string) {
+	width := 1920
+	if len(video.Chapters) > 0 && video.Chapters[0].Resolution.Width > 0 {
+		width = video.Chapters[0].Resolution.Width
+	}
+	overlayWidth := int(float64(width)*layout.Size) / 2 * 2
+	position := fmt.Sprintf(pipCorners[layout.Corner], width/50)
+
+	dirPath := inputSnapshots.translate(video.Path)
+	var inputs, filters []string
+	last := "[0:v:0]"
+	for ix, segment := range segments {
+		inputs = append(inputs,
+			"-ss", fmt.Sprintf("%.3f", segment.Skip.Seconds()),
+			"-t", fmt.Sprintf("%.3f", segment.Duration.Seconds()),
+			"-i", path.Join(dirPath, segment.Chapter.FileName))
+		label := fmt.Sprintf("[pip%d]", ix)
+		filters = append(filters, fmt.Sprintf("[%d:v:0]scale=%d:-2,setpts=PTS-STARTPTS+%.3f/TB%s",
+			ix+1, overlayWidth, segment.At.Seconds(), label))
+		out := fmt.Sprintf("[base%d]", ix)
+		if ix == len(segments)-1 {
+			out = "[v]"
+		}
+		filters = append(filters, fmt.Sprintf("%s%soverlay=%s:eof_action=pass%s", last, label, position, out))
+		last = out
+	}
+	return inputs, strings.Join(filters, ";")
+}
+
+// Renders the pip variant of a rendered multicam video, overlaying the
+// footage of the other cameras on the master. The audio of the main camera
+// is kept.
+func composePiP(ctx context.Context, runner Runner, video Video, outputDir string, config *Config) error {
+	inputFname := filepath.Join(outputDir, video.Title+VideoExt)
+	outputFname := filepath.Join(outputDir, video.Title+"."+VariantPiP+VideoExt)
+	tmpFname := filepath.Join(outputDir, "."+video.Title+"."+VariantPiP+".tmp"+VideoExt)
+	segments := pipSegments(video)
+	if len(segments) == 0 {
+		// No footage of the other cameras overlaps, the master is uploaded as is.
+		log.Printf(">>> No overlapping footage for %s, copying the master", outputFname)
+		return runCommand(runner, exec.CommandContext(ctx, binaryPath("ffmpeg"), "-v", "warning",
+			"-i", inputFname, "-map", "0", "-c", "copy", "-map_metadata", "0",
+			"-movflags", "+faststart", outputFname, "-y"))
+	}
+	log.Printf(">>> Compositing %s from %d overlays", outputFname, len(segments))
+	inputs, filters := pipFilters(video, segments, config.PiP)
+	if config.watermarkStage(video) == VariantPiP {
+		filters = strings.TrimSuffix(filters, "[v]") + "[pre];" + config.Watermark.graph("[pre]", "[v]", config.renderedWidth(video))
+	}
+	args := append([]string{"-v", "warning", "-i", inputFname}, inputs...)
+	args = append(args,
+		"-filter_complex", filters,
+		"-map", "[v]",
+		"-map", "0:a:0?",
+		"-map_metadata", "0",
+		"-map_chapters", "0")
+	args = append(args, config.encoderArgs()...)
+	args = append(args,
+		"-c:a", "copy",
+		"-movflags", "+faststart",
+		"-progress", "pipe:1", "-nostats",
+		"-f", "mp4", tmpFname, "-y")
+	cmd := exec.CommandContext(ctx, binaryPath("ffmpeg"), args...)
+	cmd.Stderr = os.Stderr
+	if err := progressBars.run(runner, cmd, video.Title, video.renderedDuration()); err != nil {
+		os.Remove(tmpFname)
+		return err
+	}
+	return renameOutput(runner, tmpFname, outputFname)
+}
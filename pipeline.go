@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Returned when rendering a video takes longer than the configured deadline.
+var errStageTimeout = errors.New("Timed out")
+
+// Uploads interrupted by network errors are retried this many times, waiting
+// twice as long each time, starting with uploadRetryBackoff.
+const (
+	maxUploadRetries   = 5
+	uploadRetryBackoff = 10 * time.Second
+)
+
+// Whether an error is due to the network rather than the request, e.g. a
+// dropped connection or a failed DNS lookup. TLS, authorization and API
+// errors are not, and retrying them would not help.
+func isConnectivityError(err error) bool {
+	// url.Error implements net.Error itself, the cause tells.
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+	if errors.As(err, &opErr) || errors.As(err, &dnsErr) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// Renders and uploads queued videos. Rendering is CPU and disk bound while
+// uploading is network bound, so both stages run concurrently: videos are
+// handed over to the upload stage as soon as they are rendered. Progress is
+// persisted in the state database, so an interrupted run resumes where it
+// left off.
+type Pipeline struct {
+	config    *Config
+	state     *State
+	outputDir string
+	// Uploads are skipped while nil. Only used by the upload stage.
+	yt *YouTube
+	// If set, uploads only happen during this window.
+	uploadWindow *TimeWindow
+	// Runs the commands rendering videos.
+	runner Runner
+	// How long to wait for connectivity before postponing uploads.
+	offlineTimeout time.Duration
+	// Titles of the videos present in the output directory. Only used by the
+	// render stage.
+	titles []string
+	// If set, renders are previewed through it.
+	preview *Preview
+	// Notified of finished and failed renders and uploads.
+	notifier *Notifier
+	// If set, rendering and upload progress is counted in it.
+	metrics *Metrics
+}
+
+// Processes queue entries in order until all are rendered and uploaded, or
+// one of the stages fails.
+func (p *Pipeline) run(ctx context.Context, entries []*VideoState) error {
+	uploads := make(chan *VideoState, len(entries))
+	uploadErr := make(chan error, 1)
+	stopped := make(chan struct{})
+	go func() {
+		err := p.uploadAll(ctx, uploads)
+		if err != nil {
+			close(stopped)
+		}
+		uploadErr <- err
+	}()
+
+	var renderErr error
+render:
+	for _, entry := range entries {
+		select {
+		case <-stopped:
+			break render
+		case <-ctx.Done():
+			renderErr = ctx.Err()
+			break render
+		default:
+		}
+		err := p.render(ctx, entry)
+		if errors.Is(err, errStageTimeout) {
+			log.Printf(">>> %v, skipping..", err)
+			continue
+		}
+		if err != nil {
+			renderErr = err
+			break
+		}
+		uploads <- entry
+	}
+	close(uploads)
+
+	if err := <-uploadErr; err != nil {
+		return err
+	}
+	return renderErr
+}
+
+// Renders a queued video unless it is present in the output directory already.
+func (p *Pipeline) render(ctx context.Context, entry *VideoState) error {
+	if entry.Status != StatusPending {
+		return nil
+	}
+	var checksum string
+	if !contains(p.titles, entry.Title) {
+		renderCtx, cancel := withStageTimeout(ctx, p.config.RenderTimeout)
+		defer cancel()
+		var err error
+		start := time.Now()
+		renderCtx, span := startSpan(renderCtx, "render", "video.title", entry.Title,
+			"video.chapters", len(entry.Video.Chapters), "video.bytes", entry.Video.size())
+		checksum, err = renderVideo(renderCtx, p.runner, entry.Video, p.outputDir, p.config, p.preview)
+		span.end(err)
+		if err != nil && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil && renderCtx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("%w: rendering %s took longer than %s", errStageTimeout, entry.Title, p.config.RenderTimeout)
+		}
+		if err != nil {
+			p.notifier.notify(ctx, EventRenderFailed, entry.Title, "", "Rendering of %s failed: %v", entry.Title, err)
+			return err
+		}
+		p.notifier.notify(ctx, EventRendered, entry.Title, "", "Rendered %s", entry.Title)
+		p.metrics.addRendered(time.Since(start))
+		p.titles = append(p.titles, entry.Title)
+	}
+	var err error
+	switch {
+	case entry.Video.is360():
+		err = p.renderVariant(ctx, entry, Variant360, "convert360", "Conversion", convert360)
+	case len(entry.Video.Overlay) > 0:
+		err = p.renderVariant(ctx, entry, VariantPiP, "compose_pip", "Compositing", composePiP)
+	case p.config.encodesVariant(entry.Video):
+		err = p.renderVariant(ctx, entry, p.config.EncodeProfile, "encode", "Encoding", encodeVariant)
+	}
+	if err != nil {
+		return err
+	}
+	uploadFname := renderedFile(p.outputDir, entry.Title, p.config.uploadVariant(entry.Video))
+	if err := p.config.ContactSheet.render(ctx, p.runner, entry.Video, uploadFname, p.outputDir); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		warnf(">>> Could not write contact sheet of %s: %v", entry.Title, err)
+	}
+	if err := p.state.update(func() {
+		entry.Status = StatusRendered
+		// Renders found in the output directory keep the checksum recorded,
+		// e.g. by remux.
+		if checksum != "" {
+			entry.Checksum = checksum
+		}
+	}); err != nil {
+		return err
+	}
+	p.writeMetadata(ctx, entry)
+	return nil
+}
+
+// Writes the metadata files of a video, as configured.
+func (p *Pipeline) writeMetadata(ctx context.Context, entry *VideoState) {
+	if p.config.MetadataFiles {
+		if err := writeVideoMetadata(ctx, entry, p.outputDir); err != nil {
+			warnf(">>> Could not write metadata of %s: %v", entry.Title, err)
+		}
+	}
+	if p.config.NFO {
+		if err := writeNFO(ctx, entry, p.outputDir); err != nil {
+			warnf(">>> Could not write NFO of %s: %v", entry.Title, err)
+		}
+	}
+}
+
+// Renders a variant of a rendered video, e.g. the equirectangular one of 360
+// videos, unless rendered already. Stage names the span, and action the
+// failure notification.
+func (p *Pipeline) renderVariant(ctx context.Context, entry *VideoState, variant, stage, action string,
+	render func(context.Context, Runner, Video, string, *Config) error) error {
+	fileName := renderedFile(p.outputDir, entry.Title, variant)
+	if fileName != filepath.Join(p.outputDir, entry.Title+VideoExt) {
+		return nil
+	}
+	renderCtx, cancel := withStageTimeout(ctx, p.config.RenderTimeout)
+	defer cancel()
+	renderCtx, span := startSpan(renderCtx, stage, "video.title", entry.Title, "video.variant", variant)
+	err := render(renderCtx, p.runner, entry.Video, p.outputDir, p.config)
+	span.end(err)
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil && renderCtx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("%w: %s of %s took longer than %s", errStageTimeout, strings.ToLower(action), entry.Title, p.config.RenderTimeout)
+	}
+	if err != nil {
+		p.notifier.notify(ctx, EventRenderFailed, entry.Title, "", "%s of %s failed: %v", action, entry.Title, err)
+	}
+	return err
+}
+
+// Uploads rendered videos as they come in.
+func (p *Pipeline) uploadAll(ctx context.Context, entries <-chan *VideoState) error {
+	for entry := range entries {
+		if err := p.upload(ctx, entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Uploads a rendered video, unless uploads are disabled or postponed.
+func (p *Pipeline) upload(ctx context.Context, entry *VideoState) error {
+	if p.yt == nil {
+		return nil
+	}
+	if p.uploadWindow != nil && !p.uploadWindow.contains(time.Now()) {
+		log.Printf(">>> Outside of upload window, upload of %s postponed..", entry.Title)
+		return nil
+	}
+	// Videos uploaded on earlier runs are not notified again.
+	status, videoID := entry.Status, entry.VideoID
+	// The deadline covers retries and waiting for YouTube to process the video.
+	uploadCtx, cancel := withStageTimeout(ctx, p.config.UploadTimeout)
+	defer cancel()
+	retries := 0
+	for {
+		// While offline, rendered videos accumulate in the channel and are
+		// uploaded once connectivity returns.
+		err := waitOnline(uploadCtx, p.offlineTimeout)
+		if err == nil {
+			spanCtx, span := startSpan(uploadCtx, "upload", "video.title", entry.Title)
+			err = uploadVideo(spanCtx, p.yt, p.state, p.config, entry.Video, p.outputDir)
+			span.setAttribute("video.status", entry.Status)
+			span.setAttribute("youtube.video_id", entry.VideoID)
+			span.end(err)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err == nil && entry.VideoID != videoID {
+			p.countUploadedBytes(entry)
+			p.writeMetadata(ctx, entry)
+		}
+		if err == nil && (entry.Status != status || entry.VideoID != videoID) {
+			p.metrics.addUploaded(entry.Status)
+			p.notifyUploaded(ctx, entry)
+		}
+		if err == nil {
+			return nil
+		}
+		if uploadCtx.Err() == context.DeadlineExceeded {
+			log.Printf(">>> Upload of %s took longer than %s, postponing..", entry.Title, p.config.UploadTimeout)
+			return p.state.save()
+		}
+		if errors.Is(err, errOffline) {
+			log.Printf(">>> %v, postponing remaining uploads", err)
+			p.yt = nil
+			return nil
+		}
+		if errors.Is(err, errAuthRequired) {
+			log.Printf(">>> %v, postponing remaining uploads", err)
+			p.notifier.notify(ctx, EventAuthRequired, entry.Title, "",
+				"Uploads of profile %s postponed: %v", p.config.profile, err)
+			p.yt = nil
+			return p.state.save()
+		}
+		if isConnectivityError(err) && retries < maxUploadRetries {
+			// Connectivity was lost midway, resume once it is back.
+			backoff := uploadRetryBackoff << retries
+			retries++
+			warnf(">>> Upload of %s interrupted: %v, retrying in %s", entry.Title, err, backoff)
+			if err := sleepContext(uploadCtx, backoff); err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				log.Printf(">>> Upload of %s took longer than %s, postponing..", entry.Title, p.config.UploadTimeout)
+				return p.state.save()
+			}
+			continue
+		}
+		if errors.Is(err, errDataCapExceeded) {
+			log.Printf(">>> Uploads paused: %v (%.1fG of %.1fG uploaded in %s)",
+				err, float64(p.yt.data.used())/(1<<30), float64(p.yt.data.limit)/(1<<30),
+				dataMonth(time.Now()))
+			p.yt = nil
+			return p.state.save()
+		}
+		if errors.Is(err, errQuotaExceeded) {
+			// Keep rendering, the remaining uploads resume on the next run.
+			log.Printf(">>> %v, postponing remaining uploads", err)
+			p.yt = nil
+			return p.state.save()
+		}
+		return err
+	}
+}
+
+// Notifies the outcome of an upload, once YouTube finished processing it.
+func (p *Pipeline) notifyUploaded(ctx context.Context, entry *VideoState) {
+	videoURL := "https://youtu.be/" + entry.VideoID
+	switch entry.Status {
+	case StatusUploaded:
+		p.notifier.notify(ctx, EventUploaded, entry.Title, videoURL, "Uploaded %s", entry.Title)
+	case StatusFailed:
+		p.notifier.notify(ctx, EventUploadFailed, entry.Title, videoURL, "Upload of %s failed: %s", entry.Title, entry.Error)
+	}
+}
+
+// Counts the bytes of a video just uploaded.
+func (p *Pipeline) countUploadedBytes(entry *VideoState) {
+	if p.metrics == nil {
+		return
+	}
+	info, err := os.Stat(renderedFile(p.outputDir, entry.Title, p.config.uploadVariant(entry.Video)))
+	if err == nil {
+		p.metrics.addUploadedBytes(info.Size())
+	}
+}
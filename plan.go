@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// What a run does with a discovered video.
+const (
+	PlanRender = "render"
+	// Already present in the output directory.
+	PlanSkip = "skip"
+)
+
+type PlanChapter struct {
+	FileName   string    `json:"file_name"`
+	CreateTime time.Time `json:"create_time"`
+	// Duration in seconds.
+	Duration   float64         `json:"duration"`
+	Size       int64           `json:"size"`
+	Resolution VideoResolution `json:"resolution"`
+}
+
+// Machine-readable description of what a run does with a video.
+type PlanVideo struct {
+	Title   string `json:"title"`
+	Path    string `json:"path"`
+	Privacy string `json:"privacy"`
+	// One of PlanRender or PlanSkip.
+	Action string `json:"action"`
+	// Whether the video would be uploaded, if uploads are enabled.
+	Upload bool `json:"upload"`
+	// Status in the state database, if the video was queued before.
+	Status string `json:"status,omitempty"`
+	// Destinations holding a copy of the video verified by checksum, and
+	// whether there are enough of them for its chapters to be deleted, see
+	// sourcesDeletable.
+	VerifiedCopies   []string `json:"verified_copies,omitempty"`
+	SourcesDeletable bool     `json:"sources_deletable"`
+	// Whether the chapters of the directory are split into several videos,
+	// see splitVideo.
+	Split bool `json:"split"`
+	// Duration in seconds.
+	Duration float64 `json:"duration"`
+	// Estimated size of the rendered file in bytes. Chapters are joined without
+	// re-encoding, so this is the size of the chapters.
+	EstimatedSize int64         `json:"estimated_size"`
+	Variants      []string      `json:"variants,omitempty"`
+	Description   string        `json:"description"`
+	Chapters      []PlanChapter `json:"chapters"`
+}
+
+// Describes what a run does with the discovered videos.
+func buildPlan(ctx context.Context, videos []Video, titles []string, variants map[string][]string, state *State, upload bool, minCopies int) []PlanVideo {
+	videosByPath := map[string]int{}
+	for _, video := range videos {
+		videosByPath[video.Path]++
+	}
+
+	results := []PlanVideo{}
+	for _, video := range videos {
+		plan := PlanVideo{
+			Title:         video.Title,
+			Path:          video.Path,
+			Privacy:       video.Privacy,
+			Action:        PlanRender,
+			Upload:        upload,
+			Split:         videosByPath[video.Path] > 1,
+			Duration:      video.duration().Seconds(),
+			EstimatedSize: video.size(),
+			Variants:      variants[video.Title],
+			Description:   videoDescription(ctx, video),
+			Chapters:      []PlanChapter{},
+		}
+		if contains(titles, video.Title) {
+			plan.Action = PlanSkip
+		}
+		if entry, ok := state.Videos[video.Title]; ok {
+			plan.Status = entry.Status
+			plan.VerifiedCopies = entry.verifiedCopies()
+			plan.SourcesDeletable = entry.sourcesDeletable(minCopies)
+			if entry.Status == StatusUploaded {
+				plan.Upload = false
+			}
+		}
+		for _, chapter := range video.Chapters {
+			plan.Chapters = append(plan.Chapters, PlanChapter{
+				FileName:   chapter.FileName,
+				CreateTime: chapter.CreateTime,
+				Duration:   chapter.Duration.Seconds(),
+				Size:       chapter.Size,
+				Resolution: chapter.Resolution,
+			})
+		}
+		results = append(results, plan)
+	}
+	return results
+}
+
+// Writes the plan as indented JSON.
+func writePlan(w io.Writer, plan []PlanVideo) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(plan)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Playlist of the HLS preview, in the preview directory.
+const previewPlaylist = "index.m3u8"
+
+// Number of HLS segments kept around, so that the preview only takes the
+// space of the last minute of footage.
+const previewSegments = 10
+
+// Experimental HLS preview of the video being rendered. The concat demuxer
+// output is teed into an HLS segmenter next to the rendered file, and the
+// segments are served over HTTP, so the quality of a render can be checked
+// while it is still in progress.
+type Preview struct {
+	dir string
+
+	mu sync.Mutex
+	// Title of the video being rendered, if any.
+	title string
+}
+
+var previewPageTemplate = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>gopro-uploader preview</title>
+</head>
+<body style="font-family: sans-serif">
+{{ if . }}
+<h1>Rendering {{ . }}</h1>
+<video src="` + previewPlaylist + `" controls autoplay muted style="max-width: 100%"></video>
+<p>Browsers without native HLS support: open <a href="` + previewPlaylist + `">` + previewPlaylist + `</a> in VLC or mpv.</p>
+{{ else }}
+<h1>Nothing is being rendered</h1>
+{{ end }}
+</body>
+</html>
+`))
+
+// Starts serving previews on the given address, e.g. ":8080".
+func startPreview(addr string) (*Preview, error) {
+	dir, err := ioutil.TempDir("", "gopro-uploader-preview")
+	if err != nil {
+		return nil, err
+	}
+	preview := &Preview{dir: dir}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	files := http.FileServer(http.Dir(dir))
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			preview.servePage(w, r)
+			return
+		}
+		files.ServeHTTP(w, r)
+	})
+	go func() {
+		log.Printf(">>> Serving render previews on http://%s/", listener.Addr())
+		if err := http.Serve(listener, handler); err != nil {
+			warnf(">>> Preview server stopped: %v", err)
+		}
+	}()
+	return preview, nil
+}
+
+func (p *Preview) servePage(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	title := p.title
+	p.mu.Unlock()
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := previewPageTemplate.Execute(w, title); err != nil {
+		warnf(">>> Error serving preview page: %v", err)
+	}
+}
+
+// Switches the preview to a new render, dropping the previous segments.
+func (p *Preview) start(title string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.title = title
+	files, err := ioutil.ReadDir(p.dir)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := os.Remove(filepath.Join(p.dir, file.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Marks the end of the render.
+func (p *Preview) stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.title = ""
+}
+
+// Escapes a file name for use in a tee muxer slave specification.
+func escapeTeeFileName(fileName string) string {
+	var b strings.Builder
+	for _, r := range fileName {
+		if strings.ContainsRune(`\'|[]`, r) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Returns the tee muxer output writing both the rendered file and the
+// preview. Segments are fragmented MP4 so that HEVC footage can be copied
+// as is.
+func (p *Preview) teeOutput(outputFname, movflags string) string {
+	mp4Options := "f=mp4"
+	if movflags != "" {
+		mp4Options += ":movflags=" + movflags
+	}
+	// Chapter announcement tracks are left out of the preview.
+	hlsOptions := fmt.Sprintf(`select=\'v:0,a:0\':f=hls:hls_time=6:hls_list_size=%d:hls_flags=delete_segments:hls_segment_type=fmp4`,
+		previewSegments)
+	return fmt.Sprintf("[%s]%s|[%s]%s", mp4Options, escapeTeeFileName(outputFname),
+		hlsOptions, escapeTeeFileName(filepath.Join(p.dir, previewPlaylist)))
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Width of progress bars, in characters.
+const progressBarWidth = 30
+
+// Interval between two progress messages when stderr is not a terminal.
+const progressLogInterval = time.Minute
+
+// Progress of a running ffmpeg job, as reported by -progress.
+type ProgressJob struct {
+	bars  *ProgressBars
+	title string
+	// Duration of the output once done, and how much of it is written.
+	total, done time.Duration
+	// Encoding speed, relative to real time.
+	speed   float64
+	started time.Time
+	logged  time.Time
+	// Partial line of -progress output.
+	buf []byte
+}
+
+// Draws one progress bar per running ffmpeg job at the bottom of the
+// terminal, plus a total while several jobs run in parallel. Log messages
+// are written above them. When stderr is not a terminal, progress is logged
+// periodically instead.
+type ProgressBars struct {
+	mu   sync.Mutex
+	w    io.Writer
+	tty  bool
+	jobs []*ProgressJob
+	// Number of lines currently drawn.
+	drawn int
+}
+
+// Progress bars of the jobs run by this process.
+var progressBars = newProgressBars(os.Stderr)
+
+func newProgressBars(f *os.File) *ProgressBars {
+	info, err := f.Stat()
+	return &ProgressBars{w: f, tty: err == nil && info.Mode()&os.ModeCharDevice != 0}
+}
+
+// Runs an ffmpeg command producing output of the given duration, tracking its
+// progress. The command must be passed "-progress pipe:1 -nostats".
+func (b *ProgressBars) run(runner Runner, cmd *exec.Cmd, title string, total time.Duration) error {
+	job := &ProgressJob{bars: b, title: title, total: total, started: time.Now()}
+	job.logged = job.started
+	b.mu.Lock()
+	b.jobs = append(b.jobs, job)
+	b.redraw()
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		for ix, other := range b.jobs {
+			if other == job {
+				b.jobs = append(b.jobs[:ix], b.jobs[ix+1:]...)
+				break
+			}
+		}
+		b.redraw()
+	}()
+	cmd.Stdout = job
+	if cmd.Stderr == os.Stderr {
+		cmd.Stderr = stderrAbove{b}
+	}
+	return runCommand(runner, cmd)
+}
+
+// Writes ffmpeg warnings above the progress bars.
+type stderrAbove struct {
+	bars *ProgressBars
+}
+
+func (w stderrAbove) Write(p []byte) (n int, err error) {
+	w.bars.write(func() { n, err = os.Stderr.Write(p) })
+	return n, err
+}
+
+// Writes to the terminal above the progress bars.
+func (b *ProgressBars) write(write func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.clear()
+	write()
+	b.redraw()
+}
+
+// Erases the progress bars. Must be called with mu held.
+func (b *ProgressBars) clear() {
+	if !b.tty || b.drawn == 0 {
+		return
+	}
+	io.WriteString(b.w, "\r"+strings.Repeat("\033[1A\033[2K", b.drawn))
+	b.drawn = 0
+}
+
+// Draws the progress bars again. Must be called with mu held.
+func (b *ProgressBars) redraw() {
+	if !b.tty {
+		return
+	}
+	b.clear()
+	var lines []string
+	var total, done, remaining time.Duration
+	for _, job := range b.jobs {
+		lines = append(lines, formatProgress(job.done, job.total, job.speed, job.eta(), job.title))
+		total += job.total
+		done += job.done
+		if eta := job.eta(); eta > remaining {
+			remaining = eta
+		}
+	}
+	if len(b.jobs) > 1 {
+		lines = append(lines, formatProgress(done, total, 0, remaining, fmt.Sprintf("Total (%d jobs)", len(b.jobs))))
+	}
+	for _, line := range lines {
+		io.WriteString(b.w, line+"\n")
+	}
+	b.drawn = len(lines)
+}
+
+// Formats a progress bar, e.g.
+// "[=========>          ]  42% 3.1x ETA 2m10s title".
+func formatProgress(done, total time.Duration, speed float64, eta time.Duration, title string) string {
+	fraction := 0.0
+	if total > 0 {
+		fraction = math.Min(float64(done)/float64(total), 1)
+	}
+	filled := int(fraction * progressBarWidth)
+	bar := strings.Repeat("=", filled)
+	if filled < progressBarWidth {
+		bar += ">" + strings.Repeat(" ", progressBarWidth-filled-1)
+	}
+	return fmt.Sprintf("[%s] %s %s", bar, formatProgressStats(fraction, speed, eta), title)
+}
+
+// Formats the percentage done, encoding speed and ETA, e.g. " 42% 3.1x ETA 2m10s".
+func formatProgressStats(fraction, speed float64, eta time.Duration) string {
+	stats := fmt.Sprintf("%3.0f%%", 100*fraction)
+	if speed > 0 {
+		stats += fmt.Sprintf(" %.1fx", speed)
+	}
+	if eta > 0 {
+		stats += " ETA " + eta.Round(time.Second).String()
+	}
+	return stats
+}
+
+// Estimates the time left, from the encoding speed so far.
+func (j *ProgressJob) eta() time.Duration {
+	if j.done <= 0 || j.done >= j.total {
+		return 0
+	}
+	elapsed := time.Since(j.started)
+	return time.Duration(float64(elapsed) * float64(j.total-j.done) / float64(j.done))
+}
+
+// Parses -progress output: blocks of key=value lines, each ending with a
+// progress=continue or progress=end line.
+func (j *ProgressJob) Write(p []byte) (int, error) {
+	j.buf = append(j.buf, p...)
+	for {
+		ix := bytes.IndexByte(j.buf, '\n')
+		if ix < 0 {
+			break
+		}
+		line := strings.TrimSpace(string(j.buf[:ix]))
+		j.buf = j.buf[ix+1:]
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		j.update(key, value)
+	}
+	return len(p), nil
+}
+
+// Records a value reported by ffmpeg, showing progress at the end of a block.
+func (j *ProgressJob) update(key, value string) {
+	b := j.bars
+	b.mu.Lock()
+	var message string
+	switch key {
+	case "out_time_us":
+		if us, err := strconv.ParseInt(value, 10, 64); err == nil {
+			j.done = time.Duration(us) * time.Microsecond
+		}
+	case "speed":
+		if speed, err := strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64); err == nil {
+			j.speed = speed
+		}
+	case "progress":
+		if b.tty {
+			b.redraw()
+		} else if time.Since(j.logged) >= progressLogInterval && j.total > 0 {
+			j.logged = time.Now()
+			fraction := math.Min(float64(j.done)/float64(j.total), 1)
+			message = fmt.Sprintf(">>> %s: %s", j.title, strings.TrimSpace(formatProgressStats(fraction, j.speed, j.eta())))
+		}
+	}
+	b.mu.Unlock()
+	// Logging goes through the progress bars, so only once unlocked.
+	if message != "" {
+		log.Print(message)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestProgressJobWrite(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		chunks    []string
+		wantDone  time.Duration
+		wantSpeed float64
+	}{
+		{
+			name:      "one block",
+			chunks:    []string{"frame=100\nout_time_us=4000000\nspeed=2.5x\nprogress=continue\n"},
+			wantDone:  4 * time.Second,
+			wantSpeed: 2.5,
+		},
+		{
+			name:      "lines split across writes",
+			chunks:    []string{"out_time", "_us=1500", "000\nspe", "ed=1.2x\r\n", "progress=end\n"},
+			wantDone:  1500 * time.Millisecond,
+			wantSpeed: 1.2,
+		},
+		{
+			name:      "latest block wins",
+			chunks:    []string{"out_time_us=1000000\nspeed=3x\nprogress=continue\nout_time_us=2000000\nspeed=2x\nprogress=continue\n"},
+			wantDone:  2 * time.Second,
+			wantSpeed: 2,
+		},
+		{
+			name:      "unknown values",
+			chunks:    []string{"out_time_us=N/A\nspeed=N/A\nbitrate=N/A\ngarbage\nprogress=continue\n"},
+			wantDone:  0,
+			wantSpeed: 0,
+		},
+		{
+			name:      "partial line",
+			chunks:    []string{"out_time_us=1000000\nout_time_us=2000000"},
+			wantDone:  time.Second,
+			wantSpeed: 0,
+		},
+	} {
+		job := &ProgressJob{bars: &ProgressBars{w: &bytes.Buffer{}}, title: "test", total: time.Minute, started: time.Now()}
+		job.logged = job.started
+		for _, chunk := range test.chunks {
+			if n, err := job.Write([]byte(chunk)); n != len(chunk) || err != nil {
+				t.Errorf("%s: Write(%q) = %d, %v, want %d, nil", test.name, chunk, n, err, len(chunk))
+			}
+		}
+		if job.done != test.wantDone || job.speed != test.wantSpeed {
+			t.Errorf("%s: done, speed = %v, %v, want %v, %v", test.name, job.done, job.speed, test.wantDone, test.wantSpeed)
+		}
+	}
+}
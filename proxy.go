@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Subdirectory of the output directory in which review proxies are rendered.
+// Proxies are never uploaded.
+const ProxiesDir = "proxies"
+
+// Size and frame rate of review proxies, small enough to be copied to a phone.
+const (
+	proxyWidth     = 960
+	proxyHeight    = 540
+	proxyFrameRate = 30
+)
+
+// Length of the sync test lead-in, and when its flash and beep happen.
+const (
+	syncTestDuration = 2 * time.Second
+	syncTestAt       = 1 * time.Second
+	// One frame, so that an offset of a single frame can be noticed.
+	syncTestPulse = time.Second / proxyFrameRate
+)
+
+// Returns the filtergraph generating the sync test lead-in: black with a
+// white flash, silent with a 1 kHz beep at the same time. Its outputs are
+// labelled [syncv] and [synca].
+func syncTestFilters() string {
+	enable := fmt.Sprintf("between(t,%.3f,%.3f)", syncTestAt.Seconds(), (syncTestAt + syncTestPulse).Seconds())
+	return strings.Join([]string{
+		fmt.Sprintf("color=c=black:s=%dx%d:r=%d:d=%.3f,format=yuv420p,drawbox=x=0:y=0:w=iw:h=ih:color=white:t=fill:enable='%s'[syncv]",
+			proxyWidth, proxyHeight, proxyFrameRate, syncTestDuration.Seconds(), enable),
+		fmt.Sprintf("sine=f=1000:r=48000:d=%.3f,volume=0:enable='not(%s)',aformat=channel_layouts=stereo[synca]",
+			syncTestDuration.Seconds(), enable),
+	}, ";")
+}
+
+// Renders a low resolution, low bitrate copy of a video for review, given
+// the ffmpeg arguments of its input, optionally starting with the sync test
+// lead-in.
+func renderProxy(ctx context.Context, inputArgs []string, outputFname, title string, duration time.Duration, syncTest bool) error {
+	scale := fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease,pad=%d:%d:(ow-iw)/2:(oh-ih)/2,setsar=1,fps=%d,format=yuv420p",
+		proxyWidth, proxyHeight, proxyWidth, proxyHeight, proxyFrameRate)
+	args := append([]string{"-v", "warning"}, inputArgs...)
+	if syncTest {
+		args = append(args, "-filter_complex", strings.Join([]string{
+			syncTestFilters(),
+			"[0:v:0]" + scale + "[v]",
+			"[0:a:0]aresample=48000,aformat=channel_layouts=stereo[a]",
+			"[syncv][synca][v][a]concat=n=2:v=1:a=1[outv][outa]",
+		}, ";"), "-map", "[outv]", "-map", "[outa]")
+		duration += syncTestDuration
+	} else {
+		args = append(args, "-map", "0:v:0", "-map", "0:a:0?", "-vf", scale)
+	}
+	args = append(args,
+		"-c:v", "libx264", "-preset", "veryfast", "-crf", "30",
+		"-c:a", "aac", "-b:a", "96k",
+		"-movflags", "+faststart",
+		"-progress", "pipe:1", "-nostats",
+		outputFname, "-y")
+	cmd := exec.CommandContext(ctx, binaryPath("ffmpeg"), args...)
+	cmd.Stderr = os.Stderr
+	return progressBars.run(execRunner{}, cmd, title, duration)
+}
+
+// Renders review proxies of rendered videos.
+func runProxyCommand(args []string) {
+	flags := flag.NewFlagSet("proxy", flag.ExitOnError)
+	outputDir := flags.String("output_dir", "", "Directory containing the rendered video files.")
+	syncTest := flags.Bool("sync_test", false, "If true, starts proxies with a 2 second flash and beep, to check A/V sync on the playback device.")
+	readConfig := configFlags(flags)
+	setupLogging := logFlags(flags)
+	titles := parseInterspersed(flags, args)
+	setupLogging()
+	if len(titles) == 0 {
+		fatalf("Usage: proxy <video-title>... [--sync_test]")
+	}
+	if *outputDir == "" {
+		fatalf("--output_dir cannot be empty")
+	}
+	if _, err := readConfig(); err != nil {
+		fatal(err)
+	}
+	checkDependencies("ffmpeg")
+	ctx, stop := interruptContext()
+	defer stop()
+
+	state, err := loadState(*outputDir)
+	if err != nil {
+		fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(*outputDir, ProxiesDir), os.ModePerm); err != nil {
+		fatal(err)
+	}
+	for _, title := range titles {
+		inputFname := filepath.Join(*outputDir, title+VideoExt)
+		if _, err := os.Stat(inputFname); err != nil {
+			fatal(err)
+		}
+		var duration time.Duration
+		if entry, ok := state.Videos[title]; ok {
+			duration = entry.Video.renderedDuration()
+		}
+		outputFname := filepath.Join(*outputDir, ProxiesDir, title+VideoExt)
+		log.Printf(">>> Rendering proxy %s", outputFname)
+		if err := renderProxy(ctx, []string{"-i", inputFname}, outputFname, title, duration, *syncTest); err != nil {
+			os.Remove(outputFname)
+			fatal(err)
+		}
+	}
+}
+
+// Renders a review proxy of a video straight from its chapters, without
+// rendering it first, so that cuts can be checked before a full render. If
+// lowRes is set and every chapter has a low resolution proxy recorded by the
+// camera, those are stitched instead, which is much faster.
+func renderPreviewProxy(ctx context.Context, video Video, outputDir string, lowRes bool) error {
+	tmpDir, err := ioutil.TempDir("", "gopro-uploader")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	dirPath := inputSnapshots.translate(video.Path)
+	var chapterLines, lrvLines []string
+	for _, chapter := range video.Chapters {
+		chapterLines = append(chapterLines, concatEntry(path.Join(dirPath, chapter.FileName), chapter))
+		if lrv := lowResFile(dirPath, chapter.FileName, ExtLRV); lrv != "" {
+			lrvLines = append(lrvLines, concatEntry(lrv, chapter))
+		}
+	}
+	inputLines := chapterLines
+	if lowRes && len(lrvLines) == len(chapterLines) {
+		inputLines = lrvLines
+	}
+	inputFname := filepath.Join(tmpDir, "input.txt")
+	if err := ioutil.WriteFile(inputFname, []byte(strings.Join(inputLines, "\n")), os.ModePerm); err != nil {
+		return err
+	}
+	outputFname := filepath.Join(outputDir, ProxiesDir, video.Title+VideoExt)
+	log.Printf(">>> Rendering proxy %s", outputFname)
+	inputArgs := []string{"-f", "concat", "-safe", "0", "-i", inputFname}
+	if err := renderProxy(ctx, inputArgs, outputFname, video.Title, video.duration(), false); err != nil {
+		os.Remove(outputFname)
+		return err
+	}
+	return nil
+}
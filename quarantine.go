@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// A chapter file left out of videos because it could not be read.
+type QuarantinedChapter struct {
+	Path   string
+	Reason string
+	// Whether the recording was likely never closed, e.g. the battery died:
+	// the file has no moov box, but the camera wrote the low resolution proxy
+	// along with it. GoPro cameras repair those (SOS) when the card is put
+	// back in and the camera turned on.
+	Unclosed bool
+}
+
+// Chapters skipped while discovering videos, reported at the end of the
+// discovery so that one corrupt file does not abort the whole run.
+type Quarantine struct {
+	mu       sync.Mutex
+	chapters []QuarantinedChapter
+}
+
+var quarantine Quarantine
+
+// Returned for chapter files which can be read but hold no video ffprobe
+// understands.
+var errCorruptChapter = errors.New("corrupt chapter")
+
+// Reads the start of a file, failing if it cannot be read at all, e.g.
+// because of too many open files or I/O errors.
+func checkReadable(fileName string) error {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Read(make([]byte, 512)); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+// Records a chapter which could not be read.
+func (q *Quarantine) add(dirPath, fileName, reason string) {
+	chapter := QuarantinedChapter{
+		Path:     filepath.Join(inputSnapshots.live(dirPath), fileName),
+		Reason:   reason,
+		Unclosed: lowResFile(dirPath, fileName, ExtLRV) != "" && !hasMoovBox(filepath.Join(dirPath, fileName)),
+	}
+	warnf(">>> Skipping %s: %s", chapter.Path, reason)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.chapters = append(q.chapters, chapter)
+}
+
+// Logs the chapters skipped, suggesting repairs where possible.
+func (q *Quarantine) report() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.chapters) == 0 {
+		return
+	}
+	var lines []string
+	unclosed := false
+	for _, chapter := range q.chapters {
+		line := chapter.Path + ": " + chapter.Reason
+		if chapter.Unclosed {
+			line += " (unclosed recording)"
+			unclosed = true
+		}
+		lines = append(lines, line)
+	}
+	warnf(">>> Skipped %d unreadable chapters:\n%s", len(q.chapters), strings.Join(lines, "\n"))
+	if unclosed {
+		warnf(">>> Unclosed recordings can be repaired by the camera: put the card back in and turn the camera on (GoPro SOS)")
+	}
+}
+
+// Moves the skipped chapters, with their proxies and thumbnails, to dir,
+// under their paths relative to the input directories. Unclosed recordings
+// are left in place for the camera to repair, as are chapters which cannot be
+// moved, with a warning.
+func (q *Quarantine) move(dir string, roots []InputRoot) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	moved := 0
+	for _, chapter := range q.chapters {
+		if chapter.Unclosed {
+			continue
+		}
+		dirPath, fileName := filepath.Split(chapter.Path)
+		rel, err := relativeToRoot(inputBase(roots, dirPath), dirPath)
+		if err != nil {
+			rel = filepath.Base(dirPath)
+		}
+		destDir := filepath.Join(dir, rel)
+		if err := os.MkdirAll(destDir, 0755); err != nil {
+			warnf(">>> Could not quarantine %s: %v", chapter.Path, err)
+			continue
+		}
+		// The proxy and thumbnail are looked up before the chapter is moved.
+		var lowResFiles []string
+		for _, ext := range []string{ExtLRV, ExtTHM} {
+			if lowRes := lowResFile(dirPath, fileName, ext); lowRes != "" {
+				lowResFiles = append(lowResFiles, lowRes)
+			}
+		}
+		if err := quarantineFile(chapter.Path, destDir); err != nil {
+			warnf(">>> Could not quarantine %s: %v", chapter.Path, err)
+			continue
+		}
+		moved++
+		for _, lowRes := range lowResFiles {
+			if err := quarantineFile(lowRes, destDir); err != nil {
+				warnf(">>> Could not quarantine %s: %v", lowRes, err)
+			}
+		}
+	}
+	if moved > 0 {
+		log.Printf(">>> Moved %d unreadable chapters to %s", moved, dir)
+	}
+}
+
+// Moves a file to dir, without overwriting.
+func quarantineFile(fileName, dir string) error {
+	dest := filepath.Join(dir, filepath.Base(fileName))
+	if _, err := os.Stat(dest); err == nil {
+		return fmt.Errorf("%s already exists", dest)
+	}
+	return os.Rename(fileName, dest)
+}
+
+// Whether an MP4 file has a moov box, which cameras write when a recording
+// is stopped.
+func hasMoovBox(fileName string) bool {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	boxes, err := readMP4Boxes(f, 0, info.Size())
+	if err != nil {
+		return false
+	}
+	_, ok := findMP4Box(boxes, "moov")
+	return ok
+}
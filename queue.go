@@ -0,0 +1,152 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Queue priority rules, applied in the configured order after manual bumps.
+const (
+	// Videos recorded most recently first.
+	PriorityNewest = "newest"
+	// Videos from folders configured as public first.
+	PriorityPublic = "public"
+	// Smallest videos first.
+	PrioritySmallest = "smallest"
+)
+
+var queuePriorityRules = []string{PriorityNewest, PriorityPublic, PrioritySmallest}
+
+// Returns the time at which the first chapter of a video was recorded.
+func (v Video) startTime() time.Time {
+	if len(v.Chapters) == 0 {
+		return time.Time{}
+	}
+	return v.Chapters[0].CreateTime
+}
+
+// Determines which of two queue entries should be processed first.
+func compareQueueEntries(a, b *VideoState, rules []string) bool {
+	if a.Priority != b.Priority {
+		return a.Priority > b.Priority
+	}
+	for _, rule := range rules {
+		switch rule {
+		case PriorityNewest:
+			aTime, bTime := a.Video.startTime(), b.Video.startTime()
+			if !aTime.Equal(bTime) {
+				return aTime.After(bTime)
+			}
+		case PriorityPublic:
+			aPublic, bPublic := a.Video.Privacy == "public", b.Video.Privacy == "public"
+			if aPublic != bPublic {
+				return aPublic
+			}
+		case PrioritySmallest:
+			aSize, bSize := a.Video.size(), b.Video.size()
+			if aSize != bSize {
+				return aSize < bSize
+			}
+		}
+	}
+	return a.Title < b.Title
+}
+
+// Returns the videos which still need to be rendered or uploaded, or their
+// title updated on YouTube, in the order in which they should be processed.
+func (s *State) queue(rules []string) []*VideoState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var entries []*VideoState
+	for _, entry := range s.Videos {
+		if entry.Status != StatusUploaded || entry.TitleOutdated {
+			entries = append(entries, entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return compareQueueEntries(entries[i], entries[j], rules)
+	})
+	return entries
+}
+
+// Moves a video to the front of the queue.
+func (s *State) bump(title string) error {
+	entry, ok := s.Videos[title]
+	if !ok || entry.Status == StatusUploaded {
+		return fmt.Errorf("%q is not queued", title)
+	}
+	top := 0
+	for _, other := range s.Videos {
+		if other.Priority > top {
+			top = other.Priority
+		}
+	}
+	entry.Priority = top + 1
+	return nil
+}
+
+// Prints the work queue, optionally bumping a video to its front.
+func runQueueCommand(args []string) {
+	flags := flag.NewFlagSet("queue", flag.ExitOnError)
+	outputDir := flags.String("output_dir", "", "Directory in which rendered video files are output.")
+	readConfig := configFlags(flags)
+	bump := flags.String("bump", "", "Title of a video to move to the front of the queue.")
+	setupLogging := logFlags(flags)
+	flags.Parse(args)
+	setupLogging()
+	if *outputDir == "" {
+		fatalf("--output_dir cannot be empty")
+	}
+
+	config, err := readConfig()
+	if err != nil {
+		fatal(err)
+	}
+	state, err := loadState(*outputDir)
+	if err != nil {
+		fatal(err)
+	}
+	_, variants, err := listRenderedVideos(*outputDir, config.variantNames())
+	if err != nil {
+		fatal(err)
+	}
+	state.setVariants(variants)
+	if *bump != "" {
+		if err := state.bump(*bump); err != nil {
+			fatal(err)
+		}
+		if err := state.save(); err != nil {
+			fatal(err)
+		}
+	}
+
+	if config.MonthlyDataCap != "" {
+		limit, _ := parseByteSize(config.MonthlyDataCap)
+		data := &DataTracker{state: state, limit: limit}
+		status := "uploading"
+		if data.used() >= limit {
+			status = "paused until next month"
+		}
+		fmt.Printf("Data cap: %.1fG of %s used this month, %s\n\n",
+			float64(data.used())/(1<<30), config.MonthlyDataCap, status)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "#\tSTATUS\tPRIORITY\tPRIVACY\tSIZE\tRECORDED\tVARIANTS\tTITLE")
+	for ix, entry := range state.queue(config.QueuePriority) {
+		variants := "-"
+		if len(entry.Variants) > 0 {
+			variants = strings.Join(entry.Variants, ",")
+		}
+		fmt.Fprintf(w, "%d\t%s\t%d\t%s\t%.1fG\t%s\t%s\t%s\n",
+			ix+1, entry.Status, entry.Priority, entry.Video.Privacy,
+			float64(entry.Video.size())/(1<<30),
+			localTime(entry.Video.startTime()).Format("2006-01-02 15:04"), variants, entry.Title)
+	}
+	w.Flush()
+}
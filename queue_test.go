@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Returns a queue entry for a video recorded at the given time, of the given
+// size.
+func queueEntry(title string, priority int, recorded time.Time, size int64, privacy string) *VideoState {
+	return &VideoState{
+		Title:    title,
+		Priority: priority,
+		Video: Video{
+			Title:    title,
+			Privacy:  privacy,
+			Chapters: []Chapter{{CreateTime: recorded, Size: size}},
+		},
+	}
+}
+
+func TestCompareQueueEntries(t *testing.T) {
+	day := func(d int) time.Time {
+		return time.Date(2020, 7, d, 10, 0, 0, 0, time.UTC)
+	}
+	for _, test := range []struct {
+		name  string
+		rules []string
+		a, b  *VideoState
+		want  bool
+	}{
+		{"bumped first", queuePriorityRules, queueEntry("b", 1, day(1), 0, ""), queueEntry("a", 0, day(2), 0, ""), true},
+		{"bumped higher first", queuePriorityRules, queueEntry("a", 1, day(2), 0, ""), queueEntry("b", 2, day(1), 0, ""), false},
+		{"newest first", queuePriorityRules, queueEntry("b", 0, day(2), 0, ""), queueEntry("a", 0, day(1), 0, ""), true},
+		{"oldest last", queuePriorityRules, queueEntry("a", 0, day(1), 0, ""), queueEntry("b", 0, day(2), 0, ""), false},
+		{"same date, public first", queuePriorityRules, queueEntry("b", 0, day(1), 0, "public"), queueEntry("a", 0, day(1), 0, "private"), true},
+		{"same date and privacy, smallest first", queuePriorityRules, queueEntry("b", 0, day(1), 10, ""), queueEntry("a", 0, day(1), 20, ""), true},
+		{"ties by title", queuePriorityRules, queueEntry("a", 0, day(1), 10, ""), queueEntry("b", 0, day(1), 10, ""), true},
+		{"ties by title, reversed", queuePriorityRules, queueEntry("b", 0, day(1), 10, ""), queueEntry("a", 0, day(1), 10, ""), false},
+		{"same entry", queuePriorityRules, queueEntry("a", 0, day(1), 10, ""), queueEntry("a", 0, day(1), 10, ""), false},
+		{"rules in order", []string{PrioritySmallest, PriorityNewest}, queueEntry("a", 0, day(1), 10, ""), queueEntry("b", 0, day(2), 20, ""), true},
+		{"no rules", nil, queueEntry("a", 0, day(1), 20, ""), queueEntry("b", 0, day(2), 10, ""), true},
+		{"no chapters", queuePriorityRules, &VideoState{Title: "b"}, queueEntry("a", 0, day(1), 0, ""), false},
+	} {
+		if got := compareQueueEntries(test.a, test.b, test.rules); got != test.want {
+			t.Errorf("%s: compareQueueEntries(%s, %s) = %v, want %v", test.name, test.a.Title, test.b.Title, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// Estimated quota cost of the API calls made by the uploader.
+// https://developers.google.com/youtube/v3/determine_quota_cost
+const (
+	QuotaCostList    = 1
+	QuotaCostInsert  = 1600
+	QuotaCostCaption = 400
+	QuotaCostUpdate  = 50
+)
+
+// Default daily quota of a Google API project.
+const DefaultDailyQuota = 10000
+
+var errQuotaExceeded = errors.New("YouTube API quota exhausted for today")
+
+// Quota units used on a given day. The quota resets at midnight Pacific Time.
+type Quota struct {
+	Day  string `json:"day"`
+	Used int    `json:"used"`
+}
+
+// Returns the quota day a time belongs to.
+func quotaDay(t time.Time) string {
+	location, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		location = time.FixedZone("PST", -8*60*60)
+	}
+	return t.In(location).Format("2006-01-02")
+}
+
+// Returns the number of quota units still available today.
+func (q *Quota) remaining(limit int) int {
+	if q.Day != quotaDay(time.Now()) {
+		return limit
+	}
+	return limit - q.Used
+}
+
+// Records the cost of an API call, failing if it would exceed the quota.
+func (q *Quota) spend(limit, cost int) error {
+	if day := quotaDay(time.Now()); q.Day != day {
+		q.Day = day
+		q.Used = 0
+	}
+	if q.Used+cost > limit {
+		return errQuotaExceeded
+	}
+	q.Used += cost
+	return nil
+}
+
+// Marks today's quota as fully used, e.g. when the API reports it exhausted
+// earlier than estimated.
+func (q *Quota) exhaust(limit int) {
+	q.Day = quotaDay(time.Now())
+	q.Used = limit
+}
+
+// Quota tracker backed by the state database, safe for concurrent use.
+type QuotaTracker struct {
+	state *State
+	limit int
+}
+
+// Records the cost of an API call, failing if it would exceed the quota.
+func (t *QuotaTracker) spend(cost int) error {
+	t.state.mu.Lock()
+	defer t.state.mu.Unlock()
+	return t.state.Quota.spend(t.limit, cost)
+}
+
+// Marks today's quota as fully used.
+func (t *QuotaTracker) exhaust() {
+	t.state.mu.Lock()
+	defer t.state.mu.Unlock()
+	t.state.Quota.exhaust(t.limit)
+}
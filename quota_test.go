@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestQuotaSpend(t *testing.T) {
+	today := quotaDay(time.Now())
+	earlier := quotaDay(time.Now().Add(-48 * time.Hour))
+	for _, test := range []struct {
+		name     string
+		quota    Quota
+		cost     int
+		wantErr  bool
+		wantUsed int
+	}{
+		{"fresh", Quota{}, QuotaCostInsert, false, QuotaCostInsert},
+		{"adds up", Quota{Day: today, Used: 100}, QuotaCostList, false, 101},
+		{"exactly the limit", Quota{Day: today, Used: 9000}, 1000, false, 10000},
+		{"over the limit", Quota{Day: today, Used: 9000}, QuotaCostInsert, true, 9000},
+		{"exhausted", Quota{Day: today, Used: 10000}, QuotaCostList, true, 10000},
+		{"reset on a new day", Quota{Day: earlier, Used: 10000}, QuotaCostInsert, false, QuotaCostInsert},
+		{"over the limit on its own", Quota{}, 20000, true, 0},
+	} {
+		quota := test.quota
+		err := quota.spend(DefaultDailyQuota, test.cost)
+		if test.wantErr && !errors.Is(err, errQuotaExceeded) || !test.wantErr && err != nil {
+			t.Errorf("%s: spend() error = %v, want error %v", test.name, err, test.wantErr)
+		}
+		if quota.Used != test.wantUsed || quota.Day != today {
+			t.Errorf("%s: quota after spend() = %+v, want %d used on %s", test.name, quota, test.wantUsed, today)
+		}
+		if remaining := quota.remaining(DefaultDailyQuota); remaining != DefaultDailyQuota-test.wantUsed {
+			t.Errorf("%s: remaining() = %d, want %d", test.name, remaining, DefaultDailyQuota-test.wantUsed)
+		}
+	}
+}
+
+func TestQuotaExhaust(t *testing.T) {
+	quota := Quota{Day: quotaDay(time.Now()), Used: 10}
+	quota.exhaust(DefaultDailyQuota)
+	if err := quota.spend(DefaultDailyQuota, QuotaCostList); !errors.Is(err, errQuotaExceeded) {
+		t.Errorf("spend() after exhaust() = %v, want %v", err, errQuotaExceeded)
+	}
+	if remaining := quota.remaining(DefaultDailyQuota); remaining != 0 {
+		t.Errorf("remaining() after exhaust() = %d, want 0", remaining)
+	}
+	earlier := Quota{Day: quotaDay(time.Now().Add(-48 * time.Hour)), Used: DefaultDailyQuota}
+	if remaining := earlier.remaining(DefaultDailyQuota); remaining != DefaultDailyQuota {
+		t.Errorf("remaining() of an earlier day's quota = %d, want %d", remaining, DefaultDailyQuota)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import "time"
+
+// Places a rendered video is uploaded to. YouTube is the only one for now.
+const CopyYouTube = "youtube"
+
+// A copy of a rendered video held by an upload destination.
+type VideoCopy struct {
+	// One of CopyYouTube.
+	Destination string `json:"destination"`
+	ID          string `json:"id"`
+	// SHA-256 of the data received by the destination, verified against the
+	// render while uploading. Empty if it could not be verified, e.g. for
+	// variants.
+	Checksum string    `json:"sha256,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// Records the copy held by a destination, replacing the previous one. Must be
+// called within State.update.
+func (e *VideoState) setCopy(c VideoCopy) {
+	for ix := range e.Copies {
+		if e.Copies[ix].Destination == c.Destination {
+			e.Copies[ix] = c
+			return
+		}
+	}
+	e.Copies = append(e.Copies, c)
+}
+
+// Returns the destinations holding a copy of the current render of a video,
+// verified by checksum. YouTube copies only count once processed, since
+// YouTube may still reject them.
+func (e *VideoState) verifiedCopies() []string {
+	var results []string
+	for _, c := range e.Copies {
+		if e.Checksum == "" || c.Checksum != e.Checksum {
+			continue
+		}
+		if c.Destination == CopyYouTube && (e.Status != StatusUploaded || c.ID != e.VideoID) {
+			continue
+		}
+		results = append(results, c.Destination)
+	}
+	return results
+}
+
+// Whether the chapters of a video may be deleted, or moved off to cold
+// storage: only once enough destinations hold a verified copy of it.
+func (e *VideoState) sourcesDeletable(minCopies int) bool {
+	return len(e.verifiedCopies()) >= minCopies
+}
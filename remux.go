@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// Regenerates the container metadata of an already rendered video: chapters,
+// creation time and faststart. Streams are copied without re-concatenating
+// chapters. Returns the SHA-256 of the remuxed file.
+func remuxVideo(ctx context.Context, runner Runner, video Video, outputDir string, config *Config) (string, error) {
+	tmpDir, err := ioutil.TempDir("", "gopro-uploader")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	metadataFname := filepath.Join(tmpDir, "chapters.txt")
+	if err := writeMetadata(video, metadataFname); err != nil {
+		return "", err
+	}
+	outputFname := filepath.Join(outputDir, video.Title+VideoExt)
+	tmpFname := filepath.Join(outputDir, "."+video.Title+".remux"+VideoExt)
+	log.Printf(">>> Remuxing %s", outputFname)
+	cmd := exec.CommandContext(ctx, binaryPath("ffmpeg"), "-v", "warning",
+		"-i", outputFname,
+		"-i", metadataFname,
+		"-map_metadata", "1",
+		"-map_chapters", "1",
+		// Keep chapter announcement tracks.
+		"-map", "0",
+		"-c", "copy")
+	cmd.Args = append(cmd.Args, config.embeddedMetadataArgs(ctx, video)...)
+	// HiLight tags are dropped by ffmpeg, and written back along with the
+	// moov box, see renderVideo.
+	var hiLights []time.Duration
+	if runner.Executes() {
+		if hiLights, err = readHiLights(outputFname); err != nil {
+			warnf(">>> Could not read HiLight tags of %s: %v", outputFname, err)
+		}
+	}
+	movflags := "+faststart"
+	if len(hiLights) > 0 {
+		movflags = ""
+	}
+	cmd.Args = append(cmd.Args,
+		"-movflags", config.movflags(movflags),
+		"-progress", "pipe:1", "-nostats",
+		tmpFname, "-y")
+	cmd.Stderr = os.Stderr
+	if err := progressBars.run(runner, cmd, video.Title, video.renderedDuration()); err != nil {
+		os.Remove(tmpFname)
+		return "", err
+	}
+	if len(hiLights) > 0 {
+		if err := writeHiLights(tmpFname, hiLights, true); err != nil {
+			warnf(">>> Could not write HiLight tags of %s: %v", outputFname, err)
+			if err := relocateMoov(ctx, runner, tmpFname, config.movflags("+faststart")); err != nil {
+				return "", err
+			}
+		}
+	}
+	checksum, err := fileChecksum(tmpFname)
+	if err != nil {
+		return "", err
+	}
+	return checksum, os.Rename(tmpFname, outputFname)
+}
+
+// Moves the moov box of a video before its media data, as a separate pass,
+// with the given movflags.
+func relocateMoov(ctx context.Context, runner Runner, fileName, movflags string) error {
+	tmpFname := filepath.Join(filepath.Dir(fileName), "."+filepath.Base(fileName)+".faststart"+VideoExt)
+	log.Printf(">>> Relocating moov of %s", fileName)
+	cmd := exec.CommandContext(ctx, binaryPath("ffmpeg"), "-v", "warning",
+		"-i", fileName,
+		"-map", "0",
+		"-c", "copy",
+		"-movflags", movflags,
+		tmpFname, "-y")
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := runCommand(runner, cmd); err != nil {
+		os.Remove(tmpFname)
+		return err
+	}
+	return renameOutput(runner, tmpFname, fileName)
+}
+
+// Remuxes rendered videos, optionally restricted to the given titles.
+func runRemuxCommand(args []string) {
+	flags := flag.NewFlagSet("remux", flag.ExitOnError)
+	inputDir := flags.String("input_dir", "", "Directory to traverse for video files.")
+	outputDir := flags.String("output_dir", "", "Directory containing the rendered video files.")
+	prefix := flags.String("prefix", "", "Prefix to use in all video titles.")
+	readConfig := configFlags(flags)
+	lockTimeout := flags.Duration("lock_timeout", 0, "How long to wait for another run using the output directory to finish.")
+	setupLogging := logFlags(flags)
+	flags.Parse(args)
+	setupLogging()
+	if *inputDir == "" {
+		fatalf("--input_dir cannot be empty")
+	}
+	if *outputDir == "" {
+		fatalf("--output_dir cannot be empty")
+	}
+	if *prefix == "" {
+		fatalf("--prefix cannot be empty")
+	}
+	ctx, stop := interruptContext()
+	defer stop()
+
+	config, err := readConfig()
+	if err != nil {
+		fatal(err)
+	}
+	checkDependencies("ffprobe", "ffmpeg")
+	release, err := acquireRunLock(*outputDir, *lockTimeout)
+	if err != nil {
+		fatal(err)
+	}
+	defer release()
+	state, err := loadState(*outputDir)
+	if err != nil {
+		fatal(err)
+	}
+	videos, err := discoverVideos(ctx, *inputDir, *prefix, config)
+	if err != nil {
+		fatal(err)
+	}
+	if _, err := retitleParts(state, videos, *outputDir, false); err != nil {
+		fatal(err)
+	}
+	titles, _, err := listRenderedVideos(*outputDir, config.variantNames())
+	if err != nil {
+		fatal(err)
+	}
+	for _, video := range videos {
+		if !contains(titles, video.Title) {
+			continue
+		}
+		if flags.NArg() > 0 && !contains(flags.Args(), video.Title) {
+			continue
+		}
+		checksum, err := remuxVideo(ctx, execRunner{}, video, *outputDir, config)
+		if err != nil {
+			fatal(err)
+		}
+		if entry, ok := state.Videos[video.Title]; ok {
+			if err := state.update(func() { entry.Checksum = checksum }); err != nil {
+				fatal(err)
+			}
+		}
+	}
+}
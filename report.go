@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Extracts a still of the first or last frame of a chapter. Frames are
+// decoded rather than seeked to, so the stills are frame accurate.
+func extractStill(ctx context.Context, fileName string, last bool, outputFname string) error {
+	var args []string
+	if last {
+		// Decode the last second and keep overwriting the output, which leaves
+		// the last frame.
+		args = []string{"-v", "error", "-sseof", "-1", "-i", fileName,
+			"-update", "1"}
+	} else {
+		args = []string{"-v", "error", "-i", fileName, "-frames:v", "1"}
+	}
+	args = append(args, "-vf", "scale=320:-2", "-q:v", "4", outputFname, "-y")
+	cmd := exec.CommandContext(ctx, binaryPath("ffmpeg"), args...)
+	cmd.Stderr = os.Stderr
+	return runCommand(execRunner{}, cmd)
+}
+
+// Extracts a still of the first or last frame of a chapter, from its
+// thumbnail or low resolution proxy if lowRes is set and there is one.
+func chapterStill(ctx context.Context, dirPath string, chapter Chapter, last, lowRes bool, outputFname string) error {
+	fileName := filepath.Join(dirPath, chapter.FileName)
+	if lowRes && !last {
+		// The thumbnail is a JPEG of the first frame already.
+		if thm := lowResFile(dirPath, chapter.FileName, ExtTHM); thm != "" {
+			data, err := ioutil.ReadFile(thm)
+			if err != nil {
+				return err
+			}
+			return ioutil.WriteFile(outputFname, data, 0644)
+		}
+	}
+	if lowRes {
+		if lrv := lowResFile(dirPath, chapter.FileName, ExtLRV); lrv != "" {
+			fileName = lrv
+		}
+	}
+	return extractStill(ctx, fileName, last, outputFname)
+}
+
+type reportChapter struct {
+	Chapter
+	Start      string
+	FirstStill string
+	LastStill  string
+	// Whether the chapter was recorded before the previous one according to
+	// the camera, i.e. the camera clock was likely reset, see
+	// repairChapterTimes.
+	OutOfOrder bool
+}
+
+type reportVideo struct {
+	Title    string
+	Path     string
+	Duration time.Duration
+	// Other renders of the video present in the output directory.
+	Variants []string
+	Chapters []reportChapter
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>gopro-uploader report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+td, th { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+.out-of-order { background: #fdd; }
+img { display: block; width: 160px; }
+</style>
+</head>
+<body>
+<h1>gopro-uploader report</h1>
+<p>Generated {{ .Generated }}</p>
+{{ range .Videos }}
+<h2>{{ .Title }}</h2>
+<p>{{ .Path }} &middot; {{ .Duration }}{{ if .Variants }} &middot; variants: {{ range $ix, $v := .Variants }}{{ if $ix }}, {{ end }}{{ $v }}{{ end }}{{ end }}</p>
+<table>
+<tr><th>Start</th><th>Chapter</th><th>Recorded</th><th>Resolution</th><th>First frame</th><th>Last frame</th></tr>
+{{ range .Chapters }}
+<tr{{ if .OutOfOrder }} class="out-of-order" title="Recorded before the previous chapter{{ if not .CameraTime.IsZero }} according to the camera ({{ .CameraTime.Format "2006-01-02 15:04:05" }}), recording time estimated{{ end }}"{{ end }}>
+<td>{{ .Start }}</td>
+<td>{{ .FileName }}</td>
+<td>{{ .CreateTime.Format "2006-01-02 15:04:05" }}</td>
+<td>{{ .Resolution.Width }}x{{ .Resolution.Height }} @ {{ printf "%.2f" .Resolution.FrameRate }} {{ .Resolution.Codec }}</td>
+<td>{{ if .FirstStill }}<img src="{{ .FirstStill }}">{{ end }}</td>
+<td>{{ if .LastStill }}<img src="{{ .LastStill }}">{{ end }}</td>
+</tr>
+{{ end }}
+</table>
+{{ end }}
+</body>
+</html>
+`))
+
+// Writes an HTML report of the discovered videos, with stills of the first
+// and last frame of each chapter stored in a directory next to it. If lowRes
+// is set, stills come from the thumbnails and proxies of chapters, when
+// present, which is much faster on slow disks.
+func writeReport(ctx context.Context, fileName string, videos []Video, variants map[string][]string, lowRes bool) error {
+	stillsDir := strings.TrimSuffix(fileName, filepath.Ext(fileName)) + "_files"
+	if err := os.MkdirAll(stillsDir, os.ModePerm); err != nil {
+		return err
+	}
+
+	data := struct {
+		Generated string
+		Videos    []reportVideo
+	}{Generated: time.Now().Format(time.RFC1123)}
+	for vix, video := range videos {
+		report := reportVideo{
+			Title:    video.Title,
+			Path:     video.Path,
+			Duration: video.duration().Round(time.Second),
+			Variants: variants[video.Title],
+		}
+		var startTime time.Duration
+		for cix, chapter := range video.Chapters {
+			rc := reportChapter{
+				Chapter:    chapter,
+				Start:      fmtDurationForYouTube(startTime),
+				OutOfOrder: !chapter.CameraTime.IsZero() || cix > 0 && chapter.CreateTime.Before(video.Chapters[cix-1].CreateTime),
+			}
+			rc.CreateTime = localTime(chapter.CreateTime)
+			rc.CameraTime = localTime(chapter.CameraTime)
+			for _, last := range []bool{false, true} {
+				name := fmt.Sprintf("%03d_%03d_first.jpg", vix, cix)
+				if last {
+					name = fmt.Sprintf("%03d_%03d_last.jpg", vix, cix)
+				}
+				if err := chapterStill(ctx, video.Path, chapter, last, lowRes, filepath.Join(stillsDir, name)); err != nil {
+					warnf(">>> Could not extract still of %s: %v", chapter.FileName, err)
+					continue
+				}
+				still := filepath.ToSlash(filepath.Join(filepath.Base(stillsDir), name))
+				if last {
+					rc.LastStill = still
+				} else {
+					rc.FirstStill = still
+				}
+			}
+			report.Chapters = append(report.Chapters, rc)
+			startTime += chapter.Duration
+		}
+		data.Videos = append(data.Videos, report)
+	}
+
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return reportTemplate.Execute(f, data)
+}
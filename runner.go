@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Runs the commands rendering videos, e.g. ffmpeg, so that they can be
+// printed instead of run, or faked in tests. Passed along to the functions
+// rendering, while probes whose output is read always run, see runProbe.
+type Runner interface {
+	Run(cmd *exec.Cmd) error
+	// Whether commands actually run, i.e. their output files exist once Run
+	// returns.
+	Executes() bool
+	// Creates a directory for the files commands read, e.g. concat lists, and
+	// returns a function removing it once the commands ran.
+	TempDir() (string, func(), error)
+}
+
+// Runs commands for real.
+type execRunner struct{}
+
+func (execRunner) Run(cmd *exec.Cmd) error {
+	return cmd.Run()
+}
+
+func (execRunner) Executes() bool {
+	return true
+}
+
+func (execRunner) TempDir() (string, func(), error) {
+	dir, err := ioutil.TempDir("", "gopro-uploader")
+	if err != nil {
+		return "", nil, err
+	}
+	return dir, func() { os.RemoveAll(dir) }, nil
+}
+
+// Prints commands, one per line as they would be typed in a shell, instead
+// of running them.
+type printRunner struct {
+	w io.Writer
+	// If set, the files commands read are kept in subdirectories of it, so
+	// that the printed commands can be run later.
+	filesDir string
+}
+
+func (r printRunner) Run(cmd *exec.Cmd) error {
+	_, err := fmt.Fprintln(r.w, shellCommand(cmd.Args))
+	return err
+}
+
+func (printRunner) Executes() bool {
+	return false
+}
+
+func (r printRunner) TempDir() (string, func(), error) {
+	if r.filesDir == "" {
+		return execRunner{}.TempDir()
+	}
+	dir, err := ioutil.TempDir(r.filesDir, "render")
+	return dir, func() {}, err
+}
+
+// Runs a command whose output is read, e.g. ffprobe, for real even when
+// other commands are only printed.
+func runProbe(cmd *exec.Cmd) error {
+	return runCommand(execRunner{}, cmd)
+}
+
+// Renames the output of a command, or prints the command doing so if
+// commands are only printed.
+func renameOutput(runner Runner, from, to string) error {
+	if !runner.Executes() {
+		return runCommand(runner, exec.Command("mv", from, to))
+	}
+	return os.Rename(from, to)
+}
+
+// Writes a shell script running the commands printed by the returned runner,
+// keeping the files they read in a directory next to it. The returned
+// function closes the script.
+func emitScript(fileName string) (printRunner, func() error, error) {
+	filesDir := strings.TrimSuffix(fileName, filepath.Ext(fileName)) + "_files"
+	if err := os.MkdirAll(filesDir, os.ModePerm); err != nil {
+		return printRunner{}, nil, err
+	}
+	f, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0755)
+	if err != nil {
+		return printRunner{}, nil, err
+	}
+	// Paths of commands may be relative to the working directory.
+	wd, err := os.Getwd()
+	if err != nil {
+		f.Close()
+		return printRunner{}, nil, err
+	}
+	if _, err := fmt.Fprintf(f, "#!/bin/sh\n# Generated by gopro-uploader on %s.\nset -e\ncd %s\n",
+		time.Now().Format(time.RFC1123), shellCommand([]string{wd})); err != nil {
+		f.Close()
+		return printRunner{}, nil, err
+	}
+	return printRunner{w: f, filesDir: filesDir}, f.Close, nil
+}
+
+// Arguments which need no quoting in a POSIX shell.
+var shellSafeRegex = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// Returns a command line a POSIX shell runs with the given arguments.
+func shellCommand(args []string) string {
+	quoted := make([]string, len(args))
+	for ix, arg := range args {
+		if shellSafeRegex.MatchString(arg) {
+			quoted[ix] = arg
+		} else {
+			quoted[ix] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+		}
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Records commands instead of running them. If executes, ffmpeg renders are
+// faked by writing output to the file following "-f mp4".
+type fakeRunner struct {
+	commands [][]string
+	executes bool
+	output   []byte
+}
+
+func (r *fakeRunner) Run(cmd *exec.Cmd) error {
+	r.commands = append(r.commands, cmd.Args)
+	if !r.executes {
+		return nil
+	}
+	for ix := 0; ix+2 < len(cmd.Args); ix++ {
+		if cmd.Args[ix] == "-f" && cmd.Args[ix+1] == "mp4" {
+			return ioutil.WriteFile(cmd.Args[ix+2], r.output, 0644)
+		}
+	}
+	return nil
+}
+
+func (r *fakeRunner) Executes() bool {
+	return r.executes
+}
+
+func (r *fakeRunner) TempDir() (string, func(), error) {
+	return execRunner{}.TempDir()
+}
+
+// Returns an MP4 file with its moov box first, as rendered with faststart.
+func fastStartMP4() []byte {
+	var data []byte
+	for _, box := range []string{"ftyp", "moov", "mdat"} {
+		data = append(data, mp4Header(box, 4)...)
+		data = append(data, "data"...)
+	}
+	return data
+}
+
+func testVideo(dir string) Video {
+	return Video{
+		Title: "[GoPro] Trip # Day 1",
+		Path:  dir,
+		Chapters: []Chapter{
+			{FileName: "GX010001.MP4", Duration: time.Minute},
+			{FileName: "GX020001.MP4", Duration: 30 * time.Second},
+		},
+	}
+}
+
+func TestRenderVideoPrintsCommands(t *testing.T) {
+	outputDir := t.TempDir()
+	runner := &fakeRunner{}
+	video := testVideo("/footage/Trip/Day 1")
+	checksum, err := renderVideo(context.Background(), runner, video, outputDir, defaultConfig(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checksum != "" {
+		t.Errorf("checksum = %q, want none for printed commands", checksum)
+	}
+	if len(runner.commands) != 2 {
+		t.Fatalf("ran %d commands, want ffmpeg and mv: %v", len(runner.commands), runner.commands)
+	}
+	ffmpeg := strings.Join(runner.commands[0], " ")
+	tmpFname := filepath.Join(outputDir, "."+video.Title+".tmp"+VideoExt)
+	for _, want := range []string{"-f concat", "-c copy", "-movflags +faststart", "-f mp4 " + tmpFname} {
+		if !strings.Contains(ffmpeg, want) {
+			t.Errorf("ffmpeg command %q does not contain %q", ffmpeg, want)
+		}
+	}
+	outputFname := filepath.Join(outputDir, video.Title+VideoExt)
+	if got, want := runner.commands[1], []string{"mv", tmpFname, outputFname}; strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("rename command = %v, want %v", got, want)
+	}
+	if _, err := os.Stat(outputFname); !os.IsNotExist(err) {
+		t.Errorf("%s was written by a dry run", outputFname)
+	}
+}
+
+func TestRenderVideoHashesRender(t *testing.T) {
+	outputDir := t.TempDir()
+	runner := &fakeRunner{executes: true, output: fastStartMP4()}
+	video := testVideo(t.TempDir())
+	checksum, err := renderVideo(context.Background(), runner, video, outputDir, defaultConfig(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runner.commands) != 1 {
+		t.Errorf("ran %d commands, want 1: %v", len(runner.commands), runner.commands)
+	}
+	sum := sha256.Sum256(runner.output)
+	if want := hex.EncodeToString(sum[:]); checksum != want {
+		t.Errorf("checksum = %s, want %s", checksum, want)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(outputDir, video.Title+VideoExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != string(runner.output) {
+		t.Errorf("rendered file was not renamed into place")
+	}
+}
+
+func TestRenderVideoChecksFastStart(t *testing.T) {
+	outputDir := t.TempDir()
+	// The moov box follows the media data, faststart did not take effect.
+	output := append(mp4Header("mdat", 4), "data"...)
+	output = append(output, append(mp4Header("moov", 4), "data"...)...)
+	runner := &fakeRunner{executes: true, output: output}
+	video := testVideo(t.TempDir())
+	if _, err := renderVideo(context.Background(), runner, video, outputDir, defaultConfig(), nil); err == nil {
+		t.Errorf("renderVideo succeeded without faststart")
+	}
+}
+
+func TestShellCommand(t *testing.T) {
+	for _, test := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"ffmpeg", "-i", "input.txt"}, "ffmpeg -i input.txt"},
+		{[]string{"mv", "a b.mp4", "c.mp4"}, "mv 'a b.mp4' c.mp4"},
+		{[]string{"echo", "it's"}, `echo 'it'\''s'`},
+		{[]string{"ffmpeg", "-filter", "[0:v]scale=1920:-2[v]"}, "ffmpeg -filter '[0:v]scale=1920:-2[v]'"},
+		{[]string{"ls", ""}, "ls ''"},
+		{[]string{"cp", "/a/[GoPro] Trip # Day 1.mp4"}, "cp '/a/[GoPro] Trip # Day 1.mp4'"},
+	} {
+		if got := shellCommand(test.args); got != test.want {
+			t.Errorf("shellCommand(%q) = %s, want %s", test.args, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Limits of YouTube video metadata: titles are counted in characters,
+// descriptions in bytes.
+const (
+	maxTitleLength       = 100
+	maxDescriptionLength = 5000
+)
+
+// YouTube only turns description timestamps into chapters if the first is
+// 0:00, there are at least 3 and each lasts at least this long.
+const minChapterMarkerDuration = 10 * time.Second
+
+// A timestamp of the chapter list of a description, covering one or more
+// chapter files.
+type ChapterMarker struct {
+	Start    time.Duration
+	Duration time.Duration
+	Chapters []Chapter
+}
+
+// Groups the chapter files of a video into markers lasting at least
+// minChapterMarkerDuration: a short chapter is merged with the next one, or
+// with the previous one if it is the last. The first marker starts at 0:00,
+// covering the intro if any, and the others where the previous chapter ends,
+// covering their title card if any.
+func chapterMarkers(video Video) []ChapterMarker {
+	var markers []ChapterMarker
+	var start time.Duration
+	offsets := video.chapterOffsets()
+	for ix, chapter := range video.Chapters {
+		if n := len(markers); n == 0 || markers[n-1].Duration >= minChapterMarkerDuration {
+			markers = append(markers, ChapterMarker{Start: start})
+		}
+		marker := &markers[len(markers)-1]
+		start = offsets[ix] + video.timeline(chapter.Duration)
+		marker.Duration = start - marker.Start
+		marker.Chapters = append(marker.Chapters, chapter)
+	}
+	if n := len(markers); n > 1 && markers[n-1].Duration < minChapterMarkerDuration {
+		markers[n-2].Duration += markers[n-1].Duration
+		markers[n-2].Chapters = append(markers[n-2].Chapters, markers[n-1].Chapters...)
+		markers = markers[:n-1]
+	}
+	return markers
+}
+
+// Lines of the chapter list of a description, e.g. "0:12:34 | GX010042.MP4".
+var timestampLineRegex = regexp.MustCompile(`^\d+:\d{2}(:\d{2})?\b`)
+
+// Removes the characters YouTube rejects in titles and descriptions.
+func stripInvalidCharacters(text string, keepNewlines bool) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '<' || r == '>':
+			return -1
+		case r == '\n' && keepNewlines:
+			return r
+		case unicode.IsControl(r):
+			return ' '
+		}
+		return r
+	}, text)
+}
+
+// Returns a title YouTube accepts: without '<' or '>', on one line, and cut at
+// a word boundary if longer than maxTitleLength characters.
+func youtubeTitle(title string) string {
+	title = strings.Join(strings.Fields(stripInvalidCharacters(title, false)), " ")
+	if utf8.RuneCountInString(title) <= maxTitleLength {
+		return title
+	}
+	cut := string([]rune(title)[:maxTitleLength-1])
+	if ix := strings.LastIndex(cut, " "); ix > len(cut)/2 {
+		cut = cut[:ix]
+	}
+	return strings.TrimRight(cut, " #-") + "…"
+}
+
+// Returns a description YouTube accepts: without '<' or '>', and at most
+// maxDescriptionLength bytes. Other lines are dropped from the end first, so
+// that the chapter timestamps YouTube turns into chapters are kept; if they
+// alone are too long, the last chapters are dropped.
+func youtubeDescription(description string) string {
+	description = stripInvalidCharacters(description, true)
+	if len(description) <= maxDescriptionLength {
+		return description
+	}
+	const ellipsis = "…"
+	lines := strings.Split(description, "\n")
+	size := len(description) + len(ellipsis) + 1
+	for ix := len(lines) - 1; ix >= 0 && size > maxDescriptionLength; ix-- {
+		if !timestampLineRegex.MatchString(lines[ix]) {
+			size -= len(lines[ix]) + 1
+			lines = append(lines[:ix], lines[ix+1:]...)
+		}
+	}
+	for size > maxDescriptionLength && len(lines) > 0 {
+		size -= len(lines[len(lines)-1]) + 1
+		lines = lines[:len(lines)-1]
+	}
+	return strings.Join(append(lines, ellipsis), "\n")
+}
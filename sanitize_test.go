@@ -0,0 +1,112 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Returns chapters of the given durations.
+func chaptersOf(durations ...time.Duration) []Chapter {
+	var chapters []Chapter
+	for _, d := range durations {
+		chapters = append(chapters, Chapter{Duration: d})
+	}
+	return chapters
+}
+
+func TestChapterMarkers(t *testing.T) {
+	type marker struct {
+		Start, Duration time.Duration
+		Chapters        int
+	}
+	for _, test := range []struct {
+		name  string
+		video Video
+		want  []marker
+	}{
+		{name: "no chapters"},
+		{
+			name:  "one per chapter",
+			video: Video{Chapters: chaptersOf(time.Minute, time.Minute, time.Minute)},
+			want:  []marker{{0, time.Minute, 1}, {time.Minute, time.Minute, 1}, {2 * time.Minute, time.Minute, 1}},
+		},
+		{
+			name:  "short chapter merged with the next",
+			video: Video{Chapters: chaptersOf(time.Minute, 5*time.Second, time.Minute)},
+			want:  []marker{{0, time.Minute, 1}, {time.Minute, time.Minute + 5*time.Second, 2}},
+		},
+		{
+			name:  "short last chapter merged with the previous",
+			video: Video{Chapters: chaptersOf(time.Minute, time.Minute, 5*time.Second)},
+			want:  []marker{{0, time.Minute, 1}, {time.Minute, time.Minute + 5*time.Second, 2}},
+		},
+		{
+			name: "inserts",
+			video: Video{
+				Chapters: chaptersOf(time.Minute, time.Minute),
+				Inserts:  &InsertDurations{Intro: 5 * time.Second, Outro: 5 * time.Second, Card: 3 * time.Second},
+			},
+			want: []marker{{0, time.Minute + 5*time.Second, 1}, {time.Minute + 5*time.Second, time.Minute + 3*time.Second, 1}},
+		},
+		{
+			name: "hyperlapse",
+			video: Video{
+				Chapters:   chaptersOf(5*time.Minute, 5*time.Minute),
+				Hyperlapse: &Hyperlapse{Speed: 10},
+			},
+			want: []marker{{0, 30 * time.Second, 1}, {30 * time.Second, 30 * time.Second, 1}},
+		},
+	} {
+		var got []marker
+		for _, m := range chapterMarkers(test.video) {
+			got = append(got, marker{m.Start, m.Duration, len(m.Chapters)})
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: chapterMarkers() = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestYouTubeTitle(t *testing.T) {
+	for _, test := range []struct {
+		title, want string
+	}{
+		{"[GoPro] Trip # Day 1", "[GoPro] Trip # Day 1"},
+		{"<b>Trip</b>", "bTrip/b"},
+		{"Trip\n#  Day\t1 ", "Trip # Day 1"},
+		{strings.Repeat("é", maxTitleLength), strings.Repeat("é", maxTitleLength)},
+		{strings.Repeat("word ", 30), strings.Repeat("word ", 18) + "word…"},
+		{strings.Repeat("a", 150), strings.Repeat("a", maxTitleLength-1) + "…"},
+		{strings.Repeat("a", 60) + " # " + strings.Repeat("b", 60), strings.Repeat("a", 60) + "…"},
+	} {
+		if got := youtubeTitle(test.title); got != test.want {
+			t.Errorf("youtubeTitle(%q) = %q, want %q", test.title, got, test.want)
+		}
+	}
+}
+
+func TestYouTubeDescription(t *testing.T) {
+	timestamps := strings.Repeat("0:00:01 | GX010001.MP4\n", 1000)
+	for _, test := range []struct {
+		name, description, want string
+	}{
+		{"short", "Recorded <here>\n\n0:00 | GX010001.MP4", "Recorded here\n\n0:00 | GX010001.MP4"},
+		{"control characters", "a\tb\x00c", "a b c"},
+		{
+			"other lines dropped first",
+			"0:00 | A\n" + strings.Repeat("x", maxDescriptionLength) + "\n0:10 | B",
+			"0:00 | A\n0:10 | B\n…",
+		},
+		{"timestamps dropped last", strings.TrimSuffix(timestamps, "\n"), timestamps[:217*23] + "…"},
+	} {
+		got := youtubeDescription(test.description)
+		if got != test.want {
+			t.Errorf("%s: youtubeDescription() = %q, want %q", test.name, got, test.want)
+		}
+		if len(got) > maxDescriptionLength {
+			t.Errorf("%s: youtubeDescription() is %d bytes long", test.name, len(got))
+		}
+	}
+}
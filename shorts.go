@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// Subdirectory of the output directory in which Shorts are rendered.
+const ShortsDir = "shorts"
+
+// Length of Shorts when none is given, and the range allowed: YouTube only
+// treats vertical videos up to a minute long as Shorts.
+const (
+	DefaultShortDuration = 30 * time.Second
+	minShortDuration     = 15 * time.Second
+	maxShortDuration     = 60 * time.Second
+)
+
+// Size of Shorts, 9:16 portrait.
+const (
+	shortWidth  = 1080
+	shortHeight = 1920
+)
+
+// Returns the stretch of a video of the given length cut around a HiLight
+// tag. Tags are usually set right after the moment, so two thirds of the
+// Short come before it.
+func shortRange(at, duration, videoDuration time.Duration) (time.Duration, time.Duration) {
+	from := at - duration*2/3
+	if videoDuration > 0 && from+duration > videoDuration {
+		from = videoDuration - duration
+	}
+	if from < 0 {
+		from = 0
+	}
+	to := from + duration
+	if videoDuration > 0 && to > videoDuration {
+		to = videoDuration
+	}
+	return from, to
+}
+
+// Renders a Short from part of a rendered video, cropping the center of the
+// frame to portrait.
+func renderShort(ctx context.Context, inputFname, outputFname string, from, to time.Duration) error {
+	args := []string{"-v", "warning",
+		"-ss", fmt.Sprintf("%.3f", from.Seconds()),
+		"-i", inputFname,
+		"-t", fmt.Sprintf("%.3f", (to - from).Seconds()),
+		"-map", "0:v:0", "-map", "0:a:0?",
+		"-vf", fmt.Sprintf("crop=ih*9/16:ih,scale=%d:%d,setsar=1,format=yuv420p", shortWidth, shortHeight),
+		"-c:v", "libx264", "-crf", "20", "-preset", "medium",
+		"-c:a", "aac", "-b:a", "192k",
+		"-movflags", "+faststart",
+		outputFname, "-y", "-stats"}
+	cmd := exec.CommandContext(ctx, binaryPath("ffmpeg"), args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return runCommand(execRunner{}, cmd)
+}
+
+// Cuts a vertical Short around each HiLight tag of rendered videos,
+// optionally uploading them with a link to the full video.
+func runShortsCommand(args []string) {
+	flags := flag.NewFlagSet("shorts", flag.ExitOnError)
+	outputDir := flags.String("output_dir", "", "Directory containing the rendered video files.")
+	duration := flags.Duration("duration", DefaultShortDuration, "Length of each Short, from 15s to 60s.")
+	upload := flags.Bool("upload", false, "If true, uploads the Shorts to YouTube with the privacy of their video, linking to it once it is uploaded.")
+	readConfig := configFlags(flags)
+	setupLogging := logFlags(flags)
+	titles := parseInterspersed(flags, args)
+	setupLogging()
+	if len(titles) == 0 {
+		fatalf("Usage: shorts <video-title>... [--duration 30s] [--upload]")
+	}
+	if *outputDir == "" {
+		fatalf("--output_dir cannot be empty")
+	}
+	if *duration < minShortDuration || *duration > maxShortDuration {
+		fatalf("--duration must be from %s to %s", minShortDuration, maxShortDuration)
+	}
+	config, err := readConfig()
+	if err != nil {
+		fatal(err)
+	}
+	checkDependencies("ffmpeg")
+	ctx, stop := interruptContext()
+	defer stop()
+
+	state, err := loadState(*outputDir)
+	if err != nil {
+		fatal(err)
+	}
+	var yt *YouTube
+	if *upload {
+		if yt, err = connectYouTube(config, state); err != nil {
+			fatal(err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(*outputDir, ShortsDir), os.ModePerm); err != nil {
+		fatal(err)
+	}
+	for _, title := range titles {
+		entry, ok := state.Videos[title]
+		if !ok {
+			fatalf("Unknown video %q, it must have been rendered first", title)
+		}
+		video := entry.Video
+		if video.is360() {
+			warnf(">>> Skipping %s: Shorts cannot be cut from 360 videos", title)
+			continue
+		}
+		inputFname := renderedFile(*outputDir, title, config.uploadVariant(video))
+		if _, err := os.Stat(inputFname); err != nil {
+			fatal(err)
+		}
+		hiLights, err := videoHiLights(video)
+		if err != nil {
+			fatal(err)
+		}
+		if len(hiLights) == 0 {
+			log.Printf(">>> No HiLight tags in %s", title)
+			continue
+		}
+		for _, at := range hiLights {
+			from, to := shortRange(at, *duration, video.renderedDuration())
+			fileName := fmt.Sprintf("%s %s%s", title, fmtTimestampForFileName(at), VideoExt)
+			outputFname := filepath.Join(*outputDir, ShortsDir, fileName)
+			if _, err := os.Stat(outputFname); err != nil {
+				log.Printf(">>> Rendering Short %s", outputFname)
+				if err := renderShort(ctx, inputFname, outputFname, from, to); err != nil {
+					os.Remove(outputFname)
+					fatal(err)
+				}
+			}
+			if yt == nil {
+				continue
+			}
+			if id, ok := entry.Shorts[fileName]; ok {
+				log.Printf(">>> Short %s already uploaded as https://youtu.be/%s", fileName, id)
+				continue
+			}
+			// The hashtag is kept out of the title, where it could be cut off.
+			description := fmt.Sprintf("HiLight of %s at %s. #Shorts", title, fmtDurationForYouTube(at))
+			if entry.VideoID != "" {
+				description += fmt.Sprintf("\nFull video: https://youtu.be/%s?t=%d", entry.VideoID, int64(from.Seconds()))
+			}
+			log.Printf(">>> Uploading %s", outputFname)
+			result, err := yt.upload(ctx, outputFname, &YouTubeVideo{
+				Snippet: &YouTubeVideoSnippet{
+					Title:       youtubeTitle(fmt.Sprintf("%s (%s)", title, fmtDurationForYouTube(at))),
+					Description: youtubeDescription(description),
+				},
+				Status: &YouTubeVideoStatus{PrivacyStatus: video.Privacy},
+			}, "")
+			if err == nil {
+				err = state.update(func() {
+					if entry.Shorts == nil {
+						entry.Shorts = map[string]string{}
+					}
+					entry.Shorts[fileName] = result.ID
+				})
+			} else if saveErr := state.save(); saveErr != nil {
+				log.Print(saveErr)
+			}
+			if err != nil {
+				fatal(err)
+			}
+			log.Printf(">>> Uploaded https://youtu.be/%s", result.ID)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Name of the optional per-directory settings file.
+const SidecarFileName = ".gopro-uploader.json"
+
+// Labels attached to a chapter.
+type ChapterLabels struct {
+	// Language spoken in the chapter, e.g. "de" or "en-US".
+	Locale  string `json:"locale"`
+	Speaker string `json:"speaker"`
+}
+
+// Stretch of a chapter file kept, e.g. {"in": "0:05", "out": "12:30"}, to cut
+// junk at its ends. Either end may be left out. Like idle trimming, cuts fall
+// on keyframes.
+type ChapterCut struct {
+	In  string `json:"in"`
+	Out string `json:"out"`
+	in  time.Duration
+	out time.Duration
+}
+
+func (c *ChapterCut) validate() error {
+	var err error
+	if c.In != "" {
+		if c.in, err = parseTimestamp(c.In); err != nil {
+			return fmt.Errorf("invalid cut in point %q", c.In)
+		}
+	}
+	if c.Out != "" {
+		if c.out, err = parseTimestamp(c.Out); err != nil || c.out <= c.in {
+			return fmt.Errorf("invalid cut out point %q", c.Out)
+		}
+	}
+	return nil
+}
+
+// Returns a chapter cut to the stretch kept, or false if the cut does not fit
+// in the chapter.
+func (c *ChapterCut) apply(chapter Chapter) (Chapter, bool) {
+	end := chapter.Duration
+	if c.out > 0 && c.out < end {
+		end = c.out
+	}
+	if c.in >= end {
+		return chapter, false
+	}
+	cut := chapter
+	cut.InPoint = c.in
+	if end < chapter.Duration {
+		cut.OutPoint = end
+	}
+	cut.Duration = end - c.in
+	cut.FileDuration = chapter.Duration
+	if !chapter.CreateTime.IsZero() {
+		cut.CreateTime = chapter.CreateTime.Add(c.in)
+	}
+	cut.Size = int64(float64(chapter.Size) * float64(cut.Duration) / float64(chapter.Duration))
+	return cut, true
+}
+
+// Per-directory settings, read from SidecarFileName.
+type Sidecar struct {
+	// If true, the directory and its subdirectories are left out.
+	Skip bool `json:"skip"`
+	// Title of the videos of the directory, instead of the one generated from
+	// its path. The prefix is still prepended by the default title template.
+	Title string `json:"title"`
+	// Place the footage was shot, for title templates, e.g. "Finale Ligure".
+	Location string `json:"location"`
+	// Template of the description, see videoDescription.
+	Description string   `json:"description"`
+	Tags        []string `json:"tags"`
+	// Privacy status of the uploaded videos, instead of the configured one.
+	Privacy string `json:"privacy"`
+	// Chapter labels, by chapter file name.
+	Chapters map[string]ChapterLabels `json:"chapters"`
+	// File names of chapters left out of the videos, e.g. test clips.
+	Exclude []string `json:"exclude"`
+	// File names of chapters in the order they are rendered in, instead of
+	// their recording order. Chapters not listed follow, in recording order.
+	Order []string `json:"order"`
+	// Stretches of chapters kept, by chapter file name.
+	Cuts map[string]*ChapterCut `json:"cuts"`
+	// Added to the recording times of the chapters, e.g. "-2h" when the
+	// camera clock was 2 hours ahead.
+	TimeOffset string `json:"time_offset"`
+	timeOffset time.Duration
+	// If set, the videos of the directory are rendered sped up.
+	Hyperlapse *Hyperlapse `json:"hyperlapse"`
+}
+
+// Loads the sidecar of a directory, or an empty one if there is none.
+func loadSidecar(dirPath string) (*Sidecar, error) {
+	sidecar := &Sidecar{}
+	fileName := filepath.Join(dirPath, SidecarFileName)
+	data, err := ioutil.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		return sidecar, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, sidecar); err != nil {
+		return nil, fmt.Errorf("Error parsing %s: %v", fileName, err)
+	}
+	if err := sidecar.validate(); err != nil {
+		return nil, fmt.Errorf("Error parsing %s: %v", fileName, err)
+	}
+	return sidecar, nil
+}
+
+// Verifies that the sidecar values are usable.
+func (s *Sidecar) validate() error {
+	switch s.Privacy {
+	case "", "private", "unlisted", "public":
+	default:
+		return fmt.Errorf("invalid privacy %q", s.Privacy)
+	}
+	if s.Description != "" {
+		if _, err := executeDescriptionTemplate(context.Background(), s.Description, Video{}); err != nil {
+			return fmt.Errorf("invalid description: %v", err)
+		}
+	}
+	if s.TimeOffset != "" {
+		offset, err := time.ParseDuration(s.TimeOffset)
+		if err != nil {
+			return fmt.Errorf("invalid time offset %q", s.TimeOffset)
+		}
+		s.timeOffset = offset
+	}
+	seen := map[string]bool{}
+	for _, fileName := range s.Order {
+		if seen[fileName] {
+			return fmt.Errorf("invalid order: %s is listed twice", fileName)
+		}
+		seen[fileName] = true
+	}
+	for fileName, cut := range s.Cuts {
+		if cut == nil {
+			return fmt.Errorf("invalid cut of %s: empty", fileName)
+		}
+		if err := cut.validate(); err != nil {
+			return fmt.Errorf("invalid cut of %s: %v", fileName, err)
+		}
+	}
+	return s.Hyperlapse.validate()
+}
+
+// Applies the sidecar settings to the chapters of its directory, returning
+// the chapters which are not excluded, in order and with their cuts applied.
+// Chapters the sidecar refers to which are not in the directory are warned
+// about, e.g. misspelt ones.
+func (s *Sidecar) apply(dirPath string, chapters []Chapter) []Chapter {
+	s.checkChapters(dirPath, chapters)
+	var results []Chapter
+	for _, chapter := range s.order(chapters) {
+		if contains(s.Exclude, chapter.FileName) {
+			continue
+		}
+		if labels, ok := s.Chapters[chapter.FileName]; ok {
+			chapter.Locale = labels.Locale
+			chapter.Speaker = labels.Speaker
+		}
+		if !chapter.CreateTime.IsZero() {
+			chapter.CreateTime = chapter.CreateTime.Add(s.timeOffset)
+		}
+		if cut, ok := s.Cuts[chapter.FileName]; ok {
+			cutChapter, ok := cut.apply(chapter)
+			if !ok {
+				warnf(">>> Cut of %s starts past its end (%s), not cutting it", chapter.FileName,
+					fmtDurationForYouTube(chapter.Duration))
+			}
+			chapter = cutChapter
+		}
+		results = append(results, chapter)
+	}
+	return results
+}
+
+// Returns the chapters in the sidecar order: those listed first, then the
+// others in their original order.
+func (s *Sidecar) order(chapters []Chapter) []Chapter {
+	if len(s.Order) == 0 {
+		return chapters
+	}
+	byName := map[string]Chapter{}
+	for _, chapter := range chapters {
+		byName[chapter.FileName] = chapter
+	}
+	var results []Chapter
+	for _, fileName := range s.Order {
+		if chapter, ok := byName[fileName]; ok {
+			results = append(results, chapter)
+		}
+	}
+	for _, chapter := range chapters {
+		if !contains(s.Order, chapter.FileName) {
+			results = append(results, chapter)
+		}
+	}
+	return results
+}
+
+// Warns about the chapters the sidecar refers to which are not among the
+// chapters of its directory.
+func (s *Sidecar) checkChapters(dirPath string, chapters []Chapter) {
+	found := map[string]bool{}
+	for _, chapter := range chapters {
+		found[chapter.FileName] = true
+	}
+	var missing []string
+	check := func(fileName string) {
+		if !found[fileName] && !contains(missing, fileName) {
+			missing = append(missing, fileName)
+		}
+	}
+	for _, fileName := range s.Exclude {
+		check(fileName)
+	}
+	for _, fileName := range s.Order {
+		check(fileName)
+	}
+	for fileName := range s.Chapters {
+		check(fileName)
+	}
+	for fileName := range s.Cuts {
+		check(fileName)
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		warnf(">>> %s refers to chapters not found in %s: %s", SidecarFileName, dirPath, strings.Join(missing, ", "))
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A read-only file system snapshot of an input directory. Videos are
+// discovered and rendered from it, so that files landing in the input
+// directory during a long run do not end up in half-rendered videos.
+type Snapshot struct {
+	// The input directory, and the same directory in the snapshot.
+	dir, path string
+	release   func() error
+	once      sync.Once
+}
+
+// Snapshots of the input directories of the run, if enabled.
+type Snapshots []*Snapshot
+
+var inputSnapshots Snapshots
+
+// Number of snapshots created by the run, so that snapshots of several input
+// directories of a file system get different names.
+var snapshotCount int
+
+// Returns the name of a new snapshot, e.g. gopro-uploader-20200704-101200-1.
+func snapshotName() string {
+	snapshotCount++
+	return fmt.Sprintf("gopro-uploader-%s-%d", time.Now().Format("20060102-150405"), snapshotCount)
+}
+
+// Returns the path of dir relative to root, failing if dir is not in root.
+func relativeToRoot(root, dir string) (string, error) {
+	rel, err := filepath.Rel(root, dir)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is not in %s", dir, root)
+	}
+	return rel, nil
+}
+
+// Returns the path of the snapshot corresponding to p, a path in one of the
+// input directories. Other paths are returned unchanged.
+func (s Snapshots) translate(p string) string {
+	for _, snapshot := range s {
+		if rel, err := relativeToRoot(snapshot.dir, p); err == nil {
+			return filepath.Join(snapshot.path, rel)
+		}
+	}
+	return p
+}
+
+// Returns the path of the input directory corresponding to p, a path in a
+// snapshot, so that the state does not refer to snapshots once released.
+func (s Snapshots) live(p string) string {
+	for _, snapshot := range s {
+		if rel, err := relativeToRoot(snapshot.path, p); err == nil {
+			return filepath.Join(snapshot.dir, rel)
+		}
+	}
+	return p
+}
+
+// Releases the snapshots.
+func (s Snapshots) remove() {
+	for _, snapshot := range s {
+		snapshot.once.Do(func() {
+			log.Printf(">>> Releasing snapshot %s", snapshot.path)
+			if err := snapshot.release(); err != nil {
+				warnf(">>> Could not release snapshot %s: %v", snapshot.path, err)
+			}
+		})
+	}
+}
+
+// Snapshots dir, see createSnapshot for the supported file systems.
+func snapshotInputDir(dir string) (*Snapshot, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	path, release, err := createSnapshot(abs)
+	if err != nil {
+		return nil, fmt.Errorf("Could not snapshot %s: %v", dir, err)
+	}
+	log.Printf(">>> Rendering from snapshot %s", path)
+	return &Snapshot{dir: dir, path: path, release: release}, nil
+}
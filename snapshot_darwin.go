@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+)
+
+// Output of tmutil localsnapshot, e.g.
+// "Created local snapshot with date: 2020-07-04-101200".
+var tmutilSnapshotRegex = regexp.MustCompile(`date: (\S+)`)
+
+// Returns a string held in a fixed size C char array.
+func cString(chars []int8) string {
+	var b strings.Builder
+	for _, c := range chars {
+		if c == 0 {
+			break
+		}
+		b.WriteByte(byte(c))
+	}
+	return b.String()
+}
+
+// Creates a read-only snapshot of the APFS volume containing dir, an absolute
+// path, with Time Machine, and mounts it. Returns the path of dir in the
+// snapshot, and a function unmounting and deleting it.
+func createSnapshot(dir string) (string, func() error, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return "", nil, err
+	}
+	if fsType := cString(st.Fstypename[:]); fsType != "apfs" {
+		return "", nil, fmt.Errorf("snapshots are not supported on %s file systems", fsType)
+	}
+	volume := cString(st.Mntonname[:])
+	rel, err := relativeToRoot(volume, dir)
+	if err != nil {
+		return "", nil, err
+	}
+	out, err := exec.Command("tmutil", "localsnapshot", volume).CombinedOutput()
+	match := tmutilSnapshotRegex.FindSubmatch(out)
+	if err != nil || match == nil {
+		return "", nil, fmt.Errorf("tmutil localsnapshot: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	date := string(match[1])
+	deleteSnapshot := func() error {
+		if out, err := exec.Command("tmutil", "deletelocalsnapshots", date).CombinedOutput(); err != nil {
+			return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+		}
+		return nil
+	}
+	mountPoint, err := ioutil.TempDir("", snapshotName())
+	if err != nil {
+		deleteSnapshot()
+		return "", nil, err
+	}
+	name := "com.apple.TimeMachine." + date + ".local"
+	if out, err := exec.Command("mount_apfs", "-o", "rdonly,nobrowse", "-s", name, volume, mountPoint).CombinedOutput(); err != nil {
+		os.Remove(mountPoint)
+		deleteSnapshot()
+		return "", nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+	release := func() error {
+		if out, err := exec.Command("umount", mountPoint).CombinedOutput(); err != nil {
+			return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+		}
+		os.Remove(mountPoint)
+		return deleteSnapshot()
+	}
+	return filepath.Join(mountPoint, rel), release, nil
+}
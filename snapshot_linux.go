@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// Inode number of the root directory of btrfs subvolumes.
+const btrfsSubvolumeInode = 256
+
+// A mounted file system, as listed in /proc/self/mountinfo.
+type mount struct {
+	// Mount point, file system type and source, e.g. /dev/sdb1 or the ZFS
+	// dataset tank/videos.
+	Point, FSType, Source string
+}
+
+// Returns the file system mounted on the longest mount point containing abs,
+// an absolute path.
+func findMount(abs string) (mount, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return mount{}, err
+	}
+	defer f.Close()
+	var found mount
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// 36 35 98:0 / /mnt rw,noatime master:1 - ext3 /dev/root rw
+		fields := strings.Fields(scanner.Text())
+		sep := -1
+		for ix, field := range fields {
+			if field == "-" {
+				sep = ix
+				break
+			}
+		}
+		if len(fields) < 5 || sep < 0 || sep+2 >= len(fields) {
+			continue
+		}
+		point := strings.ReplaceAll(fields[4], `\040`, " ")
+		if (abs == point || strings.HasPrefix(abs, strings.TrimSuffix(point, "/")+"/")) && len(point) >= len(found.Point) {
+			found = mount{Point: point, FSType: fields[sep+1], Source: fields[sep+2]}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return mount{}, err
+	}
+	if found.Point == "" {
+		return mount{}, fmt.Errorf("no file system mounted on %s", abs)
+	}
+	return found, nil
+}
+
+// Creates a read-only snapshot of dir, an absolute path, on btrfs or ZFS.
+// Returns the path of dir in the snapshot, and a function deleting it.
+func createSnapshot(dir string) (string, func() error, error) {
+	m, err := findMount(dir)
+	if err != nil {
+		return "", nil, err
+	}
+	switch m.FSType {
+	case "btrfs":
+		return btrfsSnapshot(dir, m)
+	case "zfs":
+		return zfsSnapshot(dir, m)
+	}
+	return "", nil, fmt.Errorf("snapshots are not supported on %s file systems", m.FSType)
+}
+
+// Snapshots the subvolume containing dir, next to its other files.
+func btrfsSnapshot(dir string, m mount) (string, func() error, error) {
+	subvolume := dir
+	for subvolume != m.Point {
+		var st syscall.Stat_t
+		if err := syscall.Stat(subvolume, &st); err != nil {
+			return "", nil, err
+		}
+		if st.Ino == btrfsSubvolumeInode {
+			break
+		}
+		subvolume = filepath.Dir(subvolume)
+	}
+	rel, err := relativeToRoot(subvolume, dir)
+	if err != nil {
+		return "", nil, err
+	}
+	snapshot := filepath.Join(subvolume, "."+snapshotName())
+	if out, err := exec.Command("btrfs", "subvolume", "snapshot", "-r", subvolume, snapshot).CombinedOutput(); err != nil {
+		return "", nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+	release := func() error {
+		if out, err := exec.Command("btrfs", "subvolume", "delete", snapshot).CombinedOutput(); err != nil {
+			return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+		}
+		return nil
+	}
+	return filepath.Join(snapshot, rel), release, nil
+}
+
+// Snapshots the dataset containing dir, which ZFS exposes in the hidden .zfs
+// directory of its mount point.
+func zfsSnapshot(dir string, m mount) (string, func() error, error) {
+	rel, err := relativeToRoot(m.Point, dir)
+	if err != nil {
+		return "", nil, err
+	}
+	name := snapshotName()
+	snapshot := m.Source + "@" + name
+	if out, err := exec.Command("zfs", "snapshot", snapshot).CombinedOutput(); err != nil {
+		return "", nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+	release := func() error {
+		if out, err := exec.Command("zfs", "destroy", snapshot).CombinedOutput(); err != nil {
+			return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+		}
+		return nil
+	}
+	return filepath.Join(m.Point, ".zfs", "snapshot", name, rel), release, nil
+}
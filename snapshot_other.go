@@ -0,0 +1,10 @@
+//go:build !darwin && !linux
+// +build !darwin,!linux
+
+package main
+
+import "errors"
+
+func createSnapshot(dir string) (string, func() error, error) {
+	return "", nil, errors.New("snapshots are not supported on this platform")
+}
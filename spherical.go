@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Extension of GoPro MAX 360 chapter files, e.g. GS010042.360.
+const Ext360 = ".360"
+
+// Projection of GoPro MAX footage: an equi-angular cubemap split in two
+// video tracks, which players only understand once converted.
+const ProjectionEAC = "eac"
+
+// Variant of 360 videos converted to an equirectangular projection, which is
+// the one uploaded to YouTube.
+const Variant360 = "equirect"
+
+// Layout of the cubemap tracks of GoPro MAX footage: each holds three faces
+// of 1344x1344, with 32 pixels of stitching overlap between them.
+const (
+	eacFaceSize    = 1344
+	eacFaceOverlap = 32
+)
+
+// Size of converted 360 videos, the resolution of the cubemap in 2:1.
+const (
+	equirectWidth  = 4 * eacFaceSize
+	equirectHeight = 2 * eacFaceSize
+)
+
+// UUID of the Spherical Video V1 box, see
+// https://github.com/google/spatial-media/blob/master/docs/spherical-video-rfc.md
+var sphericalBoxUUID = []byte{
+	0xff, 0xcc, 0x82, 0x63, 0xf8, 0x55, 0x4a, 0x93,
+	0x88, 0x14, 0x58, 0x7a, 0x02, 0x52, 0x1f, 0xdd,
+}
+
+const sphericalMetadata = `<?xml version="1.0"?>` +
+	`<rdf:SphericalVideo xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#" xmlns:GSpherical="http://ns.google.com/videos/1.0/spherical/">` +
+	`<GSpherical:Spherical>true</GSpherical:Spherical>` +
+	`<GSpherical:Stitched>true</GSpherical:Stitched>` +
+	`<GSpherical:StitchingSoftware>gopro-uploader</GSpherical:StitchingSoftware>` +
+	`<GSpherical:ProjectionType>equirectangular</GSpherical:ProjectionType>` +
+	`</rdf:SphericalVideo>`
+
+// Whether a video is made of 360 footage. Its chapters all share the same
+// projection, see canUseConcatDemuxer.
+func (v Video) is360() bool {
+	return len(v.Chapters) > 0 && v.Chapters[0].Projection != ""
+}
+
+// Returns the filtergraph assembling the two cubemap tracks of GoPro MAX
+// footage, dropping the overlaps, and projecting them to equirectangular.
+// The second track holds its faces rotated. Its output is labelled [v].
+func equirectFilters() string {
+	var filters []string
+	for track := 0; track < 2; track++ {
+		filters = append(filters, fmt.Sprintf("[0:v:%d]split=3[t%d0][t%d1][t%d2]", track, track, track, track))
+		for face := 0; face < 3; face++ {
+			filters = append(filters, fmt.Sprintf("[t%d%d]crop=%d:%d:%d:0[f%d%d]", track, face,
+				eacFaceSize, eacFaceSize, face*(eacFaceSize+eacFaceOverlap), track, face))
+		}
+		filters = append(filters, fmt.Sprintf("[f%d0][f%d1][f%d2]hstack=inputs=3[t%d]", track, track, track, track))
+	}
+	filters = append(filters, fmt.Sprintf(
+		"[t0][t1]vstack,v360=eac:e:in_forder=lfrdbu:in_frot=000313:w=%d:h=%d,format=yuv420p[v]",
+		equirectWidth, equirectHeight))
+	return strings.Join(filters, ";")
+}
+
+// Converts a rendered 360 video to the equirectangular variant YouTube
+// understands, with spherical metadata. The spatial audio track is not kept:
+// YouTube expects a first order ambisonics layout GoPro does not record.
+func convert360(ctx context.Context, runner Runner, video Video, outputDir string, config *Config) error {
+	inputFname := filepath.Join(outputDir, video.Title+VideoExt)
+	outputFname := filepath.Join(outputDir, video.Title+"."+Variant360+VideoExt)
+	tmpFname := filepath.Join(outputDir, "."+video.Title+"."+Variant360+".tmp"+VideoExt)
+	log.Printf(">>> Converting %s to equirectangular", outputFname)
+	args := []string{"-v", "warning",
+		"-i", inputFname,
+		"-filter_complex", equirectFilters(),
+		"-map", "[v]",
+		"-map", "0:a:0?",
+		"-map_metadata", "0",
+		"-map_chapters", "0"}
+	args = append(args, config.encoderArgs()...)
+	args = append(args,
+		"-c:a", "copy",
+		"-movflags", "+faststart",
+		"-progress", "pipe:1", "-nostats",
+		"-f", "mp4", tmpFname, "-y")
+	cmd := exec.CommandContext(ctx, binaryPath("ffmpeg"), args...)
+	cmd.Stderr = os.Stderr
+	if err := progressBars.run(runner, cmd, video.Title, video.renderedDuration()); err != nil {
+		os.Remove(tmpFname)
+		return err
+	}
+	if !runner.Executes() {
+		// The spherical metadata cannot be injected by a printed command.
+		return renameOutput(runner, tmpFname, outputFname)
+	}
+	if err := injectSphericalMetadata(tmpFname); err != nil {
+		os.Remove(tmpFname)
+		return fmt.Errorf("Could not inject spherical metadata in %s: %v", outputFname, err)
+	}
+	return os.Rename(tmpFname, outputFname)
+}
+
+// Adds a Spherical Video V1 box to the video track of an MP4 file, which
+// ffmpeg cannot write. The moov box grows, so the chunk offsets of the media
+// data stored after it are shifted accordingly.
+func injectSphericalMetadata(fileName string) error {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	boxes, err := readMP4Boxes(f, 0, info.Size())
+	if err != nil {
+		return err
+	}
+	moovBox, ok := findMP4Box(boxes, "moov")
+	if !ok {
+		return fmt.Errorf("Error parsing MP4: no moov box in %s", fileName)
+	}
+	if moovBox.HeaderSize != 8 {
+		return fmt.Errorf("Error parsing MP4: unsupported 64-bit moov box in %s", fileName)
+	}
+	moov := make([]byte, moovBox.Size)
+	if _, err := f.ReadAt(moov, moovBox.Offset); err != nil {
+		return err
+	}
+
+	uuid := make([]byte, 8, 8+len(sphericalBoxUUID)+len(sphericalMetadata))
+	copy(uuid[4:8], "uuid")
+	uuid = append(append(uuid, sphericalBoxUUID...), sphericalMetadata...)
+	binary.BigEndian.PutUint32(uuid[0:4], uint32(len(uuid)))
+
+	moov, err = appendToVideoTrack(moov, uuid)
+	if err != nil {
+		return err
+	}
+	if err := shiftChunkOffsets(moov, moovBox.Offset, int64(len(uuid))); err != nil {
+		return err
+	}
+
+	tmpFname := filepath.Join(filepath.Dir(fileName), "."+filepath.Base(fileName)+".spherical"+VideoExt)
+	out, err := os.Create(tmpFname)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, io.NewSectionReader(f, 0, moovBox.Offset))
+	if err == nil {
+		_, err = out.Write(moov)
+	}
+	if err == nil {
+		end := moovBox.Offset + moovBox.Size
+		_, err = io.Copy(out, io.NewSectionReader(f, end, info.Size()-end))
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpFname)
+		return err
+	}
+	return os.Rename(tmpFname, fileName)
+}
+
+// Appends a box to the first video trak of an in-memory moov box, updating
+// the sizes of both.
+func appendToVideoTrack(moov, box []byte) ([]byte, error) {
+	root := mp4Box{Type: "moov", Size: int64(len(moov)), HeaderSize: 8}
+	traks, err := mp4Children(moov, root)
+	if err != nil {
+		return nil, err
+	}
+	for _, trak := range traks {
+		if trak.Type != "trak" {
+			continue
+		}
+		hdlr, ok, err := findMP4Path(moov, trak, "mdia", "hdlr")
+		if err != nil {
+			return nil, err
+		}
+		if !ok || hdlr.Size < hdlr.HeaderSize+12 ||
+			string(moov[hdlr.Offset+hdlr.HeaderSize+8:hdlr.Offset+hdlr.HeaderSize+12]) != "vide" {
+			continue
+		}
+		if trak.HeaderSize != 8 {
+			return nil, fmt.Errorf("Error parsing MP4: unsupported 64-bit trak box")
+		}
+		end := trak.Offset + trak.Size
+		result := make([]byte, 0, len(moov)+len(box))
+		result = append(result, moov[:end]...)
+		result = append(result, box...)
+		result = append(result, moov[end:]...)
+		binary.BigEndian.PutUint32(result[0:4], uint32(len(result)))
+		binary.BigEndian.PutUint32(result[trak.Offset:trak.Offset+4], uint32(trak.Size)+uint32(len(box)))
+		return result, nil
+	}
+	return nil, fmt.Errorf("Error parsing MP4: no video track")
+}
+
+// Shifts the chunk offsets of an in-memory moov box pointing after it, i.e.
+// beyond moovOffset in the file, by delta bytes.
+func shiftChunkOffsets(moov []byte, moovOffset, delta int64) error {
+	root := mp4Box{Type: "moov", Size: int64(len(moov)), HeaderSize: 8}
+	traks, err := mp4Children(moov, root)
+	if err != nil {
+		return err
+	}
+	for _, trak := range traks {
+		if trak.Type != "trak" {
+			continue
+		}
+		stbl, ok, err := findMP4Path(moov, trak, "mdia", "minf", "stbl")
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		children, err := mp4Children(moov, stbl)
+		if err != nil {
+			return err
+		}
+		for _, box := range children {
+			if box.Type != "stco" && box.Type != "co64" {
+				continue
+			}
+			data := moov[box.Offset+box.HeaderSize : box.Offset+box.Size]
+			if len(data) < 8 {
+				return fmt.Errorf("Error parsing MP4: invalid %s box", box.Type)
+			}
+			count := int(binary.BigEndian.Uint32(data[4:8]))
+			entries := data[8:]
+			if box.Type == "stco" {
+				if len(entries) < 4*count {
+					return fmt.Errorf("Error parsing MP4: invalid stco box")
+				}
+				for ix := 0; ix < count; ix++ {
+					entry := entries[4*ix : 4*ix+4]
+					if offset := int64(binary.BigEndian.Uint32(entry)); offset > moovOffset {
+						if offset+delta > 0xffffffff {
+							return fmt.Errorf("Error parsing MP4: chunk offset overflow")
+						}
+						binary.BigEndian.PutUint32(entry, uint32(offset+delta))
+					}
+				}
+			} else {
+				if len(entries) < 8*count {
+					return fmt.Errorf("Error parsing MP4: invalid co64 box")
+				}
+				for ix := 0; ix < count; ix++ {
+					entry := entries[8*ix : 8*ix+8]
+					if offset := int64(binary.BigEndian.Uint64(entry)); offset > moovOffset {
+						binary.BigEndian.PutUint64(entry, uint64(offset+delta))
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Name of the state database, stored in the output directory.
+const StateFileName = ".gopro-uploader-state.json"
+
+const (
+	StatusPending  = "pending"
+	StatusRendered = "rendered"
+	// Uploaded, but YouTube has not finished processing the video yet.
+	StatusProcessing = "processing"
+	StatusUploaded   = "uploaded"
+	StatusFailed     = "failed"
+)
+
+type VideoState struct {
+	Title     string    `json:"title"`
+	Video     Video     `json:"video"`
+	Status    string    `json:"status"`
+	Priority  int       `json:"priority"`
+	VideoID   string    `json:"video_id,omitempty"`
+	PublishAt time.Time `json:"publish_at"`
+	Error     string    `json:"error,omitempty"`
+	// SHA-256 of the rendered file.
+	Checksum string `json:"sha256,omitempty"`
+	// Other renders of the video found in the output directory, see
+	// listRenderedVideos.
+	Variants []string `json:"variants,omitempty"`
+	// Copies held by upload destinations, see sourcesDeletable.
+	Copies []VideoCopy `json:"copies,omitempty"`
+	// IDs of the Shorts uploaded from the video, by file name, see
+	// runShortsCommand.
+	Shorts map[string]string `json:"shorts,omitempty"`
+	// IDs of the caption tracks uploaded to the video, by name, see
+	// uploadCaptions.
+	Captions map[string]string `json:"captions,omitempty"`
+	// Set when the video was re-titled after it was uploaded, until its title
+	// is updated on YouTube, see retitleParts.
+	TitleOutdated bool `json:"title_outdated,omitempty"`
+}
+
+// Persistent record of what has been rendered and uploaded so far.
+// Entries may be read by the goroutine processing them, but must only be
+// modified through update, which can be called concurrently.
+type State struct {
+	mu       sync.Mutex
+	fileName string
+	Videos   map[string]*VideoState `json:"videos"`
+	Quota    Quota                  `json:"quota"`
+	// Bytes uploaded this month, see DataTracker.
+	DataUsage DataUsage `json:"data_usage"`
+	// Cards chapters were imported from, by card ID.
+	Cards map[string]*CardRecord `json:"cards,omitempty"`
+}
+
+// Loads the state database from the output directory, or starts an empty one.
+func loadState(outputDir string) (*State, error) {
+	state := &State{
+		fileName: filepath.Join(outputDir, StateFileName),
+		Videos:   map[string]*VideoState{},
+	}
+	data, err := ioutil.ReadFile(state.fileName)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+	if state.Videos == nil {
+		state.Videos = map[string]*VideoState{}
+	}
+	return state, nil
+}
+
+// Writes the state database.
+func (s *State) save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.write()
+}
+
+// Applies a change to the state and persists it.
+func (s *State) update(change func()) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	change()
+	return s.write()
+}
+
+// Writes the state database atomically, so that an interrupted run never
+// leaves it truncated. Must be called with mu held.
+func (s *State) write() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpName := s.fileName + ".tmp"
+	if err := ioutil.WriteFile(tmpName, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, s.fileName)
+}
+
+// Returns the state of a video, creating it if missing.
+func (s *State) video(video Video) *VideoState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.Videos[video.Title]
+	if !ok {
+		entry = &VideoState{Title: video.Title, Video: video, Status: StatusPending}
+		s.Videos[video.Title] = entry
+	}
+	return entry
+}
+
+// Returns the state of a part of a video as it was titled when first
+// discovered, matched by its folder, number and first chapter, or nil if it is
+// not known, e.g. without state.
+func (s *State) knownPart(video Video) *VideoState {
+	if s == nil || video.Part == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, entry := range s.Videos {
+		known := entry.Video
+		if known.Path == video.Path && known.Part == video.Part && known.PartCount > 0 &&
+			len(known.Chapters) > 0 && known.Chapters[0].FileName == video.Chapters[0].FileName {
+			return entry
+		}
+	}
+	return nil
+}
+
+// Moves the state of a video to the title it has now, unless another video
+// has that title already. Uploaded videos are marked for their title to be
+// updated on YouTube.
+func (s *State) retitle(entry *VideoState, video Video) (bool, error) {
+	s.mu.Lock()
+	_, taken := s.Videos[video.Title]
+	s.mu.Unlock()
+	if taken {
+		return false, nil
+	}
+	return true, s.update(func() {
+		delete(s.Videos, entry.Title)
+		entry.Title = video.Title
+		entry.Video.Title = video.Title
+		entry.Video.PartCount = video.PartCount
+		if entry.VideoID != "" {
+			entry.TitleOutdated = true
+		}
+		s.Videos[video.Title] = entry
+	})
+}
+
+// Adds a discovered video to the work queue. Videos which were not rendered
+// yet pick up any chapters added since they were first discovered.
+func (s *State) enqueue(video Video) *VideoState {
+	entry := s.video(video)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if entry.Status == StatusPending {
+		entry.Video = video
+	}
+	return entry
+}
+
+// Returns the latest publishing time scheduled so far.
+func (s *State) lastPublishAt() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var last time.Time
+	for _, entry := range s.Videos {
+		if entry.PublishAt.After(last) {
+			last = entry.PublishAt
+		}
+	}
+	return last
+}
+
+// Records the variants of each video found in the output directory.
+func (s *State) setVariants(variants map[string][]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for title, entry := range s.Videos {
+		entry.Variants = variants[title]
+	}
+}
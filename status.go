@@ -0,0 +1,165 @@
+package main
+
+import (
+	"html/template"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// Number of uploads listed on the status page.
+const statusRecentUploads = 20
+
+// A video of the status page, copied from the state while locked.
+type StatusVideo struct {
+	Title  string
+	Status string
+	Error  string
+	// Link to the video on YouTube, once uploaded, and when.
+	URL        string
+	UploadTime time.Time
+}
+
+// A running ffmpeg job of the status page.
+type StatusJob struct {
+	Title   string
+	Percent float64
+	ETA     time.Duration
+}
+
+type statusPage struct {
+	Jobs    []StatusJob
+	Queue   []StatusVideo
+	Uploads []StatusVideo
+	Time    time.Time
+}
+
+// Single page without external assets, reloading itself every 30 seconds.
+var statusPageTemplate = template.Must(template.New("status").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta http-equiv="refresh" content="30">
+<title>gopro-uploader</title>
+<style>
+body { font-family: sans-serif; margin: 0 auto; max-width: 40em; padding: 0.5em; }
+h2 { font-size: 1.1em; margin-top: 1.5em; }
+ul { list-style: none; padding: 0; }
+li { border-bottom: 1px solid #ddd; padding: 0.6em 0; overflow-wrap: anywhere; }
+progress { width: 100%; }
+.status { color: #666; font-size: 0.9em; }
+.failed { color: #c00; }
+a { display: block; }
+</style>
+</head>
+<body>
+<h2>Rendering</h2>
+<ul>
+{{ range .Jobs }}<li>{{ .Title }}<progress max="100" value="{{ printf "%.0f" .Percent }}"></progress>
+<span class="status">{{ printf "%.0f" .Percent }}%{{ if .ETA }}, {{ .ETA }} left{{ end }}</span></li>
+{{ else }}<li class="status">Nothing is being rendered</li>
+{{ end }}
+</ul>
+<h2>Queue ({{ len .Queue }})</h2>
+<ul>
+{{ range .Queue }}<li>{{ if .URL }}<a href="{{ .URL }}">{{ .Title }}</a>{{ else }}{{ .Title }}{{ end }}
+<span class="status {{ .Status }}">{{ .Status }}{{ if .Error }}: {{ .Error }}{{ end }}</span></li>
+{{ else }}<li class="status">Empty</li>
+{{ end }}
+</ul>
+<h2>Recent uploads</h2>
+<ul>
+{{ range .Uploads }}<li><a href="{{ .URL }}">{{ .Title }}</a>
+{{ if not .UploadTime.IsZero }}<span class="status">{{ .UploadTime.Format "Mon 2 Jan 15:04" }}</span>{{ end }}</li>
+{{ else }}<li class="status">None yet</li>
+{{ end }}
+</ul>
+<p class="status">Updated {{ .Time.Format "15:04:05" }}</p>
+</body>
+</html>
+`))
+
+// Serves a status page of the run for phones on addr, e.g. ":8081": running
+// renders, the work queue and recent uploads.
+func startStatus(addr string, state *State, queueRules []string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		page := statusPage{
+			Jobs: progressBars.status(),
+			Time: time.Now(),
+		}
+		page.Queue, page.Uploads = state.status(queueRules)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := statusPageTemplate.Execute(w, page); err != nil {
+			warnf(">>> Error serving status page: %v", err)
+		}
+	})
+	go func() {
+		log.Printf(">>> Serving status page on http://%s/", listener.Addr())
+		if err := http.Serve(listener, mux); err != nil {
+			warnf(">>> Status server stopped: %v", err)
+		}
+	}()
+	return nil
+}
+
+// Returns the queued videos, in queue order, and the most recent uploads.
+func (s *State) status(queueRules []string) ([]StatusVideo, []StatusVideo) {
+	queue := s.queue(queueRules)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var queued, uploads []StatusVideo
+	for _, entry := range queue {
+		video := StatusVideo{Title: entry.Title, Status: entry.Status, Error: entry.Error}
+		if entry.VideoID != "" {
+			video.URL = "https://youtu.be/" + entry.VideoID
+		}
+		queued = append(queued, video)
+	}
+	for _, entry := range s.Videos {
+		if entry.Status != StatusUploaded || entry.VideoID == "" {
+			continue
+		}
+		video := StatusVideo{Title: entry.Title, Status: entry.Status, URL: "https://youtu.be/" + entry.VideoID}
+		for _, c := range entry.Copies {
+			if c.Destination == CopyYouTube {
+				video.UploadTime = c.Time
+			}
+		}
+		uploads = append(uploads, video)
+	}
+	sort.Slice(uploads, func(i, j int) bool {
+		return uploads[i].UploadTime.After(uploads[j].UploadTime)
+	})
+	if len(uploads) > statusRecentUploads {
+		uploads = uploads[:statusRecentUploads]
+	}
+	return queued, uploads
+}
+
+// Returns the progress of the running ffmpeg jobs.
+func (b *ProgressBars) status() []StatusJob {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var results []StatusJob
+	for _, job := range b.jobs {
+		status := StatusJob{Title: job.title, ETA: job.eta().Round(time.Second)}
+		if job.total > 0 {
+			status.Percent = 100 * math.Min(float64(job.done)/float64(job.total), 1)
+		}
+		results = append(results, status)
+	}
+	return results
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Extension of the telemetry subtitles written next to rendered videos, picked
+// up by local players.
+const SubtitlesExt = ".srt"
+
+// Name of the telemetry caption track of uploaded videos.
+const telemetryCaptionName = "Telemetry"
+
+// BCP-47 language tags, e.g. en or pt-BR.
+var languageTagRegex = regexp.MustCompile(`^[a-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+
+// Subtitles reading out speed, altitude and distance every second from the
+// telemetry of a video, which viewers can toggle, unlike overlays.
+type TelemetrySubtitles struct {
+	// Whether the subtitles are also embedded in rendered videos, as a
+	// mov_text track.
+	Embed bool `json:"embed"`
+	// Whether the subtitles are uploaded as a YouTube caption track.
+	Upload bool `json:"upload"`
+	// Language of the caption track, "en" if empty.
+	Language string `json:"language"`
+	// Whether speed, altitude and distance are in mph, feet and miles rather
+	// than km/h, meters and kilometers.
+	Imperial bool `json:"imperial"`
+}
+
+func (s *TelemetrySubtitles) validate() error {
+	if s == nil {
+		return nil
+	}
+	if s.Language != "" && !languageTagRegex.MatchString(s.Language) {
+		return fmt.Errorf("invalid subtitles language %q, expected e.g. en or pt-BR", s.Language)
+	}
+	return nil
+}
+
+func (s *TelemetrySubtitles) language() string {
+	if s.Language == "" {
+		return "en"
+	}
+	return s.Language
+}
+
+// Whether a video gets telemetry subtitles: if it has telemetry.
+func (s *TelemetrySubtitles) appliesTo(video Video) bool {
+	if s == nil {
+		return false
+	}
+	for _, chapter := range video.Chapters {
+		if chapter.TelemetryStream != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the SubRip subtitles reading out the telemetry of a video of the
+// given duration. Distances add up from the start of the video.
+func (s *TelemetrySubtitles) subtitles(telemetry *videoTelemetry, duration time.Duration) string {
+	speedUnit, speedFactor := "km/h", 3.6
+	altitudeUnit, altitudeFactor := "m", 1.0
+	distanceUnit, distanceFactor := "km", 1e-3
+	if s.Imperial {
+		speedUnit, speedFactor = "mph", 2.23694
+		altitudeUnit, altitudeFactor = "ft", 3.28084
+		distanceUnit, distanceFactor = "mi", 1/1609.344
+	}
+	var b strings.Builder
+	cue, gps := 0, 0
+	var distance float64
+	for start := time.Duration(0); start < duration && len(telemetry.GPS) > 0; start += dashboardInterval {
+		end := start + dashboardInterval
+		if end > duration {
+			end = duration
+		}
+		for gps+1 < len(telemetry.GPS) && telemetry.GPS[gps+1].Offset <= start {
+			distance += haversine(telemetry.GPS[gps], telemetry.GPS[gps+1])
+			gps++
+		}
+		sample := telemetry.GPS[gps]
+		cue++
+		fmt.Fprintf(&b, "%d\n%s --> %s\n%.0f %s · %.0f %s · %.1f %s\n\n", cue,
+			fmtDurationForSubRip(start), fmtDurationForSubRip(end),
+			sample.Speed2D*speedFactor, speedUnit,
+			sample.Altitude*altitudeFactor, altitudeUnit,
+			distance*distanceFactor, distanceUnit)
+	}
+	return b.String()
+}
+
+// Writes the telemetry subtitles of a video to fileName.
+func (s *TelemetrySubtitles) write(ctx context.Context, video Video, fileName string) error {
+	telemetry, err := fetchVideoTelemetry(ctx, video)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fileName, []byte(s.subtitles(telemetry, video.renderedDuration())), 0644)
+}
+
+// Writes the telemetry subtitles of a video next to its render, and returns
+// the ffmpeg input and output arguments embedding them if configured. The
+// input is expected to be the given one, and the subtitle stream the given
+// one of the output.
+func (s *TelemetrySubtitles) args(ctx context.Context, video Video, outputDir string, input, stream int) ([]string, []string, error) {
+	if !s.appliesTo(video) {
+		return nil, nil, nil
+	}
+	fileName := filepath.Join(outputDir, video.Title+SubtitlesExt)
+	if err := s.write(ctx, video, fileName); err != nil {
+		return nil, nil, err
+	}
+	if !s.Embed {
+		return nil, nil, nil
+	}
+	return []string{"-i", fileName}, []string{"-map", fmt.Sprintf("%d:s:0", input),
+		"-c:s", "mov_text",
+		fmt.Sprintf("-metadata:s:s:%d", stream), "title=" + telemetryCaptionName}, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Builds one aggregate video per top-level folder of the input directories
+// out of the rendered videos it contains, with one chapter per rendered video.
+func buildSupercuts(videos []Video, roots []InputRoot, outputDir, prefix string, config *Config) ([]Video, error) {
+	titleTemplate, err := parseTitleTemplate(config.TitleTemplate)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := config.splitRules()
+	if err != nil {
+		return nil, err
+	}
+	var trips []string
+	tripVideos := map[string][]Video{}
+	for _, video := range videos {
+		relPath, err := filepath.Rel(inputBase(roots, video.Path), video.Path)
+		if err != nil || relPath == "." {
+			continue
+		}
+		trip := strings.Split(filepath.ToSlash(relPath), "/")[0]
+		if _, ok := tripVideos[trip]; !ok {
+			trips = append(trips, trip)
+		}
+		tripVideos[trip] = append(tripVideos[trip], video)
+	}
+
+	var results []Video
+	for _, trip := range trips {
+		parts := tripVideos[trip]
+		if len(parts) < 2 {
+			continue
+		}
+		sort.SliceStable(parts, func(i, j int) bool {
+			return parts[i].startTime().Before(parts[j].startTime())
+		})
+
+		supercut := Video{
+			Path:    outputDir,
+			Privacy: parts[0].Privacy,
+		}
+		for _, part := range parts {
+			info, err := os.Stat(filepath.Join(outputDir, part.Title+VideoExt))
+			if err != nil {
+				// Not rendered (yet), e.g. while uploads are postponed.
+				continue
+			}
+			supercut.Chapters = append(supercut.Chapters, Chapter{
+				FileName:   part.Title + VideoExt,
+				CreateTime: part.startTime(),
+				Duration:   part.renderedDuration(),
+				Size:       info.Size(),
+				Resolution: part.Chapters[0].Resolution,
+			})
+		}
+		if len(supercut.Chapters) < 2 {
+			continue
+		}
+		data := TitleData{
+			Prefix: prefix,
+			Dirs:   []string{trip},
+			Name:   trip + " # Supercut",
+		}
+		// Supercuts span days, they are only split to fit the limits.
+		split := splitVideo(supercut, SplitRules{MaxDuration: rules.MaxDuration, MaxSize: rules.MaxSize})
+		if err := titleParts(titleTemplate, split, data); err != nil {
+			return nil, err
+		}
+		results = append(results, split...)
+	}
+	return results, nil
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+)
+
+// GPMF is the KLV format GoPro uses to store telemetry in a data track.
+// https://github.com/gopro/gpmf-parser
+type gpmfEntry struct {
+	Key    string
+	Type   byte
+	Size   int
+	Repeat int
+	Data   []byte
+	// Nested entries, for container entries (type 0).
+	Children []gpmfEntry
+}
+
+type GPSSample struct {
+	// Offset of the sample from the start of the chapter.
+	Offset    time.Duration
+	Latitude  float64
+	Longitude float64
+	// Altitude in meters.
+	Altitude float64
+	// Ground and 3D speed in m/s.
+	Speed2D float64
+	Speed3D float64
+	// UTC time of the payload holding the sample, zero if unknown.
+	Time time.Time
+}
+
+type AccelSample struct {
+	// Offset of the sample from the start of the chapter.
+	Offset time.Duration
+	// Magnitude of the acceleration, in g.
+	G float64
+}
+
+type Telemetry struct {
+	// Name of the camera, e.g. "HERO9 Black".
+	Device string
+	GPS    []GPSSample
+	Accel  []AccelSample
+}
+
+// Parses a sequence of GPMF entries.
+func parseGPMF(data []byte) ([]gpmfEntry, error) {
+	var entries []gpmfEntry
+	for len(data) >= 8 {
+		entry := gpmfEntry{
+			Key:    string(data[0:4]),
+			Type:   data[4],
+			Size:   int(data[5]),
+			Repeat: int(binary.BigEndian.Uint16(data[6:8])),
+		}
+		length := entry.Size * entry.Repeat
+		padded := (length + 3) &^ 3
+		if len(data) < 8+padded {
+			return nil, fmt.Errorf("Error parsing GPMF: truncated %s entry", entry.Key)
+		}
+		entry.Data = data[8 : 8+length]
+		if entry.Type == 0 {
+			children, err := parseGPMF(entry.Data)
+			if err != nil {
+				return nil, err
+			}
+			entry.Children = children
+		}
+		entries = append(entries, entry)
+		data = data[8+padded:]
+	}
+	return entries, nil
+}
+
+// Decodes the numeric values of an entry, one slice per sample.
+func (e gpmfEntry) values() [][]float64 {
+	var width int
+	switch e.Type {
+	case 'b', 'B':
+		width = 1
+	case 's', 'S':
+		width = 2
+	case 'l', 'L', 'f':
+		width = 4
+	case 'd', 'j', 'J':
+		width = 8
+	default:
+		return nil
+	}
+	var results [][]float64
+	for i := 0; i < e.Repeat; i++ {
+		sample := e.Data[i*e.Size : (i+1)*e.Size]
+		var values []float64
+		for j := 0; j+width <= len(sample); j += width {
+			b := sample[j : j+width]
+			var v float64
+			switch e.Type {
+			case 'b':
+				v = float64(int8(b[0]))
+			case 'B':
+				v = float64(b[0])
+			case 's':
+				v = float64(int16(binary.BigEndian.Uint16(b)))
+			case 'S':
+				v = float64(binary.BigEndian.Uint16(b))
+			case 'l':
+				v = float64(int32(binary.BigEndian.Uint32(b)))
+			case 'L':
+				v = float64(binary.BigEndian.Uint32(b))
+			case 'f':
+				v = float64(math.Float32frombits(binary.BigEndian.Uint32(b)))
+			case 'd':
+				v = math.Float64frombits(binary.BigEndian.Uint64(b))
+			case 'j':
+				v = float64(int64(binary.BigEndian.Uint64(b)))
+			case 'J':
+				v = float64(binary.BigEndian.Uint64(b))
+			}
+			values = append(values, v)
+		}
+		results = append(results, values)
+	}
+	return results
+}
+
+// Extracts GPS samples from a GPMF stream. GPS5 is used up to HERO10 and GPS9
+// from HERO11 on; both start with latitude, longitude, altitude, 2D and 3D
+// speed. Samples without a 2D or 3D fix are dropped.
+func parseGPSSamples(entries []gpmfEntry) []GPSSample {
+	var results []GPSSample
+	var streams []gpmfEntry
+	for _, devc := range entries {
+		for _, strm := range devc.Children {
+			if strm.Key == "STRM" {
+				streams = append(streams, strm)
+			}
+		}
+	}
+	for _, strm := range streams {
+		var scale []float64
+		var payloadTime time.Time
+		fix := 3.0
+		for _, entry := range strm.Children {
+			switch entry.Key {
+			case "GPSU":
+				// UTC time of the payload, e.g. "200704101200.000".
+				payloadTime, _ = time.Parse("060102150405.000", strings.TrimRight(string(entry.Data), "\x00"))
+			case "SCAL":
+				scale = nil
+				for _, v := range entry.values() {
+					scale = append(scale, v...)
+				}
+			case "GPSF":
+				if values := entry.values(); len(values) > 0 && len(values[0]) > 0 {
+					fix = values[0][0]
+				}
+			case "GPS5", "GPS9":
+				if fix < 2 {
+					continue
+				}
+				for _, sample := range gpsValues(entry) {
+					if len(sample) < 5 {
+						continue
+					}
+					for i := 0; i < 5 && i < len(scale); i++ {
+						if scale[i] != 0 {
+							sample[i] /= scale[i]
+						}
+					}
+					results = append(results, GPSSample{
+						Latitude:  sample[0],
+						Longitude: sample[1],
+						Altitude:  sample[2],
+						Speed2D:   sample[3],
+						Speed3D:   sample[4],
+						Time:      payloadTime,
+					})
+				}
+			}
+		}
+	}
+	return results
+}
+
+// Standard gravity, in m/s².
+const standardGravity = 9.80665
+
+// Extracts accelerometer samples from a GPMF stream, in m/s² on three axes.
+func parseAccelSamples(entries []gpmfEntry) []AccelSample {
+	var results []AccelSample
+	for _, devc := range entries {
+		for _, strm := range devc.Children {
+			if strm.Key != "STRM" {
+				continue
+			}
+			scale := 1.0
+			for _, entry := range strm.Children {
+				switch entry.Key {
+				case "SCAL":
+					if values := entry.values(); len(values) > 0 && len(values[0]) > 0 && values[0][0] != 0 {
+						scale = values[0][0]
+					}
+				case "ACCL":
+					for _, sample := range entry.values() {
+						var sum float64
+						for _, v := range sample {
+							sum += (v / scale) * (v / scale)
+						}
+						results = append(results, AccelSample{G: math.Sqrt(sum) / standardGravity})
+					}
+				}
+			}
+		}
+	}
+	return results
+}
+
+// Returns the name of the device which recorded a GPMF stream.
+func deviceName(entries []gpmfEntry) string {
+	for _, devc := range entries {
+		for _, entry := range devc.Children {
+			if entry.Key == "DVNM" && entry.Type == 'c' {
+				return strings.TrimRight(string(entry.Data), "\x00 ")
+			}
+		}
+	}
+	return ""
+}
+
+// Returns the raw GPS values of a GPS5 or GPS9 entry. GPS9 uses a complex
+// type whose first seven fields are 32-bit integers.
+func gpsValues(entry gpmfEntry) [][]float64 {
+	if entry.Type != '?' {
+		return entry.values()
+	}
+	raw := entry
+	raw.Type = 'l'
+	var results [][]float64
+	for _, sample := range raw.values() {
+		if len(sample) > 7 {
+			sample = sample[:7]
+		}
+		results = append(results, sample)
+	}
+	return results
+}
+
+// Reads the telemetry of a chapter, if it has any.
+func fetchTelemetry(ctx context.Context, dirPath string, chapter Chapter) (*Telemetry, error) {
+	telemetry := &Telemetry{}
+	if chapter.TelemetryStream == 0 {
+		return telemetry, nil
+	}
+	cmd := exec.CommandContext(ctx, binaryPath("ffmpeg"), "-v", "error", "-i", path.Join(dirPath, chapter.FileName),
+		"-map", fmt.Sprintf("0:%d", chapter.TelemetryStream), "-codec", "copy", "-f", "rawvideo", "-")
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	if err := runProbe(cmd); err != nil {
+		return nil, err
+	}
+	entries, err := parseGPMF(stdout.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	telemetry.Device = deviceName(entries)
+	telemetry.GPS = parseGPSSamples(entries)
+	telemetry.Accel = parseAccelSamples(entries)
+	// GPMF payloads are not timestamped individually, so spread samples
+	// evenly over the chapter.
+	duration := chapter.fileDuration()
+	for ix := range telemetry.GPS {
+		telemetry.GPS[ix].Offset = time.Duration(int64(duration) * int64(ix) / int64(len(telemetry.GPS)))
+	}
+	for ix := range telemetry.Accel {
+		telemetry.Accel[ix].Offset = time.Duration(int64(duration) * int64(ix) / int64(len(telemetry.Accel)))
+	}
+	return telemetry, nil
+}
+
+// Returns the first GPS fix recorded in a video, if any.
+func firstGPSFix(ctx context.Context, video Video) (*GPSSample, error) {
+	for _, chapter := range video.Chapters {
+		telemetry, err := fetchTelemetry(ctx, video.Path, chapter)
+		if err != nil {
+			return nil, err
+		}
+		if len(telemetry.GPS) > 0 {
+			return &telemetry.GPS[0], nil
+		}
+	}
+	return nil, nil
+}
+
+// Summary of the telemetry of a chapter.
+type TelemetrySummary struct {
+	GPSSamples int `json:"gps_samples"`
+	// First GPS fix, if any.
+	FirstFix *GPSSample `json:"first_fix,omitempty"`
+	// Distance covered in meters.
+	Distance float64 `json:"distance"`
+	// Maximum ground speed in m/s.
+	MaxSpeed    float64 `json:"max_speed"`
+	MinAltitude float64 `json:"min_altitude"`
+	MaxAltitude float64 `json:"max_altitude"`
+	// Cumulated climb in meters, ignoring altitude changes smaller than
+	// elevationGainThreshold.
+	ElevationGain float64 `json:"elevation_gain"`
+}
+
+// GPS altitude is noisy, so climbs are only counted once they exceed this
+// many meters.
+const elevationGainThreshold = 5.0
+
+// Returns the distance in meters between two GPS samples.
+func haversine(a, b GPSSample) float64 {
+	const earthRadius = 6371e3
+	lat1, lat2 := a.Latitude*math.Pi/180, b.Latitude*math.Pi/180
+	dLat := lat2 - lat1
+	dLon := (b.Longitude - a.Longitude) * math.Pi / 180
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(h))
+}
+
+// Summarizes the GPS samples of a chapter.
+func (t *Telemetry) summary() TelemetrySummary {
+	summary := TelemetrySummary{GPSSamples: len(t.GPS)}
+	var base float64
+	for ix, sample := range t.GPS {
+		if ix == 0 {
+			first := sample
+			summary.FirstFix = &first
+			summary.MinAltitude = sample.Altitude
+			summary.MaxAltitude = sample.Altitude
+			base = sample.Altitude
+		} else {
+			summary.Distance += haversine(t.GPS[ix-1], sample)
+		}
+		if climb := sample.Altitude - base; climb >= elevationGainThreshold {
+			summary.ElevationGain += climb
+			base = sample.Altitude
+		} else if climb < 0 {
+			base = sample.Altitude
+		}
+		summary.MaxSpeed = math.Max(summary.MaxSpeed, sample.Speed2D)
+		summary.MinAltitude = math.Min(summary.MinAltitude, sample.Altitude)
+		summary.MaxAltitude = math.Max(summary.MaxAltitude, sample.Altitude)
+	}
+	return summary
+}
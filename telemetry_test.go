@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Returns a GPMF entry, padded to 32 bits.
+func gpmfKLV(key string, entryType byte, size, repeat int, data []byte) []byte {
+	result := append([]byte(key), entryType, byte(size), byte(repeat>>8), byte(repeat))
+	result = append(result, data...)
+	for len(result)%4 != 0 {
+		result = append(result, 0)
+	}
+	return result
+}
+
+// Returns the big endian encoding of signed 32 bit values.
+func gpmfInt32s(values ...int32) []byte {
+	var result []byte
+	for _, v := range values {
+		result = binary.BigEndian.AppendUint32(result, uint32(v))
+	}
+	return result
+}
+
+func TestParseGPMF(t *testing.T) {
+	nested := gpmfKLV("DEVC", 0, 1, 12, gpmfKLV("DVNM", 'c', 1, 3, []byte("abc")))
+	for _, test := range []struct {
+		name    string
+		data    []byte
+		want    []gpmfEntry
+		wantErr bool
+	}{
+		{name: "empty"},
+		{
+			name: "padded",
+			data: append(gpmfKLV("DVNM", 'c', 1, 5, []byte("HERO9")), gpmfKLV("TSMP", 'L', 4, 1, gpmfInt32s(7))...),
+			want: []gpmfEntry{
+				{Key: "DVNM", Type: 'c', Size: 1, Repeat: 5, Data: []byte("HERO9")},
+				{Key: "TSMP", Type: 'L', Size: 4, Repeat: 1, Data: gpmfInt32s(7)},
+			},
+		},
+		{
+			name: "nested",
+			data: nested,
+			want: []gpmfEntry{{Key: "DEVC", Type: 0, Size: 1, Repeat: 12, Data: nested[8:20], Children: []gpmfEntry{
+				{Key: "DVNM", Type: 'c', Size: 1, Repeat: 3, Data: []byte("abc")},
+			}}},
+		},
+		{
+			name:    "truncated",
+			data:    gpmfKLV("TSMP", 'L', 4, 1, gpmfInt32s(7))[:10],
+			wantErr: true,
+		},
+		{
+			name:    "truncated child",
+			data:    gpmfKLV("DEVC", 0, 1, 10, gpmfKLV("TSMP", 'L', 4, 1, gpmfInt32s(7))[:10]),
+			wantErr: true,
+		},
+	} {
+		got, err := parseGPMF(test.data)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: parseGPMF() error = %v, want error %v", test.name, err, test.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: parseGPMF() = %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+// Returns a DEVC entry holding a GPS stream of the given entries.
+func gpsStream(entries ...[]byte) []byte {
+	var strm []byte
+	for _, entry := range entries {
+		strm = append(strm, entry...)
+	}
+	strm = gpmfKLV("STRM", 0, 1, len(strm), strm)
+	return gpmfKLV("DEVC", 0, 1, len(strm), strm)
+}
+
+func TestParseGPSSamples(t *testing.T) {
+	scale := gpmfKLV("SCAL", 'l', 4, 5, gpmfInt32s(10000000, 10000000, 1000, 1000, 100))
+	gps5 := gpmfKLV("GPS5", 'l', 20, 2, gpmfInt32s(
+		444123456, 82345678, 123456, 5000, 600,
+		444123457, 82345679, 123457, 5100, 610))
+	payloadTime := time.Date(2020, 7, 4, 10, 12, 0, 0, time.UTC)
+	for _, test := range []struct {
+		name string
+		data []byte
+		want []GPSSample
+	}{
+		{
+			name: "scaled",
+			data: gpsStream(scale, gpmfKLV("GPSF", 'L', 4, 1, gpmfInt32s(3)),
+				gpmfKLV("GPSU", 'U', 16, 1, []byte("200704101200.000")), gps5),
+			want: []GPSSample{
+				{Latitude: 44.4123456, Longitude: 8.2345678, Altitude: 123.456, Speed2D: 5, Speed3D: 6, Time: payloadTime},
+				{Latitude: 44.4123457, Longitude: 8.2345679, Altitude: 123.457, Speed2D: 5.1, Speed3D: 6.1, Time: payloadTime},
+			},
+		},
+		{
+			name: "no fix",
+			data: gpsStream(scale, gpmfKLV("GPSF", 'L', 4, 1, gpmfInt32s(0)), gps5),
+		},
+		{
+			name: "2D fix",
+			data: gpsStream(scale, gpmfKLV("GPSF", 'L', 4, 1, gpmfInt32s(2)),
+				gpmfKLV("GPS5", 'l', 20, 1, gpmfInt32s(10000000, 20000000, 1000, 1000, 100))),
+			want: []GPSSample{{Latitude: 1, Longitude: 2, Altitude: 1, Speed2D: 1, Speed3D: 1}},
+		},
+		{
+			name: "unscaled",
+			data: gpsStream(gpmfKLV("GPS5", 'l', 20, 1, gpmfInt32s(1, 2, 3, 4, 5))),
+			want: []GPSSample{{Latitude: 1, Longitude: 2, Altitude: 3, Speed2D: 4, Speed3D: 5}},
+		},
+		{
+			name: "other streams",
+			data: gpsStream(gpmfKLV("ACCL", 's', 6, 1, []byte{0, 1, 0, 2, 0, 3})),
+		},
+	} {
+		entries, err := parseGPMF(test.data)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if got := parseGPSSamples(entries); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: parseGPSSamples() = %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Reader limiting the rate at which data is read, in bytes per second.
+type throttledReader struct {
+	r     io.Reader
+	rate  int64
+	start time.Time
+	read  int64
+}
+
+func newThrottledReader(r io.Reader, rate int64) io.Reader {
+	if rate <= 0 {
+		return r
+	}
+	return &throttledReader{r: r, rate: rate, start: time.Now()}
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	// Read in small chunks so the rate stays smooth.
+	if chunk := t.rate / 10; chunk > 0 && int64(len(p)) > chunk {
+		p = p[:chunk]
+	}
+	n, err := t.r.Read(p)
+	t.read += int64(n)
+	expected := time.Duration(float64(t.read) / float64(t.rate) * float64(time.Second))
+	if wait := expected - time.Since(t.start); wait > 0 {
+		time.Sleep(wait)
+	}
+	return n, err
+}
+
+// Daily time window, e.g. 01:00-07:00. Windows may wrap around midnight.
+type TimeWindow struct {
+	start, end int // Minutes since midnight.
+}
+
+// Parses a window like 01:00-07:00.
+func parseTimeWindow(spec string) (*TimeWindow, error) {
+	parts := strings.Split(spec, "-")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("Error parsing time window: %s", spec)
+	}
+	startHour, startMinute, err := parseTimeOfDay(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	endHour, endMinute, err := parseTimeOfDay(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	return &TimeWindow{start: startHour*60 + startMinute, end: endHour*60 + endMinute}, nil
+}
+
+// Verifies if a time falls in the window.
+func (w *TimeWindow) contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	if w.start <= w.end {
+		return minute >= w.start && minute < w.end
+	}
+	return minute >= w.start || minute < w.end
+}
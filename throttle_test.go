@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeWindowContains(t *testing.T) {
+	at := func(hour, minute int) time.Time {
+		return time.Date(2020, 7, 4, hour, minute, 30, 0, time.Local)
+	}
+	for _, test := range []struct {
+		window string
+		t      time.Time
+		want   bool
+	}{
+		{"01:00-07:00", at(1, 0), true},
+		{"01:00-07:00", at(3, 15), true},
+		{"01:00-07:00", at(6, 59), true},
+		{"01:00-07:00", at(7, 0), false},
+		{"01:00-07:00", at(0, 59), false},
+		{"01:00-07:00", at(12, 0), false},
+		{"22:00-06:00", at(23, 0), true},
+		{"22:00-06:00", at(0, 0), true},
+		{"22:00-06:00", at(5, 59), true},
+		{"22:00-06:00", at(6, 0), false},
+		{"22:00-06:00", at(21, 59), false},
+		{"00:00-00:00", at(12, 0), false},
+	} {
+		window, err := parseTimeWindow(test.window)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := window.contains(test.t); got != test.want {
+			t.Errorf("%s contains %s = %v, want %v", test.window, test.t.Format("15:04:05"), got, test.want)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Zone recording times are shown in, e.g. in titles and descriptions, see
+// Config.Timezone. GoPro cameras record them in UTC.
+var recordingZone = time.UTC
+
+// Sets the zone recording times are shown in.
+func (c *Config) setTimezone() error {
+	if c.Timezone == "" {
+		recordingZone = time.UTC
+		return nil
+	}
+	zone, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		return fmt.Errorf("invalid timezone %q: %v", c.Timezone, err)
+	}
+	recordingZone = zone
+	return nil
+}
+
+// Returns a recording time in the zone it is shown in.
+func localTime(t time.Time) time.Time {
+	if t.IsZero() {
+		return t
+	}
+	return t.In(recordingZone)
+}
+
+// Repairs the create times of chapters sorted by file numbering, see
+// compareChapters. When the camera clock is reset (e.g. after a battery swap),
+// chapters recorded afterwards are timestamped before their predecessors.
+// They are moved to right after the previous chapter, keeping the gaps between
+// the chapters recorded with the reset clock, until the clock is set again.
+// Returns the file names of the repaired chapters.
+func repairChapterTimes(chapters []Chapter) []string {
+	var repaired []string
+	var shift time.Duration
+	for ix := 1; ix < len(chapters); ix++ {
+		prev := chapters[ix-1]
+		chapter := &chapters[ix]
+		if !chapter.CreateTime.Before(prev.CreateTime) {
+			// Recorded after its predecessor, the clock is right (again).
+			shift = 0
+			continue
+		}
+		if shift == 0 || chapter.CreateTime.Add(shift).Before(prev.CreateTime) {
+			shift = prev.CreateTime.Add(prev.Duration).Sub(chapter.CreateTime)
+		}
+		chapter.CameraTime = chapter.CreateTime
+		chapter.CreateTime = chapter.CreateTime.Add(shift)
+		repaired = append(repaired, chapter.FileName)
+	}
+	return repaired
+}
+
+// Generates a description block noting the chapters whose recording time was
+// repaired, since it is a guess.
+func generateRepairsDescription(chapters []Chapter) string {
+	var repaired []string
+	for _, chapter := range chapters {
+		if !chapter.CameraTime.IsZero() {
+			repaired = append(repaired, chapter.FileName)
+		}
+	}
+	if len(repaired) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("The camera clock was reset while recording: the recording times of %s are estimated.",
+		strings.Join(repaired, ", "))
+}
+
+// Where the recording time of a chapter without creation time was taken from,
+// in order of preference.
+const (
+	timeSourceGPS      = "its GPS time"
+	timeSourceSequence = "the other chapters of its recording"
+	timeSourceModTime  = "its modification time"
+)
+
+// Sets the create times of chapters lacking a creation time tag, e.g. after
+// being touched by other tools: from the GPS time of their telemetry, else
+// from the other chapters of the same recording, else from the modification
+// time of their file, written until the recording stopped. A warning notes
+// the source used.
+func fillCreateTimes(ctx context.Context, dirPath string, chapters []Chapter, modTimes map[string]time.Time) {
+	sources := map[int]string{}
+	for ix := range chapters {
+		if !chapters[ix].CreateTime.IsZero() {
+			continue
+		}
+		if createTime := gpsCreateTime(ctx, dirPath, chapters[ix]); !createTime.IsZero() {
+			chapters[ix].CreateTime = createTime
+			sources[ix] = timeSourceGPS
+		}
+	}
+	for filled := true; filled; {
+		filled = false
+		for ix := range chapters {
+			if !chapters[ix].CreateTime.IsZero() {
+				continue
+			}
+			if createTime := sequenceCreateTime(chapters, ix); !createTime.IsZero() {
+				chapters[ix].CreateTime = createTime
+				sources[ix] = timeSourceSequence
+				filled = true
+			}
+		}
+	}
+	for ix := range chapters {
+		if chapters[ix].CreateTime.IsZero() {
+			chapters[ix].CreateTime = modTimes[chapters[ix].FileName].UTC().Add(-chapters[ix].Duration)
+			sources[ix] = timeSourceModTime
+		}
+	}
+	for ix := range chapters {
+		if source, ok := sources[ix]; ok {
+			warnf(">>> No creation time in %s, estimated from %s: %s", path.Join(dirPath, chapters[ix].FileName),
+				source, chapters[ix].CreateTime.Format(time.RFC3339))
+		}
+	}
+}
+
+// Returns when a chapter started according to the GPS time of its telemetry,
+// or zero if it has no GPS fix.
+func gpsCreateTime(ctx context.Context, dirPath string, chapter Chapter) time.Time {
+	telemetry, err := fetchTelemetry(ctx, dirPath, chapter)
+	if err != nil {
+		debugf(">>> Could not read telemetry of %s: %v", path.Join(dirPath, chapter.FileName), err)
+		return time.Time{}
+	}
+	for _, sample := range telemetry.GPS {
+		if !sample.Time.IsZero() {
+			return sample.Time.Add(-sample.Offset)
+		}
+	}
+	return time.Time{}
+}
+
+// Returns when a chapter started according to the previous or next chapter
+// of the same recording, e.g. GX020042.MP4 starts when GX010042.MP4 ends, or
+// zero if neither is known.
+func sequenceCreateTime(chapters []Chapter, ix int) time.Time {
+	number, recording, ok := goproChapterNumber(chapters[ix].FileName)
+	if !ok {
+		return time.Time{}
+	}
+	for _, other := range chapters {
+		otherNumber, otherRecording, ok := goproChapterNumber(other.FileName)
+		if !ok || otherRecording != recording || other.CreateTime.IsZero() {
+			continue
+		}
+		switch otherNumber {
+		case number - 1:
+			return other.CreateTime.Add(other.Duration)
+		case number + 1:
+			return other.CreateTime.Add(-chapters[ix].Duration)
+		}
+	}
+	return time.Time{}
+}
+
+// Returns the chapter number and the file number of a GoPro chapter, e.g. 2
+// and "0042" for GX020042.MP4.
+func goproChapterNumber(fileName string) (int, string, bool) {
+	match := goproFnameRegex.FindStringSubmatch(fileName)
+	if len(match) != 3 {
+		return 0, "", false
+	}
+	number, err := strconv.Atoi(match[1])
+	return number, match[2], err == nil
+}
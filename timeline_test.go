@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Returns chapters recorded at the given times of day, lasting 10 minutes
+// each and named in file order.
+func recordedChapters(times ...string) []Chapter {
+	var chapters []Chapter
+	for ix, t := range times {
+		createTime, err := time.Parse("15:04", t)
+		if err != nil {
+			panic(err)
+		}
+		chapters = append(chapters, Chapter{
+			FileName:   goproChapterFileName(ix+1, "0042"),
+			CreateTime: createTime,
+			Duration:   10 * time.Minute,
+		})
+	}
+	return chapters
+}
+
+// Returns the file name of a GoPro chapter, e.g. GX020042.MP4.
+func goproChapterFileName(number int, recording string) string {
+	return fmt.Sprintf("GX%02d%s.MP4", number, recording)
+}
+
+func TestRepairChapterTimes(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		chapters []Chapter
+		want     []string
+		repaired []string
+	}{
+		{name: "empty"},
+		{"single", recordedChapters("10:00"), []string{"10:00"}, nil},
+		{"in order", recordedChapters("10:00", "10:10", "11:00"), []string{"10:00", "10:10", "11:00"}, nil},
+		{
+			"clock reset", recordedChapters("10:00", "08:00", "08:15"),
+			[]string{"10:00", "10:10", "10:25"}, []string{"GX020042.MP4", "GX030042.MP4"},
+		},
+		{
+			"clock set again", recordedChapters("10:00", "08:00", "11:00"),
+			[]string{"10:00", "10:10", "11:00"}, []string{"GX020042.MP4"},
+		},
+		{
+			"second reset", recordedChapters("10:00", "08:00", "07:00"),
+			[]string{"10:00", "10:10", "10:20"}, []string{"GX020042.MP4", "GX030042.MP4"},
+		},
+	} {
+		original := append([]Chapter(nil), test.chapters...)
+		repaired := repairChapterTimes(test.chapters)
+		if !reflect.DeepEqual(repaired, test.repaired) {
+			t.Errorf("%s: repairChapterTimes() = %v, want %v", test.name, repaired, test.repaired)
+		}
+		var got []string
+		for ix, chapter := range test.chapters {
+			got = append(got, chapter.CreateTime.Format("15:04"))
+			wasRepaired := contains(test.repaired, chapter.FileName)
+			if wasRepaired && !chapter.CameraTime.Equal(original[ix].CreateTime) {
+				t.Errorf("%s: camera time of %s = %v, want %v", test.name, chapter.FileName, chapter.CameraTime, original[ix].CreateTime)
+			}
+			if !wasRepaired && !chapter.CameraTime.IsZero() {
+				t.Errorf("%s: camera time of %s set without a repair", test.name, chapter.FileName)
+			}
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: create times = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestFillCreateTimes(t *testing.T) {
+	at := func(t string) time.Time {
+		return recordedChapters(t)[0].CreateTime
+	}
+	// Chapters recorded from 10:00, without the creation time of some.
+	withoutTimes := func(known string, chapters []Chapter) []Chapter {
+		for ix := range chapters {
+			if known[ix] == '-' {
+				chapters[ix].CreateTime = time.Time{}
+			}
+		}
+		return chapters
+	}
+	for _, test := range []struct {
+		name     string
+		chapters []Chapter
+		modTimes map[string]time.Time
+		want     []time.Time
+	}{
+		{
+			name:     "all known",
+			chapters: recordedChapters("10:00", "10:10"),
+			want:     []time.Time{at("10:00"), at("10:10")},
+		},
+		{
+			name:     "from the previous chapter",
+			chapters: withoutTimes("x--", recordedChapters("10:00", "10:10", "10:20")),
+			want:     []time.Time{at("10:00"), at("10:10"), at("10:20")},
+		},
+		{
+			name:     "from the next chapter",
+			chapters: withoutTimes("--x", recordedChapters("10:00", "10:10", "10:20")),
+			want:     []time.Time{at("10:00"), at("10:10"), at("10:20")},
+		},
+		{
+			name:     "from the modification time",
+			chapters: withoutTimes("-", recordedChapters("10:00")),
+			modTimes: map[string]time.Time{"GX010042.MP4": at("12:10")},
+			want:     []time.Time{at("12:00")},
+		},
+		{
+			name: "other recording",
+			chapters: append(recordedChapters("10:00"), Chapter{
+				FileName: goproChapterFileName(2, "0043"),
+				Duration: 10 * time.Minute,
+			}),
+			modTimes: map[string]time.Time{"GX020043.MP4": at("12:10")},
+			want:     []time.Time{at("10:00"), at("12:00")},
+		},
+		{
+			name:     "not a GoPro chapter",
+			chapters: append(recordedChapters("10:00"), Chapter{FileName: "clip.mp4", Duration: 5 * time.Minute}),
+			modTimes: map[string]time.Time{"clip.mp4": at("12:10")},
+			want:     []time.Time{at("10:00"), at("12:05")},
+		},
+	} {
+		fillCreateTimes(context.Background(), t.TempDir(), test.chapters, test.modTimes)
+		var got []time.Time
+		for _, chapter := range test.chapters {
+			got = append(got, chapter.CreateTime)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: fillCreateTimes() create times = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestSequenceCreateTime(t *testing.T) {
+	chapters := recordedChapters("10:00", "10:10", "10:20")
+	chapters[1].CreateTime = time.Time{}
+	chapters[2].Duration = 5 * time.Minute
+	for _, test := range []struct {
+		name     string
+		chapters []Chapter
+		ix       int
+		want     time.Time
+	}{
+		{"after the previous chapter", chapters[:2], 1, recordedChapters("10:10")[0].CreateTime},
+		{"before the next chapter", chapters[1:], 0, recordedChapters("10:10")[0].CreateTime},
+		{"only chapter", chapters[1:2], 0, time.Time{}},
+		{"not a chapter", []Chapter{{FileName: "clip.mp4"}, chapters[2]}, 0, time.Time{}},
+	} {
+		if got := sequenceCreateTime(test.chapters, test.ix); !got.Equal(test.want) {
+			t.Errorf("%s: sequenceCreateTime() = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Template of video titles used when none is configured, e.g.
+// "[GoPro] Alps 2024 # Day 1 pt 2 of 3".
+const DefaultTitleTemplate = `[{{.Prefix}}] {{.Name}}{{if gt .PartCount 1}} pt {{.Part}} of {{.PartCount}}{{end}}`
+
+// Fields available in title templates.
+type TitleData struct {
+	Prefix string
+	// Folders from the input directory to the one of the video, e.g.
+	// ["Alps 2024", "Day 1"].
+	Dirs []string
+	// Title set in the sidecar, or else Dirs joined with " # ".
+	Name string
+	// Recording time of the first chapter, and its date, e.g. 2020-07-04.
+	Time time.Time
+	Date string
+	// Location set in the sidecar, e.g. "Finale Ligure".
+	Location string
+	// Label of the camera with multicam split, e.g. "Helmet", see
+	// Config.Multicam.
+	Camera string
+	// Number of the part, and how many there are when the chapters of a
+	// folder are split into several videos, see splitVideo.
+	Part, PartCount int
+}
+
+// Returns the folders from rootPath to dirPath.
+func relativeDirs(dirPath, rootPath string) []string {
+	var parts []string
+	var part string
+
+	dirPath = path.Clean(dirPath)
+	rootPath = path.Clean(rootPath)
+	for dirPath != "" && dirPath != "/" && dirPath != rootPath {
+		dirPath, part = path.Split(dirPath)
+		dirPath = path.Clean(dirPath)
+		parts = append([]string{part}, parts...)
+	}
+	return parts
+}
+
+// Generates a title for the video based on path.
+func generateVideoTitle(dirPath, rootPath, prefix string) string {
+	return fmt.Sprintf("[%s] %s", prefix, strings.Join(relativeDirs(dirPath, rootPath), " # "))
+}
+
+// Parses a title template, checking that it expands to a title.
+func parseTitleTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("title").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	sample := TitleData{
+		Prefix:    "GoPro",
+		Dirs:      []string{"Trip", "Day 1"},
+		Name:      "Trip # Day 1",
+		Time:      time.Date(2020, 7, 4, 10, 12, 0, 0, time.Local),
+		Date:      "2020-07-04",
+		Location:  "Place",
+		Camera:    "Helmet",
+		Part:      1,
+		PartCount: 2,
+	}
+	if _, err := executeTitleTemplate(tmpl, sample); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// Returns the characters titles cannot contain, since rendered files are
+// named after them: path separators, and drive separators on Windows.
+func invalidTitleChars() string {
+	if runtime.GOOS == "windows" {
+		return `/\:`
+	}
+	return `/\`
+}
+
+// Expands a title template, rejecting empty titles and titles which are not
+// valid file names.
+func executeTitleTemplate(tmpl *template.Template, data TitleData) (string, error) {
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	title := strings.TrimSpace(b.String())
+	if title == "" {
+		return "", fmt.Errorf("empty title for %s", path.Join(data.Dirs...))
+	}
+	if strings.ContainsAny(title, invalidTitleChars()) {
+		return "", fmt.Errorf("invalid title %q, titles name the rendered files and cannot contain any of %s",
+			title, invalidTitleChars())
+	}
+	return title, nil
+}
+
+// Sets the titles of the parts a video was split into, expanding the title
+// template with the data of each part. Parts keep their number when chapters
+// are added later, all of them show the current part count, see
+// retitleParts.
+func titleParts(tmpl *template.Template, parts []Video, data TitleData) error {
+	for ix := range parts {
+		parts[ix].Part = ix + 1
+		parts[ix].PartCount = len(parts)
+		data.Part = parts[ix].Part
+		data.PartCount = parts[ix].PartCount
+		data.Time = localTime(parts[ix].startTime())
+		data.Date = ""
+		if !data.Time.IsZero() {
+			data.Date = data.Time.Format("2006-01-02")
+		}
+		title, err := executeTitleTemplate(tmpl, data)
+		if err != nil {
+			return fmt.Errorf("Error expanding title template: %v", err)
+		}
+		parts[ix].Title = title
+	}
+	return nil
+}
+
+// Moves the known parts of the videos whose part count changed since they were
+// titled to their new titles, so that a video is not rendered and uploaded
+// again when a part is added to it: their state entries and files in the
+// output directory are renamed, and uploaded ones are marked for their title
+// to be updated on YouTube. Returns the new titles, by old title. With dryRun,
+// nothing is renamed.
+func retitleParts(state *State, videos []Video, outputDir string, dryRun bool) (map[string]string, error) {
+	results := map[string]string{}
+	for _, video := range videos {
+		entry := state.knownPart(video)
+		if entry == nil || entry.Title == video.Title || entry.Video.PartCount == video.PartCount {
+			continue
+		}
+		oldTitle := entry.Title
+		if dryRun {
+			log.Printf(">>> Would rename %s to %s", oldTitle, video.Title)
+			results[oldTitle] = video.Title
+			continue
+		}
+		if ok, err := state.retitle(entry, video); err != nil {
+			return nil, err
+		} else if !ok {
+			warnf(">>> Not renaming %s, %s is known already", oldTitle, video.Title)
+			continue
+		}
+		log.Printf(">>> Renaming %s to %s", oldTitle, video.Title)
+		if err := renameVideoFiles(outputDir, oldTitle, video.Title); err != nil {
+			return nil, err
+		}
+		results[oldTitle] = video.Title
+	}
+	return results, nil
+}
+
+// Renames the files of a video in the output directory, e.g. its render,
+// variants, metadata and stills, after it was re-titled.
+func renameVideoFiles(outputDir, oldTitle, newTitle string) error {
+	for _, dir := range []string{outputDir, filepath.Join(outputDir, StillsDir), filepath.Join(outputDir, ProxiesDir)} {
+		files, err := ioutil.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			name := file.Name()
+			if !strings.HasPrefix(name, oldTitle+".") && name != oldTitle+"-thumb.jpg" {
+				continue
+			}
+			newName := filepath.Join(dir, newTitle+name[len(oldTitle):])
+			if _, err := os.Stat(newName); err == nil {
+				warnf(">>> %s exists already, not renaming %s", newName, name)
+				continue
+			}
+			if err := os.Rename(filepath.Join(dir, name), newName); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"text/template"
+	"time"
+)
+
+func TestExecuteTitleTemplate(t *testing.T) {
+	data := TitleData{
+		Prefix:    "GoPro",
+		Dirs:      []string{"Trip", "Day 1"},
+		Name:      "Trip # Day 1",
+		Time:      time.Date(2020, 7, 4, 10, 12, 0, 0, time.Local),
+		Date:      "2020-07-04",
+		Part:      1,
+		PartCount: 1,
+	}
+	split := data
+	split.Part, split.PartCount = 2, 3
+	for _, test := range []struct {
+		name, text string
+		data       TitleData
+		want       string
+		wantErr    bool
+	}{
+		{name: "default", text: DefaultTitleTemplate, data: data, want: "[GoPro] Trip # Day 1"},
+		{name: "default split", text: DefaultTitleTemplate, data: split, want: "[GoPro] Trip # Day 1 pt 2 of 3"},
+		{name: "parts", text: `{{.Name}} ({{.Part}} of {{.PartCount}})`, data: split, want: "Trip # Day 1 (2 of 3)"},
+		{name: "printf", text: `{{.Name}} {{printf "Part %02d" .Part}}`, data: split, want: "Trip # Day 1 Part 02"},
+		{name: "date", text: `{{.Date}} {{index .Dirs 0}}`, data: data, want: "2020-07-04 Trip"},
+		{name: "time", text: `{{.Time.Format "Jan 2"}}`, data: data, want: "Jul 4"},
+		{name: "trimmed", text: ` {{.Name}} `, data: data, want: "Trip # Day 1"},
+		{name: "empty", text: `{{.Location}}`, data: data, wantErr: true},
+		{name: "slash", text: `{{.Name}} 1/2`, data: data, wantErr: true},
+		{name: "backslash", text: `{{.Name}} 1\2`, data: data, wantErr: true},
+		{name: "unknown field", text: `{{.Folder}}`, data: data, wantErr: true},
+	} {
+		tmpl := template.Must(template.New("title").Option("missingkey=error").Parse(test.text))
+		got, err := executeTitleTemplate(tmpl, test.data)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: executeTitleTemplate() error = %v, want error %v", test.name, err, test.wantErr)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s: executeTitleTemplate() = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+// Returns the parts of a video of Trip/Day 1, one per chapter file.
+func testParts(fileNames ...string) []Video {
+	var parts []Video
+	for _, fileName := range fileNames {
+		parts = append(parts, Video{Path: "/footage/Trip/Day 1", Chapters: []Chapter{{FileName: fileName}}})
+	}
+	return parts
+}
+
+func videoTitles(videos []Video) []string {
+	var results []string
+	for _, video := range videos {
+		results = append(results, video.Title)
+	}
+	return results
+}
+
+func TestTitleParts(t *testing.T) {
+	data := TitleData{Prefix: "GoPro", Name: "Trip # Day 1"}
+	padded := `{{.Name}} Part {{printf "%02d" .Part}} of {{printf "%02d" .PartCount}}`
+	for _, test := range []struct {
+		name, text string
+		parts      []Video
+		want       []string
+	}{
+		{"single", DefaultTitleTemplate, testParts("GX010001.MP4"), []string{"[GoPro] Trip # Day 1"}},
+		{
+			"default totals", DefaultTitleTemplate, testParts("GX010001.MP4", "GX010002.MP4"),
+			[]string{"[GoPro] Trip # Day 1 pt 1 of 2", "[GoPro] Trip # Day 1 pt 2 of 2"},
+		},
+		{
+			"padded", padded, testParts("GX010001.MP4", "GX010002.MP4", "GX010003.MP4"),
+			[]string{"Trip # Day 1 Part 01 of 03", "Trip # Day 1 Part 02 of 03", "Trip # Day 1 Part 03 of 03"},
+		},
+	} {
+		tmpl := template.Must(template.New("title").Option("missingkey=error").Parse(test.text))
+		if err := titleParts(tmpl, test.parts, data); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if got := videoTitles(test.parts); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: titleParts() = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestRetitleParts(t *testing.T) {
+	outputDir := t.TempDir()
+	state, err := loadState(outputDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := template.Must(parseTitleTemplate(DefaultTitleTemplate))
+	data := TitleData{Prefix: "GoPro", Name: "Trip # Day 1"}
+
+	// Two parts, the first rendered and uploaded, the second pending.
+	parts := testParts("GX010001.MP4", "GX010002.MP4")
+	if err := titleParts(tmpl, parts, data); err != nil {
+		t.Fatal(err)
+	}
+	for _, part := range parts {
+		state.enqueue(part)
+	}
+	uploaded := state.Videos[parts[0].Title]
+	uploaded.Status = StatusUploaded
+	uploaded.VideoID = "abc"
+	for _, name := range []string{parts[0].Title + VideoExt, parts[0].Title + ".youtube" + VideoExt, parts[0].Title + "-thumb.jpg", "other.mp4"} {
+		if err := ioutil.WriteFile(filepath.Join(outputDir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Nothing changes while the part count is the same.
+	renamed, err := retitleParts(state, parts, outputDir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(renamed) != 0 {
+		t.Errorf("retitleParts() renamed %v without a new part", renamed)
+	}
+
+	// A third part retitles all of them with the new total.
+	parts = testParts("GX010001.MP4", "GX010002.MP4", "GX010003.MP4")
+	if err := titleParts(tmpl, parts, data); err != nil {
+		t.Fatal(err)
+	}
+	if renamed, err := retitleParts(state, parts, outputDir, true); err != nil || len(renamed) != 2 {
+		t.Errorf("retitleParts() with dryRun = %v, %v, want 2 renames", renamed, err)
+	}
+	if _, ok := state.Videos["[GoPro] Trip # Day 1 pt 1 of 2"]; !ok {
+		t.Errorf("retitleParts() with dryRun changed the state")
+	}
+	renamed, err = retitleParts(state, parts, outputDir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"[GoPro] Trip # Day 1 pt 1 of 2": "[GoPro] Trip # Day 1 pt 1 of 3",
+		"[GoPro] Trip # Day 1 pt 2 of 2": "[GoPro] Trip # Day 1 pt 2 of 3",
+	}
+	if !reflect.DeepEqual(renamed, want) {
+		t.Errorf("retitleParts() = %v, want %v", renamed, want)
+	}
+	for oldTitle, newTitle := range want {
+		if _, ok := state.Videos[oldTitle]; ok {
+			t.Errorf("%s is still in the state", oldTitle)
+		}
+		if entry, ok := state.Videos[newTitle]; !ok || entry.Title != newTitle || entry.Video.PartCount != 3 {
+			t.Errorf("%s is not in the state with 3 parts: %+v", newTitle, entry)
+		}
+	}
+	if entry := state.Videos["[GoPro] Trip # Day 1 pt 1 of 3"]; entry == nil || !entry.TitleOutdated {
+		t.Errorf("uploaded part is not marked for its title to be updated")
+	}
+	if entry := state.Videos["[GoPro] Trip # Day 1 pt 2 of 3"]; entry == nil || entry.TitleOutdated {
+		t.Errorf("part not uploaded yet is marked for its title to be updated")
+	}
+	files, err := ioutil.ReadDir(outputDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, file := range files {
+		names = append(names, file.Name())
+	}
+	wantNames := []string{StateFileName, "[GoPro] Trip # Day 1 pt 1 of 3-thumb.jpg", "[GoPro] Trip # Day 1 pt 1 of 3.mp4",
+		"[GoPro] Trip # Day 1 pt 1 of 3.youtube.mp4", "other.mp4"}
+	if !reflect.DeepEqual(names, wantNames) {
+		t.Errorf("output files = %q, want %q", names, wantNames)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+)
+
+// Where OAuth tokens are cached.
+const (
+	// A JSON file in the config directory, readable only by the user.
+	TokenStoreFile = "file"
+	// The OS keychain: macOS Keychain, Secret Service (through secret-tool) on
+	// Linux or Windows Credential Manager.
+	TokenStoreKeychain = "keychain"
+)
+
+// Service name under which tokens are stored in the keychain; the account is
+// the profile name.
+const keychainService = "gopro-uploader"
+
+type tokenStore interface {
+	load() (*Token, error)
+	save(token *Token) error
+	remove() error
+}
+
+type fileTokenStore struct {
+	fileName string
+}
+
+func (s *fileTokenStore) load() (*Token, error) {
+	return loadToken(s.fileName)
+}
+
+func (s *fileTokenStore) save(token *Token) error {
+	return saveToken(s.fileName, token)
+}
+
+func (s *fileTokenStore) remove() error {
+	if err := os.Remove(s.fileName); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+type keychainTokenStore struct {
+	account string
+	// Token cache used before switching to the keychain, migrated on first use.
+	legacy *fileTokenStore
+}
+
+func (s *keychainTokenStore) load() (*Token, error) {
+	data, err := keychainGet(keychainService, s.account)
+	if err != nil {
+		return s.migrate()
+	}
+	var token Token
+	if err := json.Unmarshal(data, &token); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (s *keychainTokenStore) save(token *Token) error {
+	data, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	return keychainSet(keychainService, s.account, data)
+}
+
+func (s *keychainTokenStore) remove() error {
+	if _, err := keychainGet(keychainService, s.account); err != nil {
+		return nil
+	}
+	return keychainDelete(keychainService, s.account)
+}
+
+// Moves a token cached in a file into the keychain.
+func (s *keychainTokenStore) migrate() (*Token, error) {
+	token, err := s.legacy.load()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.save(token); err != nil {
+		return nil, err
+	}
+	log.Printf(">>> Moved token from %s to the keychain", s.legacy.fileName)
+	if err := os.Remove(s.legacy.fileName); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// Returns the token store selected in the config, and the file locked while
+// refreshing tokens. Falls back to the file cache if no keychain is available.
+func newTokenStore(config *Config) (tokenStore, string, error) {
+	cacheFile, err := tokenCacheFile(config.profile)
+	if err != nil {
+		return nil, "", err
+	}
+	fileStore := &fileTokenStore{fileName: cacheFile}
+	lockFile, err := tokenLockFile(config.profile)
+	if err != nil {
+		return nil, "", err
+	}
+	if config.TokenStore != TokenStoreKeychain {
+		return fileStore, lockFile, nil
+	}
+	if !keychainAvailable() {
+		log.Printf(">>> No keychain available, caching token in %s", cacheFile)
+		return fileStore, lockFile, nil
+	}
+	account := config.profile
+	if account == "" {
+		account = DefaultProfile
+	}
+	return &keychainTokenStore{account: account, legacy: fileStore}, lockFile, nil
+}
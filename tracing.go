@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Interval between two exports of the finished spans.
+const traceExportInterval = 5 * time.Second
+
+// OTLP span kinds and status codes.
+const (
+	spanKindInternal = 1
+	spanKindClient   = 3
+	spanStatusError  = 2
+)
+
+// Exports spans of the run to an OpenTelemetry collector, using OTLP over HTTP
+// with JSON encoding, so that slow stages can be debugged with existing
+// tracing backends.
+type Tracer struct {
+	// URL spans are posted to, e.g. http://localhost:4318/v1/traces.
+	endpoint string
+	headers  map[string]string
+	resource []otlpAttribute
+
+	mu sync.Mutex
+	// Finished spans not exported yet.
+	spans []otlpSpan
+	// Whether an export failed already, so that failures are only logged once.
+	failed  bool
+	stopped chan struct{}
+	once    sync.Once
+}
+
+// Tracer of the run, or nil if tracing is disabled.
+var tracer *Tracer
+
+type otlpAttribute struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+// Returns an attribute holding a string or an integer.
+func newAttribute(key string, value interface{}) otlpAttribute {
+	switch v := value.(type) {
+	case int:
+		return otlpAttribute{Key: key, Value: map[string]interface{}{"intValue": strconv.Itoa(v)}}
+	case int64:
+		return otlpAttribute{Key: key, Value: map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}}
+	case bool:
+		return otlpAttribute{Key: key, Value: map[string]interface{}{"boolValue": v}}
+	}
+	return otlpAttribute{Key: key, Value: map[string]interface{}{"stringValue": fmt.Sprint(value)}}
+}
+
+// Returns the OTLP traces endpoint configured with the standard
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT or OTEL_EXPORTER_OTLP_ENDPOINT
+// environment variables, if any.
+func otlpEndpointFromEnv() string {
+	if endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"); endpoint != "" {
+		return endpoint
+	}
+	if endpoint := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); endpoint != "" {
+		return strings.TrimRight(endpoint, "/") + "/v1/traces"
+	}
+	return ""
+}
+
+// Starts exporting spans to endpoint. Headers (e.g. for authentication) and
+// the service name are read from the standard OTEL_EXPORTER_OTLP_HEADERS and
+// OTEL_SERVICE_NAME environment variables.
+func startTracing(endpoint, profile string) *Tracer {
+	service := os.Getenv("OTEL_SERVICE_NAME")
+	if service == "" {
+		service = "gopro-uploader"
+	}
+	t := &Tracer{
+		endpoint: endpoint,
+		headers:  map[string]string{},
+		resource: []otlpAttribute{
+			newAttribute("service.name", service),
+			newAttribute("gopro_uploader.profile", profile),
+		},
+		stopped: make(chan struct{}),
+	}
+	for _, header := range strings.Split(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"), ",") {
+		if parts := strings.SplitN(header, "=", 2); len(parts) == 2 {
+			t.headers[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+		}
+	}
+	go func() {
+		ticker := time.NewTicker(traceExportInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-t.stopped:
+				return
+			case <-ticker.C:
+				t.export()
+			}
+		}
+	}()
+	fatalHooks = append(fatalHooks, t.stop)
+	return t
+}
+
+// Exports the remaining spans and stops exporting.
+func (t *Tracer) stop() {
+	if t == nil {
+		return
+	}
+	t.once.Do(func() {
+		close(t.stopped)
+		t.export()
+	})
+}
+
+// Posts the finished spans to the collector.
+func (t *Tracer) export() {
+	t.mu.Lock()
+	spans := t.spans
+	t.spans = nil
+	t.mu.Unlock()
+	if len(spans) == 0 {
+		return
+	}
+	err := t.post(spans)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err != nil && !t.failed {
+		warnf(">>> Could not export traces to %s: %v", t.endpoint, err)
+	}
+	t.failed = err != nil
+}
+
+func (t *Tracer) post(spans []otlpSpan) error {
+	data, err := json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{"attributes": t.resource},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]interface{}{"name": "gopro-uploader"},
+				"spans": spans,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", t.endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range t.headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return nil
+}
+
+// A timed operation of the run, e.g. rendering a video.
+type Span struct {
+	tracer   *Tracer
+	traceID  string
+	spanID   string
+	parentID string
+	name     string
+	kind     int
+	start    time.Time
+
+	mu         sync.Mutex
+	attributes []otlpAttribute
+}
+
+type spanKey struct{}
+
+// Returns a random hex encoded ID of n bytes.
+func randomID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Starts a span, child of the one of ctx if any, with attributes given as
+// key and value pairs. Returns a context holding the span, and the span, nil
+// if tracing is disabled.
+func startSpan(ctx context.Context, name string, attributes ...interface{}) (context.Context, *Span) {
+	return startSpanKind(ctx, name, spanKindInternal, attributes...)
+}
+
+func startSpanKind(ctx context.Context, name string, kind int, attributes ...interface{}) (context.Context, *Span) {
+	if tracer == nil {
+		return ctx, nil
+	}
+	span := &Span{tracer: tracer, spanID: randomID(8), name: name, kind: kind, start: time.Now()}
+	if parent, ok := ctx.Value(spanKey{}).(*Span); ok {
+		span.traceID = parent.traceID
+		span.parentID = parent.spanID
+	} else {
+		span.traceID = randomID(16)
+	}
+	for ix := 0; ix+1 < len(attributes); ix += 2 {
+		span.setAttribute(fmt.Sprint(attributes[ix]), attributes[ix+1])
+	}
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// Sets an attribute of the span, e.g. the ID of an uploaded video.
+func (s *Span) setAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attributes = append(s.attributes, newAttribute(key, value))
+}
+
+// Ends the span, marking it as failed if err is set.
+func (s *Span) end(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	span := otlpSpan{
+		TraceID:           s.traceID,
+		SpanID:            s.spanID,
+		ParentSpanID:      s.parentID,
+		Name:              s.name,
+		Kind:              s.kind,
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(time.Now().UnixNano(), 10),
+		Attributes:        s.attributes,
+	}
+	s.mu.Unlock()
+	if err != nil {
+		span.Status = otlpStatus{Code: spanStatusError, Message: err.Error()}
+	}
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	s.tracer.spans = append(s.tracer.spans, span)
+}
+
+// HTTP transport recording a client span per request, e.g. YouTube API calls.
+type tracingTransport struct {
+	base http.RoundTripper
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// The query, holding upload session IDs, is left out.
+	_, span := startSpanKind(req.Context(), req.Method+" "+req.URL.Host, spanKindClient,
+		"http.method", req.Method, "url.path", req.URL.Path)
+	resp, err := t.base.RoundTrip(req)
+	spanErr := err
+	if err == nil {
+		span.setAttribute("http.status_code", resp.StatusCode)
+		if resp.StatusCode >= 400 {
+			spanErr = fmt.Errorf("%s", resp.Status)
+		}
+	}
+	span.end(spanErr)
+	return resp, err
+}
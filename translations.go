@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"sort"
+)
+
+// Translated title and description of uploaded videos, in a language other
+// than Config.Language, shown to viewers using YouTube in that language.
+type Translation struct {
+	// Template of the title, see DescriptionData, e.g. "{{.Title}} (Deutsch)".
+	// The original title if empty.
+	Title string `json:"title"`
+	// Path to a template file of the description, see DescriptionData. The
+	// original description if empty.
+	DescriptionTemplate string `json:"description_template"`
+	// Contents of the DescriptionTemplate file.
+	descriptionTemplate string
+}
+
+// Reads the description templates of translations.
+func loadTranslations(translations map[string]*Translation) error {
+	for language, translation := range translations {
+		if translation == nil || translation.DescriptionTemplate == "" {
+			continue
+		}
+		text, err := ioutil.ReadFile(translation.DescriptionTemplate)
+		if err != nil {
+			return fmt.Errorf("invalid %s translation: %v", language, err)
+		}
+		translation.descriptionTemplate = string(text)
+	}
+	return nil
+}
+
+func validateTranslations(language string, translations map[string]*Translation) error {
+	if language != "" && !languageTagRegex.MatchString(language) {
+		return fmt.Errorf("invalid language %q, expected e.g. en or pt-BR", language)
+	}
+	if len(translations) > 0 && language == "" {
+		return fmt.Errorf("translations need the language of videos to be set")
+	}
+	for tag, translation := range translations {
+		if !languageTagRegex.MatchString(tag) {
+			return fmt.Errorf("invalid translation language %q, expected e.g. en or pt-BR", tag)
+		}
+		if tag == language {
+			return fmt.Errorf("invalid translation language %q, videos are already in it", tag)
+		}
+		if translation == nil {
+			return fmt.Errorf("invalid %s translation: empty", tag)
+		}
+		for _, text := range []string{translation.Title, translation.descriptionTemplate} {
+			if _, err := executeDescriptionTemplate(context.Background(), text, Video{}); err != nil {
+				return fmt.Errorf("invalid %s translation: %v", tag, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Returns the translated titles and descriptions of a video, by language.
+// Templates which fail to expand fall back to the original title or
+// description, with a warning.
+func videoLocalizations(ctx context.Context, config *Config, video Video) map[string]YouTubeLocalization {
+	if len(config.Translations) == 0 {
+		return nil
+	}
+	title, description := youtubeTitle(video.Title), youtubeDescription(videoDescription(ctx, video))
+	var languages []string
+	for language := range config.Translations {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages)
+	localizations := map[string]YouTubeLocalization{}
+	for _, language := range languages {
+		translation := config.Translations[language]
+		localization := YouTubeLocalization{Title: title, Description: description}
+		if translation.Title != "" {
+			if text, err := executeDescriptionTemplate(ctx, translation.Title, video); err != nil {
+				warnf(">>> Could not expand %s title of %s: %v", language, video.Title, err)
+			} else {
+				localization.Title = youtubeTitle(text)
+			}
+		}
+		if translation.descriptionTemplate != "" {
+			if text, err := executeDescriptionTemplate(ctx, translation.descriptionTemplate, video); err != nil {
+				warnf(">>> Could not expand %s description of %s: %v", language, video.Title, err)
+			} else {
+				localization.Description = youtubeDescription(text)
+			}
+		}
+		localizations[language] = localization
+	}
+	return localizations
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+	"time"
+)
+
+// Defaults of idle footage trimming.
+const (
+	DefaultIdleSpeed    = 3.0
+	DefaultIdleDuration = time.Minute
+	DefaultIdleMargin   = 5 * time.Second
+)
+
+// Cuts idle footage, e.g. minutes of standing around at a chairlift, from
+// chapters: stretches where the ground speed of the telemetry stays below
+// MinSpeed. Chapters are cut with the inpoint and outpoint directives of the
+// concat demuxer, so footage is still copied, and cuts fall on keyframes.
+type IdleTrim struct {
+	// Ground speed under which footage is idle, in km/h, DefaultIdleSpeed if
+	// zero.
+	MinSpeed float64 `json:"min_speed"`
+	// Shortest idle stretch cut, e.g. "2m", DefaultIdleDuration if empty.
+	MinDuration string `json:"min_duration"`
+	// Footage kept before and after activity, DefaultIdleMargin if empty.
+	Margin string `json:"margin"`
+}
+
+func (t *IdleTrim) validate() error {
+	if t == nil {
+		return nil
+	}
+	if t.MinSpeed < 0 {
+		return fmt.Errorf("invalid idle trim speed %v", t.MinSpeed)
+	}
+	_, _, err := t.durations()
+	return err
+}
+
+// Returns the shortest idle stretch cut, and the margin kept around activity.
+func (t *IdleTrim) durations() (time.Duration, time.Duration, error) {
+	minDuration, margin := DefaultIdleDuration, DefaultIdleMargin
+	if t.MinDuration != "" {
+		duration, err := time.ParseDuration(t.MinDuration)
+		if err != nil || duration <= 0 {
+			return 0, 0, fmt.Errorf("invalid idle trim duration %q", t.MinDuration)
+		}
+		minDuration = duration
+	}
+	if t.Margin != "" {
+		duration, err := time.ParseDuration(t.Margin)
+		if err != nil || duration < 0 {
+			return 0, 0, fmt.Errorf("invalid idle trim margin %q", t.Margin)
+		}
+		margin = duration
+	}
+	return minDuration, margin, nil
+}
+
+// A stretch of a chapter file, from its start.
+type footageRange struct {
+	Start, End time.Duration
+}
+
+// Returns the idle stretches of a chapter according to its GPS samples, with
+// the margins kept.
+func (t *IdleTrim) idleRanges(samples []GPSSample, duration time.Duration) []footageRange {
+	minDuration, margin, _ := t.durations()
+	minSpeed := t.MinSpeed
+	if minSpeed == 0 {
+		minSpeed = DefaultIdleSpeed
+	}
+	var ranges []footageRange
+	start := -1
+	flush := func(end time.Duration) {
+		if start < 0 {
+			return
+		}
+		idle := footageRange{samples[start].Offset, end}
+		start = -1
+		// The start and end of the chapter need no margin.
+		if idle.Start > 0 {
+			idle.Start += margin
+		}
+		if idle.End < duration {
+			idle.End -= margin
+		}
+		if idle.End-idle.Start >= minDuration {
+			ranges = append(ranges, idle)
+		}
+	}
+	for ix, sample := range samples {
+		if sample.Speed2D*3.6 < minSpeed {
+			if start < 0 {
+				start = ix
+			}
+			continue
+		}
+		flush(sample.Offset)
+	}
+	flush(duration)
+	if len(ranges) > 0 && len(samples) > 0 && ranges[0].Start == samples[0].Offset {
+		// Samples start after the first payload, the chapter is idle from
+		// its start.
+		ranges[0].Start = 0
+	}
+	return ranges
+}
+
+// Returns the chapters with their idle stretches cut: a chapter is split into
+// the segments kept around them, and dropped if it is idle throughout.
+// Chapters without GPS are kept whole. Cuts are logged.
+func (t *IdleTrim) trim(ctx context.Context, dirPath string, chapters []Chapter) []Chapter {
+	if t == nil {
+		return chapters
+	}
+	var results []Chapter
+	for _, chapter := range chapters {
+		telemetry, err := fetchTelemetry(ctx, dirPath, chapter)
+		if err != nil {
+			warnf(">>> Could not read telemetry of %s, not trimming it: %v", path.Join(dirPath, chapter.FileName), err)
+			results = append(results, chapter)
+			continue
+		}
+		// Chapters cut in their sidecar are only trimmed within the stretch
+		// kept, with offsets from its start.
+		var samples []GPSSample
+		for _, sample := range telemetry.GPS {
+			if sample.Offset >= chapter.InPoint && sample.Offset < chapter.InPoint+chapter.Duration {
+				sample.Offset -= chapter.InPoint
+				samples = append(samples, sample)
+			}
+		}
+		idle := t.idleRanges(samples, chapter.Duration)
+		if len(idle) == 0 {
+			results = append(results, chapter)
+			continue
+		}
+		var cut time.Duration
+		var cuts []string
+		var position time.Duration
+		for ix, r := range append(idle, footageRange{chapter.Duration, chapter.Duration}) {
+			if r.Start > position {
+				segment := chapter
+				segment.InPoint = chapter.InPoint + position
+				segment.Duration = r.Start - position
+				if r.Start < chapter.Duration {
+					segment.OutPoint = chapter.InPoint + r.Start
+				}
+				segment.FileDuration = chapter.fileDuration()
+				segment.CreateTime = chapter.CreateTime.Add(position)
+				segment.Size = int64(float64(chapter.Size) * float64(segment.Duration) / float64(chapter.Duration))
+				results = append(results, segment)
+			}
+			if ix < len(idle) {
+				cut += r.End - r.Start
+				cuts = append(cuts, fmtDurationForYouTube(chapter.InPoint+r.Start)+"-"+fmtDurationForYouTube(chapter.InPoint+r.End))
+			}
+			position = r.End
+		}
+		log.Printf(">>> Trimmed %s of idle footage from %s: %s", cut.Round(time.Second),
+			path.Join(dirPath, chapter.FileName), strings.Join(cuts, ", "))
+	}
+	return results
+}
+
+// Returns the chapters with their inpoints moved back to the keyframe before
+// them. With stream copy, the concat demuxer outputs the footage from that
+// keyframe on, so chapter offsets only add up to the rendered video this way.
+func snapToKeyframes(dirPath string, chapters []Chapter) []Chapter {
+	tracks := map[string]*mp4Track{}
+	for ix, chapter := range chapters {
+		if chapter.InPoint == 0 {
+			continue
+		}
+		track, ok := tracks[chapter.FileName]
+		if !ok {
+			fileName := path.Join(dirPath, chapter.FileName)
+			info, err := readMP4Info(fileName)
+			if err != nil {
+				debugf(">>> Could not read keyframes of %s: %v", fileName, err)
+			} else {
+				for jx := range info.Tracks {
+					if info.Tracks[jx].Handler == "vide" {
+						track = &info.Tracks[jx]
+						break
+					}
+				}
+			}
+			tracks[chapter.FileName] = track
+		}
+		if track == nil {
+			continue
+		}
+		delta := chapter.InPoint - track.keyframeBefore(chapter.InPoint)
+		chapters[ix].InPoint -= delta
+		chapters[ix].Duration += delta
+		chapters[ix].CreateTime = chapter.CreateTime.Add(-delta)
+	}
+	return chapters
+}
+
+// Returns the length of the file of a chapter, which may be longer than the
+// chapter if idle footage was cut.
+func (c Chapter) fileDuration() time.Duration {
+	if c.FileDuration > 0 {
+		return c.FileDuration
+	}
+	return c.Duration
+}
+
+// Returns the concat demuxer list entry of a file, with the inpoint and
+// outpoint of trimmed chapters.
+func concatEntry(fileName string, chapter Chapter) string {
+	entry := fmt.Sprintf("file '%s'", fileName)
+	if chapter.InPoint > 0 {
+		entry += fmt.Sprintf("\ninpoint %.3f", chapter.InPoint.Seconds())
+	}
+	if chapter.OutPoint > 0 {
+		entry += fmt.Sprintf("\noutpoint %.3f", chapter.OutPoint.Seconds())
+	}
+	return entry
+}
+
+// Generates a description block noting the footage cut from chapters, idle or
+// cut in their sidecar.
+func generateTrimsDescription(chapters []Chapter) string {
+	var files []string
+	segments := map[string][]Chapter{}
+	for _, chapter := range chapters {
+		if chapter.InPoint == 0 && chapter.OutPoint == 0 {
+			continue
+		}
+		if _, ok := segments[chapter.FileName]; !ok {
+			files = append(files, chapter.FileName)
+		}
+		segments[chapter.FileName] = append(segments[chapter.FileName], chapter)
+	}
+	if len(files) == 0 {
+		return ""
+	}
+	lines := []string{"Footage was cut:"}
+	for _, file := range files {
+		var cuts []string
+		var end time.Duration
+		for ix, segment := range segments[file] {
+			if segment.InPoint > end {
+				cuts = append(cuts, fmtDurationForYouTube(end)+"-"+fmtDurationForYouTube(segment.InPoint))
+			}
+			end = segment.InPoint + segment.Duration
+			if ix == len(segments[file])-1 && segment.OutPoint > 0 {
+				cuts = append(cuts, fmtDurationForYouTube(segment.OutPoint)+" to the end")
+			}
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s", file, strings.Join(cuts, ", ")))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+	"time"
+)
+
+// Interval between two checks of the processing status of an upload.
+const verifyPollInterval = 30 * time.Second
+
+// Maximum difference tolerated between the local and reported durations;
+// YouTube reports durations rounded to the second.
+const verifyDurationSlack = 2 * time.Second
+
+// Builds the YouTube metadata of a video.
+func videoMetadata(ctx context.Context, state *State, config *Config, video Video) (*YouTubeVideo, error) {
+	entry := state.video(video)
+	metadata := &YouTubeVideo{
+		Snippet: &YouTubeVideoSnippet{
+			Title:       youtubeTitle(video.Title),
+			Description: youtubeDescription(videoDescription(ctx, video)),
+			Tags:        video.Tags,
+		},
+		Status:        &YouTubeVideoStatus{PrivacyStatus: video.Privacy},
+		Localizations: videoLocalizations(ctx, config, video),
+	}
+	metadata.Snippet.DefaultLanguage = config.Language
+	if metadata.Status.PrivacyStatus == "" {
+		metadata.Status.PrivacyStatus = config.Privacy
+	}
+	if start := video.startTime(); !start.IsZero() {
+		metadata.RecordingDetails = &YouTubeRecordingDetails{
+			RecordingDate: start.UTC().Format(time.RFC3339),
+		}
+		if config.RecordLocation {
+			fix, err := firstGPSFix(ctx, video)
+			if err != nil {
+				return nil, err
+			}
+			if fix != nil {
+				metadata.RecordingDetails.Location = config.LocationPrivacy.apply(*fix)
+				if metadata.RecordingDetails.Location == nil {
+					log.Printf(">>> Recording location of %s is private, leaving it out", video.Title)
+				}
+			}
+		}
+	}
+	if config.PublishSchedule != nil {
+		if entry.PublishAt.IsZero() {
+			slot := config.PublishSchedule.nextSlot(time.Now(), state.lastPublishAt())
+			if err := state.update(func() { entry.PublishAt = slot }); err != nil {
+				return nil, err
+			}
+		}
+		// Scheduled videos must be private until their publishing time.
+		metadata.Status.PrivacyStatus = "private"
+		metadata.Status.PublishAt = entry.PublishAt.UTC().Format(time.RFC3339)
+		log.Printf(">>> Scheduled to be published at %s", entry.PublishAt.Format(time.RFC1123))
+	}
+	return metadata, nil
+}
+
+// Uploads a rendered video to YouTube and records the outcome in state.
+func uploadVideo(ctx context.Context, yt *YouTube, state *State, config *Config, video Video, outputDir string) error {
+	entry := state.video(video)
+	if entry.TitleOutdated && entry.VideoID != "" {
+		log.Printf(">>> Updating title of https://youtu.be/%s", entry.VideoID)
+		if err := yt.updateTitle(ctx, entry.VideoID, youtubeTitle(video.Title)); err != nil {
+			return err
+		}
+		if err := state.update(func() { entry.TitleOutdated = false }); err != nil {
+			return err
+		}
+	}
+	if entry.Status == StatusUploaded {
+		log.Printf(">>> Already uploaded as %s.. skipping..", entry.VideoID)
+		uploadCaptions(ctx, yt, state, config, entry, outputDir)
+		return nil
+	}
+
+	if entry.Status != StatusProcessing && entry.Status != StatusFailed {
+		uploads, err := yt.channelVideos(ctx)
+		if err != nil {
+			return err
+		}
+		if id, ok := uploads[youtubeTitle(video.Title)]; ok {
+			log.Printf(">>> Already on the channel as https://youtu.be/%s", id)
+			if err := state.update(func() {
+				entry.Status = StatusProcessing
+				entry.VideoID = id
+			}); err != nil {
+				return err
+			}
+		}
+	}
+
+	fileName := renderedFile(outputDir, video.Title, config.uploadVariant(video))
+	if entry.Status != StatusProcessing {
+		metadata, err := videoMetadata(ctx, state, config, video)
+		if err != nil {
+			return err
+		}
+		// Variants are rendered by other tools, only the checksum of the master
+		// is known.
+		checksum := ""
+		if fileName == filepath.Join(outputDir, video.Title+VideoExt) {
+			checksum = entry.Checksum
+		}
+		log.Printf(">>> Uploading %s", fileName)
+		result, err := yt.upload(ctx, fileName, metadata, checksum)
+		if err != nil {
+			return err
+		}
+		log.Printf(">>> Uploaded https://youtu.be/%s", result.ID)
+		if yt.uploads != nil {
+			yt.uploads[youtubeTitle(video.Title)] = result.ID
+		}
+		if err := state.update(func() {
+			entry.Status = StatusProcessing
+			entry.VideoID = result.ID
+			entry.Error = ""
+			entry.setCopy(VideoCopy{Destination: CopyYouTube, ID: result.ID, Checksum: checksum, Time: time.Now()})
+		}); err != nil {
+			return err
+		}
+	}
+	uploadCaptions(ctx, yt, state, config, entry, outputDir)
+	return verifyUpload(ctx, yt, state, config, entry, uploadedDuration(fileName, video))
+}
+
+// Returns the duration of the uploaded file, as measured from its moov box.
+// Stream copied cuts start at keyframes, so the sum of the chapters is only
+// used when the file cannot be read, e.g. once cleaned up.
+func uploadedDuration(fileName string, video Video) time.Duration {
+	info, err := readMP4Info(fileName)
+	if err != nil {
+		debugf(">>> Could not read duration of %s: %v", fileName, err)
+		return video.renderedDuration()
+	}
+	return info.Duration
+}
+
+// Waits for YouTube to process an upload, and only then marks it as uploaded.
+// Videos still processing after the configured timeout are checked again on
+// the next run.
+func verifyUpload(ctx context.Context, yt *YouTube, state *State, config *Config, entry *VideoState, duration time.Duration) error {
+	timeout, _ := time.ParseDuration(config.VerifyTimeout)
+	deadline := time.Now().Add(timeout)
+	log.Printf(">>> Waiting for YouTube to process %s", entry.VideoID)
+	for {
+		result, err := yt.video(ctx, entry.VideoID)
+		if err != nil {
+			return err
+		}
+		if reason := verificationFailure(result, duration); reason != "" {
+			warnf(">>> Upload of %s failed: %s", entry.Title, reason)
+			return state.update(func() {
+				entry.Status = StatusFailed
+				entry.Error = reason
+			})
+		}
+		if result.ProcessingDetails != nil && result.ProcessingDetails.ProcessingStatus == "succeeded" {
+			log.Printf(">>> Processed https://youtu.be/%s", entry.VideoID)
+			return state.update(func() { entry.Status = StatusUploaded })
+		}
+		if time.Now().Add(verifyPollInterval).After(deadline) {
+			log.Printf(">>> Still processing, will check again on next run..")
+			return nil
+		}
+		if err := sleepContext(ctx, verifyPollInterval); err != nil {
+			return err
+		}
+	}
+}
+
+// Returns why an upload should be considered failed, if it should, given the
+// duration of the uploaded file.
+func verificationFailure(result *YouTubeVideo, local time.Duration) string {
+	if result == nil {
+		return "video not found on YouTube"
+	}
+	if result.Status != nil {
+		switch result.Status.UploadStatus {
+		case "failed":
+			return "upload failed: " + result.Status.FailureReason
+		case "rejected":
+			return "upload rejected: " + result.Status.RejectionReason
+		case "deleted":
+			return "video was deleted"
+		}
+	}
+	if result.ProcessingDetails != nil {
+		switch result.ProcessingDetails.ProcessingStatus {
+		case "failed", "terminated":
+			return "processing failed: " + result.ProcessingDetails.ProcessingFailureReason
+		case "succeeded":
+			if result.ContentDetails == nil {
+				break
+			}
+			reported, err := parseYouTubeDuration(result.ContentDetails.Duration)
+			if err != nil {
+				return err.Error()
+			}
+			if reported < local-verifyDurationSlack || reported > local+verifyDurationSlack {
+				return fmt.Sprintf("duration mismatch: YouTube reports %v, rendered %v",
+					reported, local.Round(time.Second))
+			}
+		}
+	}
+	return ""
+}
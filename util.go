@@ -1,19 +1,53 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
 	"log"
+	"os"
 	"os/exec"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 )
 
-// Verifies if dependencies are installed and fails otherwise.
+// Verifies if dependencies are installed, and recent enough for ffmpeg and
+// ffprobe, and fails otherwise.
 func checkDependencies(commands ...string) {
 	for _, dep := range commands {
-		if _, err := exec.LookPath(dep); err != nil {
-			log.Fatalf("Could not find missing dependency %v :%v\n", dep, err)
+		if _, err := exec.LookPath(binaryPath(dep)); err != nil {
+			fatalf("Could not find missing dependency %v :%v\n", binaryPath(dep), err)
+		}
+		if dep == "ffmpeg" || dep == "ffprobe" {
+			if _, err := checkFFmpegVersion(dep); err != nil {
+				fatal(err)
+			}
 		}
 	}
 }
 
+// Whether the standard input is a terminal, i.e. someone can answer prompts.
+func isInteractive() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Opens a URL in the default browser.
+func openBrowser(url string) error {
+	switch runtime.GOOS {
+	case "darwin":
+		return exec.Command("open", url).Start()
+	case "windows":
+		return exec.Command("rundll32", "url.dll,FileProtocolHandler", url).Start()
+	default:
+		return exec.Command("xdg-open", url).Start()
+	}
+}
+
 // Verifies if an element is present in a list.
 func contains(list []string, elem string) bool {
 	for _, i := range list {
@@ -23,3 +57,108 @@ func contains(list []string, elem string) bool {
 	}
 	return false
 }
+
+// Parses a size like 500K, 2M or 1.5G (powers of 1024) into bytes.
+func parseByteSize(spec string) (int64, error) {
+	units := map[string]float64{"": 1, "K": 1 << 10, "M": 1 << 20, "G": 1 << 30, "T": 1 << 40}
+	s := strings.ToUpper(strings.TrimSpace(spec))
+	s = strings.TrimSuffix(s, "B")
+	unit := ""
+	if s != "" && strings.ContainsAny(s[len(s)-1:], "KMGT") {
+		unit = s[len(s)-1:]
+		s = s[:len(s)-1]
+	}
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("Error parsing size: %s", spec)
+	}
+	return int64(value * units[unit]), nil
+}
+
+// Parses a timestamp like 90, 12:30 or 1:02:03.5 into a duration.
+func parseTimestamp(spec string) (time.Duration, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("Error parsing timestamp: %s", spec)
+	}
+	var d time.Duration
+	for ix, part := range parts {
+		value, err := strconv.ParseFloat(part, 64)
+		if err != nil || value < 0 {
+			return 0, fmt.Errorf("Error parsing timestamp: %s", spec)
+		}
+		if ix < len(parts)-1 && value != float64(int64(value)) {
+			return 0, fmt.Errorf("Error parsing timestamp: %s", spec)
+		}
+		d = d*60 + time.Duration(value*float64(time.Second))
+	}
+	return d, nil
+}
+
+// Repeatable flag, e.g. holding input directories or glob patterns.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ", ")
+}
+
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// Parses flags which may be interspersed with positional arguments, and
+// returns the positional arguments.
+func parseInterspersed(flags *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		flags.Parse(args)
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// Sleeps for the given duration, unless the context is done first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Returns a context canceled on the first interrupt, so that ffmpeg is
+// stopped and temporary files cleaned up. A second interrupt exits right
+// away.
+func interruptContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-signals:
+			log.Printf(">>> Interrupted, stopping..")
+			cancel()
+			signal.Stop(signals)
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// Returns a context with the given deadline, e.g. "6h", or without one if
+// empty.
+func withStageTimeout(ctx context.Context, timeout string) (context.Context, context.CancelFunc) {
+	d, err := time.ParseDuration(timeout)
+	if timeout == "" || err != nil {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, d)
+}
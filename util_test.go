@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseByteSize(t *testing.T) {
+	for _, test := range []struct {
+		spec    string
+		want    int64
+		wantErr bool
+	}{
+		{spec: "0", want: 0},
+		{spec: "1024", want: 1024},
+		{spec: "500K", want: 500 << 10},
+		{spec: "2M", want: 2 << 20},
+		{spec: "2mb", want: 2 << 20},
+		{spec: "1.5G", want: 3 << 29},
+		{spec: " 1T ", want: 1 << 40},
+		{spec: "10B", want: 10},
+		{spec: "", wantErr: true},
+		{spec: "M", wantErr: true},
+		{spec: "-1G", wantErr: true},
+		{spec: "2P", wantErr: true},
+	} {
+		got, err := parseByteSize(test.spec)
+		if (err != nil) != test.wantErr {
+			t.Errorf("parseByteSize(%q) error = %v, want error %v", test.spec, err, test.wantErr)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("parseByteSize(%q) = %d, want %d", test.spec, got, test.want)
+		}
+	}
+}
+
+func TestParseTimestamp(t *testing.T) {
+	for _, test := range []struct {
+		spec    string
+		want    time.Duration
+		wantErr bool
+	}{
+		{spec: "90", want: 90 * time.Second},
+		{spec: "2.5", want: 2500 * time.Millisecond},
+		{spec: "12:30", want: 12*time.Minute + 30*time.Second},
+		{spec: "1:02:03.5", want: time.Hour + 2*time.Minute + 3500*time.Millisecond},
+		{spec: "0:00", want: 0},
+		{spec: "", wantErr: true},
+		{spec: "1:2:3:4", wantErr: true},
+		{spec: "1.5:00", wantErr: true},
+		{spec: "-1", wantErr: true},
+		{spec: "1:ab", wantErr: true},
+	} {
+		got, err := parseTimestamp(test.spec)
+		if (err != nil) != test.wantErr {
+			t.Errorf("parseTimestamp(%q) error = %v, want error %v", test.spec, err, test.wantErr)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("parseTimestamp(%q) = %v, want %v", test.spec, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Defaults of the watermark settings.
+const (
+	DefaultWatermarkOpacity = 0.8
+	DefaultWatermarkSize    = 0.15
+)
+
+// Where the watermark of a video is applied, if it is not to a variant.
+const watermarkMaster = "master"
+
+// A logo overlaid on uploaded videos. It can only be applied when they are
+// reencoded, e.g. with an encode profile: footage copied as recorded is left
+// alone.
+type Watermark struct {
+	// PNG image, with transparency if any.
+	File string `json:"file"`
+	// One of PiPTopLeft, PiPTopRight, PiPBottomLeft or PiPBottomRight
+	// (default).
+	Corner string `json:"corner"`
+	// Opacity from 0 to 1, DefaultWatermarkOpacity if zero.
+	Opacity float64 `json:"opacity"`
+	// Width of the logo, as a fraction of the video width,
+	// DefaultWatermarkSize if zero.
+	Size float64 `json:"size"`
+}
+
+func (w *Watermark) validate() error {
+	if w == nil {
+		return nil
+	}
+	if _, err := os.Stat(w.File); err != nil {
+		return fmt.Errorf("Could not read watermark: %v", err)
+	}
+	if _, ok := pipCorners[w.Corner]; !ok && w.Corner != "" {
+		return fmt.Errorf("invalid watermark corner %q, expected %s, %s, %s or %s", w.Corner,
+			PiPTopLeft, PiPTopRight, PiPBottomLeft, PiPBottomRight)
+	}
+	if w.Opacity < 0 || w.Opacity > 1 {
+		return fmt.Errorf("invalid watermark opacity %v, expected 0 to 1", w.Opacity)
+	}
+	if w.Size < 0 || w.Size >= 1 {
+		return fmt.Errorf("invalid watermark size %v, expected a fraction of the width, e.g. 0.15", w.Size)
+	}
+	return nil
+}
+
+// Returns the filtergraph overlaying the watermark on the input label of a
+// video of the given width, e.g. "[in]", into the output label.
+func (w *Watermark) graph(input, output string, width int) string {
+	corner, opacity, size := w.Corner, w.Opacity, w.Size
+	if corner == "" {
+		corner = PiPBottomRight
+	}
+	if opacity == 0 {
+		opacity = DefaultWatermarkOpacity
+	}
+	if size == 0 {
+		size = DefaultWatermarkSize
+	}
+	return fmt.Sprintf("movie=%s,format=rgba,colorchannelmixer=aa=%g,scale=%d:-2[wm];%s[wm]overlay=%s%s",
+		escapeFilterPath(w.File), opacity, int(float64(width)*size)/2*2, input,
+		fmt.Sprintf(pipCorners[corner], width/50), output)
+}
+
+// Returns which encode of a video applies its watermark: the last one of the
+// file uploaded, that is the variant uploaded, or watermarkMaster. Empty if
+// the uploaded file is not reencoded, or is a 360 video.
+func (c *Config) watermarkStage(video Video) string {
+	if c.Watermark == nil || video.is360() {
+		return ""
+	}
+	if len(video.Overlay) > 0 {
+		return VariantPiP
+	}
+	if c.encodesVariant(video) {
+		return c.EncodeProfile
+	}
+	if encoderArgs, _ := c.masterEncoder(video); encoderArgs != nil {
+		return watermarkMaster
+	}
+	return ""
+}
+
+// Returns the width of the rendered video, the conform one if conformed.
+func (c *Config) renderedWidth(video Video) int {
+	if c.Conform.appliesTo(video) {
+		return c.Conform.Width
+	}
+	if len(video.Chapters) > 0 && video.Chapters[0].Resolution.Width > 0 {
+		return video.Chapters[0].Resolution.Width
+	}
+	return 1920
+}
+
+// Returns the -vf argument of the master of a video, given the filters
+// applied to it, nil if there are none.
+func (c *Config) masterVideoFilters(video Video, filters []string) []string {
+	if c.watermarkStage(video) == watermarkMaster {
+		chain := "null"
+		if len(filters) > 0 {
+			chain = strings.Join(filters, ",")
+		}
+		return []string{"-vf", "[in]" + chain + "[base];" + c.Watermark.graph("[base]", "[out]", c.renderedWidth(video))}
+	}
+	if c.Watermark != nil && c.watermarkStage(video) == "" {
+		warnf(">>> Watermark not applied to %s, which is not reencoded; set encode_profile to reencode it", video.Title)
+	}
+	if len(filters) == 0 {
+		return nil
+	}
+	return []string{"-vf", strings.Join(filters, ",")}
+}
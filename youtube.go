@@ -1,10 +1,35 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
 	"time"
 )
 
+const (
+	youTubeAPIURL      = "https://www.googleapis.com/youtube/v3"
+	youTubeUploadURL   = "https://www.googleapis.com/upload/youtube/v3/videos"
+	youTubeCaptionsURL = "https://www.googleapis.com/upload/youtube/v3/captions"
+)
+
+// ISO 8601 durations as reported by the API, e.g. PT1H2M3S or P1DT2H. At
+// least one component must follow P, and T when present.
+var youTubeDurationRegex = regexp.MustCompile(`^P(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
 // Format duration in a way YouTube understands.
 func fmtDurationForYouTube(d time.Duration) string {
 	num_hours := int64(d.Hours())
@@ -12,3 +37,538 @@ func fmtDurationForYouTube(d time.Duration) string {
 	num_seconds := int64(d.Seconds())
 	return fmt.Sprintf("%01d:%02d:%02d", num_hours, num_minutes-60*num_hours, num_seconds-60*num_minutes)
 }
+
+// Parses a duration as reported by the YouTube API.
+func parseYouTubeDuration(spec string) (time.Duration, error) {
+	match := youTubeDurationRegex.FindStringSubmatch(spec)
+	if last := len(spec) - 1; match == nil || spec[last] == 'P' || spec[last] == 'T' {
+		return 0, fmt.Errorf("Error parsing duration: %s", spec)
+	}
+	var d time.Duration
+	for ix, unit := range []time.Duration{24 * time.Hour, time.Hour, time.Minute, time.Second} {
+		if match[ix+1] == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(match[ix+1], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("Error parsing duration: %s", spec)
+		}
+		d += time.Duration(n) * unit
+	}
+	return d, nil
+}
+
+// Subset of the YouTube video resource used by the uploader.
+// https://developers.google.com/youtube/v3/docs/videos#resource
+type YouTubeVideo struct {
+	ID               string                   `json:"id,omitempty"`
+	Snippet          *YouTubeVideoSnippet     `json:"snippet,omitempty"`
+	Status           *YouTubeVideoStatus      `json:"status,omitempty"`
+	RecordingDetails *YouTubeRecordingDetails `json:"recordingDetails,omitempty"`
+	// Translated titles and descriptions, by language.
+	Localizations map[string]YouTubeLocalization `json:"localizations,omitempty"`
+	// Read-only parts, returned by videos.list.
+	ContentDetails    *YouTubeContentDetails    `json:"contentDetails,omitempty"`
+	ProcessingDetails *YouTubeProcessingDetails `json:"processingDetails,omitempty"`
+}
+
+type YouTubeVideoSnippet struct {
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	Tags        []string `json:"tags,omitempty"`
+	// Language of the title and description, needed by localizations.
+	DefaultLanguage string `json:"defaultLanguage,omitempty"`
+}
+
+type YouTubeLocalization struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+}
+
+type YouTubeVideoStatus struct {
+	PrivacyStatus   string `json:"privacyStatus"`
+	PublishAt       string `json:"publishAt,omitempty"`
+	UploadStatus    string `json:"uploadStatus,omitempty"`
+	FailureReason   string `json:"failureReason,omitempty"`
+	RejectionReason string `json:"rejectionReason,omitempty"`
+}
+
+type YouTubeContentDetails struct {
+	Duration string `json:"duration"`
+}
+
+type YouTubeProcessingDetails struct {
+	ProcessingStatus        string `json:"processingStatus"`
+	ProcessingFailureReason string `json:"processingFailureReason,omitempty"`
+}
+
+type YouTubeRecordingDetails struct {
+	RecordingDate string           `json:"recordingDate,omitempty"`
+	Location      *YouTubeLocation `json:"location,omitempty"`
+}
+
+type YouTubeLocation struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Altitude  float64 `json:"altitude,omitempty"`
+}
+
+type YouTubeChannel struct {
+	ID      string `json:"id"`
+	Snippet struct {
+		Title string `json:"title"`
+	} `json:"snippet"`
+}
+
+// Minimal client for the YouTube Data API v3.
+type YouTube struct {
+	client *http.Client
+	// Titles of the videos uploaded to the channel, mapped to their IDs.
+	// Fetched lazily, see channelVideos.
+	uploads map[string]string
+	// Quota usage, persisted in the state database.
+	quota *QuotaTracker
+	// Maximum upload rate in bytes per second, or 0 for no limit.
+	maxUploadRate int64
+	// Uploaded bytes, counted against the monthly data cap.
+	data *DataTracker
+	// Resumable upload sessions of interrupted uploads, by file name, resumed
+	// when retried rather than creating another video.
+	sessions map[string]string
+}
+
+// Returns a client authorized with the configured credentials, accounting
+// quota and data usage in state.
+func connectYouTube(config *Config, state *State) (*YouTube, error) {
+	if config.ClientSecrets == "" {
+		return nil, fmt.Errorf("client_secrets must be configured to upload videos")
+	}
+	secrets, err := loadClientSecrets(config.ClientSecrets)
+	if err != nil {
+		return nil, err
+	}
+	source, err := newTokenSource(secrets, config)
+	if err != nil {
+		return nil, err
+	}
+	yt := &YouTube{
+		client: newAuthorizedClient(source),
+		quota:  &QuotaTracker{state: state, limit: config.DailyQuota},
+	}
+	if config.MonthlyDataCap != "" {
+		limit, _ := parseByteSize(config.MonthlyDataCap)
+		yt.data = &DataTracker{state: state, limit: limit}
+	}
+	return yt, nil
+}
+
+// Accounts for the cost of an API call before making it.
+func (yt *YouTube) spend(cost int) error {
+	if yt.quota == nil {
+		return nil
+	}
+	return yt.quota.spend(cost)
+}
+
+// Issues a GET request to the API.
+func (yt *YouTube) get(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return yt.client.Do(req)
+}
+
+// Decodes an API response, turning non-2xx responses into errors.
+func (yt *YouTube) decodeResponse(resp *http.Response, result interface{}) error {
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		if resp.StatusCode == http.StatusForbidden && bytes.Contains(body, []byte(`"quotaExceeded"`)) {
+			if yt.quota != nil {
+				yt.quota.exhaust()
+			}
+			return errQuotaExceeded
+		}
+		return fmt.Errorf("YouTube API error: %s: %s", resp.Status, body)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(body, result)
+}
+
+// Uploads a video file using the resumable upload protocol and returns the
+// created video resource. If a checksum is given, the uploaded data is
+// verified against it. Uploads interrupted earlier are resumed where they
+// stopped.
+// https://developers.google.com/youtube/v3/guides/using_resumable_upload_protocol
+func (yt *YouTube) upload(ctx context.Context, fileName string, metadata *YouTubeVideo, checksum string) (*YouTubeVideo, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	var offset int64
+	sessionURL := yt.sessions[fileName]
+	if sessionURL != "" {
+		var result *YouTubeVideo
+		offset, result, err = yt.uploadedBytes(ctx, sessionURL, info.Size())
+		if err != nil {
+			return nil, err
+		}
+		if result != nil {
+			delete(yt.sessions, fileName)
+			return result, nil
+		}
+		if offset < 0 {
+			// The session expired, start over.
+			sessionURL, offset = "", 0
+		} else {
+			log.Printf(">>> Resuming upload of %s at %.1fG", fileName, float64(offset)/(1<<30))
+		}
+	}
+	if yt.data != nil {
+		if err := yt.data.reserve(info.Size() - offset); err != nil {
+			return nil, err
+		}
+	}
+	if sessionURL == "" {
+		if sessionURL, err = yt.startUpload(ctx, metadata, info.Size()); err != nil {
+			return nil, err
+		}
+		if yt.sessions == nil {
+			yt.sessions = map[string]string{}
+		}
+		yt.sessions[fileName] = sessionURL
+	}
+
+	// The file is hashed as it is uploaded, to verify it did not change since
+	// it was rendered without reading it again. Bytes uploaded before an
+	// interruption are hashed before resuming.
+	hash := sha256.New()
+	if _, err := io.CopyN(hash, f, offset); err != nil {
+		return nil, err
+	}
+	var content io.Reader = io.TeeReader(f, hash)
+	if yt.data != nil {
+		content = &countingReader{r: content, tracker: yt.data}
+	}
+	req, err := http.NewRequestWithContext(ctx, "PUT", sessionURL, newThrottledReader(content, yt.maxUploadRate))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = info.Size() - offset
+	req.Header.Set("Content-Type", "video/mp4")
+	if offset > 0 {
+		req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, info.Size()-1, info.Size()))
+	}
+	resp, err := yt.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	var result YouTubeVideo
+	if err := yt.decodeResponse(resp, &result); err != nil {
+		return nil, err
+	}
+	delete(yt.sessions, fileName)
+	if uploaded := hex.EncodeToString(hash.Sum(nil)); checksum != "" && uploaded != checksum {
+		warnf(">>> %s changed since it was rendered (SHA-256 %s, expected %s)",
+			fileName, uploaded, checksum)
+	}
+	return &result, nil
+}
+
+// Creates the video resource of an upload, and returns the URL of its upload
+// session.
+func (yt *YouTube) startUpload(ctx context.Context, metadata *YouTubeVideo, size int64) (string, error) {
+	body, err := json.Marshal(metadata)
+	if err != nil {
+		return "", err
+	}
+	if err := yt.spend(QuotaCostInsert); err != nil {
+		return "", err
+	}
+	parts := "snippet,status,recordingDetails"
+	if metadata.Localizations != nil {
+		parts += ",localizations"
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST",
+		youTubeUploadURL+"?uploadType=resumable&part="+parts, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Upload-Content-Length", fmt.Sprint(size))
+	req.Header.Set("X-Upload-Content-Type", "video/mp4")
+	resp, err := yt.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	if err := yt.decodeResponse(resp, nil); err != nil {
+		return "", err
+	}
+	sessionURL := resp.Header.Get("Location")
+	if sessionURL == "" {
+		return "", fmt.Errorf("YouTube API error: missing upload session URL")
+	}
+	return sessionURL, nil
+}
+
+// Asks an upload session how many bytes it received. Returns the video
+// resource if the upload completed, or -1 if the session expired.
+func (yt *YouTube) uploadedBytes(ctx context.Context, sessionURL string, size int64) (int64, *YouTubeVideo, error) {
+	req, err := http.NewRequestWithContext(ctx, "PUT", sessionURL, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+	resp, err := yt.client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusPermanentRedirect:
+		// "Resume Incomplete", with the range received so far if any, e.g.
+		// "bytes=0-42".
+		resp.Body.Close()
+		var last int64
+		if _, err := fmt.Sscanf(resp.Header.Get("Range"), "bytes=0-%d", &last); err != nil {
+			return 0, nil, nil
+		}
+		return last + 1, nil, nil
+	case http.StatusNotFound, http.StatusGone:
+		resp.Body.Close()
+		return -1, nil, nil
+	}
+	var result YouTubeVideo
+	if err := yt.decodeResponse(resp, &result); err != nil {
+		return 0, nil, err
+	}
+	return 0, &result, nil
+}
+
+// Fetches a video resource, or nil if it does not exist (anymore).
+func (yt *YouTube) video(ctx context.Context, id string) (*YouTubeVideo, error) {
+	params := url.Values{
+		"id":   {id},
+		"part": {"status,contentDetails,processingDetails"},
+	}
+	if err := yt.spend(QuotaCostList); err != nil {
+		return nil, err
+	}
+	resp, err := yt.get(ctx, youTubeAPIURL+"/videos?"+params.Encode())
+	if err != nil {
+		return nil, err
+	}
+	var result struct {
+		Items []YouTubeVideo `json:"items"`
+	}
+	if err := yt.decodeResponse(resp, &result); err != nil {
+		return nil, err
+	}
+	if len(result.Items) == 0 {
+		return nil, nil
+	}
+	return &result.Items[0], nil
+}
+
+// Changes the title of a video, keeping the rest of its snippet. Updates of
+// the snippet must include its category, so it is fetched first.
+// https://developers.google.com/youtube/v3/docs/videos/update
+func (yt *YouTube) updateTitle(ctx context.Context, id, title string) error {
+	if err := yt.spend(QuotaCostList); err != nil {
+		return err
+	}
+	resp, err := yt.get(ctx, youTubeAPIURL+"/videos?"+url.Values{"id": {id}, "part": {"snippet"}}.Encode())
+	if err != nil {
+		return err
+	}
+	var result struct {
+		Items []struct {
+			Snippet map[string]interface{} `json:"snippet"`
+		} `json:"items"`
+	}
+	if err := yt.decodeResponse(resp, &result); err != nil {
+		return err
+	}
+	if len(result.Items) == 0 {
+		return fmt.Errorf("video %s not found on YouTube", id)
+	}
+	snippet := map[string]interface{}{"title": title}
+	for _, key := range []string{"categoryId", "description", "tags", "defaultLanguage"} {
+		if value, ok := result.Items[0].Snippet[key]; ok {
+			snippet[key] = value
+		}
+	}
+	body, err := json.Marshal(map[string]interface{}{"id": id, "snippet": snippet})
+	if err != nil {
+		return err
+	}
+	if err := yt.spend(QuotaCostUpdate); err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "PUT", youTubeAPIURL+"/videos?part=snippet", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	resp, err = yt.client.Do(req)
+	if err != nil {
+		return err
+	}
+	return yt.decodeResponse(resp, nil)
+}
+
+// Uploads a caption track to a video, and returns the ID of the caption
+// resource.
+// https://developers.google.com/youtube/v3/docs/captions/insert
+func (yt *YouTube) insertCaption(ctx context.Context, videoID, language, name, fileName string) (string, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return "", err
+	}
+	snippet, err := json.Marshal(map[string]interface{}{
+		"snippet": map[string]interface{}{
+			"videoId":  videoID,
+			"language": language,
+			"name":     name,
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for _, part := range []struct {
+		contentType string
+		data        []byte
+	}{{"application/json; charset=UTF-8", snippet}, {"application/octet-stream", data}} {
+		pw, err := w.CreatePart(textproto.MIMEHeader{"Content-Type": {part.contentType}})
+		if err != nil {
+			return "", err
+		}
+		pw.Write(part.data)
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	if err := yt.spend(QuotaCostCaption); err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST",
+		youTubeCaptionsURL+"?uploadType=multipart&part=snippet", &body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "multipart/related; boundary="+w.Boundary())
+	resp, err := yt.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	var result struct {
+		ID string `json:"id"`
+	}
+	if err := yt.decodeResponse(resp, &result); err != nil {
+		return "", err
+	}
+	return result.ID, nil
+}
+
+// Returns the channel of the authorized account.
+func (yt *YouTube) channel(ctx context.Context) (*YouTubeChannel, error) {
+	if err := yt.spend(QuotaCostList); err != nil {
+		return nil, err
+	}
+	resp, err := yt.get(ctx, youTubeAPIURL+"/channels?part=snippet&mine=true")
+	if err != nil {
+		return nil, err
+	}
+	var channels struct {
+		Items []YouTubeChannel `json:"items"`
+	}
+	if err := yt.decodeResponse(resp, &channels); err != nil {
+		return nil, err
+	}
+	if len(channels.Items) == 0 {
+		return nil, fmt.Errorf("YouTube API error: no channel for the authorized account")
+	}
+	return &channels.Items[0], nil
+}
+
+// Returns the videos uploaded to the authorized channel, by title. Listing the
+// uploads playlist is cheaper in quota and more up to date than search.
+func (yt *YouTube) channelVideos(ctx context.Context) (map[string]string, error) {
+	if yt.uploads != nil {
+		return yt.uploads, nil
+	}
+	if err := yt.spend(QuotaCostList); err != nil {
+		return nil, err
+	}
+	resp, err := yt.get(ctx, youTubeAPIURL+"/channels?part=contentDetails&mine=true")
+	if err != nil {
+		return nil, err
+	}
+	var channels struct {
+		Items []struct {
+			ContentDetails struct {
+				RelatedPlaylists struct {
+					Uploads string `json:"uploads"`
+				} `json:"relatedPlaylists"`
+			} `json:"contentDetails"`
+		} `json:"items"`
+	}
+	if err := yt.decodeResponse(resp, &channels); err != nil {
+		return nil, err
+	}
+	if len(channels.Items) == 0 {
+		return nil, fmt.Errorf("YouTube API error: no channel for the authorized account")
+	}
+
+	uploads := map[string]string{}
+	params := url.Values{
+		"part":       {"snippet"},
+		"playlistId": {channels.Items[0].ContentDetails.RelatedPlaylists.Uploads},
+		"maxResults": {"50"},
+	}
+	for {
+		if err := yt.spend(QuotaCostList); err != nil {
+			return nil, err
+		}
+		resp, err := yt.get(ctx, youTubeAPIURL+"/playlistItems?"+params.Encode())
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			NextPageToken string `json:"nextPageToken"`
+			Items         []struct {
+				Snippet struct {
+					Title      string `json:"title"`
+					ResourceID struct {
+						VideoID string `json:"videoId"`
+					} `json:"resourceId"`
+				} `json:"snippet"`
+			} `json:"items"`
+		}
+		if err := yt.decodeResponse(resp, &page); err != nil {
+			return nil, err
+		}
+		for _, item := range page.Items {
+			uploads[item.Snippet.Title] = item.Snippet.ResourceID.VideoID
+		}
+		if page.NextPageToken == "" {
+			break
+		}
+		params.Set("pageToken", page.NextPageToken)
+	}
+	yt.uploads = uploads
+	return uploads, nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseYouTubeDuration(t *testing.T) {
+	for _, test := range []struct {
+		spec    string
+		want    time.Duration
+		wantErr bool
+	}{
+		{spec: "PT15S", want: 15 * time.Second},
+		{spec: "PT1M", want: time.Minute},
+		{spec: "PT1H2M3S", want: time.Hour + 2*time.Minute + 3*time.Second},
+		{spec: "PT2H", want: 2 * time.Hour},
+		{spec: "P1DT1S", want: 24*time.Hour + time.Second},
+		{spec: "P0D", want: 0},
+		{spec: "PT0S", want: 0},
+		{spec: "", wantErr: true},
+		{spec: "15S", wantErr: true},
+		{spec: "PT1.5S", wantErr: true},
+		{spec: "PT1S2M", wantErr: true},
+		{spec: "P", wantErr: true},
+		{spec: "PT", wantErr: true},
+		{spec: "P1DT", wantErr: true},
+	} {
+		got, err := parseYouTubeDuration(test.spec)
+		if (err != nil) != test.wantErr {
+			t.Errorf("parseYouTubeDuration(%q) error = %v, want error %v", test.spec, err, test.wantErr)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("parseYouTubeDuration(%q) = %v, want %v", test.spec, got, test.want)
+		}
+	}
+}